@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/santiagolertora/blc-perf-analyzer/internal/analysis"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// Analyze flags
+	inputFormat string
+	jobs        int
+)
+
+var analyzeCmd = &cobra.Command{
+	Use:   "analyze <input> [<input>...]",
+	Short: "Analyze a previously saved capture",
+	Long: `Analyze a capture without running perf record.
+
+<input> may be a perf.data file recorded earlier, here or on another machine,
+to regenerate the flamegraph, heatmap or summary without profiling again.
+perf script is run on it locally, so symbols resolve only for binaries and
+debug info present on this host (see 'perf archive'). --annotate-top and
+--generate-perf-report are available for perf.data inputs.
+
+With --input-format perfscript, <input> is the text output of 'perf script'
+saved on another machine. Gzip-compressed files (e.g. perf-script.txt.gz) are
+decompressed transparently.
+
+With --input-format ftrace, <input> is the output of 'trace-cmd report' or a
+raw ftrace trace file. Stack traces (stacktrace/userstacktrace options) become
+one sample each; with the function_graph tracer every leaf call becomes a
+sample, so the profile counts calls rather than time.
+
+Without output flags the summary and flamegraph are generated. Output flags
+such as --generate-heatmap or --generate-pprof write only those outputs and
+the summary, as for a live capture (add --generate-flamegraph to keep the
+flamegraph). Process, PID and duration are taken from the samples
+unless --process/--pid/--duration are given. For a capture of the whole host (perf
+record -a), pass --system-wide to group the summary and heatmap by process.
+
+Several inputs can be analyzed at once; each gets its own subdirectory of the
+output directory, named after the input file. Inputs are processed in
+parallel by up to --jobs workers.`,
+	Args: cobra.MinimumNArgs(1),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if !validInputFormat(inputFormat) {
+			return fmt.Errorf("invalid --input-format %q (supported: %s)", inputFormat, strings.Join(analysis.InputFormats, ", "))
+		}
+		for _, input := range args {
+			if !analysis.IsPerfData(input) {
+				if annotateTop > 0 {
+					return fmt.Errorf("--annotate-top needs perf.data and is not available for perf script input %s", input)
+				}
+				if generatePerfReport {
+					return fmt.Errorf("--generate-perf-report needs perf.data and is not available for perf script input %s", input)
+				}
+			} else if inputFormat != analysis.InputFormatPerfScript {
+				return fmt.Errorf("--input-format %s does not apply to %s, a perf.data file", inputFormat, input)
+			}
+		}
+		if jobs < 1 {
+			return fmt.Errorf("--jobs must be at least 1")
+		}
+		if heatmapWindowSize <= 0 {
+			return fmt.Errorf("heatmap window size must be greater than 0")
+		}
+		if topN <= 0 {
+			return fmt.Errorf("top-n must be positive")
+		}
+		if err := checkSummaryToStdout(); err != nil {
+			return err
+		}
+		if err := checkOutputDir(); err != nil {
+			return err
+		}
+		if ratioShiftDelta <= 0 || ratioShiftDelta > 100 {
+			return fmt.Errorf("ratio-shift-delta must be between 0 and 100")
+		}
+		if err := checkPatternThresholds(); err != nil {
+			return err
+		}
+		if failOnAnomalies && !generateHeatmap {
+			return fmt.Errorf("--fail-on-anomalies requires --generate-heatmap")
+		}
+		if flamegraphByThread && !flamegraphRequested() {
+			return fmt.Errorf("--flamegraph-by-thread requires --generate-flamegraph")
+		}
+		if flamegraphInverted && !flamegraphRequested() {
+			return fmt.Errorf("--flamegraph-inverted requires --generate-flamegraph")
+		}
+		if flamegraphPerTID && !flamegraphRequested() {
+			return fmt.Errorf("--flamegraph-per-thread requires --generate-flamegraph")
+		}
+		if flamegraphDiff != "" && !flamegraphRequested() {
+			return fmt.Errorf("--flamegraph-diff requires --generate-flamegraph")
+		}
+		if err := checkFlamegraphDiff(); err != nil {
+			return err
+		}
+		if heatmapAnimate && !generateHeatmap {
+			return fmt.Errorf("--heatmap-animate requires --generate-heatmap")
+		}
+		if heatmapCSV && !generateHeatmap {
+			return fmt.Errorf("--heatmap-csv requires --generate-heatmap")
+		}
+		if err := checkOfflineAssets(); err != nil {
+			return err
+		}
+		if err := checkHeatmapTheme(); err != nil {
+			return err
+		}
+		if alertThreshold < 0 || alertThreshold > 100 {
+			return fmt.Errorf("--alert-function-threshold must be between 0 and 100")
+		}
+		if err := flamegraphOptions().Validate(); err != nil {
+			return err
+		}
+		if err := parseRedact(); err != nil {
+			return err
+		}
+		if err := parseCPUFilter(); err != nil {
+			return err
+		}
+		return parseExcludeThreads()
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
+		if err := applyExternalTools(); err != nil {
+			return err
+		}
+		if err := applySelfAffinity(); err != nil {
+			return err
+		}
+
+		progress = progressWriter()
+
+		finalOutputDir := outputDir
+		if finalOutputDir == "" {
+			timestamp := time.Now().Format("20060102-150405")
+			finalOutputDir = filepath.Join(".", fmt.Sprintf("blc-perf-analyzer-%s", timestamp))
+		}
+		if !summaryToStdout {
+			if err := os.MkdirAll(finalOutputDir, 0755); err != nil {
+				return fmt.Errorf("error creating output directory: %v", err)
+			}
+		}
+
+		configs := make([]*analysis.ReportConfig, len(args))
+		seen := make(map[string]string)
+		for i, input := range args {
+			inputDir := finalOutputDir
+			if len(args) > 1 {
+				name := inputName(input)
+				if previous, exists := seen[name]; exists {
+					return fmt.Errorf("inputs %s and %s would share the output directory %s", previous, input, name)
+				}
+				seen[name] = input
+				inputDir = filepath.Join(finalOutputDir, name)
+				if !summaryToStdout {
+					if err := os.MkdirAll(inputDir, 0755); err != nil {
+						return fmt.Errorf("error creating output directory: %v", err)
+					}
+				}
+			}
+			configs[i] = &analysis.ReportConfig{
+				InputFormat:        inputFormat,
+				OutputDir:          inputDir,
+				ProcessName:        processName,
+				PID:                pid,
+				GenerateFlamegraph: flamegraphRequested(),
+				Flamegraph:         flamegraphOptions(),
+				GenerateHeatmap:    generateHeatmap,
+				GeneratePprof:      generatePprof,
+				Markdown:           markdownSummary,
+				TopN:               topN,
+				HeatmapWindowSize:  heatmapWindowSize,
+				FlamegraphByThread: flamegraphByThread,
+				FlamegraphInverted: flamegraphInverted,
+				FlamegraphPerTID:   flamegraphPerTID,
+				FlamegraphDiff:     flamegraphDiff,
+				HeatmapAnimate:     heatmapAnimate,
+				HeatmapOffline:     offlineAssets,
+				HeatmapTheme:       heatmapTheme,
+				HeatmapCSV:         heatmapCSV,
+				RatioShiftDelta:    ratioShiftDelta,
+				PatternThresholds:  patternThresholds,
+				ExcludeThreads:     excludeThreads,
+				CPUFilter:          cpuFilter,
+				SummaryOnly:        summaryToStdout,
+				CallersOf:          callersOf,
+				CompareThreads:     compareThreads,
+				WeightByPeriod:     weightByPeriod,
+				IncludeIdle:        includeIdle,
+				SystemWide:         systemWide,
+				Redact:             redactor,
+				QuietMode:          quietMode,
+				Progress:           progress,
+			}
+			if analysis.IsPerfData(input) {
+				configs[i].PerfDataPath = input
+				configs[i].OfflinePerfData = true
+				configs[i].GeneratePerfReport = generatePerfReport
+				configs[i].AnnotateTop = annotateTop
+			} else {
+				configs[i].ScriptPath = input
+			}
+			if cmd.Flags().Changed("duration") {
+				configs[i].Duration = duration
+			}
+		}
+
+		reports, err := analysis.GenerateReports(configs, jobs)
+		if err != nil {
+			return fmt.Errorf("error generating reports: %v", err)
+		}
+
+		if summaryToStdout {
+			for _, report := range reports {
+				fmt.Fprint(os.Stdout, report.SummaryText(topN))
+			}
+		} else if jsonOutput {
+			if err := printJSON(os.Stdout, reports); err != nil {
+				return err
+			}
+		} else if quietMode {
+			fmt.Fprintf(progress, "%s\n", finalOutputDir)
+		} else {
+			fmt.Fprintf(progress, "\nAnalysis complete. Results saved in: %s\n", finalOutputDir)
+			fmt.Fprintln(progress)
+			for _, report := range reports {
+				fmt.Fprintf(progress, "Verdict: %s\n", report.Summary.Verdict)
+			}
+		}
+
+		if uploadURL != "" {
+			if err := uploadResults(finalOutputDir); err != nil {
+				return err
+			}
+		}
+
+		for _, report := range reports {
+			if err := evaluateIssues(report); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+// validInputFormat reports whether format is one of analysis.InputFormats
+func validInputFormat(format string) bool {
+	for _, supported := range analysis.InputFormats {
+		if format == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// inputName derives the per-input output directory name from its file name,
+// e.g. "/tmp/node1.perf-script.txt.gz" becomes "node1.perf-script"
+func inputName(input string) string {
+	name := filepath.Base(input)
+	name = strings.TrimSuffix(name, ".gz")
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+func init() {
+	analyzeCmd.Flags().IntVar(&jobs, "jobs", runtime.NumCPU(), "Number of inputs analyzed in parallel (default: number of CPUs)")
+	analyzeCmd.Flags().StringVar(&inputFormat, "input-format", "perfscript", "Format of <input>: perfscript (perf script text) or ftrace (trace-cmd report or ftrace trace file), optionally .gz")
+
+	rootCmd.AddCommand(analyzeCmd)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/santiagolertora/blc-perf-analyzer/internal/analysis"
+	"github.com/santiagolertora/blc-perf-analyzer/internal/capture"
+	"github.com/santiagolertora/blc-perf-analyzer/internal/detector"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// Bench flags
+	benchLaunch string
+	benchMetric string
+	metric      analysis.Metric
+)
+
+var benchCmd = &cobra.Command{
+	Use:   "bench --launch <command> [--metric <metric>]",
+	Short: "Profile a benchmark command and print a single metric",
+	Long: `Profile a benchmark command from start to finish and print a single metric
+on stdout, for CI harnesses that compare runs across commits.
+
+The command runs through sh -c under perf record. Its output and all progress
+messages go to stderr, so stdout holds only the metric value. A failing
+command is an error (exit code 1).
+
+Metrics:
+  kernel_percent, userland_percent, unknown_percent, idle_percent,
+  mm_pressure_percent, jit_percent, go_runtime_percent, allocator_percent,
+  total_samples, top_function_percent,
+  function_self_percent:<function>   Samples with <function> as the leaf
+  function_total_percent:<function>  Samples with <function> anywhere on the stack
+
+Example:
+  blc-perf-analyzer bench --launch "./db_bench --benchmarks=fillrandom" --metric function_total_percent:malloc`,
+	Args: cobra.NoArgs,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if benchLaunch == "" {
+			return fmt.Errorf("--launch is required")
+		}
+		var err error
+		metric, err = analysis.ParseMetric(benchMetric)
+		if err != nil {
+			return err
+		}
+		if err := checkCallGraph(); err != nil {
+			return err
+		}
+		if err := parseCPUFilter(); err != nil {
+			return err
+		}
+		return parseExcludeThreads()
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
+		// stdout carries only the metric
+		progress = os.Stderr
+
+		if err := applyExternalTools(); err != nil {
+			return err
+		}
+		if err := applySelfAffinity(); err != nil {
+			return err
+		}
+		if _, err := detector.DetectSystem(); err != nil {
+			return fmt.Errorf("error detecting system: %w", err)
+		}
+		if err := detector.CheckPermissions(); err != nil {
+			return fmt.Errorf("error checking permissions: %w", err)
+		}
+
+		workDir, err := os.MkdirTemp("", "blc-perf-bench-")
+		if err != nil {
+			return fmt.Errorf("error creating temporary directory: %v", err)
+		}
+		defer os.RemoveAll(workDir)
+
+		result, err := capture.Capture(&capture.CaptureConfig{
+			Launch:    benchLaunch,
+			OutputDir: workDir,
+			QuietMode: quietMode,
+			Progress:  progress,
+			CallGraph: callGraph,
+			Frequency: frequency,
+			Events:    events,
+		})
+		if err != nil {
+			return fmt.Errorf("error during capture: %w", err)
+		}
+
+		config := &analysis.ReportConfig{
+			PerfDataPath:   result.PerfDataPath,
+			OutputDir:      workDir,
+			Duration:       int(result.EndTime.Sub(result.RecordStartTime).Seconds()),
+			ExcludeThreads: excludeThreads,
+			CPUFilter:      cpuFilter,
+			SummaryOnly:    true,
+			IncludeIdle:    includeIdle,
+			WeightByPeriod: weightByPeriod,
+			QuietMode:      quietMode,
+			Progress:       progress,
+		}
+		if metric.NeedsCallers() {
+			config.CallersOf = metric.Function
+		}
+		report, err := analysis.GenerateReport(config)
+		if err != nil {
+			return fmt.Errorf("error generating reports: %v", err)
+		}
+
+		value, err := metric.Value(report)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stdout, metric.Format(value))
+		return nil
+	},
+}
+
+func init() {
+	benchCmd.Flags().StringVar(&benchLaunch, "launch", "", "Benchmark command to run and profile (through sh -c)")
+	benchCmd.Flags().StringVar(&benchMetric, "metric", "kernel_percent", "Metric printed on stdout (see the command help for the list)")
+
+	rootCmd.AddCommand(benchCmd)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/santiagolertora/blc-perf-analyzer/internal/capture"
+	"github.com/spf13/cobra"
+)
+
+var captureCmd = &cobra.Command{
+	Use:   "capture",
+	Short: "Capture perf.data without analyzing it",
+	Long: `Run the preflight checks, resolve the target and record perf.data, without
+any analysis. Only the absolute path of perf.data is printed on stdout; all
+progress messages go to stderr.
+
+Target, timing and sampling flags are the same as for a regular run. Analyze
+the capture later, on this host or elsewhere, with 'perf script' and the
+analyze subcommand.
+
+Example:
+  data=$(blc-perf-analyzer capture --systemd-unit mariadb.service --duration 60)
+  perf script -i "$data" | gzip > mariadb.perf.gz
+  blc-perf-analyzer analyze mariadb.perf.gz --generate-heatmap`,
+	Args: cobra.NoArgs,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := validateCaptureFlags(); err != nil {
+			return err
+		}
+		return checkOutputDir()
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
+		// stdout carries only the perf.data path
+		progress = os.Stderr
+
+		if err := applyExternalTools(); err != nil {
+			return err
+		}
+		if err := applySelfAffinity(); err != nil {
+			return err
+		}
+		if err := checkRequirements(); err != nil {
+			return err
+		}
+		if err := resolvePidSource(); err != nil {
+			return err
+		}
+
+		finalOutputDir := outputDir
+		if finalOutputDir == "" {
+			timestamp := time.Now().Format("20060102-150405")
+			finalOutputDir = filepath.Join(".", fmt.Sprintf("blc-perf-capture-%s", timestamp))
+		}
+
+		config := captureConfig(finalOutputDir)
+		config.Log.Printf("started: %s", strings.Join(os.Args, " "))
+		result, err := capture.Capture(config)
+		if err != nil {
+			config.Log.Printf("capture failed: %v", err)
+			return fmt.Errorf("error during capture: %w", err)
+		}
+		config.Log.Printf("capture finished: %s", result.PerfDataPath)
+
+		perfDataPath, err := filepath.Abs(result.PerfDataPath)
+		if err != nil {
+			perfDataPath = result.PerfDataPath
+		}
+		fmt.Fprintln(os.Stdout, perfDataPath)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(captureCmd)
+}
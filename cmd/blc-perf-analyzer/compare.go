@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/santiagolertora/blc-perf-analyzer/internal/analysis"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// Compare flags
+	compareThreshold float64
+)
+
+var compareCmd = &cobra.Command{
+	Use:   "compare <before> <after>",
+	Short: "Compare the functions of two captures",
+	Long: `Compare the self samples of every function in two captures taken before
+and after a change, and write diff.json and diff.txt.
+
+Each argument may be a result directory produced by a previous run (its
+summary.json is used, or its perf.data when the summary has no function
+list), a perf.data file, or saved perf script text. Functions are matched by
+name; those present on one side only are reported as new or gone. Changes
+are percentage points of each capture's samples, so captures of different
+length can be compared. The list is sorted by largest regression first.`,
+	Args: cobra.ExactArgs(2),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if compareThreshold < 0 {
+			return fmt.Errorf("--threshold cannot be negative")
+		}
+		return checkOutputDir()
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
+		if err := applyExternalTools(); err != nil {
+			return err
+		}
+		if err := applySelfAffinity(); err != nil {
+			return err
+		}
+
+		finalOutputDir := outputDir
+		if finalOutputDir == "" {
+			timestamp := time.Now().Format("20060102-150405")
+			finalOutputDir = filepath.Join(".", fmt.Sprintf("blc-perf-compare-%s", timestamp))
+		}
+
+		comparison, err := analysis.CompareCaptures(args[0], args[1], compareThreshold)
+		if err != nil {
+			return err
+		}
+		if err := analysis.WriteComparison(finalOutputDir, comparison); err != nil {
+			return err
+		}
+
+		if quietMode {
+			fmt.Fprintf(progress, "%s\n", finalOutputDir)
+			return nil
+		}
+		fmt.Fprintf(progress, "\n%s", comparison.Text())
+		fmt.Fprintf(progress, "\nComparison saved in: %s\n", finalOutputDir)
+		return nil
+	},
+}
+
+func init() {
+	compareCmd.Flags().Float64Var(&compareThreshold, "threshold", analysis.DefaultCompareThreshold, "Change in percentage points of self samples above which a function is reported as grown or shrunk")
+
+	rootCmd.AddCommand(compareCmd)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/santiagolertora/blc-perf-analyzer/internal/analysis"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// Diff flags
+	diffFlamegraph bool
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff <before> <after>",
+	Short: "Compare two captures",
+	Long: `Compare two captures taken before and after a change.
+
+Each argument may be a perf.data file, a perf.folded file, or a result
+directory produced by a previous run.
+
+With --flamegraph a differential flamegraph is generated: frames that got
+hotter in <after> are red, frames that got colder are blue. Counts are raw
+sample counts, so use captures of similar volume (see --sample-count).`,
+	Args: cobra.ExactArgs(2),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if !diffFlamegraph {
+			return fmt.Errorf("nothing to compare: specify --flamegraph")
+		}
+		if err := checkOutputDir(); err != nil {
+			return err
+		}
+		return flamegraphOptions().Validate()
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
+		if err := applyExternalTools(); err != nil {
+			return err
+		}
+		if err := applySelfAffinity(); err != nil {
+			return err
+		}
+
+		finalOutputDir := outputDir
+		if finalOutputDir == "" {
+			timestamp := time.Now().Format("20060102-150405")
+			finalOutputDir = filepath.Join(".", fmt.Sprintf("blc-perf-diff-%s", timestamp))
+		}
+
+		if diffFlamegraph {
+			svgPath, err := analysis.GenerateDiffFlamegraph(args[0], args[1], finalOutputDir, flamegraphOptions(), quietProgress())
+			if err != nil {
+				return fmt.Errorf("error generating differential flamegraph: %v", err)
+			}
+			if !quietMode {
+				fmt.Fprintf(progress, "\nDifferential flamegraph saved to: %s\n", svgPath)
+			}
+		}
+
+		if quietMode {
+			fmt.Fprintf(progress, "%s\n", finalOutputDir)
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	diffCmd.Flags().BoolVar(&diffFlamegraph, "flamegraph", false, "Generate a differential flamegraph SVG (red = hotter, blue = colder)")
+
+	rootCmd.AddCommand(diffCmd)
+}
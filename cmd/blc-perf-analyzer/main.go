@@ -1,18 +1,48 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/santiagolertora/blc-perf-analyzer/internal/analysis"
 	"github.com/santiagolertora/blc-perf-analyzer/internal/capture"
 	"github.com/santiagolertora/blc-perf-analyzer/internal/detector"
+	"github.com/santiagolertora/blc-perf-analyzer/internal/heatmap"
+	"github.com/santiagolertora/blc-perf-analyzer/internal/parser"
+	"github.com/santiagolertora/blc-perf-analyzer/internal/process"
+	"github.com/santiagolertora/blc-perf-analyzer/internal/upload"
 	"github.com/spf13/cobra"
 )
 
+// Exit codes returned by the CLI. Automation can rely on these to tell a
+// broken run apart from a successful run that detected problems.
+const (
+	// ExitOK means the analysis completed and no issues were flagged
+	ExitOK = 0
+	// ExitError means the tool failed or was invoked incorrectly
+	ExitError = 1
+	// ExitIssuesFound means the analysis completed but a --fail-on-* condition matched
+	ExitIssuesFound = 2
+)
+
+// issuesFoundError reports that analysis succeeded but detected problems
+// that the user asked to fail on
+type issuesFoundError struct {
+	reason string
+}
+
+func (e *issuesFoundError) Error() string {
+	return e.reason
+}
+
 var (
 	// Build information
 	Version   = "dev"
@@ -22,14 +52,76 @@ var (
 	// Flags
 	processName        string
 	pid                int
+	pidFile            string
+	systemdUnit        string
+	systemWide         bool
+	cgroupPath         string
+	containerID        string
+	allMatching        bool
+	exactMatch         bool
 	duration           int
 	delayStart         int
 	profileWindow      int
 	outputDir          string
+	forceOutput        bool
 	quietMode          bool
+	summaryToStdout    bool
+	jsonOutput         bool
+	keepPerfData       bool
+	perfDataMode       string
+	uploadURL          string
+	annotateTop        int
+	callersOf          string
+	compareThreads     bool
+	weightByPeriod     bool
+	includeIdle        bool
 	generateFlamegraph bool
+	flamegraphTitle    string
+	flamegraphWidth    int
+	flamegraphHeight   int
+	flamegraphMinWidth string
+	flamegraphByThread bool
+	flamegraphInverted bool
+	flamegraphPerTID   bool
+	flamegraphDiff     string
 	generateHeatmap    bool
+	generatePprof      bool
+	markdownSummary    bool
+	topN               int
+	generateSummary    bool
+	generatePerfReport bool
 	heatmapWindowSize  float64
+	heatmapAnimate     bool
+	offlineAssets      bool
+	heatmapTheme       string
+	heatmapCSV         bool
+	ratioShiftDelta    float64
+	patternThresholds  heatmap.PatternThresholds
+	markSpecs          []string
+	markers            []*heatmap.Marker
+	excludeSpecs       []string
+	excludeThreads     []*parser.ThreadMatcher
+	cpuFilterSpec      string
+	cpuFilter          []int
+	redactPatterns     []string
+	redactor           *parser.Redactor
+	callGraph          string
+	branchStack        bool
+	frequency          int
+	events             []string
+	sampleCount        int
+	snapshotMode       bool
+	guestMode          bool
+	hostMode           bool
+	withStat           bool
+	triggerFile        string
+	triggerCPU         float64
+	perfPath           string
+	noDownload         bool
+	selfAffinity       string
+	debugDir           string
+	failOnAnomalies    bool
+	alertThreshold     float64
 	showVersion        bool
 )
 
@@ -53,27 +145,41 @@ When to use it?
 Target users: SREs, DBAs, performance engineers, DevOps, and anyone needing 
 to understand process internals under load.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		// Los errores a partir de aquí no son de uso; no imprimir la ayuda
+		cmd.SilenceUsage = true
+
+		// Con --summary-to-stdout o --json el progreso va a stderr y stdout lleva solo el resumen
+		progress = progressWriter()
+
 		// 1. Detectar sistema y verificar requisitos
-		sysInfo, err := detector.DetectSystem()
-		if err != nil {
-			return fmt.Errorf("error detecting system: %v", err)
+		if err := applyExternalTools(); err != nil {
+			return err
+		}
+		if err := applySelfAffinity(); err != nil {
+			return err
 		}
 
-		if !sysInfo.PerfInstalled {
-			fmt.Printf("perf is not installed. Attempting to install on %s...\n", sysInfo.Distro)
-			if err := detector.InstallPerf(sysInfo.Distro); err != nil {
-				return fmt.Errorf("error installing perf: %v", err)
-			}
+		// 2. Verificar perf y permisos
+		if err := checkRequirements(); err != nil {
+			return err
 		}
 
-		// 2. Verificar permisos
-		if err := detector.CheckPermissions(); err != nil {
-			return fmt.Errorf("error checking permissions: %v", err)
+		// El PID de un pidfile o una unidad de systemd se resuelve justo antes de capturar
+		if err := resolvePidSource(); err != nil {
+			return err
 		}
 
 		// 3. Preparar directorio de salida
 		var finalOutputDir string
-		if outputDir != "" {
+		if summaryToStdout {
+			// perf.data necesita un lugar; se descarta al terminar
+			tmpDir, err := os.MkdirTemp("", "blc-perf-analyzer-")
+			if err != nil {
+				return fmt.Errorf("error creating temporary directory: %v", err)
+			}
+			defer os.RemoveAll(tmpDir)
+			finalOutputDir = tmpDir
+		} else if outputDir != "" {
 			finalOutputDir = outputDir
 		} else {
 			timestamp := time.Now().Format("20060102-150405")
@@ -81,105 +187,340 @@ to understand process internals under load.`,
 		}
 
 		// 4. Determinar duración efectiva
-		effectiveDuration := duration
-		if profileWindow > 0 {
-			effectiveDuration = profileWindow
+		effectiveDuration := effectiveCaptureDuration()
+
+		// 5. Configurar y ejecutar captura; capture.log registra cada paso
+		config := captureConfig(finalOutputDir)
+		config.Log.Printf("started: %s", strings.Join(os.Args, " "))
+		result, err := capture.Capture(config)
+		if err != nil {
+			config.Log.Printf("capture failed: %v", err)
+			return fmt.Errorf("error during capture: %w", err)
 		}
+		config.Log.Printf("capture finished: %s", result.PerfDataPath)
 
-		// 5. Configurar y ejecutar captura
-		config := &capture.CaptureConfig{
-			ProcessName: processName,
-			PID:         pid,
-			Duration:    effectiveDuration,
-			DelayStart:  delayStart,
-			OutputDir:   finalOutputDir,
-			QuietMode:   quietMode,
+		// En modo --sample-count o --snapshot la duración es la que efectivamente tomó la captura
+		if sampleCount > 0 || snapshotMode {
+			effectiveDuration = int(math.Ceil(result.EndTime.Sub(result.StartTime).Seconds()))
+		}
+		// Si se interrumpió con Ctrl-C, es lo que alcanzó a grabar perf
+		if result.Interrupted && !snapshotMode {
+			effectiveDuration = int(math.Ceil(result.EndTime.Sub(result.RecordStartTime).Seconds()))
 		}
 
-		result, err := capture.Capture(config)
-		if err != nil {
-			return fmt.Errorf("error during capture: %v", err)
+		// Registrar símbolos de depuración separados antes de cualquier perf script/report
+		if debugDir != "" {
+			n, cleanup, err := analysis.RegisterDebugSymbols(result.PerfDataPath, debugDir, quietProgress())
+			if err != nil {
+				return fmt.Errorf("error registering debug symbols: %v", err)
+			}
+			defer cleanup()
+			if !quietMode {
+				fmt.Fprintf(progress, "Registered %d debug symbol files from %s\n", n, debugDir)
+			}
 		}
 
 		// 6. Procesar resultados y generar reportes
-		if generateFlamegraph || generateHeatmap {
+		var analysisReport *analysis.AnalysisResult
+		if analysisRequested() {
 			if !quietMode {
-				fmt.Println("Generating analysis reports...")
+				fmt.Fprintln(progress, "Generating analysis reports...")
 			}
-			if err := analysis.GenerateReport(result.PerfDataPath, finalOutputDir, processName, pid, effectiveDuration, generateHeatmap, heatmapWindowSize); err != nil {
+			report, err := analysis.GenerateReport(&analysis.ReportConfig{
+				PerfDataPath:       result.PerfDataPath,
+				StatPath:           result.StatPath,
+				OutputDir:          finalOutputDir,
+				ProcessName:        processName,
+				PID:                pid,
+				PIDs:               result.PIDs,
+				Duration:           effectiveDuration,
+				GenerateFlamegraph: flamegraphRequested(),
+				Flamegraph:         flamegraphOptions(),
+				GeneratePerfReport: generatePerfReport,
+				GenerateHeatmap:    generateHeatmap,
+				GeneratePprof:      generatePprof,
+				Markdown:           markdownSummary,
+				TopN:               topN,
+				HeatmapWindowSize:  heatmapWindowSize,
+				FlamegraphByThread: flamegraphByThread,
+				FlamegraphInverted: flamegraphInverted,
+				FlamegraphPerTID:   flamegraphPerTID,
+				FlamegraphDiff:     flamegraphDiff,
+				HeatmapAnimate:     heatmapAnimate,
+				HeatmapOffline:     offlineAssets,
+				HeatmapTheme:       heatmapTheme,
+				HeatmapCSV:         heatmapCSV,
+				SampleLimit:        sampleCount,
+				CaptureStart:       result.RecordStartTime,
+				LaunchTime:         result.StartTime,
+				LaunchClock:        result.StartClock,
+				Markers:            markers,
+				RatioShiftDelta:    ratioShiftDelta,
+				PatternThresholds:  patternThresholds,
+				ExcludeThreads:     excludeThreads,
+				CPUFilter:          cpuFilter,
+				Target:             result.Target,
+				SummaryOnly:        summaryToStdout,
+				AnnotateTop:        annotateTop,
+				CallersOf:          callersOf,
+				CompareThreads:     compareThreads,
+				WeightByPeriod:     weightByPeriod,
+				IncludeIdle:        includeIdle,
+				SystemWide:         systemWide,
+				Cgroup:             cgroupPath,
+				Redact:             redactor,
+				QuietMode:          quietMode,
+				Progress:           progress,
+			})
+			if err != nil {
+				config.Log.Printf("analysis failed: %v", err)
 				return fmt.Errorf("error generating reports: %v", err)
 			}
+			config.Log.Printf("parsed %d samples, analysis finished", report.Summary.TotalSamples)
+			analysisReport = report
 		} else {
 			// Solo procesar perf script si no se genera flamegraph ni heatmap
 			if err := capture.ProcessCapture(result); err != nil {
+				config.Log.Printf("perf script failed: %v", err)
 				return fmt.Errorf("error processing capture: %v", err)
 			}
+			config.Log.Printf("perf script output written")
 		}
 
-		if !quietMode {
-			fmt.Printf("\nAnalysis complete. Results saved in: %s\n", finalOutputDir)
-			fmt.Println("\nGenerated files:")
-			fmt.Println("   - perf.data: Raw perf data")
+		// Solo se borra o comprime perf.data cuando el análisis terminó bien
+		if err := capture.ApplyPerfDataRetention(result, perfDataMode); err != nil {
+			fmt.Fprintf(progress, "Warning: %v\n", err)
+		}
+
+		if summaryToStdout {
+			fmt.Fprint(os.Stdout, analysisReport.SummaryText(topN))
+		} else if jsonOutput {
+			if err := printJSON(os.Stdout, []*analysis.AnalysisResult{analysisReport}); err != nil {
+				return err
+			}
+		} else if !quietMode {
+			fmt.Fprintf(progress, "\nAnalysis complete. Results saved in: %s\n", finalOutputDir)
+			if analysisReport != nil {
+				fmt.Fprintf(progress, "\nVerdict: %s\n", analysisReport.Summary.Verdict)
+			}
+			fmt.Fprintln(progress, "\nGenerated files:")
+			fmt.Fprintln(progress, "   - capture.log: Timestamped capture lifecycle events")
+			if result.PerfDataPath != "" {
+				fmt.Fprintf(progress, "   - %s: Raw perf data\n", filepath.Base(result.PerfDataPath))
+			}
+
+			if analysisRequested() {
+				fmt.Fprintln(progress, "   - summary.json: Detailed analysis in JSON format")
+				fmt.Fprintln(progress, "   - summary.txt: Human-readable analysis summary")
+			}
+			if markdownSummary {
+				fmt.Fprintln(progress, "   - summary.md: Summary in Markdown, for tickets and pull requests")
+			}
 
-			if generateFlamegraph || generateHeatmap {
-				fmt.Println("   - summary.json: Detailed analysis in JSON format")
-				fmt.Println("   - summary.txt: Human-readable analysis summary")
-				fmt.Println("   - perf-report.txt: Detailed perf report")
+			if generatePerfReport {
+				fmt.Fprintln(progress, "   - perf-report.txt: Detailed perf report")
 			}
 
 			if generateFlamegraph {
-				fmt.Println("   - flamegraph.svg: Interactive flamegraph visualization")
-				fmt.Println("   - perf.folded: Folded stack traces")
+				fmt.Fprintln(progress, "   - flamegraph.svg: Interactive flamegraph visualization")
+				fmt.Fprintln(progress, "   - perf.folded: Folded stack traces")
+			}
+			if generateFlamegraph && flamegraphByThread {
+				fmt.Fprintln(progress, "   - flamegraph-threads.svg: Flamegraph with one tower per thread")
+				fmt.Fprintln(progress, "   - perf-threads.folded: Folded stack traces rooted at their thread")
+			}
+			if generateFlamegraph && flamegraphDiff != "" {
+				fmt.Fprintln(progress, "   - flamegraph-diff.svg: Differential flamegraph against the --flamegraph-diff baseline (red = hotter now, blue = colder)")
+				fmt.Fprintln(progress, "   - diff.folded: Folded stacks with the baseline and current counts")
+			}
+			if generateFlamegraph && flamegraphInverted {
+				fmt.Fprintln(progress, "   - flamegraph-inverted.svg: Inverted (icicle) flamegraph merged from the leaf: the top row is the functions on CPU, whoever called them")
+			}
+			if analysisReport != nil {
+				for _, file := range analysisReport.ThreadFlamegraphs {
+					fmt.Fprintf(progress, "   - %s: Flamegraph of one thread\n", file)
+				}
+			}
+
+			if generatePprof {
+				fmt.Fprintln(progress, "   - profile.pb.gz: pprof profile (go tool pprof)")
 			}
 
 			if generateHeatmap {
-				fmt.Println("   - heatmap.html: Interactive temporal heatmap")
-				fmt.Println("   - heatmap-data.json: Heatmap data in JSON format")
-				fmt.Println("   - patterns.json: Detected performance patterns and anomalies")
+				fmt.Fprintln(progress, "   - heatmap.html: Interactive temporal heatmap")
+				fmt.Fprintln(progress, "   - heatmap-data.json: Heatmap data in JSON format")
+				if heatmapCSV {
+					fmt.Fprintln(progress, "   - heatmap-data.csv: Time windows in CSV format")
+				}
+				fmt.Fprintln(progress, "   - patterns.json: Detected performance patterns and anomalies")
+			}
+			if analysisReport != nil {
+				for _, dir := range analysisReport.EventHeatmaps {
+					fmt.Fprintf(progress, "   - %s/: Heatmap of one more recorded event\n", dir)
+				}
+			}
+
+			if analysisReport != nil && len(analysisReport.Summary.Annotations) > 0 {
+				fmt.Fprintln(progress, "   - annotations/: Per-instruction annotations of the top functions")
+			}
+
+			if result.StatPath != "" {
+				fmt.Fprintln(progress, "   - perf-stat.csv: Hardware counters from perf stat")
+			}
+
+			if analysisReport != nil && len(analysisReport.Summary.Branches) > 0 {
+				fmt.Fprintln(progress, "   - branch-mispredictions.json: Top branch misprediction sources ")
 			}
 
-			if !generateFlamegraph && !generateHeatmap {
-				fmt.Println("   - perf-output.txt: Processed perf script output")
+			if !analysisRequested() {
+				fmt.Fprintln(progress, "   - perf-output.txt: Processed perf script output")
 			}
 
-			fmt.Println("\nTips:")
-			fmt.Println("   - Use --generate-flamegraph to visualize call stacks")
-			fmt.Println("   - Use --generate-heatmap to see performance over time")
-			fmt.Println("   - Use --delay-start to exclude warm-up periods")
-			fmt.Println("   - Combine flags for comprehensive analysis")
+			fmt.Fprintln(progress, "\nTips:")
+			fmt.Fprintln(progress, "   - Use --generate-flamegraph to visualize call stacks")
+			fmt.Fprintln(progress, "   - Use --generate-heatmap to see performance over time")
+			fmt.Fprintln(progress, "   - Use --delay-start to exclude warm-up periods")
+			fmt.Fprintln(progress, "   - Combine flags for comprehensive analysis")
 		} else {
-			fmt.Printf("%s\n", finalOutputDir)
+			fmt.Fprintf(progress, "%s\n", finalOutputDir)
 		}
 
-		return nil
+		// 7. Subir el tarball de resultados
+		if uploadURL != "" {
+			if err := uploadResults(finalOutputDir); err != nil {
+				return err
+			}
+		}
+
+		// 8. Evaluar condiciones --fail-on-* y alertas
+		return evaluateIssues(analysisReport)
 	},
 }
 
+// evaluateIssues applies --alert-function-threshold and --fail-on-anomalies to
+// a report and returns an issuesFoundError when any of them triggers, or a
+// plain error when anomalies were to be checked but detection did not run
+func evaluateIssues(report *analysis.AnalysisResult) error {
+	if report == nil {
+		return nil
+	}
+	if alertThreshold > 0 {
+		offenders := report.FunctionsAbove(alertThreshold)
+		for _, fn := range offenders {
+			fmt.Fprintf(os.Stderr, "ALERT: %s at %.0f%%\n", fn.Name, fn.Percentage)
+		}
+		if len(offenders) > 0 {
+			return &issuesFoundError{reason: fmt.Sprintf("%d functions above %.0f%% (--alert-function-threshold)", len(offenders), alertThreshold)}
+		}
+	}
+	if failOnAnomalies {
+		// Without a heatmap nothing was checked, which must not pass as clean
+		if report.Patterns == nil {
+			return fmt.Errorf("--fail-on-anomalies: anomaly detection did not run (no heatmap was generated)")
+		}
+		if n := len(report.Patterns.Anomalies); n > 0 {
+			return &issuesFoundError{reason: fmt.Sprintf("%d anomalies detected (--fail-on-anomalies)", n)}
+		}
+	}
+	return nil
+}
+
 func init() {
 	// Target flags
 	rootCmd.PersistentFlags().StringVarP(&processName, "process", "p", "", "Name of the process to analyze (e.g., 'mariadbd', 'nginx')")
 	rootCmd.PersistentFlags().IntVar(&pid, "pid", 0, "PID of the process to analyze (e.g., 1234)")
+	rootCmd.PersistentFlags().StringVar(&pidFile, "pid-from-file", "", "Read the PID of the process to analyze from a pidfile (e.g., /run/nginx.pid)")
+	rootCmd.PersistentFlags().StringVar(&systemdUnit, "systemd-unit", "", "Analyze the main process of a systemd unit (e.g., 'mariadb.service')")
+	rootCmd.PersistentFlags().BoolVarP(&systemWide, "system-wide", "a", false, "Profile every process on the host (perf record -a) instead of one target")
+	rootCmd.PersistentFlags().StringVar(&cgroupPath, "cgroup", "", "Profile every process in this cgroup (perf record -a -G), e.g. /system.slice/docker-<id>.scope")
+	rootCmd.PersistentFlags().StringVar(&containerID, "container", "", "Profile every process in this container (Docker, Podman, containerd), by ID as shown by docker ps")
+	rootCmd.PersistentFlags().BoolVar(&allMatching, "all-matching", false, "With --process, attach to every matching process (e.g. all nginx workers); without it several matches are an error")
+	rootCmd.PersistentFlags().BoolVar(&exactMatch, "exact-match", false, "With --process, match the whole process name (pgrep -x) so 'mysql' does not match mysqld_safe or mysql_exporter")
 
 	// Timing flags
 	rootCmd.PersistentFlags().IntVarP(&duration, "duration", "d", 30, "Capture duration in seconds (default: 30)")
 	rootCmd.PersistentFlags().IntVar(&profileWindow, "profile-window", 0, "Profiling window duration in seconds (alternative to --duration)")
 	rootCmd.PersistentFlags().IntVar(&delayStart, "delay-start", 0, "Delay in seconds before starting capture (useful for excluding warm-up)")
+	rootCmd.PersistentFlags().IntVar(&sampleCount, "sample-count", 0, "Capture exactly N samples instead of a fixed duration")
+	rootCmd.PersistentFlags().BoolVar(&snapshotMode, "snapshot", false, "Always-on mode: keep a rolling buffer and write perf.data on SIGUSR1 (or --trigger-file)")
+	rootCmd.PersistentFlags().StringVar(&triggerFile, "trigger-file", "", "In --snapshot mode, write the snapshot when this file is created")
+	rootCmd.PersistentFlags().Float64Var(&triggerCPU, "trigger-cpu", 0, "Wait until the target uses more than this % CPU (of one core, as in top) before recording")
+
+	// Sampling flags
+	rootCmd.PersistentFlags().StringVar(&selfAffinity, "self-affinity", "", "Pin the analyzer and its perf child to these CPUs (e.g. 0-1,8) to keep it off the cores under study")
+	rootCmd.PersistentFlags().StringVar(&perfPath, "perf-path", "", "Path to the perf binary to use (overrides detection; env: BLC_PERF_BINARY)")
+	rootCmd.PersistentFlags().BoolVar(&noDownload, "no-download", false, "Never download flamegraph.pl: fail unless it is in PATH or already cached, for air-gapped hosts")
+	rootCmd.PersistentFlags().BoolVar(&guestMode, "guest", false, "Record KVM guest samples with perf kvm (target the VM's qemu process)")
+	rootCmd.PersistentFlags().BoolVar(&hostMode, "host", false, "Record host samples with perf kvm (combine with --guest for a guest-vs-host split)")
+	rootCmd.PersistentFlags().BoolVar(&withStat, "with-stat", false, "Also run perf stat on the target for the capture duration and add IPC, cache/branch miss rates and context switches to the summary")
+	rootCmd.PersistentFlags().StringVar(&callGraph, "call-graph", "fp", "Call-graph recording mode: fp (frame pointers), dwarf[,size] (for binaries built without frame pointers; size is the stack bytes copied per sample, default 8192) or lbr (Intel LBR call stacks)")
+	rootCmd.PersistentFlags().BoolVar(&branchStack, "branch-mispredictions", false, "Also record taken branches (perf record -j any,u, Intel LBR) and report the top branch misprediction sources")
+	rootCmd.PersistentFlags().StringArrayVarP(&events, "event", "e", nil, "perf event to record instead of the default cycles, e.g. cache-misses or page-faults (repeatable; see perf list)")
+	rootCmd.PersistentFlags().IntVarP(&frequency, "frequency", "F", 0, "Sampling frequency in Hz passed to perf record -F (default: perf's own, usually 4000)")
+
+	// Symbol flags
+	rootCmd.PersistentFlags().StringVar(&debugDir, "debug-dir", "", "Directory with split debug symbols (e.g., /usr/lib/debug) used to resolve stripped binaries")
 
 	// Output flags
-	rootCmd.PersistentFlags().StringVar(&outputDir, "output-dir", "", "Output directory for results (default: auto-generated with timestamp)")
+	rootCmd.PersistentFlags().StringVarP(&outputDir, "output-dir", "o", "", "Output directory for results, created if needed (default: auto-generated with timestamp)")
+	rootCmd.PersistentFlags().BoolVar(&forceOutput, "force", false, "Write into an existing, non-empty --output-dir")
 	rootCmd.PersistentFlags().BoolVarP(&quietMode, "quiet", "q", false, "Quiet mode: minimal output, prints only result directory path")
+	rootCmd.PersistentFlags().BoolVar(&keepPerfData, "keep-perf-data", true, "Keep perf.data after analysis (--keep-perf-data=false is --perf-data delete)")
+	rootCmd.PersistentFlags().StringVar(&perfDataMode, "perf-data", capture.PerfDataKeep, "What to do with perf.data once the analysis succeeded: keep, delete, or gzip (compress it to perf.data.gz)")
+	rootCmd.PersistentFlags().StringVar(&uploadURL, "upload", "", "Upload a .tar.gz of the results with HTTP PUT to this URL (e.g. a presigned S3/GCS URL; a trailing / appends the file name)")
+	rootCmd.PersistentFlags().StringArrayVar(&redactPatterns, "redact", nil, "Mask symbols and modules matching this regular expression (e.g. '^acme::') in every generated file (repeatable)")
+	rootCmd.PersistentFlags().BoolVar(&summaryToStdout, "summary-to-stdout", false, "Print the text summary to stdout and write no files (same as --output-dir -)")
+	rootCmd.PersistentFlags().BoolVar(&jsonOutput, "json", false, "Print the summary as JSON to stdout (as saved in summary.json, which is still written); progress goes to stderr")
 
 	// Analysis flags
 	rootCmd.PersistentFlags().BoolVar(&generateFlamegraph, "generate-flamegraph", false, "Generate a flamegraph SVG visualization")
+	rootCmd.PersistentFlags().StringVar(&flamegraphTitle, "flamegraph-title", "", "Flamegraph title (default: \"CPU Flame Graph\")")
+	rootCmd.PersistentFlags().IntVar(&flamegraphWidth, "flamegraph-width", 0, "Flamegraph width in pixels (default: 1200)")
+	rootCmd.PersistentFlags().IntVar(&flamegraphHeight, "flamegraph-height", 0, "Flamegraph frame height in pixels (default: 16)")
+	rootCmd.PersistentFlags().StringVar(&flamegraphMinWidth, "flamegraph-min-width", "", "Omit flamegraph frames narrower than this, in pixels (e.g. 2) or % of samples (e.g. 0.5%)")
+	rootCmd.PersistentFlags().BoolVar(&flamegraphPerTID, "flamegraph-per-thread", false, "Also write a flamegraph-tid-<tid>.svg for each thread with 100+ samples, hottest 16 threads at most")
+	rootCmd.PersistentFlags().StringVar(&flamegraphDiff, "flamegraph-diff", "", "Also write flamegraph-diff.svg comparing this capture with a baseline result directory (or its perf.folded/perf.data): red = hotter now, blue = colder")
+	rootCmd.PersistentFlags().BoolVar(&flamegraphInverted, "flamegraph-inverted", false, "Also write flamegraph-inverted.svg, an icicle graph merged from the leaf that shows which functions dominate regardless of caller")
+	rootCmd.PersistentFlags().BoolVar(&flamegraphByThread, "flamegraph-by-thread", false, "Also write flamegraph-threads.svg, where every thread is a separate tower rooted at <comm>-<tid>")
 	rootCmd.PersistentFlags().BoolVar(&generateHeatmap, "generate-heatmap", false, "Generate an interactive temporal heatmap")
+	rootCmd.PersistentFlags().BoolVar(&generateSummary, "generate-summary", false, "Analyze the capture and write summary.json/summary.txt (also written with any other output)")
+	rootCmd.PersistentFlags().BoolVar(&generatePerfReport, "generate-perf-report", false, "Write perf-report.txt (runs an extra perf report pass)")
+	rootCmd.PersistentFlags().BoolVar(&generatePprof, "generate-pprof", false, "Write profile.pb.gz, a pprof profile for go tool pprof")
+	rootCmd.PersistentFlags().BoolVar(&markdownSummary, "markdown", false, "Also write summary.md, the summary with Markdown tables for incident tickets and pull requests")
+	rootCmd.PersistentFlags().IntVar(&topN, "top-n", analysis.DefaultTopN, "Number of top functions and modules listed in summary.txt (functions also in summary.md; summary.json has all of them)")
 	rootCmd.PersistentFlags().Float64Var(&heatmapWindowSize, "heatmap-window-size", 1.0, "Time window size in seconds for heatmap (default: 1.0)")
+	rootCmd.PersistentFlags().BoolVar(&heatmapAnimate, "heatmap-animate", false, "Add a chart to heatmap.html that plays the function distribution window by window, with a slider")
+	rootCmd.PersistentFlags().BoolVar(&heatmapCSV, "heatmap-csv", false, "Also write the time windows to heatmap-data.csv, one row per window with the top function counts")
+	rootCmd.PersistentFlags().StringVar(&heatmapTheme, "heatmap-theme", heatmap.ThemeDark, "Color theme of heatmap.html: dark, or light for reports and printing")
+	rootCmd.PersistentFlags().BoolVar(&offlineAssets, "offline-assets", false, "Inline the Plotly library into heatmap.html instead of loading it from the CDN, so it renders without network access")
+	rootCmd.PersistentFlags().Float64Var(&ratioShiftDelta, "ratio-shift-delta", heatmap.DefaultRatioShiftDelta, "Kernel percentage swing between adjacent heatmap windows reported as a ratio_shift anomaly")
+	rootCmd.PersistentFlags().Float64Var(&patternThresholds.LockContentionPct, "lock-threshold", heatmap.DefaultLockContentionPct, "Percentage of a heatmap window's samples in lock functions reported as a lock_contention anomaly")
+	rootCmd.PersistentFlags().Float64Var(&patternThresholds.SyscallPct, "syscall-threshold", heatmap.DefaultSyscallPct, "Percentage of a heatmap window's samples in the kernel reported as a high_syscall anomaly")
+	rootCmd.PersistentFlags().Float64Var(&patternThresholds.SpikeMultiplier, "spike-multiplier", heatmap.DefaultSpikeMultiplier, "Samples of a heatmap window, relative to the average window, reported as a cpu_spike anomaly")
+	rootCmd.PersistentFlags().BoolVar(&includeIdle, "include-idle", false, "Keep idle task (swapper, PID 0) samples in the profile instead of reporting them as idle CPU %")
+	rootCmd.PersistentFlags().StringVar(&callersOf, "callers-of", "", "Report the top immediate callers of a function (e.g. 'malloc') in the summary")
+	rootCmd.PersistentFlags().BoolVar(&compareThreads, "compare-threads", false, "Compare the threads' function distributions and report threads whose profile diverges from their peers")
+	rootCmd.PersistentFlags().BoolVar(&weightByPeriod, "weight-by-period", false, "Count each sample for its period (the events it stands for) instead of 1 in the summary, heatmap, flamegraphs and pprof profile")
+	rootCmd.PersistentFlags().IntVar(&annotateTop, "annotate-top", 0, "Save perf annotate output for the top N functions under annotations/")
+	rootCmd.PersistentFlags().StringVar(&cpuFilterSpec, "cpu-filter", "", "Analyze only samples taken on these CPUs, e.g. 0-7 (re-slices a system-wide capture)")
+	rootCmd.PersistentFlags().StringArrayVar(&excludeSpecs, "exclude-thread", nil, "Leave a thread out of the summary and heatmap, by TID or name pattern, e.g. 'log-*' (repeatable)")
+	rootCmd.PersistentFlags().StringArrayVar(&markSpecs, "mark", nil, "Wall-clock event marker for the heatmap charts, e.g. '14:32:05=deploy' (repeatable)")
+
+	// Exit code flags
+	rootCmd.PersistentFlags().BoolVar(&failOnAnomalies, "fail-on-anomalies", false, "Exit with code 2 if the heatmap analysis detects anomalies")
+	rootCmd.PersistentFlags().Float64Var(&alertThreshold, "alert-function-threshold", 0, "Print an ALERT to stderr and exit with code 2 if any function exceeds this percentage of samples")
 
 	// Version flag
 	rootCmd.PersistentFlags().BoolVarP(&showVersion, "version", "v", false, "Show version information")
 
 	// Validation
-	rootCmd.MarkFlagsMutuallyExclusive("process", "pid")
+	rootCmd.MarkFlagsMutuallyExclusive("process", "pid", "pid-from-file", "systemd-unit", "system-wide")
 	rootCmd.MarkFlagsMutuallyExclusive("duration", "profile-window")
+	rootCmd.MarkFlagsMutuallyExclusive("sample-count", "duration")
+	rootCmd.MarkFlagsMutuallyExclusive("sample-count", "profile-window")
+	rootCmd.MarkFlagsMutuallyExclusive("snapshot", "duration")
+	rootCmd.MarkFlagsMutuallyExclusive("snapshot", "profile-window")
+	rootCmd.MarkFlagsMutuallyExclusive("snapshot", "sample-count")
 
 	// Add custom validation
 	rootCmd.PreRunE = func(cmd *cobra.Command, args []string) error {
@@ -189,41 +530,607 @@ func init() {
 			os.Exit(0)
 		}
 
-		if processName == "" && pid == 0 {
-			return fmt.Errorf("either --process or --pid must be specified")
+		if err := validateCaptureFlags(); err != nil {
+			return err
 		}
-		if processName != "" {
-			// Check if process name looks like a number
-			if _, err := strconv.Atoi(processName); err == nil {
-				return fmt.Errorf("--process flag expects a process name (e.g., 'mariadbd'), not a number. Use --pid for process IDs")
-			}
+		effectiveDuration := effectiveCaptureDuration()
+		untimed := sampleCount > 0 || snapshotMode
+
+		if err := checkSummaryToStdout(); err != nil {
+			return err
+		}
+		if err := checkOutputDir(); err != nil {
+			return err
+		}
+		if callersOf != "" && !analysisRequested() {
+			return fmt.Errorf("--callers-of requires an analysis output (e.g. --generate-summary)")
 		}
-		if pid != 0 && pid < 1 {
-			return fmt.Errorf("PID must be a positive number")
+		if compareThreads && !analysisRequested() {
+			return fmt.Errorf("--compare-threads requires an analysis output (e.g. --generate-summary)")
+		}
+		if weightByPeriod && !analysisRequested() {
+			return fmt.Errorf("--weight-by-period requires an analysis output (e.g. --generate-summary)")
+		}
+		if annotateTop < 0 {
+			return fmt.Errorf("annotate-top cannot be negative")
+		}
+		if topN <= 0 {
+			return fmt.Errorf("top-n must be positive")
+		}
+		if annotateTop > 0 && (!analysisRequested() || summaryToStdout) {
+			return fmt.Errorf("--annotate-top requires an analysis output (e.g. --generate-summary)")
 		}
 
-		// Timing validations
-		effectiveDuration := duration
-		if profileWindow > 0 {
-			effectiveDuration = profileWindow
+		if failOnAnomalies && !generateHeatmap {
+			return fmt.Errorf("--fail-on-anomalies requires --generate-heatmap")
 		}
-		if effectiveDuration < 1 {
-			return fmt.Errorf("duration or profile-window must be at least 1 second")
+
+		if alertThreshold < 0 || alertThreshold > 100 {
+			return fmt.Errorf("alert-function-threshold must be between 0 and 100")
 		}
-		if delayStart < 0 {
-			return fmt.Errorf("delay-start cannot be negative")
+		if alertThreshold > 0 && !analysisRequested() {
+			return fmt.Errorf("--alert-function-threshold requires an analysis output (e.g. --generate-summary)")
 		}
 
 		// Heatmap validations
 		if heatmapWindowSize <= 0 {
 			return fmt.Errorf("heatmap window size must be positive")
 		}
-		if heatmapWindowSize > float64(effectiveDuration) {
+		if !untimed && heatmapWindowSize > float64(effectiveDuration) {
 			return fmt.Errorf("heatmap window size cannot be larger than capture duration")
 		}
+		if ratioShiftDelta <= 0 || ratioShiftDelta > 100 {
+			return fmt.Errorf("ratio-shift-delta must be between 0 and 100")
+		}
+		if err := checkPatternThresholds(); err != nil {
+			return err
+		}
+		if len(markSpecs) > 0 && !generateHeatmap {
+			return fmt.Errorf("--mark requires --generate-heatmap")
+		}
+		if flamegraphByThread && !generateFlamegraph {
+			return fmt.Errorf("--flamegraph-by-thread requires --generate-flamegraph")
+		}
+		if flamegraphInverted && !generateFlamegraph {
+			return fmt.Errorf("--flamegraph-inverted requires --generate-flamegraph")
+		}
+		if flamegraphPerTID && !generateFlamegraph {
+			return fmt.Errorf("--flamegraph-per-thread requires --generate-flamegraph")
+		}
+		if flamegraphDiff != "" && !generateFlamegraph {
+			return fmt.Errorf("--flamegraph-diff requires --generate-flamegraph")
+		}
+		if err := checkFlamegraphDiff(); err != nil {
+			return err
+		}
+		if heatmapAnimate && !generateHeatmap {
+			return fmt.Errorf("--heatmap-animate requires --generate-heatmap")
+		}
+		if heatmapCSV && !generateHeatmap {
+			return fmt.Errorf("--heatmap-csv requires --generate-heatmap")
+		}
+		if err := checkOfflineAssets(); err != nil {
+			return err
+		}
+		if err := checkHeatmapTheme(); err != nil {
+			return err
+		}
+		if err := checkPerfDataMode(cmd); err != nil {
+			return err
+		}
+		markers = markers[:0]
+		for _, spec := range markSpecs {
+			marker, err := heatmap.ParseMarker(spec)
+			if err != nil {
+				return err
+			}
+			markers = append(markers, marker)
+		}
+
+		if len(excludeSpecs) > 0 && !analysisRequested() {
+			return fmt.Errorf("--exclude-thread requires an analysis output (e.g. --generate-summary)")
+		}
+		if cpuFilterSpec != "" && !analysisRequested() {
+			return fmt.Errorf("--cpu-filter requires an analysis output (e.g. --generate-summary)")
+		}
+		if len(redactPatterns) > 0 && !analysisRequested() {
+			return fmt.Errorf("--redact requires an analysis output (e.g. --generate-summary)")
+		}
+		if len(redactPatterns) > 0 && (generatePerfReport || annotateTop > 0) {
+			return fmt.Errorf("--redact cannot be combined with --generate-perf-report or --annotate-top (raw perf output is not redacted)")
+		}
+		if err := flamegraphOptions().Validate(); err != nil {
+			return err
+		}
+		if err := parseRedact(); err != nil {
+			return err
+		}
+		if err := parseCPUFilter(); err != nil {
+			return err
+		}
+		return parseExcludeThreads()
+	}
+}
+
+// validateCaptureFlags checks the target, timing and sampling flags shared
+// by every command that runs a capture
+func validateCaptureFlags() error {
+	if processName == "" && pid == 0 && pidFile == "" && systemdUnit == "" && !systemWide && cgroupPath == "" && containerID == "" {
+		return fmt.Errorf("one of --process, --pid, --pid-from-file, --systemd-unit, --container, --cgroup or --system-wide must be specified")
+	}
+	if cgroupPath != "" || containerID != "" {
+		flag := "--cgroup"
+		if containerID != "" {
+			flag = "--container"
+		}
+		if (cgroupPath != "" && containerID != "") || processName != "" || pid != 0 || pidFile != "" || systemdUnit != "" || systemWide {
+			return fmt.Errorf("%s cannot be combined with another target (--process, --pid, --pid-from-file, --systemd-unit, --cgroup, --container or --system-wide)", flag)
+		}
+		if triggerCPU > 0 {
+			return fmt.Errorf("--trigger-cpu watches a target process and cannot be combined with %s", flag)
+		}
+		if guestMode || hostMode {
+			return fmt.Errorf("%s cannot be combined with --guest or --host", flag)
+		}
+	}
+	if processName != "" {
+		// Check if process name looks like a number
+		if _, err := strconv.Atoi(processName); err == nil {
+			return fmt.Errorf("--process flag expects a process name (e.g., 'mariadbd'), not a number. Use --pid for process IDs")
+		}
+	}
+	if pid != 0 && pid < 1 {
+		return fmt.Errorf("PID must be a positive number")
+	}
+	if allMatching && processName == "" {
+		return fmt.Errorf("--all-matching requires --process")
+	}
+	if exactMatch && processName == "" {
+		return fmt.Errorf("--exact-match requires --process")
+	}
+
+	// Timing validations
+	if sampleCount < 0 {
+		return fmt.Errorf("sample-count cannot be negative")
+	}
+	untimed := sampleCount > 0 || snapshotMode
+	if triggerFile != "" && !snapshotMode {
+		return fmt.Errorf("--trigger-file requires --snapshot")
+	}
+	if !untimed && effectiveCaptureDuration() < 1 {
+		return fmt.Errorf("duration or profile-window must be at least 1 second")
+	}
+	if delayStart < 0 {
+		return fmt.Errorf("delay-start cannot be negative")
+	}
+	if triggerCPU < 0 {
+		return fmt.Errorf("--trigger-cpu cannot be negative")
+	}
+	if triggerCPU > 0 && snapshotMode {
+		return fmt.Errorf("--trigger-cpu cannot be combined with --snapshot")
+	}
+	if triggerCPU > 0 && systemWide {
+		return fmt.Errorf("--trigger-cpu watches a target process and cannot be combined with --system-wide")
+	}
+	if withStat && untimed {
+		return fmt.Errorf("--with-stat requires a timed capture (--duration or --profile-window)")
+	}
+
+	// Sampling validations
+	if err := checkCallGraph(); err != nil {
+		return err
+	}
+	if branchStack && callGraph == "lbr" {
+		return fmt.Errorf("--branch-mispredictions cannot be combined with --call-graph lbr, which uses the LBR for call stacks")
+	}
+	for _, event := range events {
+		if strings.TrimSpace(event) == "" {
+			return fmt.Errorf("--event cannot be empty")
+		}
+	}
+	if frequency < 0 {
+		return fmt.Errorf("--frequency must be a positive number of Hz")
+	}
+	return nil
+}
+
+// effectiveCaptureDuration returns the capture duration in seconds,
+// --profile-window taking precedence over --duration
+func effectiveCaptureDuration() int {
+	if profileWindow > 0 {
+		return profileWindow
+	}
+	return duration
+}
+
+// progress receives progress messages and warnings. Commands whose stdout
+// carries a result (a summary, JSON, a metric or a path) point it to stderr
+// before doing anything else.
+var progress io.Writer = os.Stdout
 
+// progressWriter returns where progress goes for a run or analyze: stderr
+// when stdout carries the summary (--summary-to-stdout) or JSON (--json)
+func progressWriter() io.Writer {
+	if summaryToStdout || jsonOutput {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// quietProgress returns progress, or nil with --quiet, for library calls that
+// take an optional progress writer
+func quietProgress() io.Writer {
+	if quietMode {
+		return nil
+	}
+	return progress
+}
+
+// checkRequirements detects the system, installs perf when it is missing and
+// verifies that the current user may profile
+func checkRequirements() error {
+	sysInfo, err := detector.DetectSystem()
+	if err != nil {
+		return fmt.Errorf("error detecting system: %w", err)
+	}
+
+	if !sysInfo.PerfInstalled {
+		fmt.Fprintf(progress, "perf is not installed. Attempting to install on %s...\n", sysInfo.Distro)
+		if err := detector.InstallPerf(sysInfo.Distro, sysInfo.DistroLike); err != nil {
+			return fmt.Errorf("error installing perf: %w", err)
+		}
+	}
+
+	if err := detector.CheckPermissions(); err != nil {
+		return fmt.Errorf("error checking permissions: %w", err)
+	}
+	return nil
+}
+
+// checkCallGraph validates --call-graph: fp, lbr, dwarf, or dwarf,<size>
+// where size is the stack dump size perf accepts (a multiple of 8, at most
+// 65528 bytes)
+func checkCallGraph() error {
+	mode, size, hasSize := strings.Cut(callGraph, ",")
+	switch {
+	case (mode == "fp" || mode == "lbr") && !hasSize:
+		return nil
+	case mode == "dwarf" && !hasSize:
+		return nil
+	case mode == "dwarf":
+		n, err := strconv.Atoi(size)
+		if err != nil || n <= 0 || n%8 != 0 || n > 65528 {
+			return fmt.Errorf("invalid --call-graph dwarf stack size %q (expected a multiple of 8 up to 65528, e.g. dwarf,16384)", size)
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid --call-graph %q (expected fp, dwarf, dwarf,<size> or lbr)", callGraph)
+}
+
+// captureConfig builds the capture configuration from the target, timing and
+// sampling flags
+func captureConfig(outputDir string) *capture.CaptureConfig {
+	return &capture.CaptureConfig{
+		ProcessName: processName,
+		PID:         pid,
+		Duration:    effectiveCaptureDuration(),
+		DelayStart:  delayStart,
+		OutputDir:   outputDir,
+		QuietMode:   quietMode,
+		Progress:    progress,
+		CallGraph:   callGraph,
+		BranchStack: branchStack,
+		Frequency:   frequency,
+		Events:      events,
+		SystemWide:  systemWide,
+		Cgroup:      cgroupPath,
+		AllMatching: allMatching,
+		ExactMatch:  exactMatch,
+		SampleCount: sampleCount,
+		Snapshot:    snapshotMode,
+		TriggerFile: triggerFile,
+		Guest:       guestMode,
+		Host:        hostMode,
+		WithStat:    withStat,
+		TriggerCPU:  triggerCPU,
+		Log:         capture.NewEventLog(outputDir),
+	}
+}
+
+// analysisRequested reports whether the capture is analyzed (any report
+// output, or the summary on stdout) instead of only converted to text
+func analysisRequested() bool {
+	return generateFlamegraph || generateHeatmap || generateSummary || generatePerfReport || generatePprof || markdownSummary || summaryToStdout || jsonOutput
+}
+
+// flamegraphRequested reports whether an analysis writes the flamegraph: with
+// --generate-flamegraph, or when no output is chosen at all. The root command
+// does not analyze a capture without outputs, so only analyze gets the default.
+func flamegraphRequested() bool {
+	return generateFlamegraph || !analysisRequested()
+}
+
+// checkOfflineAssets validates --offline-assets before anything is captured
+func checkOfflineAssets() error {
+	if !offlineAssets {
+		return nil
+	}
+	if !generateHeatmap {
+		return fmt.Errorf("--offline-assets requires --generate-heatmap")
+	}
+	if !heatmap.OfflineAssetsAvailable() {
+		return fmt.Errorf("--offline-assets is not available: Plotly was not embedded in this build (run 'make assets' before building)")
+	}
+	return nil
+}
+
+// checkPatternThresholds validates --lock-threshold, --syscall-threshold and
+// --spike-multiplier
+func checkPatternThresholds() error {
+	if patternThresholds.LockContentionPct <= 0 || patternThresholds.LockContentionPct > 100 {
+		return fmt.Errorf("--lock-threshold must be between 0 and 100")
+	}
+	if patternThresholds.SyscallPct <= 0 || patternThresholds.SyscallPct > 100 {
+		return fmt.Errorf("--syscall-threshold must be between 0 and 100")
+	}
+	if patternThresholds.SpikeMultiplier <= 1 {
+		return fmt.Errorf("--spike-multiplier must be greater than 1")
+	}
+	return nil
+}
+
+// checkHeatmapTheme validates --heatmap-theme before anything is captured
+func checkHeatmapTheme() error {
+	if !heatmap.ValidTheme(heatmapTheme) {
+		return fmt.Errorf("--heatmap-theme must be %s or %s", heatmap.ThemeDark, heatmap.ThemeLight)
+	}
+	if heatmapTheme != heatmap.ThemeDark && !generateHeatmap {
+		return fmt.Errorf("--heatmap-theme requires --generate-heatmap")
+	}
+	return nil
+}
+
+// checkPerfDataMode validates --perf-data and folds --keep-perf-data=false
+// into it as delete
+func checkPerfDataMode(cmd *cobra.Command) error {
+	valid := false
+	for _, mode := range capture.PerfDataModes {
+		if perfDataMode == mode {
+			valid = true
+		}
+	}
+	if !valid {
+		return fmt.Errorf("invalid --perf-data %q (supported: %s)", perfDataMode, strings.Join(capture.PerfDataModes, ", "))
+	}
+	if !keepPerfData {
+		if cmd.Flags().Changed("perf-data") && perfDataMode != capture.PerfDataDelete {
+			return fmt.Errorf("--keep-perf-data=false conflicts with --perf-data %s", perfDataMode)
+		}
+		perfDataMode = capture.PerfDataDelete
+	}
+	return nil
+}
+
+// checkFlamegraphDiff verifies that the --flamegraph-diff baseline exists
+// before anything is captured
+func checkFlamegraphDiff() error {
+	if flamegraphDiff == "" {
+		return nil
+	}
+	if _, err := os.Stat(flamegraphDiff); err != nil {
+		return fmt.Errorf("invalid --flamegraph-diff baseline: %v", err)
+	}
+	return nil
+}
+
+// checkOutputDir refuses an --output-dir that already holds files unless
+// --force is given, so that reusing a path does not mix or overwrite the
+// results of an earlier run. A missing directory is created later.
+func checkOutputDir() error {
+	if outputDir == "" || forceOutput {
 		return nil
 	}
+	entries, err := os.ReadDir(outputDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("cannot use --output-dir %s: %v", outputDir, err)
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("output directory %s is not empty; use --force to write into it anyway", outputDir)
+	}
+	return nil
+}
+
+// checkSummaryToStdout accepts "--output-dir -" as --summary-to-stdout and
+// rejects the options that only make sense when files are written
+func checkSummaryToStdout() error {
+	if outputDir == "-" {
+		summaryToStdout = true
+		outputDir = ""
+	}
+	if !summaryToStdout {
+		return nil
+	}
+	if jsonOutput {
+		return fmt.Errorf("--summary-to-stdout cannot be combined with --json")
+	}
+	if outputDir != "" {
+		return fmt.Errorf("--summary-to-stdout writes no files and cannot be combined with --output-dir")
+	}
+	if generateFlamegraph {
+		return fmt.Errorf("--summary-to-stdout writes no files and cannot be combined with --generate-flamegraph")
+	}
+	if generateHeatmap {
+		return fmt.Errorf("--summary-to-stdout writes no files and cannot be combined with --generate-heatmap")
+	}
+	if generatePerfReport {
+		return fmt.Errorf("--summary-to-stdout writes no files and cannot be combined with --generate-perf-report")
+	}
+	if generatePprof {
+		return fmt.Errorf("--summary-to-stdout writes no files and cannot be combined with --generate-pprof")
+	}
+	if markdownSummary {
+		return fmt.Errorf("--summary-to-stdout writes no files and cannot be combined with --markdown")
+	}
+	if quietMode {
+		return fmt.Errorf("--summary-to-stdout cannot be combined with --quiet")
+	}
+	if uploadURL != "" {
+		return fmt.Errorf("--summary-to-stdout writes no files and cannot be combined with --upload")
+	}
+	return nil
+}
+
+// printJSON writes the summary of each report to w as in summary.json: an
+// object for a single report, an array for several
+func printJSON(w io.Writer, reports []*analysis.AnalysisResult) error {
+	documents := make([]json.RawMessage, len(reports))
+	for i, report := range reports {
+		content, err := report.SummaryJSON()
+		if err != nil {
+			return err
+		}
+		documents[i] = content
+	}
+
+	var content []byte
+	if len(documents) == 1 {
+		content = documents[0]
+	} else {
+		var err error
+		if content, err = json.MarshalIndent(documents, "", "  "); err != nil {
+			return fmt.Errorf("error marshaling summaries: %v", err)
+		}
+	}
+	_, err := fmt.Fprintf(w, "%s\n", content)
+	return err
+}
+
+// uploadResults archives the result directory next to it as <dir>.tar.gz and
+// uploads the archive to --upload
+func uploadResults(dir string) error {
+	archivePath := filepath.Clean(dir) + ".tar.gz"
+	if err := upload.CreateArchive(dir, archivePath); err != nil {
+		return err
+	}
+
+	destination, err := upload.Upload(archivePath, uploadURL)
+	if err != nil {
+		return fmt.Errorf("error uploading results: %v", err)
+	}
+	if !quietMode {
+		fmt.Fprintf(progress, "\nResults uploaded to: %s\n", destination)
+	}
+	return nil
+}
+
+// resolvePidSource sets pid from --pid-from-file or --systemd-unit, or the
+// cgroup from --container, and checks that perf can filter by the cgroup
+func resolvePidSource() error {
+	if containerID != "" {
+		resolved, err := process.ContainerCgroup(containerID)
+		if err != nil {
+			return fmt.Errorf("error resolving container: %w", err)
+		}
+		cgroupPath = resolved
+		if !quietMode {
+			fmt.Fprintf(progress, "Resolved container cgroup: %s\n", cgroupPath)
+		}
+	}
+	if cgroupPath != "" {
+		return detector.CheckCgroup(cgroupPath)
+	}
+
+	var err error
+	switch {
+	case pidFile != "":
+		pid, err = process.ReadPidFile(pidFile)
+	case systemdUnit != "":
+		pid, err = process.GetPidBySystemdUnit(systemdUnit)
+	default:
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error resolving target PID: %w", err)
+	}
+	if !quietMode {
+		fmt.Fprintf(progress, "Resolved target PID: %d\n", pid)
+	}
+	return nil
+}
+
+// flamegraphOptions returns the flamegraph rendering options from the
+// --flamegraph-* flags
+func flamegraphOptions() analysis.FlamegraphOptions {
+	return analysis.FlamegraphOptions{
+		Title:          flamegraphTitle,
+		Width:          flamegraphWidth,
+		Height:         flamegraphHeight,
+		MinWidth:       flamegraphMinWidth,
+		WeightByPeriod: weightByPeriod,
+	}
+}
+
+// parseRedact compiles the --redact patterns into redactor
+func parseRedact() error {
+	var err error
+	redactor, err = parser.NewRedactor(redactPatterns)
+	return err
+}
+
+// parseCPUFilter validates --cpu-filter into cpuFilter
+func parseCPUFilter() error {
+	cpuFilter = nil
+	if cpuFilterSpec == "" {
+		return nil
+	}
+	cpus, err := process.ParseCPUList(cpuFilterSpec)
+	if err != nil {
+		return fmt.Errorf("invalid --cpu-filter: %v", err)
+	}
+	cpuFilter = cpus
+	return nil
+}
+
+// parseExcludeThreads validates --exclude-thread selectors into excludeThreads
+func parseExcludeThreads() error {
+	excludeThreads = excludeThreads[:0]
+	for _, spec := range excludeSpecs {
+		matcher, err := parser.ParseThreadMatcher(spec)
+		if err != nil {
+			return fmt.Errorf("invalid --exclude-thread: %v", err)
+		}
+		excludeThreads = append(excludeThreads, matcher)
+	}
+	return nil
+}
+
+// applyExternalTools configures the perf binary from --perf-path or
+// BLC_PERF_BINARY, and whether flamegraph.pl may be downloaded (--no-download)
+func applyExternalTools() error {
+	analysis.SetNoDownload(noDownload)
+	if perfPath == "" {
+		perfPath = os.Getenv("BLC_PERF_BINARY")
+	}
+	if perfPath == "" {
+		return nil
+	}
+	return detector.SetPerfBinary(perfPath)
+}
+
+// applySelfAffinity pins the analyzer to the --self-affinity CPUs. Child
+// processes started afterwards (perf, flamegraph.pl) inherit the mask.
+func applySelfAffinity() error {
+	if selfAffinity == "" {
+		return nil
+	}
+	cpus, err := process.ParseCPUList(selfAffinity)
+	if err != nil {
+		return fmt.Errorf("invalid --self-affinity: %v", err)
+	}
+	return process.SetSelfAffinity(cpus)
 }
 
 func printVersion() {
@@ -237,9 +1144,22 @@ func printVersion() {
 	fmt.Println("License: MIT")
 }
 
+// exitCode maps the error returned by the command to a process exit code
+func exitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	var issues *issuesFoundError
+	if errors.As(err, &issues) {
+		return ExitIssuesFound
+	}
+	return ExitError
+}
+
 func main() {
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+	err := rootCmd.Execute()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
 	}
+	os.Exit(exitCode(err))
 }
@@ -1,7 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/santiagolertora/blc-perf-analyzer/internal/analysis"
+	"github.com/santiagolertora/blc-perf-analyzer/internal/heatmap"
 )
 
 func TestFlagValidation(t *testing.T) {
@@ -232,6 +242,51 @@ func TestOutputDirLogic(t *testing.T) {
 	}
 }
 
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"success", nil, ExitOK},
+		{"tool error", fmt.Errorf("error during capture: boom"), ExitError},
+		{"issues found", &issuesFoundError{reason: "3 anomalies detected"}, ExitIssuesFound},
+		{"wrapped issues found", fmt.Errorf("wrapped: %w", &issuesFoundError{reason: "x"}), ExitIssuesFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(tt.err); got != tt.want {
+				t.Errorf("exitCode() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateIssues(t *testing.T) {
+	defer func() { failOnAnomalies = false }()
+	failOnAnomalies = true
+
+	tests := []struct {
+		name     string
+		patterns *heatmap.PatternDetection
+		want     int
+	}{
+		{"no anomalies", &heatmap.PatternDetection{}, ExitOK},
+		{"anomalies", &heatmap.PatternDetection{Anomalies: []heatmap.Anomaly{{}}}, ExitIssuesFound},
+		{"detection did not run", nil, ExitError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := evaluateIssues(&analysis.AnalysisResult{Patterns: tt.patterns})
+			if got := exitCode(err); got != tt.want {
+				t.Errorf("exitCode(evaluateIssues()) = %d, want %d (%v)", got, tt.want, err)
+			}
+		})
+	}
+}
+
 func BenchmarkFlagValidation(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		// Simulate validation logic
@@ -250,3 +305,171 @@ func BenchmarkFlagValidation(b *testing.B) {
 		}
 	}
 }
+
+func TestInputName(t *testing.T) {
+	tests := map[string]string{
+		"/tmp/node1.perf-script.txt.gz": "node1.perf-script",
+		"capture.txt":                   "capture",
+		"dump":                          "dump",
+	}
+	for input, expected := range tests {
+		if got := inputName(input); got != expected {
+			t.Errorf("inputName(%q) = %q, want %q", input, got, expected)
+		}
+	}
+}
+
+func TestCheckCallGraph(t *testing.T) {
+	defer func(saved string) { callGraph = saved }(callGraph)
+
+	tests := map[string]bool{
+		"fp":          true,
+		"lbr":         true,
+		"dwarf":       true,
+		"dwarf,16384": true,
+		"dwarf,100":   false,
+		"dwarf,":      false,
+		"dwarf,99999": false,
+		"fp,8":        false,
+		"frame":       false,
+	}
+	for mode, valid := range tests {
+		callGraph = mode
+		if err := checkCallGraph(); (err == nil) != valid {
+			t.Errorf("checkCallGraph(%q) = %v, want valid=%v", mode, err, valid)
+		}
+	}
+}
+
+func TestPrintJSON(t *testing.T) {
+	report := &analysis.AnalysisResult{
+		Summary:      analysis.SummaryStats{TotalSamples: 10, ProcessName: "mariadbd"},
+		TopFunctions: []analysis.FunctionStats{{Name: "do_command", SelfSamples: 10, Percentage: 100}},
+	}
+
+	var out bytes.Buffer
+	if err := printJSON(&out, []*analysis.AnalysisResult{report}); err != nil {
+		t.Fatalf("printJSON failed: %v", err)
+	}
+	var single map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &single); err != nil {
+		t.Fatalf("Output is not a JSON object: %v\n%s", err, out.String())
+	}
+	if single["process_name"] != "mariadbd" || single["top_functions"] == nil {
+		t.Errorf("Unexpected summary: %v", single)
+	}
+
+	out.Reset()
+	if err := printJSON(&out, []*analysis.AnalysisResult{report, report}); err != nil {
+		t.Fatalf("printJSON failed: %v", err)
+	}
+	var several []map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &several); err != nil || len(several) != 2 {
+		t.Errorf("Expected an array of 2 summaries, got %v (%v)", several, err)
+	}
+}
+
+func TestFlamegraphRequested(t *testing.T) {
+	defer func() { generateFlamegraph, generateHeatmap, generatePerfReport = false, false, false }()
+	tests := []struct {
+		name               string
+		generateFlamegraph bool
+		generateHeatmap    bool
+		generatePerfReport bool
+		want               bool
+	}{
+		{"no outputs", false, false, false, true},
+		{"flamegraph", true, false, false, true},
+		{"heatmap only", false, true, false, false},
+		{"perf report only", false, false, true, false},
+		{"heatmap and flamegraph", true, true, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			generateFlamegraph, generateHeatmap, generatePerfReport = tt.generateFlamegraph, tt.generateHeatmap, tt.generatePerfReport
+			if got := flamegraphRequested(); got != tt.want {
+				t.Errorf("flamegraphRequested() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProgressWriter(t *testing.T) {
+	defer func() { summaryToStdout, jsonOutput = false, false }()
+	tests := []struct {
+		name            string
+		summaryToStdout bool
+		jsonOutput      bool
+		want            *os.File
+	}{
+		{"default", false, false, os.Stdout},
+		{"summary to stdout", true, false, os.Stderr},
+		{"json", false, true, os.Stderr},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			summaryToStdout, jsonOutput = tt.summaryToStdout, tt.jsonOutput
+			if got := progressWriter(); got != tt.want {
+				t.Errorf("progressWriter() = %v, want %v", got, tt.want.Name())
+			}
+		})
+	}
+}
+
+func TestAppendWatchRow(t *testing.T) {
+	report := &analysis.AnalysisResult{
+		Summary:      analysis.SummaryStats{TotalSamples: 200, KernelPercent: 25, UserlandPercent: 75},
+		TopFunctions: []analysis.FunctionStats{{Name: "do_command", SelfSamples: 80, Percentage: 40}},
+	}
+	start := time.Date(2024, 12, 16, 10, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), watchFileName)
+
+	for i := 0; i < 2; i++ {
+		row := newWatchRow(report, start.Add(time.Duration(i)*time.Minute), time.Minute)
+		if err := appendWatchRow(path, row); err != nil {
+			t.Fatalf("appendWatchRow failed: %v", err)
+		}
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %d:\n%s", len(lines), content)
+	}
+	var row watchRow
+	if err := json.Unmarshal([]byte(lines[1]), &row); err != nil {
+		t.Fatalf("Line is not JSON: %v", err)
+	}
+	if !row.Time.Equal(start.Add(time.Minute)) || row.TotalSamples != 200 || row.KernelPercent != 25 ||
+		row.TopFunction != "do_command" || row.TopFunctionPercent != 40 || row.DurationSeconds != 60 {
+		t.Errorf("Unexpected row: %+v", row)
+	}
+}
+
+func TestCheckOutputDir(t *testing.T) {
+	defer func() { outputDir, forceOutput = "", false }()
+	dir := t.TempDir()
+
+	outputDir = filepath.Join(dir, "new")
+	if err := checkOutputDir(); err != nil {
+		t.Errorf("A missing directory should be accepted: %v", err)
+	}
+	outputDir = dir
+	if err := checkOutputDir(); err != nil {
+		t.Errorf("An empty directory should be accepted: %v", err)
+	}
+
+	os.WriteFile(filepath.Join(dir, "summary.txt"), []byte("earlier run"), 0644)
+	if err := checkOutputDir(); err == nil || !strings.Contains(err.Error(), "--force") {
+		t.Errorf("Expected a non-empty directory to be refused, got %v", err)
+	}
+	forceOutput = true
+	if err := checkOutputDir(); err != nil {
+		t.Errorf("--force should accept a non-empty directory: %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/santiagolertora/blc-perf-analyzer/internal/analysis"
+	"github.com/santiagolertora/blc-perf-analyzer/internal/tui"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// TUI flags
+	tuiInput string
+)
+
+var tuiCmd = &cobra.Command{
+	Use:   "tui --input <perf.data|perf-script.txt>",
+	Short: "Browse a capture interactively in the terminal",
+	Long: `Browse a capture interactively in the terminal, for hosts where the HTML
+reports cannot be opened.
+
+The input may be a perf.data file or saved perf script text (optionally
+gzip-compressed). Three views are available:
+
+  1  Top functions by self samples
+  2  Threads; Enter restricts every view to the selected thread, a clears it
+  3  ASCII flamegraph; Enter focuses on the selected frame, Backspace goes up
+
+Up/Down, PgUp/PgDn, Home/End (or j/k) scroll, Tab switches views and q or
+Esc quits.`,
+	Args: cobra.NoArgs,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if tuiInput == "" {
+			return fmt.Errorf("--input is required")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
+		if err := applyExternalTools(); err != nil {
+			return err
+		}
+		if err := applySelfAffinity(); err != nil {
+			return err
+		}
+
+		samples, err := analysis.LoadSamples(tuiInput)
+		if err != nil {
+			return fmt.Errorf("error loading %s: %v", tuiInput, err)
+		}
+
+		if err := tui.NewBrowser(samples).Run(); err != nil {
+			return fmt.Errorf("error starting the terminal UI: %v", err)
+		}
+		return nil
+	},
+}
+
+func init() {
+	tuiCmd.Flags().StringVar(&tuiInput, "input", "", "perf.data file or perf script text (.gz accepted) to browse")
+
+	rootCmd.AddCommand(tuiCmd)
+}
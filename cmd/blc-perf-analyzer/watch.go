@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/santiagolertora/blc-perf-analyzer/internal/analysis"
+	"github.com/santiagolertora/blc-perf-analyzer/internal/capture"
+	"github.com/spf13/cobra"
+)
+
+// watchFileName is the time series appended to by the watch subcommand
+const watchFileName = "watch.jsonl"
+
+var (
+	// Watch flags
+	watchInterval int
+)
+
+// watchRow is one line of watch.jsonl: the summary of one capture window
+type watchRow struct {
+	Time               time.Time `json:"time"` // Start of the window
+	DurationSeconds    float64   `json:"duration_seconds"`
+	TotalSamples       int       `json:"total_samples"`
+	KernelPercent      float64   `json:"kernel_percent"`
+	UserlandPercent    float64   `json:"userland_percent"`
+	TopFunction        string    `json:"top_function,omitempty"`
+	TopFunctionPercent float64   `json:"top_function_percent,omitempty"`
+	Interrupted        bool      `json:"interrupted,omitempty"` // The window was cut short by Ctrl-C
+}
+
+var watchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Profile a target repeatedly and append a time series to watch.jsonl",
+	Long: `Capture the target for --interval seconds, analyze the capture, append one
+line to watch.jsonl in the output directory and start over, until
+interrupted. Each line holds the window's start time, sample count, kernel
+and userland percentages and top function, to spot gradual regressions over
+hours without re-running the tool by hand.
+
+Ctrl-C (or SIGTERM) stops the window being recorded, which is still analyzed
+and appended, and exits. Target and sampling flags are the same as for a
+regular run.
+
+Example:
+  blc-perf-analyzer watch --process mariadbd --interval 60 --output-dir /var/tmp/mariadb-watch
+  tail -f /var/tmp/mariadb-watch/watch.jsonl`,
+	Args: cobra.NoArgs,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if watchInterval < 1 {
+			return fmt.Errorf("--interval must be at least 1 second")
+		}
+		if cmd.Flags().Changed("duration") || cmd.Flags().Changed("profile-window") {
+			return fmt.Errorf("watch windows are set with --interval, not --duration or --profile-window")
+		}
+		if snapshotMode || sampleCount > 0 {
+			return fmt.Errorf("watch records fixed windows of --interval seconds and cannot be combined with --snapshot or --sample-count")
+		}
+		if err := validateCaptureFlags(); err != nil {
+			return err
+		}
+		if err := parseCPUFilter(); err != nil {
+			return err
+		}
+		return parseExcludeThreads()
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
+		if err := applyExternalTools(); err != nil {
+			return err
+		}
+		if err := applySelfAffinity(); err != nil {
+			return err
+		}
+		if err := checkRequirements(); err != nil {
+			return err
+		}
+		if err := resolvePidSource(); err != nil {
+			return err
+		}
+
+		watchDir := outputDir
+		if watchDir == "" {
+			timestamp := time.Now().Format("20060102-150405")
+			watchDir = filepath.Join(".", fmt.Sprintf("blc-perf-watch-%s", timestamp))
+		}
+		if err := os.MkdirAll(watchDir, 0755); err != nil {
+			return fmt.Errorf("error creating output directory: %v", err)
+		}
+		watchPath := filepath.Join(watchDir, watchFileName)
+		capture.NewEventLog(watchDir).Printf("started: %s", strings.Join(os.Args, " "))
+
+		// A signal ends the loop once the window being recorded is analyzed;
+		// capture.Capture stops perf itself
+		stopping := make(chan os.Signal, 1)
+		signal.Notify(stopping, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(stopping)
+
+		if !quietMode {
+			fmt.Fprintf(progress, "Watching in %d second windows, appending to %s (Ctrl-C to stop)\n", watchInterval, watchPath)
+		}
+		for {
+			row, err := watchWindow(watchDir)
+			stopped := false
+			select {
+			case <-stopping:
+				signal.Stop(stopping)
+				stopped = true
+			default:
+			}
+			if err != nil {
+				if stopped {
+					return nil
+				}
+				return err
+			}
+
+			if err := appendWatchRow(watchPath, row); err != nil {
+				return err
+			}
+			if !quietMode {
+				fmt.Fprintf(progress, "%s  %d samples  kernel %.1f%%  top %s (%.1f%%)\n",
+					row.Time.Format("15:04:05"), row.TotalSamples, row.KernelPercent, row.TopFunction, row.TopFunctionPercent)
+			}
+			if stopped || row.Interrupted {
+				return nil
+			}
+		}
+	},
+}
+
+// watchWindow captures and analyzes one window of --interval seconds in dir
+// and returns its row. perf.data is removed once analyzed.
+func watchWindow(dir string) (*watchRow, error) {
+	config := captureConfig(dir)
+	config.Duration = watchInterval
+	config.QuietMode = true
+	result, err := capture.Capture(config)
+	if err != nil {
+		config.Log.Printf("window capture failed: %v", err)
+		return nil, fmt.Errorf("error during capture: %w", err)
+	}
+	defer os.Remove(result.PerfDataPath)
+
+	window := result.EndTime.Sub(result.RecordStartTime)
+	report, err := analysis.GenerateReport(&analysis.ReportConfig{
+		PerfDataPath:   result.PerfDataPath,
+		OutputDir:      dir,
+		ProcessName:    processName,
+		PID:            pid,
+		PIDs:           result.PIDs,
+		Duration:       int(window.Seconds()),
+		ExcludeThreads: excludeThreads,
+		CPUFilter:      cpuFilter,
+		Target:         result.Target,
+		SummaryOnly:    true,
+		IncludeIdle:    includeIdle,
+		WeightByPeriod: weightByPeriod,
+		SystemWide:     systemWide,
+		Cgroup:         cgroupPath,
+		QuietMode:      true,
+	})
+	if err != nil {
+		config.Log.Printf("analysis failed: %v", err)
+		return nil, fmt.Errorf("error generating reports: %v", err)
+	}
+	config.Log.Printf("parsed %d samples, analysis finished", report.Summary.TotalSamples)
+
+	row := newWatchRow(report, result.RecordStartTime, window)
+	row.Interrupted = result.Interrupted
+	return row, nil
+}
+
+// newWatchRow summarizes the report of a window that started at start
+func newWatchRow(report *analysis.AnalysisResult, start time.Time, window time.Duration) *watchRow {
+	row := &watchRow{
+		Time:            start,
+		DurationSeconds: window.Seconds(),
+		TotalSamples:    report.Summary.TotalSamples,
+		KernelPercent:   report.Summary.KernelPercent,
+		UserlandPercent: report.Summary.UserlandPercent,
+	}
+	if len(report.TopFunctions) > 0 {
+		row.TopFunction = report.TopFunctions[0].Name
+		row.TopFunctionPercent = report.TopFunctions[0].Percentage
+	}
+	return row
+}
+
+// appendWatchRow appends row to the JSON lines file at path
+func appendWatchRow(path string, row *watchRow) error {
+	content, err := json.Marshal(row)
+	if err != nil {
+		return fmt.Errorf("error marshaling watch row: %v", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %v", path, err)
+	}
+	if _, err := f.Write(append(content, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing %s: %v", path, err)
+	}
+	return f.Close()
+}
+
+func init() {
+	watchCmd.Flags().IntVar(&watchInterval, "interval", 60, "Length in seconds of each capture window")
+
+	rootCmd.AddCommand(watchCmd)
+}
@@ -2,9 +2,19 @@ module github.com/santiagolertora/blc-perf-analyzer
 
 go 1.21
 
-require github.com/spf13/cobra v1.8.0
+require (
+	github.com/gdamore/tcell/v2 v2.7.4
+	github.com/spf13/cobra v1.8.0
+)
 
 require (
+	github.com/gdamore/encoding v1.0.0 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
+	github.com/lucasb-eyer/go-colorful v1.2.0 // indirect
+	github.com/mattn/go-runewidth v0.0.15 // indirect
+	github.com/rivo/uniseg v0.4.3 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
+	golang.org/x/sys v0.18.0 // indirect
+	golang.org/x/term v0.18.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
 )
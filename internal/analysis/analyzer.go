@@ -3,27 +3,135 @@ package analysis
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/santiagolertora/blc-perf-analyzer/internal/detector"
 	"github.com/santiagolertora/blc-perf-analyzer/internal/heatmap"
 	"github.com/santiagolertora/blc-perf-analyzer/internal/parser"
+	"github.com/santiagolertora/blc-perf-analyzer/internal/process"
 )
 
 // AnalysisResult contains the analysis results
 type AnalysisResult struct {
-	TopFunctions []FunctionStats `json:"top_functions"`
-	Summary      SummaryStats    `json:"summary"`
+	TopFunctions []FunctionStats           `json:"top_functions"`
+	ModuleStats  []ModuleStat              `json:"module_stats,omitempty"` // Self samples per module, busiest first
+	Summary      SummaryStats              `json:"summary"`
+	Patterns     *heatmap.PatternDetection `json:"patterns,omitempty"`
+	// EventHeatmaps are the directories, relative to the output directory,
+	// holding the heatmap of each event after the first one
+	EventHeatmaps []string `json:"event_heatmaps,omitempty"`
+	// ThreadFlamegraphs are the flamegraph-tid-<tid>.svg files written with
+	// FlamegraphPerTID, hottest thread first
+	ThreadFlamegraphs []string `json:"thread_flamegraphs,omitempty"`
+}
+
+// FunctionsAbove returns the functions whose share of samples exceeds threshold percent
+func (r *AnalysisResult) FunctionsAbove(threshold float64) []FunctionStats {
+	offenders := make([]FunctionStats, 0)
+	for _, fn := range r.TopFunctions {
+		if fn.Percentage > threshold {
+			offenders = append(offenders, fn)
+		}
+	}
+	return offenders
+}
+
+// DefaultTopN is the number of functions the summary lists by default;
+// summary.json always has all of them
+const DefaultTopN = 10
+
+// ReportConfig contains the configuration for report generation
+type ReportConfig struct {
+	PerfDataPath       string
+	OfflinePerfData    bool   // PerfDataPath was recorded earlier, maybe on another host: metadata comes from the samples and /proc is not read
+	ScriptPath         string // perf script text (optionally gzipped) used instead of PerfDataPath
+	InputFormat        string // Format of ScriptPath: InputFormatPerfScript (default) or InputFormatFtrace
+	StatPath           string // perf stat CSV recorded alongside the capture (--with-stat)
+	OutputDir          string
+	ProcessName        string
+	PID                int
+	PIDs               []int // Processes the capture attached to; several with --all-matching
+	Duration           int
+	GenerateFlamegraph bool   // Write flamegraph.svg and perf.folded
+	FlamegraphByThread bool   // With GenerateFlamegraph, also write flamegraph-threads.svg with one tower per thread
+	FlamegraphInverted bool   // With GenerateFlamegraph, also write flamegraph-inverted.svg, merged from the leaf
+	FlamegraphPerTID   bool   // With GenerateFlamegraph, also write a flamegraph-tid-<tid>.svg for each of the hottest threads
+	FlamegraphDiff     string // With GenerateFlamegraph, also write flamegraph-diff.svg against this earlier result directory, perf.folded or perf.data
+	Flamegraph         FlamegraphOptions
+	GeneratePerfReport bool // Write perf-report.txt (an extra perf report pass over perf.data)
+	GeneratePprof      bool // Write profile.pb.gz for go tool pprof
+	Markdown           bool // Also write the summary as summary.md
+	TopN               int  // Functions and modules listed in summary.txt and summary.md, DefaultTopN when 0
+	GenerateHeatmap    bool
+	HeatmapWindowSize  float64
+	HeatmapAnimate     bool      // Add the animated function distribution chart to heatmap.html
+	HeatmapOffline     bool      // Inline the embedded Plotly library into heatmap.html instead of using the CDN
+	HeatmapTheme       string    // Color theme of heatmap.html, heatmap.ThemeDark or heatmap.ThemeLight
+	HeatmapCSV         bool      // Also write the time windows to heatmap-data.csv
+	HostCores          int       // CPUs of the host that recorded the capture, for the single_thread_bottleneck anomaly (0: read from the perf.data header)
+	SampleLimit        int       // When > 0, only the first SampleLimit samples are analyzed
+	CaptureStart       time.Time // Wall-clock time perf started recording
+	LaunchTime         time.Time // Wall-clock time the capture started, before any delay (zero for existing data)
+	LaunchClock        float64   // CLOCK_MONOTONIC seconds at LaunchTime, comparable with sample timestamps (0 if unknown)
+	Markers            []*heatmap.Marker
+	RatioShiftDelta    float64
+	PatternThresholds  heatmap.PatternThresholds // Sensitivity of the lock_contention, high_syscall and cpu_spike anomalies
+	ExcludeThreads     []*parser.ThreadMatcher   // Samples from these threads are left out of the summary and heatmap
+	CPUFilter          []int                     // When set, only samples taken on these CPUs are analyzed
+	Target             *process.TargetInfo
+	SummaryOnly        bool             // Only compute the summary, without writing any file to OutputDir (not even summary.json/summary.txt)
+	AnnotateTop        int              // When > 0, save perf annotate output for this many top functions
+	CallersOf          string           // When set, report the immediate callers of this function
+	CompareThreads     bool             // Compare the threads' function distributions and report outliers
+	IncludeIdle        bool             // Keep idle task (swapper) samples in the profile instead of reporting them apart
+	SystemWide         bool             // The capture recorded every process (perf record -a): group by command
+	Cgroup             string           // The capture recorded every process of this cgroup (perf record -G): group by command
+	WeightByPeriod     bool             // Count each sample for its period instead of 1 in the percentages and heatmap
+	Redact             *parser.Redactor // Masks matching symbols and modules in every artifact (nil: no redaction)
+	QuietMode          bool             // Suppress progress messages and warnings; errors are still returned
+	Progress           io.Writer        // Where progress messages and warnings go (nil: stdout)
+}
+
+// progress returns the writer of progress messages, nil in quiet mode
+func (config *ReportConfig) progress() io.Writer {
+	if config.QuietMode {
+		return nil
+	}
+	if config.Progress == nil {
+		return os.Stdout
+	}
+	return config.Progress
+}
+
+// flamegraphOptions returns config.Flamegraph with the sample weighting of
+// the report
+func (config *ReportConfig) flamegraphOptions() FlamegraphOptions {
+	opts := config.Flamegraph
+	opts.WeightByPeriod = config.WeightByPeriod
+	return opts
+}
+
+// logf prints a progress message or warning unless config.QuietMode is set
+func (config *ReportConfig) logf(format string, args ...interface{}) {
+	if w := config.progress(); w != nil {
+		fmt.Fprintf(w, format, args...)
+	}
 }
 
 // FunctionStats contains statistics for a single function
 type FunctionStats struct {
 	Name            string  `json:"name"`
-	Type            string  `json:"type"` // "userland", "kernel", "unknown"
-	Percentage      float64 `json:"percentage"`
+	Type            string  `json:"type"`             // "userland", "kernel", "unknown"
+	Percentage      float64 `json:"percentage"`       // Self samples, share of all samples
+	TotalPercentage float64 `json:"total_percentage"` // Self and children samples, share of all samples
 	TotalSamples    int     `json:"total_samples"`
 	SelfSamples     int     `json:"self_samples"`
 	ChildrenSamples int     `json:"children_samples"`
@@ -31,85 +139,548 @@ type FunctionStats struct {
 
 // SummaryStats contains summary statistics
 type SummaryStats struct {
-	TotalSamples    int     `json:"total_samples"`
-	UserlandPercent float64 `json:"userland_percent"`
-	KernelPercent   float64 `json:"kernel_percent"`
-	UnknownPercent  float64 `json:"unknown_percent"`
-	CaptureDuration int     `json:"capture_duration"`
-	ProcessName     string  `json:"process_name"`
-	PID             int     `json:"pid"`
-}
-
-// GenerateReport generates a complete analysis report including flamegraph
-func GenerateReport(perfDataPath, outputDir string, processName string, pid int, duration int, generateHeatmapFlag bool, heatmapWindowSize float64) error {
-	// 1. Generate flamegraph
-	if err := generateFlamegraph(perfDataPath, outputDir); err != nil {
-		return fmt.Errorf("error generating flamegraph: %v", err)
+	TotalSamples      int                 `json:"total_samples"`
+	UserlandPercent   float64             `json:"userland_percent"`
+	KernelPercent     float64             `json:"kernel_percent"`
+	JITPercent        float64             `json:"jit_percent,omitempty"`        // Part of userland in JIT-compiled code resolved through a perf map (Java, Node.js)
+	GoRuntimePercent  float64             `json:"go_runtime_percent,omitempty"` // Part of userland spent in the Go runtime (allocation, GC, scheduler)
+	AllocatorPercent  float64             `json:"allocator_percent,omitempty"`  // Part of userland spent in the memory allocator (malloc/free, jemalloc, tcmalloc, operator new)
+	UnknownPercent    float64             `json:"unknown_percent"`
+	UnmappedPercent   float64             `json:"unmapped_percent,omitempty"`    // Leaf address not mapped to any module (JIT, lost mmap events)
+	NoSymbolPercent   float64             `json:"no_symbol_percent,omitempty"`   // Leaf in a known module but without a symbol (stripped binary)
+	MMPressurePercent float64             `json:"mm_pressure_percent,omitempty"` // Samples on the page-fault/page-table walk path
+	IdlePercent       float64             `json:"idle_percent,omitempty"`        // Idle CPU samples left out of the profile (system-wide captures)
+	GuestPercent      float64             `json:"guest_percent,omitempty"`       // Only set for perf kvm captures with guest samples
+	HostPercent       float64             `json:"host_percent,omitempty"`
+	CaptureDuration   int                 `json:"capture_duration"`
+	ProcessName       string              `json:"process_name"`
+	PID               int                 `json:"pid"`
+	PIDs              []int               `json:"pids,omitempty"` // Every profiled process, only set when several matched (--all-matching)
+	ProfileShape      *ProfileShape       `json:"profile_shape,omitempty"`
+	TopStacks         []StackStats        `json:"top_stacks,omitempty"`
+	KernelModules     []ModuleStat        `json:"kernel_modules,omitempty"` // Kernel modules (drivers) on the sampled stacks, by samples
+	CPUStability      *CPUStability       `json:"cpu_stability,omitempty"`
+	CPUFilter         string              `json:"cpu_filter,omitempty"` // CPUs the analysis was restricted to (--cpu-filter)
+	Verdict           string              `json:"verdict"`              // One-line summary for notifications
+	Target            *process.TargetInfo `json:"target,omitempty"`
+	SampleWarning     string              `json:"sample_warning,omitempty"` // Set when there are too few samples to trust the percentages
+	Annotations       []Annotation        `json:"annotations,omitempty"`
+	CallersOf         *CallerReport       `json:"callers_of,omitempty"`
+	EventComparison   *EventComparison    `json:"event_comparison,omitempty"` // Only set when several events were recorded
+	PerCgroup         []CgroupStats       `json:"per_cgroup,omitempty"`       // Only set for live captures, where PIDs can be mapped to cgroups
+	SystemWide        bool                `json:"system_wide,omitempty"`
+	Cgroup            string              `json:"cgroup,omitempty"`             // Cgroup the capture was restricted to (--cgroup, --container)
+	WeightedByPeriod  bool                `json:"weighted_by_period,omitempty"` // Percentages are shares of sample periods (--weight-by-period)
+	PerProcess        []ProcessStats      `json:"per_process,omitempty"`        // Only set for system-wide and cgroup captures
+	Counters          *StatCounters       `json:"counters,omitempty"`           // perf stat counters, only set with --with-stat
+	Timing            *CaptureTiming      `json:"timing,omitempty"`             // Only set for live captures
+	ThreadComparison  *ThreadComparison   `json:"thread_comparison,omitempty"`  // Only set with --compare-threads
+	Recommendations   []Recommendation    `json:"recommendations,omitempty"`
+	Branches          []BranchStats       `json:"branch_mispredictions,omitempty"` // Only set when perf.data has taken branches (--branch-mispredictions)
+}
+
+// GenerateReport analyzes a capture and writes the summary plus the artifacts
+// requested in config; perf script runs once for all of them. The returned result carries the summary and, when a heatmap was generated,
+// the detected patterns so callers can act on them.
+func GenerateReport(config *ReportConfig) (*AnalysisResult, error) {
+	// 1-2. Parse the samples once, streaming perf script output from
+	// perf.data or a saved text dump
+	samples, err := parseSamples(config)
+	if err != nil {
+		return nil, err
 	}
+	config.Redact.Samples(samples)
+	if config.PID == 0 && len(config.PIDs) > 0 {
+		config.PID = config.PIDs[0]
+	}
+	timing := computeCaptureTiming(config, samples, time.Now())
 
-	// 2. Generate perf report
-	if err := generatePerfReport(perfDataPath, outputDir); err != nil {
-		return fmt.Errorf("error generating perf report: %v", err)
+	// Narrow the samples before any artifact is written, so the flamegraphs,
+	// the pprof profile, the heatmap and the summary all describe the same set
+	if config.SampleLimit > 0 && len(samples) > config.SampleLimit {
+		samples = samples[:config.SampleLimit]
+	}
+	if len(config.ExcludeThreads) > 0 {
+		var excluded int
+		samples, excluded = parser.ExcludeThreads(samples, config.ExcludeThreads)
+		config.logf("Excluded %d samples from filtered threads (%d remaining)\n", excluded, len(samples))
+	}
+	if len(config.CPUFilter) > 0 {
+		var filtered int
+		samples, filtered = parser.FilterCPUs(samples, config.CPUFilter)
+		config.logf("CPU filter %s matched %d samples (%d filtered out)\n", formatCPUList(config.CPUFilter), len(samples), filtered)
+	}
+	var idleSamples int
+	if !config.IncludeIdle {
+		active := make([]*parser.Sample, 0, len(samples))
+		for _, sample := range samples {
+			if !sample.IsIdle() {
+				active = append(active, sample)
+			}
+		}
+		idleSamples = len(samples) - len(active)
+		if idleSamples > 0 {
+			config.logf("Reporting %d idle CPU samples (swapper) separately\n", idleSamples)
+		}
+		samples = active
+	}
+	if config.ScriptPath != "" || config.OfflinePerfData {
+		fillScriptMetadata(config, samples)
 	}
 
-	// 3. Parse perf script output for advanced analysis
-	samples, err := parsePerfScriptData(perfDataPath)
-	if err != nil {
-		fmt.Printf("Warning: Could not parse perf script for advanced analysis: %v\n", err)
-		samples = []*parser.Sample{} // Continue with empty samples
+	// 3. Generate flamegraph if requested
+	var threadFlamegraphs []string
+	if config.GenerateFlamegraph && !config.SummaryOnly {
+		if err := generateFlamegraph(foldSamples(samples, false, config.WeightByPeriod), config); err != nil {
+			return nil, fmt.Errorf("error generating flamegraph: %v", err)
+		}
+		if config.FlamegraphByThread {
+			if err := generateThreadFlamegraph(samples, config); err != nil {
+				return nil, fmt.Errorf("error generating per-thread flamegraph: %v", err)
+			}
+		}
+		if config.FlamegraphInverted {
+			if err := generateInvertedFlamegraph(config); err != nil {
+				return nil, fmt.Errorf("error generating inverted flamegraph: %v", err)
+			}
+		}
+		if config.FlamegraphPerTID {
+			threadFlamegraphs, err = generatePerThreadFlamegraphs(samples, config)
+			if err != nil {
+				return nil, fmt.Errorf("error generating thread flamegraphs: %v", err)
+			}
+		}
+		if config.FlamegraphDiff != "" {
+			// The perf.folded just written is the after side of the diff
+			config.logf("Generating differential flamegraph against %s...\n", config.FlamegraphDiff)
+			if _, err := GenerateDiffFlamegraph(config.FlamegraphDiff, config.OutputDir, config.OutputDir, config.flamegraphOptions(), config.progress()); err != nil {
+				return nil, fmt.Errorf("error generating differential flamegraph: %v", err)
+			}
+		}
+	}
+
+	// 4. Generate perf report if requested (informational only, the summary uses parsed samples)
+	if config.GeneratePerfReport && config.PerfDataPath != "" && !config.SummaryOnly {
+		if err := generatePerfReport(config.PerfDataPath, config.OutputDir); err != nil {
+			config.logf("Warning: Could not generate perf-report.txt: %v\n", err)
+		}
+	}
+	if config.GeneratePprof && !config.SummaryOnly {
+		config.logf("Writing pprof profile...\n")
+		if err := ExportPprof(samples, filepath.Join(config.OutputDir, PprofFileName), config.WeightByPeriod); err != nil {
+			return nil, err
+		}
 	}
 
-	// 4. Generate heatmap if requested and samples available
-	if generateHeatmapFlag && len(samples) > 0 {
-		fmt.Println("Generating interactive heatmap...")
-		if err := heatmap.GenerateHeatmap(samples, outputDir, processName, pid, heatmapWindowSize); err != nil {
-			fmt.Printf("Warning: Could not generate heatmap: %v\n", err)
+	// 5. Generate heatmap if requested and samples available. Counts of
+	// different events can't share a timeline: with several events the one
+	// with the most samples drives heatmap.html and the anomaly detection,
+	// and every other event gets its own heatmap in a subdirectory
+	var patterns *heatmap.PatternDetection
+	var eventHeatmaps []string
+	if config.GenerateHeatmap && !config.SummaryOnly && len(samples) > 0 {
+		config.logf("Generating interactive heatmap...\n")
+		if config.HostCores == 0 && config.PerfDataPath != "" {
+			config.HostCores = perfDataCores(config.PerfDataPath)
+		}
+		heatmapSamples, event := samples, ""
+		events := eventsBySamples(samples)
+		if len(events) > 1 {
+			event = events[0]
+			heatmapSamples = samplesOfEvent(samples, event)
+		}
+		patterns, err = heatmap.GenerateHeatmap(heatmapSamples, heatmapConfig(config, config.OutputDir, event))
+		if err != nil {
+			config.logf("Warning: Could not generate heatmap: %v\n", err)
+		}
+
+		for i := 1; i < len(events); i++ {
+			dir := eventHeatmapDir(events[i])
+			config.logf("Generating heatmap for event %s...\n", events[i])
+			if err := os.MkdirAll(filepath.Join(config.OutputDir, dir), 0755); err != nil {
+				config.logf("Warning: Could not create %s: %v\n", dir, err)
+				continue
+			}
+			_, err := heatmap.GenerateHeatmap(samplesOfEvent(samples, events[i]), heatmapConfig(config, filepath.Join(config.OutputDir, dir), events[i]))
+			if err != nil {
+				config.logf("Warning: Could not generate heatmap for event %s: %v\n", events[i], err)
+				continue
+			}
+			eventHeatmaps = append(eventHeatmaps, dir)
 		}
 	}
 
-	// 5. Generate summary with parsed data
-	if err := generateSummary(perfDataPath, outputDir, processName, pid, duration, samples); err != nil {
-		return fmt.Errorf("error generating summary: %v", err)
+	// 6. Generate summary with parsed data
+	result := generateSummary(config, samples, patterns)
+	result.Patterns = patterns
+	result.EventHeatmaps = eventHeatmaps
+	result.ThreadFlamegraphs = threadFlamegraphs
+	result.Summary.Timing = timing
+	if idleSamples > 0 {
+		result.Summary.IdlePercent = float64(idleSamples) / float64(idleSamples+len(samples)) * 100
+	}
+	if config.PerfDataPath != "" {
+		branches, err := branchMispredictions(config.PerfDataPath, config.Redact)
+		if err != nil {
+			config.logf("Warning: Could not analyze branch records: %v\n", err)
+		}
+		result.Summary.Branches = branches
+	}
+	if config.SummaryOnly {
+		return result, nil
+	}
+	if config.AnnotateTop > 0 && config.PerfDataPath != "" {
+		config.logf("Annotating top %d functions...\n", config.AnnotateTop)
+		result.Summary.Annotations = generateAnnotations(config.PerfDataPath, config.OutputDir, result.TopFunctions, config.AnnotateTop, config.logf)
+	}
+	if err := writeSummary(config.OutputDir, result, config.TopN); err != nil {
+		return nil, fmt.Errorf("error generating summary: %v", err)
+	}
+	if config.Markdown {
+		if err := os.WriteFile(filepath.Join(config.OutputDir, "summary.md"), []byte(result.SummaryMarkdown(config.TopN)), 0644); err != nil {
+			return nil, fmt.Errorf("error saving summary.md: %v", err)
+		}
+	}
+	if len(result.Summary.Branches) > 0 {
+		if err := writeBranchReport(config.OutputDir, result.Summary.Branches); err != nil {
+			config.logf("Warning: Could not write branch-mispredictions.json: %v\n", err)
+		}
 	}
 
-	return nil
+	return result, nil
 }
 
-func generateFlamegraph(perfDataPath, outputDir string) error {
-	fmt.Println("Generating flamegraph...")
+// heatmapConfig builds the heatmap configuration for the samples of event
+// ("" when the capture has a single event), written to outputDir
+func heatmapConfig(config *ReportConfig, outputDir, event string) *heatmap.HeatmapConfig {
+	return &heatmap.HeatmapConfig{
+		OutputDir:       outputDir,
+		ProcessName:     config.ProcessName,
+		PID:             config.PID,
+		WindowSize:      config.HeatmapWindowSize,
+		CaptureStart:    config.CaptureStart,
+		Markers:         config.Markers,
+		RatioShiftDelta: config.RatioShiftDelta,
+		Thresholds:      config.PatternThresholds,
+		Animate:         config.HeatmapAnimate,
+		OfflineAssets:   config.HeatmapOffline,
+		Theme:           config.HeatmapTheme,
+		CSV:             config.HeatmapCSV,
+		QuietMode:       config.QuietMode,
+		Progress:        config.Progress,
+		Event:           event,
+		ByProcess:       config.SystemWide || config.Cgroup != "",
+		WeightByPeriod:  config.WeightByPeriod,
+		HostCores:       config.HostCores,
+	}
+}
 
-	// First, generate the folded stack
-	foldedPath := filepath.Join(outputDir, "perf.folded")
-	fmt.Println("Running perf script to generate stack traces...")
-	cmd := exec.Command("perf", "script", "-i", perfDataPath)
-	output, err := cmd.Output()
+// openPerfScript returns the perf script text for the report, read from
+// config.ScriptPath when set or streamed from perf script run on perf.data.
+// Closing it reports whether perf script succeeded.
+func openPerfScript(config *ReportConfig) (io.ReadCloser, error) {
+	if config.ScriptPath != "" {
+		config.logf("Reading perf script output from %s\n", config.ScriptPath)
+		input, err := parser.OpenInput(config.ScriptPath)
+		if err != nil {
+			return nil, fmt.Errorf("error opening perf script input: %v", err)
+		}
+		return input, nil
+	}
+
+	config.logf("Running perf script to generate stack traces...\n")
+	cmd := exec.Command(detector.PerfBinary(), detector.PerfArgs("script", "-i", config.PerfDataPath)...)
+	stdout, err := cmd.StdoutPipe()
 	if err != nil {
+		return nil, fmt.Errorf("error running perf script: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("error running perf script: %v", err)
+	}
+	return &perfScriptOutput{ReadCloser: stdout, cmd: cmd}, nil
+}
+
+// perfScriptOutput is the stdout of a running perf script
+type perfScriptOutput struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+// Close drains what the reader left unread, so that perf script is not
+// blocked writing to the pipe, and waits for it to exit
+func (p *perfScriptOutput) Close() error {
+	io.Copy(io.Discard, p.ReadCloser)
+	if err := p.cmd.Wait(); err != nil {
 		return fmt.Errorf("error running perf script: %v", err)
 	}
+	return nil
+}
+
+// readPerfScript returns the whole perf script text, for the parsers that
+// can't stream
+func readPerfScript(config *ReportConfig) (string, error) {
+	input, err := openPerfScript(config)
+	if err != nil {
+		return "", err
+	}
+
+	content, readErr := io.ReadAll(input)
+	if err := input.Close(); err != nil {
+		return "", err
+	}
+	if readErr != nil {
+		return "", fmt.Errorf("error reading perf script input: %v", readErr)
+	}
+	return string(content), nil
+}
+
+// perfDataMagic is the header of perf.data files
+const perfDataMagic = "PERFILE2"
+
+// LoadSamples parses the samples of a perf.data file or a saved perf script
+// text dump (optionally gzip-compressed), sorted by timestamp
+func LoadSamples(path string) ([]*parser.Sample, error) {
+	config := &ReportConfig{ScriptPath: path}
+	if IsPerfData(path) {
+		config = &ReportConfig{PerfDataPath: path}
+	}
+
+	return parsePerfScriptData(config)
+}
+
+// IsPerfData reports whether path starts with the perf.data magic
+func IsPerfData(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	magic := make([]byte, len(perfDataMagic))
+	if _, err := io.ReadFull(file, magic); err != nil {
+		return false
+	}
+	return string(magic) == perfDataMagic
+}
+
+// perfDataCores returns the number of CPUs online on the host that recorded
+// perfDataPath, read from its header, or 0 when perf can't tell
+func perfDataCores(perfDataPath string) int {
+	header, err := exec.Command(detector.PerfBinary(), detector.PerfArgs("script", "--header-only", "-i", perfDataPath)...).Output()
+	if err != nil {
+		return 0
+	}
+	return parseNrCPUs(string(header))
+}
+
+// parseNrCPUs returns the CPU count of a perf.data header, from its
+// "# nrcpus online : 8" line or else "# nrcpus avail : 8", 0 when missing
+func parseNrCPUs(header string) int {
+	cpus := make(map[string]int)
+	for _, line := range strings.Split(header, "\n") {
+		key, value, found := strings.Cut(strings.TrimPrefix(line, "# "), ":")
+		if !found || !strings.HasPrefix(key, "nrcpus ") {
+			continue
+		}
+		if n, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+			cpus[strings.TrimSpace(strings.TrimPrefix(key, "nrcpus "))] = n
+		}
+	}
+	if cpus["online"] > 0 {
+		return cpus["online"]
+	}
+	return cpus["avail"]
+}
+
+// profiledPIDs returns the PIDs recorded in the summary: all of them when the
+// capture attached to several processes, none otherwise (PID is enough)
+func profiledPIDs(pids []int) []int {
+	if len(pids) < 2 {
+		return nil
+	}
+	return pids
+}
+
+// formatPIDs lists PIDs for the summary text
+func formatPIDs(pids []int) string {
+	list := make([]string, len(pids))
+	for i, pid := range pids {
+		list[i] = strconv.Itoa(pid)
+	}
+	return strings.Join(list, ", ")
+}
+
+// fillScriptMetadata derives the process, PID and duration of a saved perf
+// script dump or perf.data from its samples, since no capture metadata is
+// available
+func fillScriptMetadata(config *ReportConfig, samples []*parser.Sample) {
+	if len(samples) == 0 {
+		return
+	}
+	if config.ProcessName == "" && config.PID == 0 && !config.SystemWide {
+		config.ProcessName = samples[0].Command
+		config.PID = samples[0].PID
+	}
+	if config.Duration <= 0 {
+		first, last := samples[0].Timestamp, samples[0].Timestamp
+		for _, sample := range samples {
+			if sample.Timestamp < first {
+				first = sample.Timestamp
+			}
+			if sample.Timestamp > last {
+				last = sample.Timestamp
+			}
+		}
+		config.Duration = int(math.Ceil(last - first))
+	}
+}
+
+func generateFlamegraph(folded string, config *ReportConfig) error {
+	config.logf("Generating flamegraph...\n")
+
+	// First, generate the folded stack
+	foldedPath := filepath.Join(config.OutputDir, "perf.folded")
 
 	// Process the output to create folded stacks
-	fmt.Println("Processing stack traces...")
-	foldedStacks := processPerfOutput(string(output))
+	config.logf("Processing stack traces...\n")
+	// The samples are already redacted. flamegraph.pl escapes markup itself,
+	// but the SVG must be valid UTF-8
+	foldedStacks := strings.ToValidUTF8(folded, "\uFFFD")
 	if err := os.WriteFile(foldedPath, []byte(foldedStacks), 0644); err != nil {
 		return fmt.Errorf("error writing folded stacks: %v", err)
 	}
 
+	// Generate the flamegraph
+	svgPath := filepath.Join(config.OutputDir, "flamegraph.svg")
+	if err := renderFlamegraph(foldedPath, svgPath, config.flamegraphOptions().args("CPU Flame Graph"), config.progress()); err != nil {
+		return err
+	}
+
+	config.logf("Flamegraph generation complete!\n")
+	return nil
+}
+
+// generateThreadFlamegraph writes perf-threads.folded and
+// flamegraph-threads.svg: the whole process with every stack rooted at a
+// "<comm>-<tid>" frame, so each thread forms its own tower. samples are
+// already redacted.
+func generateThreadFlamegraph(samples []*parser.Sample, config *ReportConfig) error {
+	config.logf("Generating per-thread flamegraph...\n")
+
+	foldedPath := filepath.Join(config.OutputDir, "perf-threads.folded")
+	folded := strings.ToValidUTF8(foldSamples(samples, true, config.WeightByPeriod), "\uFFFD")
+	if err := os.WriteFile(foldedPath, []byte(folded), 0644); err != nil {
+		return fmt.Errorf("error writing folded stacks: %v", err)
+	}
+
+	svgPath := filepath.Join(config.OutputDir, "flamegraph-threads.svg")
+	return renderFlamegraph(foldedPath, svgPath, config.flamegraphOptions().args("CPU Flame Graph by Thread"), config.progress())
+}
+
+const (
+	// minThreadFlamegraphSamples is the number of samples a thread needs for
+	// its own flamegraph
+	minThreadFlamegraphSamples = 100
+	// maxThreadFlamegraphs caps the flamegraph-tid-<tid>.svg files, so a
+	// process with thousands of threads does not flood the output directory
+	maxThreadFlamegraphs = 16
+)
+
+// generatePerThreadFlamegraphs writes flamegraph-tid-<tid>.svg for each of
+// the hottest threads with at least minThreadFlamegraphSamples samples, and
+// returns the file names. samples are already redacted.
+func generatePerThreadFlamegraphs(samples []*parser.Sample, config *ReportConfig) ([]string, error) {
+	byThread := make(map[int][]*parser.Sample)
+	for _, sample := range samples {
+		byThread[sample.TID] = append(byThread[sample.TID], sample)
+	}
+	tids, skipped := hottestThreads(byThread, minThreadFlamegraphSamples, maxThreadFlamegraphs)
+	if len(tids) == 0 {
+		config.logf("Warning: No thread has %d+ samples, no thread flamegraphs written\n", minThreadFlamegraphSamples)
+		return nil, nil
+	}
+	if skipped > 0 {
+		config.logf("Writing flamegraphs of the %d hottest threads (%d more threads with %d+ samples skipped)\n", len(tids), skipped, minThreadFlamegraphSamples)
+	}
+
+	files := make([]string, 0, len(tids))
+	for _, tid := range tids {
+		threadSamples := byThread[tid]
+		config.logf("Generating flamegraph of thread %d...\n", tid)
+
+		// The folded stacks are only an input to flamegraph.pl
+		foldedPath := filepath.Join(config.OutputDir, fmt.Sprintf("perf-tid-%d.folded", tid))
+		folded := strings.ToValidUTF8(foldSamples(threadSamples, false, config.WeightByPeriod), "\uFFFD")
+		if err := os.WriteFile(foldedPath, []byte(folded), 0644); err != nil {
+			return files, fmt.Errorf("error writing folded stacks: %v", err)
+		}
+
+		name := fmt.Sprintf("flamegraph-tid-%d.svg", tid)
+		title := fmt.Sprintf("CPU Flame Graph: %s thread %d", threadSamples[0].Command, tid)
+		err := renderFlamegraph(foldedPath, filepath.Join(config.OutputDir, name), config.flamegraphOptions().args(title), config.progress())
+		os.Remove(foldedPath)
+		if err != nil {
+			return files, err
+		}
+		files = append(files, name)
+	}
+	return files, nil
+}
+
+// hottestThreads returns the TIDs with at least minSamples samples, most
+// samples first, up to limit, and how many more qualified
+func hottestThreads(byThread map[int][]*parser.Sample, minSamples, limit int) ([]int, int) {
+	tids := make([]int, 0)
+	for tid, threadSamples := range byThread {
+		if len(threadSamples) >= minSamples {
+			tids = append(tids, tid)
+		}
+	}
+	sort.Slice(tids, func(i, j int) bool {
+		if len(byThread[tids[i]]) != len(byThread[tids[j]]) {
+			return len(byThread[tids[i]]) > len(byThread[tids[j]])
+		}
+		return tids[i] < tids[j]
+	})
+	if len(tids) > limit {
+		return tids[:limit], len(tids) - limit
+	}
+	return tids, 0
+}
+
+// generateInvertedFlamegraph writes flamegraph-inverted.svg from the
+// perf.folded written by generateFlamegraph, with the stacks merged from the
+// leaf: the top row shows which functions dominate regardless of caller
+func generateInvertedFlamegraph(config *ReportConfig) error {
+	config.logf("Generating inverted flamegraph...\n")
+
+	foldedPath := filepath.Join(config.OutputDir, "perf.folded")
+	svgPath := filepath.Join(config.OutputDir, "flamegraph-inverted.svg")
+	return renderFlamegraph(foldedPath, svgPath, config.flamegraphOptions().invertedArgs(), config.progress())
+}
+
+// renderFlamegraph runs flamegraph.pl with args on a folded stacks file and
+// saves the SVG. flamegraph.pl is downloaded into the cache when it is not in
+// PATH (see flamegraphScript). Progress messages go to progress unless it is
+// nil.
+func renderFlamegraph(foldedPath, svgPath string, args []string, progress io.Writer) error {
+	logf := func(format string, args ...interface{}) {
+		if progress != nil {
+			fmt.Fprintf(progress, format, args...)
+		}
+	}
+
 	// Check if flamegraph.pl is available
-	fmt.Println("Checking for flamegraph.pl...")
-	flamegraphPath, err := exec.LookPath("flamegraph.pl")
+	logf("Checking for flamegraph.pl...\n")
+	flamegraphPath, err := flamegraphScript(logf)
 	if err != nil {
-		fmt.Println("flamegraph.pl not found, downloading...")
-		// Try to download flamegraph.pl
-		if err := downloadFlamegraph(outputDir); err != nil {
-			return fmt.Errorf("error downloading flamegraph.pl: %v", err)
-		}
-		flamegraphPath = filepath.Join(outputDir, "flamegraph.pl")
+		return err
 	}
 
 	// Generate the flamegraph
-	fmt.Println("Generating flamegraph visualization...")
-	cmd = exec.Command(flamegraphPath, "--title", "CPU Flame Graph", "--countname", "samples", foldedPath)
-	output, err = cmd.Output()
+	logf("Generating flamegraph visualization...\n")
+	cmd := exec.Command(flamegraphPath, append(args, foldedPath)...)
+	output, err := cmd.Output()
 	if err != nil {
 		// If the command fails, try to get more detailed error information
 		if exitErr, ok := err.(*exec.ExitError); ok {
@@ -119,19 +690,17 @@ func generateFlamegraph(perfDataPath, outputDir string) error {
 	}
 
 	// Save the flamegraph
-	flamegraphPath = filepath.Join(outputDir, "flamegraph.svg")
-	fmt.Println("Saving flamegraph to", flamegraphPath)
-	if err := os.WriteFile(flamegraphPath, output, 0644); err != nil {
+	logf("Saving flamegraph to %s\n", svgPath)
+	if err := os.WriteFile(svgPath, output, 0644); err != nil {
 		return fmt.Errorf("error saving flamegraph: %v", err)
 	}
 
-	fmt.Println("Flamegraph generation complete!")
 	return nil
 }
 
 func generatePerfReport(perfDataPath, outputDir string) error {
 	// Generate perf report
-	cmd := exec.Command("perf", "report", "-i", perfDataPath, "--stdio")
+	cmd := exec.Command(detector.PerfBinary(), detector.PerfArgs("report", "-i", perfDataPath, "--stdio")...)
 	output, err := cmd.Output()
 	if err != nil {
 		return fmt.Errorf("error generating perf report: %v", err)
@@ -146,32 +715,85 @@ func generatePerfReport(perfDataPath, outputDir string) error {
 	return nil
 }
 
-func generateSummary(perfDataPath, outputDir, processName string, pid int, duration int, samples []*parser.Sample) error {
-	// Generate perf report for analysis
-	cmd := exec.Command("perf", "report", "-i", perfDataPath, "--stdio")
-	output, err := cmd.Output()
-	if err != nil {
-		return fmt.Errorf("error generating perf report for analysis: %v", err)
-	}
-
-	// Parse the report using both old and new methods
-	stats := parsePerfReport(string(output), samples)
+// generateSummary builds the summary statistics from the parsed samples
+func generateSummary(config *ReportConfig, samples []*parser.Sample, patterns *heatmap.PatternDetection) *AnalysisResult {
+	// Build the statistics from the parsed samples
+	stats := parsePerfReport("", samples, config.WeightByPeriod)
 
 	// Create summary
 	summary := SummaryStats{
-		TotalSamples:    stats.Summary.TotalSamples,
-		UserlandPercent: stats.Summary.UserlandPercent,
-		KernelPercent:   stats.Summary.KernelPercent,
-		UnknownPercent:  stats.Summary.UnknownPercent,
-		CaptureDuration: duration,
-		ProcessName:     processName,
-		PID:             pid,
+		TotalSamples:      stats.Summary.TotalSamples,
+		UserlandPercent:   stats.Summary.UserlandPercent,
+		KernelPercent:     stats.Summary.KernelPercent,
+		JITPercent:        stats.Summary.JITPercent,
+		GoRuntimePercent:  stats.Summary.GoRuntimePercent,
+		AllocatorPercent:  stats.Summary.AllocatorPercent,
+		UnknownPercent:    stats.Summary.UnknownPercent,
+		UnmappedPercent:   stats.Summary.UnmappedPercent,
+		NoSymbolPercent:   stats.Summary.NoSymbolPercent,
+		MMPressurePercent: stats.Summary.MMPressurePercent,
+		GuestPercent:      stats.Summary.GuestPercent,
+		HostPercent:       stats.Summary.HostPercent,
+		CaptureDuration:   config.Duration,
+		ProcessName:       config.ProcessName,
+		PID:               config.PID,
+		PIDs:              profiledPIDs(config.PIDs),
+		ProfileShape:      stats.Summary.ProfileShape,
+		TopStacks:         stats.Summary.TopStacks,
+		CPUStability:      computeCPUStability(samples, config.HeatmapWindowSize),
+		CPUFilter:         formatCPUList(config.CPUFilter),
+		Target:            config.Target,
+		SystemWide:        config.SystemWide,
+		Cgroup:            config.Cgroup,
+		WeightedByPeriod:  config.WeightByPeriod,
 	}
+	if config.SystemWide || config.Cgroup != "" {
+		summary.PerProcess = processBreakdown(samples)
+	}
+	summary.Verdict = buildVerdict(summary, stats.TopFunctions, patterns)
+	summary.SampleWarning = significanceWarning(summary.TotalSamples, stats.TopFunctions)
+	if config.CallersOf != "" {
+		summary.CallersOf = findCallers(samples, config.Redact.Name(config.CallersOf))
+	}
+	summary.EventComparison = compareEvents(samples)
+	if config.CompareThreads {
+		summary.ThreadComparison = compareThreads(samples)
+		if summary.ThreadComparison == nil {
+			config.logf("Warning: Fewer than 3 threads with %d+ samples, threads not compared\n", minComparedThreadSamples)
+		}
+	}
+	summary.Recommendations = buildRecommendations(summary, stats.TopFunctions, patterns)
+	if config.StatPath != "" {
+		counters, err := loadStatCounters(config.StatPath, config.Duration)
+		if err != nil {
+			config.logf("Warning: Could not read perf stat counters: %v\n", err)
+		}
+		summary.Counters = counters
+	}
+	if config.PerfDataPath != "" && config.ScriptPath == "" && !config.OfflinePerfData {
+		// Sampled PIDs are mapped through /proc, which only describes this host
+		summary.PerCgroup = cgroupBreakdown(samples, process.CgroupOf)
+	}
+
+	stats.Summary = summary
+	return stats
+}
+
+// savedSummary is the content of summary.json: the summary with the function
+// statistics, so that captures can be compared without their perf.data
+type savedSummary struct {
+	SummaryStats
+	TopFunctions []FunctionStats `json:"top_functions"`
+	ModuleStats  []ModuleStat    `json:"module_stats,omitempty"`
+}
 
+// writeSummary saves the summary as summary.json and summary.txt, the latter
+// listing the topN hottest functions
+func writeSummary(outputDir string, result *AnalysisResult, topN int) error {
 	// Save summary as JSON
-	summaryJSON, err := json.MarshalIndent(summary, "", "  ")
+	summaryJSON, err := result.SummaryJSON()
 	if err != nil {
-		return fmt.Errorf("error marshaling summary: %v", err)
+		return err
 	}
 
 	summaryPath := filepath.Join(outputDir, "summary.json")
@@ -180,77 +802,35 @@ func generateSummary(perfDataPath, outputDir, processName string, pid int, durat
 	}
 
 	// Save human-readable summary
-	summaryText := generateSummaryText(summary, stats.TopFunctions)
 	summaryTextPath := filepath.Join(outputDir, "summary.txt")
-	if err := os.WriteFile(summaryTextPath, []byte(summaryText), 0644); err != nil {
+	if err := os.WriteFile(summaryTextPath, []byte(result.SummaryText(topN)), 0644); err != nil {
 		return fmt.Errorf("error saving summary text: %v", err)
 	}
 
 	return nil
 }
 
-func downloadFlamegraph(outputDir string) error {
-	// Download flamegraph.pl from GitHub
-	cmd := exec.Command("curl", "-L", "https://raw.githubusercontent.com/brendangregg/FlameGraph/master/flamegraph.pl", "-o", filepath.Join(outputDir, "flamegraph.pl"))
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("error downloading flamegraph.pl: %v", err)
-	}
-
-	// Make it executable
-	cmd = exec.Command("chmod", "+x", filepath.Join(outputDir, "flamegraph.pl"))
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("error making flamegraph.pl executable: %v", err)
+// SummaryJSON returns the summary with the function statistics, as saved in
+// summary.json
+func (r *AnalysisResult) SummaryJSON() ([]byte, error) {
+	content, err := json.MarshalIndent(savedSummary{SummaryStats: r.Summary, TopFunctions: r.TopFunctions, ModuleStats: r.ModuleStats}, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling summary: %v", err)
 	}
-
-	return nil
+	return content, nil
 }
 
-func processPerfOutput(output string) string {
-	// Process perf script output to create folded stacks
-	var folded strings.Builder
-	lines := strings.Split(output, "\n")
-
-	// Track unique stacks to avoid duplicates
-	stackCounts := make(map[string]int)
-
-	for _, line := range lines {
-		if strings.TrimSpace(line) == "" {
-			continue
-		}
-
-		// Parse the perf script output
-		// Example line: "process 1234 [000] 123.456: cpu-clock: ..."
-		parts := strings.Fields(line)
-		if len(parts) < 2 {
-			continue
-		}
-
-		// Extract the stack trace (everything after the timestamp)
-		stackStart := -1
-		for i, part := range parts {
-			if strings.HasSuffix(part, ":") {
-				stackStart = i + 1
-				break
-			}
-		}
-		if stackStart == -1 || stackStart >= len(parts) {
-			continue
-		}
-
-		// Create the folded stack
-		stack := strings.Join(parts[stackStart:], ";")
-		stackCounts[stack]++
-	}
-
-	// Write the folded stacks
-	for stack, count := range stackCounts {
-		folded.WriteString(fmt.Sprintf("%s %d\n", stack, count))
-	}
-
-	return folded.String()
+// SummaryText returns the human-readable summary, as saved in summary.txt,
+// listing the topN hottest functions (DefaultTopN when topN is 0)
+func (r *AnalysisResult) SummaryText(topN int) string {
+	return generateSummaryText(r.Summary, r.TopFunctions, r.ModuleStats, topN)
 }
 
-func parsePerfReport(report string, samples []*parser.Sample) *AnalysisResult {
+// parsePerfReport builds the time distribution and function statistics of the
+// samples. With byPeriod each sample counts for its period instead of 1, so
+// the function sample counts are event counts and the percentages are shares
+// of all events; Summary.TotalSamples stays the number of samples.
+func parsePerfReport(report string, samples []*parser.Sample, byPeriod bool) *AnalysisResult {
 	result := &AnalysisResult{
 		TopFunctions: make([]FunctionStats, 0),
 		Summary: SummaryStats{
@@ -267,124 +847,429 @@ func parsePerfReport(report string, samples []*parser.Sample) *AnalysisResult {
 
 	// Count by function and category
 	functionCounts := make(map[string]*FunctionStats)
-	var kernelCount, userlandCount, unknownCount int
+	var kernelCount, userlandCount, unknownCount, guestCount int
+	var unmappedCount, noSymbolCount, mmCount, jitCount, goRuntimeCount, allocatorCount, totalWeight int
 
 	for _, sample := range samples {
-		if topFrame := sample.GetTopFrame(); topFrame != nil {
-			key := topFrame.Symbol
-
-			if _, exists := functionCounts[key]; !exists {
-				funcType := "unknown"
-				if topFrame.IsKernel {
-					funcType = "kernel"
-				} else if topFrame.IsUserland {
-					funcType = "userland"
-				}
-
-				functionCounts[key] = &FunctionStats{
-					Name:         topFrame.Symbol,
-					Type:         funcType,
-					TotalSamples: 0,
-					SelfSamples:  0,
-				}
-			}
+		weight := sample.Weight(byPeriod)
+		totalWeight += weight
+		if sample.InMMFault() {
+			mmCount += weight
+		}
+		countStack(functionCounts, sample.Stack, weight)
 
-			functionCounts[key].SelfSamples++
-			functionCounts[key].TotalSamples++
+		if topFrame := sample.GetTopFrame(); topFrame != nil {
 
 			// Count categories
+			if topFrame.Type == parser.FrameTypeGuest {
+				guestCount += weight
+			}
+			switch topFrame.Type {
+			case parser.FrameTypeJIT:
+				jitCount += weight
+			case parser.FrameTypeGoRuntime:
+				goRuntimeCount += weight
+			case parser.FrameTypeAllocator:
+				allocatorCount += weight
+			}
+			if topFrame.IsUnmapped() {
+				unmappedCount += weight
+			} else if topFrame.IsUnresolved() {
+				noSymbolCount += weight
+			}
 			if topFrame.IsKernel {
-				kernelCount++
+				kernelCount += weight
 			} else if topFrame.IsUserland {
-				userlandCount++
+				userlandCount += weight
 			} else {
-				unknownCount++
+				unknownCount += weight
 			}
 		}
 	}
 
 	// Calculate percentages
-	totalSamples := float64(len(samples))
+	totalSamples := float64(totalWeight)
 	if totalSamples > 0 {
 		result.Summary.KernelPercent = float64(kernelCount) / totalSamples * 100
 		result.Summary.UserlandPercent = float64(userlandCount) / totalSamples * 100
 		result.Summary.UnknownPercent = float64(unknownCount) / totalSamples * 100
+		result.Summary.UnmappedPercent = float64(unmappedCount) / totalSamples * 100
+		result.Summary.NoSymbolPercent = float64(noSymbolCount) / totalSamples * 100
+		result.Summary.MMPressurePercent = float64(mmCount) / totalSamples * 100
+		result.Summary.JITPercent = float64(jitCount) / totalSamples * 100
+		result.Summary.GoRuntimePercent = float64(goRuntimeCount) / totalSamples * 100
+		result.Summary.AllocatorPercent = float64(allocatorCount) / totalSamples * 100
+		if guestCount > 0 {
+			result.Summary.GuestPercent = float64(guestCount) / totalSamples * 100
+			result.Summary.HostPercent = 100 - result.Summary.GuestPercent
+		}
 	}
 
 	// Convert to slice and calculate percentages
+	leaves := 0
 	for _, stats := range functionCounts {
+		stats.TotalSamples = stats.SelfSamples + stats.ChildrenSamples
 		stats.Percentage = float64(stats.SelfSamples) / totalSamples * 100
+		stats.TotalPercentage = float64(stats.TotalSamples) / totalSamples * 100
 		result.TopFunctions = append(result.TopFunctions, *stats)
+		if stats.SelfSamples > 0 {
+			leaves++
+		}
 	}
 
-	// Sort by total samples descending
+	// Sort by self samples descending, so the hottest function is where the
+	// CPU was spent and callers that only appear below it come last
 	sort.Slice(result.TopFunctions, func(i, j int) bool {
-		return result.TopFunctions[i].TotalSamples > result.TopFunctions[j].TotalSamples
+		a, b := result.TopFunctions[i], result.TopFunctions[j]
+		if a.SelfSamples != b.SelfSamples {
+			return a.SelfSamples > b.SelfSamples
+		}
+		if a.TotalSamples != b.TotalSamples {
+			return a.TotalSamples > b.TotalSamples
+		}
+		return a.Name < b.Name
 	})
 
+	// The shape describes leaf concentration, so callers without self samples
+	// are left out
+	result.Summary.ProfileShape = computeProfileShape(result.TopFunctions[:leaves], totalWeight)
+	result.Summary.TopStacks = findRepeatedStacks(samples, maxRepeatedStacks)
+	result.ModuleStats = moduleBreakdown(samples, byPeriod)
+	result.Summary.KernelModules = kernelModuleBreakdown(samples, byPeriod)
+
 	return result
 }
 
-// parsePerfScriptData executes perf script and parses the output
-func parsePerfScriptData(perfDataPath string) ([]*parser.Sample, error) {
-	fmt.Println("Parsing perf script output for detailed analysis...")
-	
-	cmd := exec.Command("perf", "script", "-i", perfDataPath)
-	output, err := cmd.Output()
+// countStack counts one sample of the given weight against every function
+// on its stack, leaf first: the leaf gets self samples and each function
+// below it children samples. A recursive function is counted once per sample,
+// as self when it is the leaf.
+func countStack(functionCounts map[string]*FunctionStats, stack []parser.StackFrame, weight int) {
+	seen := make(map[string]bool, len(stack))
+	for i, frame := range stack {
+		key := frame.Symbol
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		stats, exists := functionCounts[key]
+		if !exists {
+			funcType := "unknown"
+			if frame.IsKernel {
+				funcType = "kernel"
+			} else if frame.IsUserland {
+				funcType = "userland"
+			}
+			stats = &FunctionStats{Name: frame.Symbol, Type: funcType}
+			functionCounts[key] = stats
+		}
+
+		if i == 0 {
+			stats.SelfSamples += weight
+		} else {
+			stats.ChildrenSamples += weight
+		}
+	}
+}
+
+// unknownSymbolsGuidance explains a profile dominated by [unknown] frames.
+// Unmapped addresses and mapped-but-stripped code have different fixes, so
+// the advice follows whichever of the two is present.
+func unknownSymbolsGuidance(summary SummaryStats) string {
+	var text strings.Builder
+
+	text.WriteString("\n⚠️  High percentage of [unknown] symbols detected!\n")
+	text.WriteString(fmt.Sprintf("- Unmapped addresses (no module): %.2f%%\n", summary.UnmappedPercent))
+	text.WriteString(fmt.Sprintf("- Mapped but without symbols: %.2f%%\n", summary.NoSymbolPercent))
+
+	// Default to the symbol advice when there is no breakdown
+	unmapped := summary.UnmappedPercent > 0
+	noSymbol := summary.NoSymbolPercent > 0 || !unmapped
+
+	if unmapped {
+		text.WriteString("\nUnmapped addresses - possible causes:\n")
+		text.WriteString("  • JIT-compiled code (Java, Node.js, .NET, LuaJIT) with no perf map\n")
+		text.WriteString("  • mmap events lost or missing (process started mapping code before the capture)\n")
+		text.WriteString("\nRecommendations:\n")
+		text.WriteString("  1. Make the runtime write /tmp/perf-<pid>.map:\n")
+		text.WriteString("     Java:    perf-map-agent or -XX:+UnlockDiagnosticVMOptions -XX:+DumpPerfMapAtExit\n")
+		text.WriteString("     Node.js: node --perf-basic-prof\n")
+		text.WriteString("  2. Check perf's output for \"lost events\" warnings and retry with a lower frequency\n")
+	}
+
+	if noSymbol {
+		text.WriteString("\nMissing symbols - possible causes:\n")
+		text.WriteString("  • Binary is stripped (compiled without debug symbols)\n")
+		text.WriteString("  • Missing debug packages\n")
+		text.WriteString("  • Compiler optimizations (inlined functions)\n")
+		text.WriteString("\nRecommendations:\n")
+		text.WriteString("  1. Install debug symbols for the process:\n")
+		text.WriteString("     Ubuntu/Debian: apt install <package>-dbg or <package>-dbgsym\n")
+		text.WriteString("     RHEL/CentOS:   yum install <package>-debuginfo\n")
+		text.WriteString("  2. Check if binary is stripped: file /path/to/binary\n")
+		text.WriteString("  3. For ScyllaDB: Install scylla-debuginfo package\n")
+		text.WriteString("  4. Recompile with -g flag if source is available\n")
+		text.WriteString("  5. Point --debug-dir at split debug files\n")
+		text.WriteString("  6. If stacks stop after one or two frames, the binary was built without frame pointers: capture with --call-graph dwarf\n")
+	}
+
+	return text.String()
+}
+
+// parseSamples parses the input text in config.InputFormat, sorted by timestamp
+func parseSamples(config *ReportConfig) ([]*parser.Sample, error) {
+	if config.InputFormat != InputFormatFtrace {
+		return parsePerfScriptData(config)
+	}
+
+	scriptOutput, err := readPerfScript(config)
 	if err != nil {
-		return nil, fmt.Errorf("error running perf script: %v", err)
+		return nil, err
+	}
+	config.logf("Parsing ftrace output for detailed analysis...\n")
+	samples, err := parser.ParseFtrace(scriptOutput)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing ftrace output: %v", err)
 	}
+	parser.SortByTimestamp(samples)
 
-	samples, err := parser.ParsePerfScript(string(output))
+	config.logf("Parsed %d samples from ftrace data\n", len(samples))
+	return samples, nil
+}
+
+// parsePerfScriptData parses perf script output into samples as it is read,
+// without holding the whole text in memory
+func parsePerfScriptData(config *ReportConfig) ([]*parser.Sample, error) {
+	input, err := openPerfScript(config)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing perf script: %v", err)
+		return nil, err
+	}
+
+	config.logf("Parsing perf script output for detailed analysis...\n")
+	samples, parseErr := parser.ParsePerfScriptReader(input)
+	if err := input.Close(); err != nil {
+		return nil, err
+	}
+	if parseErr != nil {
+		return nil, fmt.Errorf("error parsing perf script: %v", parseErr)
 	}
+	parser.SortByTimestamp(samples)
 
-	fmt.Printf("Parsed %d samples from perf data\n", len(samples))
+	config.logf("Parsed %d samples from perf data\n", len(samples))
 	return samples, nil
 }
 
-func generateSummaryText(summary SummaryStats, topFunctions []FunctionStats) string {
+func generateSummaryText(summary SummaryStats, topFunctions []FunctionStats, modules []ModuleStat, topN int) string {
 	var text strings.Builder
 
 	text.WriteString("Performance Analysis Summary\n")
 	text.WriteString("==========================\n\n")
 
-	text.WriteString(fmt.Sprintf("Process: %s (PID: %d)\n", summary.ProcessName, summary.PID))
+	if summary.Verdict != "" {
+		text.WriteString(fmt.Sprintf("Verdict: %s\n\n", summary.Verdict))
+	}
+
+	if summary.SystemWide {
+		text.WriteString("Process: all (system-wide)\n")
+	} else if summary.Cgroup != "" {
+		text.WriteString(fmt.Sprintf("Process: all in cgroup %s\n", summary.Cgroup))
+	} else {
+		if len(summary.PIDs) > 1 {
+			text.WriteString(fmt.Sprintf("Process: %s (%d PIDs: %s)\n", summary.ProcessName, len(summary.PIDs), formatPIDs(summary.PIDs)))
+		} else {
+			text.WriteString(fmt.Sprintf("Process: %s (PID: %d)\n", summary.ProcessName, summary.PID))
+		}
+	}
+	if summary.Target != nil && len(summary.Target.Cmdline) > 0 {
+		text.WriteString(fmt.Sprintf("Command: %s\n", strings.Join(summary.Target.Cmdline, " ")))
+	}
 	text.WriteString(fmt.Sprintf("Duration: %d seconds\n", summary.CaptureDuration))
-	text.WriteString(fmt.Sprintf("Total Samples: %d\n\n", summary.TotalSamples))
+	if summary.CPUFilter != "" {
+		text.WriteString(fmt.Sprintf("Total Samples: %d (CPUs %s)\n\n", summary.TotalSamples, summary.CPUFilter))
+	} else {
+		text.WriteString(fmt.Sprintf("Total Samples: %d\n\n", summary.TotalSamples))
+	}
+	if summary.WeightedByPeriod {
+		text.WriteString("Percentages are weighted by sample period.\n\n")
+	}
+
+	if summary.SampleWarning != "" {
+		text.WriteString(fmt.Sprintf("⚠️  %s\n\n", summary.SampleWarning))
+	}
 
 	text.WriteString("Time Distribution:\n")
 	text.WriteString(fmt.Sprintf("- Userland: %.2f%%\n", summary.UserlandPercent))
+	if summary.JITPercent > 0 {
+		text.WriteString(fmt.Sprintf("  - JIT-compiled code: %.2f%%\n", summary.JITPercent))
+	}
+	if summary.GoRuntimePercent > 0 {
+		text.WriteString(fmt.Sprintf("  - Go runtime (GC, allocation, scheduler): %.2f%%\n", summary.GoRuntimePercent))
+	}
+	if summary.AllocatorPercent > 0 {
+		text.WriteString(fmt.Sprintf("  - Memory allocator: %.2f%%\n", summary.AllocatorPercent))
+	}
 	text.WriteString(fmt.Sprintf("- Kernel: %.2f%%\n", summary.KernelPercent))
-	text.WriteString(fmt.Sprintf("- Unknown: %.2f%%\n\n", summary.UnknownPercent))
+	text.WriteString(fmt.Sprintf("- Unknown: %.2f%%\n", summary.UnknownPercent))
+	if summary.IdlePercent > 0 {
+		text.WriteString(fmt.Sprintf("- Idle CPU (not in profile): %.2f%%\n", summary.IdlePercent))
+	}
+	if summary.MMPressurePercent > 0 {
+		text.WriteString(fmt.Sprintf("- Page-fault/MM pressure: %.2f%%\n", summary.MMPressurePercent))
+	}
+	text.WriteString("\n")
+	if summary.MMPressurePercent > heatmap.MMPressureThreshold {
+		text.WriteString("⚠️  Much of the time goes to page faults and page-table walks (large mmaps, no huge pages?)\n\n")
+	}
 
+	if summary.GuestPercent > 0 {
+		text.WriteString("Virtualization:\n")
+		text.WriteString(fmt.Sprintf("- Guest: %.2f%%\n", summary.GuestPercent))
+		text.WriteString(fmt.Sprintf("- Host: %.2f%%\n\n", summary.HostPercent))
+	}
+
+	if shape := summary.ProfileShape; shape != nil && shape.UniqueFunctions > 0 {
+		text.WriteString(fmt.Sprintf("Profile Shape: %s\n", shape.Classification))
+		text.WriteString(fmt.Sprintf("- Top 1 function: %.2f%%\n", shape.Top1Percent))
+		text.WriteString(fmt.Sprintf("- Top 5 functions: %.2f%%\n", shape.Top5Percent))
+		text.WriteString(fmt.Sprintf("- Top 10 functions: %.2f%%\n", shape.Top10Percent))
+		text.WriteString(fmt.Sprintf("- Entropy: %.2f (%d unique functions)\n\n", shape.NormalizedEntropy, shape.UniqueFunctions))
+	}
+
+	if stability := summary.CPUStability; stability != nil {
+		text.WriteString(fmt.Sprintf("CPU Stability: %s\n", stability.Classification))
+		text.WriteString(fmt.Sprintf("- Samples per %.1fs window: mean %.1f, stddev %.1f\n", stability.WindowSize, stability.MeanSamples, stability.StdDevSamples))
+		text.WriteString(fmt.Sprintf("- Coefficient of variation: %.2f (%d windows)\n\n", stability.CoefficientOfVariation, stability.Windows))
+	}
+
+	if topN <= 0 {
+		topN = DefaultTopN
+	}
 	text.WriteString("Top Functions:\n")
 	unknownCount := 0
 	for i, fn := range topFunctions {
-		if i >= 10 { // Show only top 10
+		if i >= topN {
 			break
 		}
-		text.WriteString(fmt.Sprintf("%d. %s (%.2f%%)\n", i+1, fn.Name, fn.Percentage))
+		if fn.ChildrenSamples > 0 {
+			text.WriteString(fmt.Sprintf("%d. %s (%.2f%%, %.2f%% with callees)\n", i+1, fn.Name, fn.Percentage, fn.TotalPercentage))
+		} else {
+			text.WriteString(fmt.Sprintf("%d. %s (%.2f%%)\n", i+1, fn.Name, fn.Percentage))
+		}
 		if fn.Name == "[unknown]" || strings.Contains(fn.Name, "unknown") {
 			unknownCount++
 		}
 	}
 
-	// Add recommendations if many unknowns
-	if len(topFunctions) > 0 && topFunctions[0].Name == "[unknown]" && topFunctions[0].Percentage > 50 {
-		text.WriteString("\n⚠️  High percentage of [unknown] symbols detected!\n")
-		text.WriteString("\nPossible causes:\n")
-		text.WriteString("  • Binary is stripped (compiled without debug symbols)\n")
-		text.WriteString("  • Missing debug packages\n")
-		text.WriteString("  • Compiler optimizations (inlined functions)\n")
+	if len(modules) > 0 {
+		text.WriteString("\nTop Modules:\n")
+		for i, module := range modules {
+			if i >= topN {
+				break
+			}
+			text.WriteString(fmt.Sprintf("%d. %s (%.2f%%, %d samples)\n", i+1, module.Module, module.Percentage, module.Samples))
+		}
+	}
+
+	if len(summary.KernelModules) > 0 {
+		text.WriteString("\nTop Kernel Modules (samples with the module on the stack):\n")
+		for i, module := range summary.KernelModules {
+			if i >= topN {
+				break
+			}
+			text.WriteString(fmt.Sprintf("%d. %s (%.2f%%, %d samples)\n", i+1, module.Module, module.Percentage, module.Samples))
+		}
+	}
+
+	if len(summary.Branches) > 0 {
+		text.WriteString("\nTop Branch Mispredictions:\n")
+		for i, branch := range summary.Branches {
+			if i >= topN {
+				break
+			}
+			text.WriteString(fmt.Sprintf("%d. %s (%d of %d mispredicted, %.2f%%)\n", i+1, branch.Source, branch.Mispredicted, branch.Total, branch.MispredictRate))
+		}
+	}
+
+	if len(summary.TopStacks) > 0 {
+		text.WriteString("\nTop Repeated Stacks:\n")
+		for i, stack := range summary.TopStacks {
+			text.WriteString(fmt.Sprintf("%d. %.2f%% (%d samples): %s\n", i+1, stack.Percentage, stack.Samples, formatStack(stack.Stack, 6)))
+		}
+		if summary.TopStacks[0].Percentage > dominantStackPercent {
+			text.WriteString("\n⚠️  A single code path accounts for most samples (polling loop or hot path?)\n")
+		}
+	}
+
+	if comparison := summary.EventComparison; comparison != nil {
+		text.WriteString("\nCross-Event Comparison (% of each event's samples):\n")
+		text.WriteString(formatEventComparison(comparison))
+	}
+
+	if summary.Counters != nil {
+		text.WriteString(formatStatCounters(summary.Counters))
+	}
+
+	if summary.Timing != nil {
+		text.WriteString(formatCaptureTiming(summary.Timing))
+	}
+
+	if len(summary.PerProcess) > 0 {
+		text.WriteString("\nTop Processes:\n")
+		for i, proc := range summary.PerProcess {
+			text.WriteString(fmt.Sprintf("%d. %s: %.2f%% (%d samples, %d PIDs)\n", i+1, proc.Command, proc.Percentage, proc.Samples, proc.PIDs))
+		}
+	}
+
+	if len(summary.PerCgroup) > 0 {
+		text.WriteString("\nTop Cgroups:\n")
+		for i, cgroup := range summary.PerCgroup {
+			text.WriteString(fmt.Sprintf("%d. %s: %.2f%% (%d samples, %d processes)\n", i+1, cgroup.Cgroup, cgroup.Percentage, cgroup.Samples, cgroup.Processes))
+		}
+	}
+
+	if comparison := summary.ThreadComparison; comparison != nil {
+		text.WriteString(fmt.Sprintf("\nThread Comparison (%d threads, mean similarity %.2f):\n", comparison.Threads, comparison.MeanSimilarity))
+		if len(comparison.Outliers) == 0 {
+			text.WriteString("- all threads have a similar profile\n")
+		}
+		for _, outlier := range comparison.Outliers {
+			text.WriteString(fmt.Sprintf("- thread %d (%s, similarity %.2f) spends %.1f%% in %s while peers spend %.1f%%\n",
+				outlier.TID, outlier.Name, outlier.Similarity, outlier.ThreadPercent, outlier.Function, outlier.PeerPercent))
+		}
+	}
+
+	if callers := summary.CallersOf; callers != nil {
+		text.WriteString(fmt.Sprintf("\nCallers of %s (%d samples):\n", callers.Function, callers.Samples))
+		if len(callers.Callers) == 0 {
+			text.WriteString("- function not found in any sampled stack\n")
+		}
+		for i, caller := range callers.Callers {
+			text.WriteString(fmt.Sprintf("%d. %s (%.2f%%, %d samples)\n", i+1, caller.Name, caller.Percentage, caller.Samples))
+		}
+	}
+
+	if len(summary.Annotations) > 0 {
+		text.WriteString("\nAnnotations:\n")
+		for _, annotation := range summary.Annotations {
+			if annotation.File != "" {
+				text.WriteString(fmt.Sprintf("- %s: %s\n", annotation.Function, annotation.File))
+			} else {
+				text.WriteString(fmt.Sprintf("- %s: %s\n", annotation.Function, annotation.Error))
+			}
+		}
+	}
+
+	if len(summary.Recommendations) > 0 {
 		text.WriteString("\nRecommendations:\n")
-		text.WriteString("  1. Install debug symbols for the process:\n")
-		text.WriteString("     Ubuntu/Debian: apt install <package>-dbg or <package>-dbgsym\n")
-		text.WriteString("     RHEL/CentOS:   yum install <package>-debuginfo\n")
-		text.WriteString("  2. Check if binary is stripped: file /path/to/binary\n")
-		text.WriteString("  3. For ScyllaDB: Install scylla-debuginfo package\n")
-		text.WriteString("  4. Recompile with -g flag if source is available\n")
+		for _, recommendation := range summary.Recommendations {
+			text.WriteString(fmt.Sprintf("- [%s] %s\n", recommendation.Severity, recommendation.Message))
+		}
+	}
+
+	// Add the detailed symbol guidance if many unknowns
+	if mostlyUnknown(topFunctions) {
+		text.WriteString(unknownSymbolsGuidance(summary))
 	}
 
 	return text.String()
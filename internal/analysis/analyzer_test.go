@@ -1,8 +1,22 @@
 package analysis
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/santiagolertora/blc-perf-analyzer/internal/detector"
+	"github.com/santiagolertora/blc-perf-analyzer/internal/heatmap"
 	"github.com/santiagolertora/blc-perf-analyzer/internal/parser"
 )
 
@@ -51,7 +65,7 @@ func TestParsePerfReport(t *testing.T) {
 		},
 	}
 
-	result := parsePerfReport("", samples)
+	result := parsePerfReport("", samples, false)
 
 	if result == nil {
 		t.Fatal("parsePerfReport returned nil")
@@ -79,16 +93,16 @@ func TestParsePerfReport(t *testing.T) {
 		t.Error("Expected some functions in TopFunctions, got none")
 	}
 
-	// Verify functions are sorted by total samples
+	// Verify functions are sorted by self samples
 	for i := 0; i < len(result.TopFunctions)-1; i++ {
-		if result.TopFunctions[i].TotalSamples < result.TopFunctions[i+1].TotalSamples {
+		if result.TopFunctions[i].SelfSamples < result.TopFunctions[i+1].SelfSamples {
 			t.Errorf("TopFunctions not sorted correctly at index %d", i)
 		}
 	}
 }
 
 func TestParsePerfReportEmptySamples(t *testing.T) {
-	result := parsePerfReport("", []*parser.Sample{})
+	result := parsePerfReport("", []*parser.Sample{}, false)
 
 	if result == nil {
 		t.Fatal("parsePerfReport returned nil")
@@ -103,6 +117,116 @@ func TestParsePerfReportEmptySamples(t *testing.T) {
 	}
 }
 
+func TestParsePerfReportChildrenSamples(t *testing.T) {
+	frame := func(symbol string) parser.StackFrame {
+		return parser.StackFrame{Symbol: symbol, Module: "/usr/bin/app", IsUserland: true}
+	}
+	samples := []*parser.Sample{
+		{Stack: []parser.StackFrame{frame("leaf"), frame("middle"), frame("main")}},
+		{Stack: []parser.StackFrame{frame("leaf"), frame("middle"), frame("main")}},
+		{Stack: []parser.StackFrame{frame("middle"), frame("main")}},
+		// Recursion counts once per sample
+		{Stack: []parser.StackFrame{frame("leaf"), frame("leaf"), frame("main")}},
+	}
+
+	result := parsePerfReport("", samples, false)
+
+	stats := make(map[string]FunctionStats)
+	for _, fn := range result.TopFunctions {
+		stats[fn.Name] = fn
+	}
+
+	cases := []struct {
+		name                  string
+		self, children, total int
+	}{
+		{"leaf", 3, 0, 3},
+		{"middle", 1, 2, 3},
+		{"main", 0, 4, 4},
+	}
+	for _, tc := range cases {
+		fn, ok := stats[tc.name]
+		if !ok {
+			t.Errorf("%s missing from TopFunctions", tc.name)
+			continue
+		}
+		if fn.SelfSamples != tc.self || fn.ChildrenSamples != tc.children || fn.TotalSamples != tc.total {
+			t.Errorf("%s: self=%d children=%d total=%d, want %d/%d/%d",
+				tc.name, fn.SelfSamples, fn.ChildrenSamples, fn.TotalSamples, tc.self, tc.children, tc.total)
+		}
+	}
+
+	if stats["main"].Percentage != 0 || stats["main"].TotalPercentage != 100 {
+		t.Errorf("main: percentage %.1f, total %.1f, want 0 and 100", stats["main"].Percentage, stats["main"].TotalPercentage)
+	}
+
+	// The hottest function is the one with the most self samples
+	if result.TopFunctions[0].Name != "leaf" {
+		t.Errorf("first function = %s, want leaf", result.TopFunctions[0].Name)
+	}
+	if result.TopFunctions[len(result.TopFunctions)-1].Name != "main" {
+		t.Errorf("last function = %s, want main", result.TopFunctions[len(result.TopFunctions)-1].Name)
+	}
+
+	// Callers without self samples stay out of the profile shape
+	if result.Summary.ProfileShape.UniqueFunctions != 2 {
+		t.Errorf("UniqueFunctions = %d, want 2", result.Summary.ProfileShape.UniqueFunctions)
+	}
+}
+
+func TestParsePerfReportWeightByPeriod(t *testing.T) {
+	sample := func(symbol string, period uint64) *parser.Sample {
+		return &parser.Sample{
+			Period: period,
+			Stack:  []parser.StackFrame{{Symbol: symbol, Module: "/usr/bin/app", IsUserland: true}},
+		}
+	}
+	samples := []*parser.Sample{sample("cheap", 1000), sample("cheap", 1000), sample("costly", 8000)}
+
+	byCount := parsePerfReport("", samples, false)
+	if byCount.TopFunctions[0].Name != "cheap" || byCount.TopFunctions[0].SelfSamples != 2 {
+		t.Errorf("by count: top = %+v, want cheap with 2 samples", byCount.TopFunctions[0])
+	}
+
+	byPeriod := parsePerfReport("", samples, true)
+	top := byPeriod.TopFunctions[0]
+	if top.Name != "costly" || top.SelfSamples != 8000 || top.Percentage != 80 {
+		t.Errorf("by period: top = %+v, want costly with 8000 (80%%)", top)
+	}
+	if byPeriod.Summary.TotalSamples != 3 {
+		t.Errorf("TotalSamples = %d, want the 3 samples", byPeriod.Summary.TotalSamples)
+	}
+}
+
+func TestParsePerfReportUnknownBreakdown(t *testing.T) {
+	frame := func(symbol, module string) *parser.Sample {
+		return &parser.Sample{Stack: []parser.StackFrame{{Symbol: symbol, Module: module}}}
+	}
+	samples := []*parser.Sample{
+		frame("[unknown]", "[unknown]"),     // JIT region
+		frame("[unknown]", "[unknown]"),     // JIT region
+		frame("[unknown]", "/usr/sbin/app"), // Stripped binary
+		frame("main", "/usr/sbin/app"),
+	}
+
+	result := parsePerfReport("", samples, false)
+	if result.Summary.UnmappedPercent != 50 {
+		t.Errorf("Expected 50%% unmapped, got %.1f", result.Summary.UnmappedPercent)
+	}
+	if result.Summary.NoSymbolPercent != 25 {
+		t.Errorf("Expected 25%% without symbols, got %.1f", result.Summary.NoSymbolPercent)
+	}
+
+	jitOnly := unknownSymbolsGuidance(SummaryStats{UnmappedPercent: 90})
+	if !strings.Contains(jitOnly, "perf-<pid>.map") || strings.Contains(jitOnly, "debuginfo") {
+		t.Errorf("Expected only JIT guidance for unmapped samples, got:\n%s", jitOnly)
+	}
+	stripped := unknownSymbolsGuidance(SummaryStats{NoSymbolPercent: 90})
+	if strings.Contains(stripped, "perf-<pid>.map") || !strings.Contains(stripped, "debuginfo") {
+		t.Errorf("Expected only debug symbol guidance for mapped samples, got:\n%s", stripped)
+	}
+}
+
 func TestGenerateSummaryText(t *testing.T) {
 	summary := SummaryStats{
 		ProcessName:     "test_process",
@@ -120,7 +244,7 @@ func TestGenerateSummaryText(t *testing.T) {
 		{Name: "function_c", Percentage: 10.1, TotalSamples: 101},
 	}
 
-	text := generateSummaryText(summary, topFunctions)
+	text := generateSummaryText(summary, topFunctions, nil, 3)
 
 	// Check that text contains expected elements
 	if text == "" {
@@ -145,6 +269,10 @@ func TestGenerateSummaryText(t *testing.T) {
 			t.Errorf("Summary text missing required string: %s", required)
 		}
 	}
+
+	if text := generateSummaryText(summary, topFunctions, nil, 2); contains(text, "function_c") {
+		t.Errorf("Summary text lists more than the top 2 functions:\n%s", text)
+	}
 }
 
 func TestFunctionStatsPercentageCalculation(t *testing.T) {
@@ -188,7 +316,7 @@ func TestFunctionStatsPercentageCalculation(t *testing.T) {
 		}
 	}
 
-	result := parsePerfReport("", samples)
+	result := parsePerfReport("", samples, false)
 
 	// Find function_a in results
 	var funcA *FunctionStats
@@ -223,28 +351,28 @@ func TestFunctionStatsPercentageCalculation(t *testing.T) {
 	}
 }
 
-func TestProcessPerfOutput(t *testing.T) {
-	// Test the folded stack generation
-	input := `process 1234 [000] 123.456: cpu-clock: 
-	    7ffff7a0d000 function_a+0x10 (/lib/test.so)
-	    55555560abcd function_b+0x20 (/usr/bin/app)
+func TestFoldPerfScriptRootFirst(t *testing.T) {
+	// perf prints the leaf first; the folded stack starts at the root
+	input := `app 1234/1234 [000] 123.456:     250000 cpu-clock: 
+	    55555560abcd leaf+0x10 (/usr/bin/app)
+	    55555560dcba main+0x20 (/usr/bin/app)
 
-process 1234 [000] 124.456: cpu-clock: 
-	    7ffff7a0d000 function_a+0x10 (/lib/test.so)
-	    55555560abcd function_b+0x20 (/usr/bin/app)
-`
+app 1234/1234 [000] 123.457:     250000 cpu-clock: 
+	    55555560abcd leaf+0x10 (/usr/bin/app)
+	    55555560dcba main+0x20 (/usr/bin/app)
 
-	output := processPerfOutput(input)
+app 1234/1234 [000] 123.458:     250000 cpu-clock: 
+	    7ffff7a0d000 [unknown] (/lib/libc.so.6)
+	    55555560dcba std::vector<int>::push_back(int const&)+0x8 (/usr/bin/app)
+`
 
-	if output == "" {
-		t.Error("processPerfOutput returned empty string")
+	samples, err := parser.ParsePerfScript(input)
+	if err != nil {
+		t.Fatalf("ParsePerfScript failed: %v", err)
 	}
-
-	// The output should contain stack information
-	// Note: The actual format depends on the implementation
-	// This is a basic check that something was generated
-	if len(output) < 10 {
-		t.Error("processPerfOutput generated suspiciously short output")
+	expected := "main;leaf 2\nstd::vector<int>::push_back(int const&);[unknown] 1\n"
+	if output := foldSamples(samples, false, false); output != expected {
+		t.Errorf("foldSamples() = %q, want %q", output, expected)
 	}
 }
 
@@ -282,7 +410,7 @@ func BenchmarkParsePerfReport(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_ = parsePerfReport("", samples)
+		_ = parsePerfReport("", samples, false)
 	}
 }
 
@@ -308,7 +436,1541 @@ func BenchmarkGenerateSummaryText(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_ = generateSummaryText(summary, topFunctions)
+		_ = generateSummaryText(summary, topFunctions, nil, 0)
+	}
+}
+
+func TestAnalyzeBranches(t *testing.T) {
+	records := []parser.BranchRecord{
+		{From: "loop+0x10", To: "loop+0x0", Mispredicted: true},
+		{From: "loop+0x10", To: "loop+0x0", Predicted: true},
+		{From: "loop+0x10", To: "loop+0x0", Mispredicted: true},
+		{From: "hash+0x42", To: "hash+0x60", Mispredicted: true},
+		{From: "main+0x8", To: "loop+0x0", Predicted: true},
+	}
+
+	stats := analyzeBranches(records)
+
+	if len(stats) != 2 {
+		t.Fatalf("Expected 2 misprediction sources, got %d", len(stats))
+	}
+	if stats[0].Source != "loop+0x10" || stats[0].Mispredicted != 2 || stats[0].Total != 3 {
+		t.Errorf("Unexpected top source: %+v", stats[0])
+	}
+	if stats[1].Source != "hash+0x42" || stats[1].MispredictRate != 100.0 {
+		t.Errorf("Unexpected second source: %+v", stats[1])
+	}
+}
+
+func TestBranchStackRecorded(t *testing.T) {
+	tests := map[string]bool{
+		"cycles: size: 136, sample_type: IP|TID|TIME|CALLCHAIN|PERIOD, disabled: 1":                               false,
+		"cycles: size: 136, sample_type: IP|TID|BRANCH_STACK, branch_sample_type: ANY|USER":                       true,
+		"cycles: size: 136, sample_type: IP|TID|BRANCH_STACK, branch_sample_type: USER|CALL_STACK|NO_FLAGS, x: 1": false,
+	}
+	for evlist, want := range tests {
+		if got := branchStackRecorded(evlist); got != want {
+			t.Errorf("branchStackRecorded(%q) = %v, want %v", evlist, got, want)
+		}
+	}
+}
+
+func TestGenerateReportBranchMispredictions(t *testing.T) {
+	// A fake perf whose perf.data has taken branches (perf record -j any,u)
+	dir := t.TempDir()
+	fakePerf := filepath.Join(dir, "perf")
+	script := "#!/bin/sh\n" +
+		"case \"$*\" in\n" +
+		"evlist*) echo 'cycles: sample_type: IP|TID|BRANCH_STACK, branch_sample_type: ANY|USER' ;;\n" +
+		"*brstacksym*) echo ' loop+0x10/loop+0x0/M/-/-/0 loop+0x10/loop+0x0/M/-/-/0 loop+0x10/loop+0x0/P/-/-/0 hash+0x42/hash+0x60/M/-/-/0' ;;\n" +
+		"*) printf 'app 1/1 [001] 100.000000:     1 cycles: \\n\\t    4005d0 loop+0x10 (/usr/bin/app)\\n\\n' ;;\n" +
+		"esac\n"
+	if err := os.WriteFile(fakePerf, []byte(script), 0755); err != nil {
+		t.Fatalf("Failed to write fake perf: %v", err)
+	}
+	original := detector.PerfBinary()
+	if err := detector.SetPerfBinary(fakePerf); err != nil {
+		t.Fatalf("SetPerfBinary failed: %v", err)
+	}
+	defer detector.SetPerfBinary(original)
+
+	perfData := filepath.Join(dir, "perf.data")
+	if err := os.WriteFile(perfData, []byte(perfDataMagic+"\x00\x00"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outputDir := filepath.Join(dir, "out")
+	if err := os.Mkdir(outputDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := GenerateReport(&ReportConfig{PerfDataPath: perfData, OfflinePerfData: true, OutputDir: outputDir, QuietMode: true})
+	if err != nil {
+		t.Fatalf("GenerateReport failed: %v", err)
+	}
+	branches := result.Summary.Branches
+	if len(branches) != 2 || branches[0].Source != "loop+0x10" || branches[0].Total != 3 {
+		t.Fatalf("Unexpected branch mispredictions: %+v", branches)
+	}
+	for name, want := range map[string]string{
+		"summary.json":               `"branch_mispredictions"`,
+		"summary.txt":                "1. loop+0x10 (2 of 3 mispredicted, 66.67%)",
+		"branch-mispredictions.json": `"source": "hash+0x42"`,
+	} {
+		content, err := os.ReadFile(filepath.Join(outputDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(content), want) {
+			t.Errorf("Expected %s to contain %q, got:\n%s", name, want, content)
+		}
+	}
+
+	result, err = GenerateReport(&ReportConfig{PerfDataPath: perfData, OfflinePerfData: true, OutputDir: outputDir, SummaryOnly: true, QuietMode: true})
+	if err != nil {
+		t.Fatalf("GenerateReport failed: %v", err)
+	}
+	if !strings.Contains(result.SummaryText(0), "Top Branch Mispredictions:") {
+		t.Error("Expected the branch mispredictions in the summary on stdout")
+	}
+}
+
+func TestComputeProfileShape(t *testing.T) {
+	spiky := []FunctionStats{
+		{Name: "hot", SelfSamples: 80, Percentage: 80},
+		{Name: "warm", SelfSamples: 10, Percentage: 10},
+		{Name: "cold", SelfSamples: 10, Percentage: 10},
+	}
+	shape := computeProfileShape(spiky, 100)
+	if shape.Classification != "spiky" {
+		t.Errorf("Expected spiky profile, got %s", shape.Classification)
+	}
+	if shape.Top1Percent != 80 || shape.Top5Percent != 100 {
+		t.Errorf("Unexpected coverage: top1=%.1f top5=%.1f", shape.Top1Percent, shape.Top5Percent)
+	}
+
+	flat := make([]FunctionStats, 100)
+	for i := range flat {
+		flat[i] = FunctionStats{Name: "fn", SelfSamples: 1, Percentage: 1}
+	}
+	shape = computeProfileShape(flat, 100)
+	if shape.Classification != "flat" {
+		t.Errorf("Expected flat profile, got %s", shape.Classification)
+	}
+	if shape.NormalizedEntropy < 0.99 {
+		t.Errorf("Expected entropy close to 1 for an even profile, got %.3f", shape.NormalizedEntropy)
+	}
+
+	empty := computeProfileShape(nil, 0)
+	if empty.UniqueFunctions != 0 || empty.Classification != "" {
+		t.Errorf("Expected empty shape, got %+v", empty)
+	}
+}
+
+func TestDiffFoldedStacks(t *testing.T) {
+	before, err := parseFoldedStacks(strings.NewReader("main;work;hot 10\nmain;idle 5\nbad line\n"))
+	if err != nil {
+		t.Fatalf("parseFoldedStacks failed: %v", err)
+	}
+	after, err := parseFoldedStacks(strings.NewReader("main;work;hot 30\nmain;new 2\n"))
+	if err != nil {
+		t.Fatalf("parseFoldedStacks failed: %v", err)
+	}
+
+	if before["main;work;hot"] != 10 || before["main;idle"] != 5 || len(before) != 2 {
+		t.Fatalf("Unexpected parsed folded stacks: %v", before)
+	}
+
+	got := diffFoldedStacks(before, after)
+	want := "main;idle 5 0\nmain;new 0 2\nmain;work;hot 10 30\n"
+	if got != want {
+		t.Errorf("diffFoldedStacks() = %q, want %q", got, want)
+	}
+	long := strings.Repeat("frame;", 200000) + "leaf 1\n"
+	if _, err := parseFoldedStacks(strings.NewReader("main;work 1\n" + long)); err == nil {
+		t.Error("Expected an error for a line over the scanner limit")
+	}
+}
+
+func TestDebugFileCandidates(t *testing.T) {
+	entries := parseBuildIDList("abcdef0123 /usr/sbin/mysqld\n99887766 [kernel.kallsyms]\n\nshort\n")
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 build-id entries, got %d", len(entries))
+	}
+
+	got := debugFileCandidates("/usr/lib/debug", entries[0])
+	want := []string{
+		"/usr/lib/debug/.build-id/ab/cdef0123.debug",
+		"/usr/lib/debug/usr/sbin/mysqld.debug",
+		"/usr/lib/debug/usr/sbin/mysqld",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("debugFileCandidates() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("candidate %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+
+	kernel := debugFileCandidates("/usr/lib/debug", entries[1])
+	if len(kernel) != 1 {
+		t.Errorf("Expected only the build-id candidate for the kernel, got %v", kernel)
+	}
+}
+
+func TestRegisterDebugSymbols(t *testing.T) {
+	// A fake perf that lists one DSO and logs its buildid-cache calls
+	dir := t.TempDir()
+	debugDir := filepath.Join(dir, "debug")
+	if err := os.MkdirAll(filepath.Join(debugDir, ".build-id", "ab"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(debugDir, ".build-id", "ab", "cdef0123.debug"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	logPath := filepath.Join(dir, "calls.log")
+	fakePerf := filepath.Join(dir, "perf")
+	script := "#!/bin/sh\n" +
+		"case \"$*\" in *buildid-list*) echo 'abcdef0123 /usr/sbin/mysqld' ;; *) echo \"$*\" >> " + logPath + " ;; esac\n"
+	if err := os.WriteFile(fakePerf, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	original := detector.PerfBinary()
+	if err := detector.SetPerfBinary(fakePerf); err != nil {
+		t.Fatal(err)
+	}
+	defer detector.SetPerfBinary(original)
+
+	n, cleanup, err := RegisterDebugSymbols(filepath.Join(dir, "perf.data"), debugDir, nil)
+	if err != nil || n != 1 {
+		t.Fatalf("RegisterDebugSymbols() = %d, %v, want 1 file", n, err)
+	}
+	calls, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := strings.Fields(string(calls))
+	if len(fields) < 2 || fields[0] != "--buildid-dir" {
+		t.Fatalf("buildid-cache not pointed at a private cache: %q", calls)
+	}
+	cacheDir := fields[1]
+	if args := detector.PerfArgs("script"); len(args) != 3 || args[1] != cacheDir {
+		t.Errorf("Later perf calls do not use the private cache: %v", args)
+	}
+
+	cleanup()
+	if _, err := os.Stat(cacheDir); !os.IsNotExist(err) {
+		t.Errorf("Build-id cache %s not removed", cacheDir)
+	}
+	if args := detector.PerfArgs("script"); len(args) != 1 {
+		t.Errorf("Build-id cache still used after cleanup: %v", args)
+	}
+}
+
+func TestFunctionsAbove(t *testing.T) {
+	result := &AnalysisResult{
+		TopFunctions: []FunctionStats{
+			{Name: "do_syscall_64", Percentage: 52.0},
+			{Name: "malloc", Percentage: 40.0},
+			{Name: "memcpy", Percentage: 8.0},
+		},
+	}
+
+	offenders := result.FunctionsAbove(40)
+	if len(offenders) != 1 || offenders[0].Name != "do_syscall_64" {
+		t.Errorf("FunctionsAbove(40) = %+v, want only do_syscall_64", offenders)
+	}
+
+	if got := result.FunctionsAbove(60); len(got) != 0 {
+		t.Errorf("FunctionsAbove(60) = %+v, want none", got)
+	}
+}
+
+func TestFindRepeatedStacks(t *testing.T) {
+	poll := []parser.StackFrame{{Symbol: "epoll_wait"}, {Symbol: "poll_loop"}, {Symbol: "main"}}
+	work := []parser.StackFrame{{Symbol: "epoll_wait"}, {Symbol: "worker"}, {Symbol: "main"}}
+
+	samples := make([]*parser.Sample, 0)
+	for i := 0; i < 6; i++ {
+		samples = append(samples, &parser.Sample{Stack: poll})
+	}
+	samples = append(samples, &parser.Sample{Stack: work}, &parser.Sample{Stack: work})
+	samples = append(samples, &parser.Sample{Stack: []parser.StackFrame{{Symbol: "once"}}})
+	samples = append(samples, &parser.Sample{})
+
+	stacks := findRepeatedStacks(samples, 5)
+	if len(stacks) != 2 {
+		t.Fatalf("Expected 2 repeated stacks, got %d", len(stacks))
+	}
+	if stacks[0].Stack != "epoll_wait;poll_loop;main" || stacks[0].Samples != 6 {
+		t.Errorf("Unexpected top stack: %+v", stacks[0])
+	}
+	if stacks[0].Percentage != 60.0 {
+		t.Errorf("Expected 60%% for the polling stack, got %.1f", stacks[0].Percentage)
+	}
+
+	if got := formatStack("a;b;c;d", 2); got != "a <- b <- ..." {
+		t.Errorf("formatStack() = %q", got)
+	}
+}
+
+func TestBuildVerdict(t *testing.T) {
+	summary := SummaryStats{
+		TotalSamples:    1000,
+		UserlandPercent: 62.4,
+		KernelPercent:   37.6,
+		ProcessName:     "mariadbd",
+	}
+	topFunctions := []FunctionStats{{Name: "do_command", Percentage: 18.2}}
+	patterns := &heatmap.PatternDetection{
+		Anomalies: []heatmap.Anomaly{
+			{Type: "lock_contention"},
+			{Type: "cpu_spike"},
+			{Type: "lock_contention"},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		summary  SummaryStats
+		patterns *heatmap.PatternDetection
+		expected string
+	}{
+		{"with anomalies", summary, patterns, "mariadbd: 62% userland, hottest=do_command (18%), 3 anomalies (lock_contention, cpu_spike)"},
+		{"without heatmap", summary, nil, "mariadbd: 62% userland, hottest=do_command (18%)"},
+		{"no anomalies", summary, &heatmap.PatternDetection{}, "mariadbd: 62% userland, hottest=do_command (18%), no anomalies"},
+		{"kernel dominated", SummaryStats{TotalSamples: 10, KernelPercent: 80, UserlandPercent: 20, PID: 42}, nil, "PID 42: 80% kernel, hottest=do_command (18%)"},
+		{"no samples", SummaryStats{ProcessName: "mariadbd"}, nil, "mariadbd: no samples captured"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := buildVerdict(tt.summary, topFunctions, tt.patterns); got != tt.expected {
+				t.Errorf("buildVerdict() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGenerateReports(t *testing.T) {
+	dir := t.TempDir()
+	script := "mysqld 12345/12346 [001] 123456.789012:     999999 cpu-clock: \n" +
+		"\t    55555560abcd handle_connection+0x123 (/usr/sbin/mysqld)\n\n"
+
+	configs := make([]*ReportConfig, 0)
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(script), 0644); err != nil {
+			t.Fatal(err)
+		}
+		configs = append(configs, &ReportConfig{ScriptPath: path, SummaryOnly: true})
+	}
+	configs = append(configs, &ReportConfig{ScriptPath: filepath.Join(dir, "missing.txt"), SummaryOnly: true})
+
+	results, err := GenerateReports(configs, 2)
+	if err == nil || !strings.Contains(err.Error(), "missing.txt") {
+		t.Errorf("Expected error mentioning missing.txt, got %v", err)
+	}
+	if len(results) != len(configs) {
+		t.Fatalf("Expected %d results, got %d", len(configs), len(results))
+	}
+	for i, result := range results[:3] {
+		if result == nil || result.Summary.TotalSamples != 1 {
+			t.Errorf("Result %d: expected 1 sample, got %+v", i, result)
+		}
+	}
+	if results[3] != nil {
+		t.Error("Expected nil result for failed input")
+	}
+}
+
+func TestGenerateReportQuiet(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "perf.txt")
+	script := "mysqld 12345/12346 [001] 123456.789012:     999999 cpu-clock: \n" +
+		"\t    55555560abcd handle_connection+0x123 (/usr/sbin/mysqld)\n\n"
+	if err := os.WriteFile(path, []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	read, write, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = write
+	_, err = GenerateReport(&ReportConfig{ScriptPath: path, OutputDir: dir, GenerateHeatmap: true, HeatmapWindowSize: 1.0, QuietMode: true})
+	os.Stdout = stdout
+	write.Close()
+	if err != nil {
+		t.Fatalf("GenerateReport failed: %v", err)
+	}
+
+	output, _ := io.ReadAll(read)
+	if len(output) > 0 {
+		t.Errorf("Expected no output in quiet mode, got %q", output)
+	}
+}
+
+func TestGenerateReportProgress(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "perf.txt")
+	script := "mysqld 12345/12346 [001] 123456.789012:     999999 cpu-clock: \n" +
+		"\t    55555560abcd handle_connection+0x123 (/usr/sbin/mysqld)\n\n"
+	if err := os.WriteFile(path, []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var progress bytes.Buffer
+	if _, err := GenerateReport(&ReportConfig{ScriptPath: path, OutputDir: dir, GenerateHeatmap: true, HeatmapWindowSize: 1.0, Progress: &progress}); err != nil {
+		t.Fatalf("GenerateReport failed: %v", err)
+	}
+	if progress.Len() == 0 {
+		t.Error("Expected progress messages on the configured writer")
+	}
+}
+
+// stubFlamegraph puts a flamegraph.pl in PATH that prints an empty SVG
+func stubFlamegraph(t *testing.T) {
+	t.Helper()
+	bin := t.TempDir()
+	script := "#!/bin/sh\necho '<svg></svg>'\n"
+	if err := os.WriteFile(filepath.Join(bin, "flamegraph.pl"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestGenerateReportExcludeThreadFlamegraph(t *testing.T) {
+	stubFlamegraph(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "perf.txt")
+	script := "mysqld 12345/12346 [001] 100.000000:     999999 cpu-clock: \n" +
+		"\t    55555560abcd handle_connection+0x123 (/usr/sbin/mysqld)\n\n" +
+		"mysqld 12345/12399 [002] 100.001000:     999999 cpu-clock: \n" +
+		"\t    55555560beef purge_coordinator+0x10 (/usr/sbin/mysqld)\n\n"
+	if err := os.WriteFile(path, []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+	matcher, err := parser.ParseThreadMatcher("12399")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = GenerateReport(&ReportConfig{ScriptPath: path, OutputDir: dir, GenerateFlamegraph: true,
+		ExcludeThreads: []*parser.ThreadMatcher{matcher}, QuietMode: true})
+	if err != nil {
+		t.Fatalf("GenerateReport failed: %v", err)
+	}
+	folded, err := os.ReadFile(filepath.Join(dir, "perf.folded"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(folded), "purge_coordinator") {
+		t.Errorf("Excluded thread in perf.folded: %q", folded)
+	}
+	if !strings.Contains(string(folded), "handle_connection") {
+		t.Errorf("Kept thread missing from perf.folded: %q", folded)
+	}
+}
+
+func TestGenerateReportOutputSelection(t *testing.T) {
+	stubFlamegraph(t)
+	// A fake perf with a perf report and two samples 3 seconds apart
+	dir := t.TempDir()
+	fakePerf := filepath.Join(dir, "perf")
+	script := "#!/bin/sh\n" +
+		"if [ \"$1\" = report ]; then echo '# perf report'; exit 0; fi\n" +
+		"printf 'mysqld 12345/12346 [001] 100.000000:     999999 cpu-clock: \\n\\t    55555560abcd handle_connection+0x123 (/usr/sbin/mysqld)\\n\\n'\n" +
+		"printf 'mysqld 12345/12346 [001] 103.000000:     999999 cpu-clock: \\n\\t    55555560abcd handle_connection+0x123 (/usr/sbin/mysqld)\\n\\n'\n"
+	if err := os.WriteFile(fakePerf, []byte(script), 0755); err != nil {
+		t.Fatalf("Failed to write fake perf: %v", err)
+	}
+	original := detector.PerfBinary()
+	if err := detector.SetPerfBinary(fakePerf); err != nil {
+		t.Fatalf("SetPerfBinary failed: %v", err)
+	}
+	defer detector.SetPerfBinary(original)
+	perfData := filepath.Join(dir, "perf.data")
+	if err := os.WriteFile(perfData, []byte(perfDataMagic+"\x00\x00"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	artifacts := []string{"summary.json", "summary.txt", "summary.md", "flamegraph.svg", "perf.folded",
+		"heatmap.html", "heatmap-data.json", "patterns.json", "perf-report.txt", PprofFileName}
+	tests := []struct {
+		name   string
+		config ReportConfig
+		want   []string
+	}{
+		{"summary only", ReportConfig{}, []string{"summary.json", "summary.txt"}},
+		{"flamegraph", ReportConfig{GenerateFlamegraph: true}, []string{"summary.json", "summary.txt", "flamegraph.svg", "perf.folded"}},
+		{"heatmap", ReportConfig{GenerateHeatmap: true, HeatmapWindowSize: 1}, []string{"summary.json", "summary.txt", "heatmap.html", "heatmap-data.json", "patterns.json"}},
+		{"perf report", ReportConfig{GeneratePerfReport: true}, []string{"summary.json", "summary.txt", "perf-report.txt"}},
+		{"pprof and markdown", ReportConfig{GeneratePprof: true, Markdown: true}, []string{"summary.json", "summary.txt", "summary.md", PprofFileName}},
+		{"summary to stdout", ReportConfig{GenerateFlamegraph: true, GenerateHeatmap: true, SummaryOnly: true}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			config.PerfDataPath = perfData
+			config.OfflinePerfData = true
+			config.OutputDir = t.TempDir()
+			config.QuietMode = true
+			if _, err := GenerateReport(&config); err != nil {
+				t.Fatalf("GenerateReport failed: %v", err)
+			}
+
+			want := make(map[string]bool)
+			for _, name := range tt.want {
+				want[name] = true
+			}
+			for _, name := range artifacts {
+				_, err := os.Stat(filepath.Join(config.OutputDir, name))
+				if written := err == nil; written != want[name] {
+					t.Errorf("%s written = %v, want %v", name, written, want[name])
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateReportOfflinePerfData(t *testing.T) {
+	// A fake perf whose perf script prints two samples, 3 seconds apart
+	dir := t.TempDir()
+	fakePerf := filepath.Join(dir, "perf")
+	script := "#!/bin/sh\n" +
+		"printf 'mysqld 12345/12346 [001] 100.000000:     999999 cpu-clock: \\n\\t    55555560abcd handle_connection+0x123 (/usr/sbin/mysqld)\\n\\n'\n" +
+		"printf 'mysqld 12345/12346 [001] 103.000000:     999999 cpu-clock: \\n\\t    55555560abcd handle_connection+0x123 (/usr/sbin/mysqld)\\n\\n'\n"
+	if err := os.WriteFile(fakePerf, []byte(script), 0755); err != nil {
+		t.Fatalf("Failed to write fake perf: %v", err)
+	}
+	original := detector.PerfBinary()
+	if err := detector.SetPerfBinary(fakePerf); err != nil {
+		t.Fatalf("SetPerfBinary failed: %v", err)
+	}
+	defer detector.SetPerfBinary(original)
+
+	perfData := filepath.Join(dir, "perf.data")
+	if err := os.WriteFile(perfData, []byte(perfDataMagic+"\x00\x00"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !IsPerfData(perfData) || IsPerfData(fakePerf) {
+		t.Fatal("IsPerfData does not tell perf.data from other files")
+	}
+
+	result, err := GenerateReport(&ReportConfig{PerfDataPath: perfData, OfflinePerfData: true, OutputDir: dir, SummaryOnly: true, QuietMode: true})
+	if err != nil {
+		t.Fatalf("GenerateReport failed: %v", err)
+	}
+	summary := result.Summary
+	if summary.TotalSamples != 2 || summary.ProcessName != "mysqld" || summary.PID != 12345 || summary.CaptureDuration != 3 {
+		t.Errorf("Metadata not derived from the samples: %d samples, process %q, PID %d, %d seconds",
+			summary.TotalSamples, summary.ProcessName, summary.PID, summary.CaptureDuration)
+	}
+	if summary.PerCgroup != nil {
+		t.Error("Cgroups of an offline capture were looked up in this host's /proc")
+	}
+}
+
+func TestParseNrCPUs(t *testing.T) {
+	header := "# ========\n# captured on    : Mon Oct 12 10:00:00 2026\n# nrcpus online : 48\n# nrcpus avail : 64\n"
+	if cores := parseNrCPUs(header); cores != 48 {
+		t.Errorf("Expected the 48 online CPUs, got %d", cores)
+	}
+	if cores := parseNrCPUs("# nrcpus avail : 64\n"); cores != 64 {
+		t.Errorf("Expected the 64 available CPUs without an online count, got %d", cores)
+	}
+	if cores := parseNrCPUs("# hostname : db1\n"); cores != 0 {
+		t.Errorf("Expected 0 without nrcpus, got %d", cores)
+	}
+}
+
+func TestGenerateReportHostCores(t *testing.T) {
+	// A fake perf whose perf.data was recorded on a host with more CPUs
+	// than this one
+	cores := runtime.NumCPU() + 64
+	dir := t.TempDir()
+	fakePerf := filepath.Join(dir, "perf")
+	script := "#!/bin/sh\n" +
+		"case \"$*\" in\n" +
+		fmt.Sprintf("*--header-only*) echo '# nrcpus online : %d' ;;\n", cores) +
+		"*) printf 'app 1/1 [001] 100.000000:     1 cycles: \\n\\t    4005d0 loop+0x10 (/usr/bin/app)\\n\\n' ;;\n" +
+		"esac\n"
+	if err := os.WriteFile(fakePerf, []byte(script), 0755); err != nil {
+		t.Fatalf("Failed to write fake perf: %v", err)
+	}
+	original := detector.PerfBinary()
+	if err := detector.SetPerfBinary(fakePerf); err != nil {
+		t.Fatalf("SetPerfBinary failed: %v", err)
+	}
+	defer detector.SetPerfBinary(original)
+
+	perfData := filepath.Join(dir, "perf.data")
+	if err := os.WriteFile(perfData, []byte(perfDataMagic+"\x00\x00"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := &ReportConfig{PerfDataPath: perfData, OfflinePerfData: true, OutputDir: dir, GenerateHeatmap: true, HeatmapWindowSize: 1, QuietMode: true}
+	if _, err := GenerateReport(config); err != nil {
+		t.Fatalf("GenerateReport failed: %v", err)
+	}
+	if config.HostCores != cores || heatmapConfig(config, dir, "").HostCores != cores {
+		t.Errorf("Expected the %d CPUs of the perf.data header, got %d", cores, config.HostCores)
+	}
+	html, err := os.ReadFile(filepath.Join(dir, "heatmap.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(html), fmt.Sprintf(`"host_cores":%d`, cores)) {
+		t.Errorf("Expected heatmap.html to report %d host cores", cores)
+	}
+}
+
+func TestSignificanceWarning(t *testing.T) {
+	top := []FunctionStats{{Name: "do_command", Percentage: 50}}
+
+	tests := []struct {
+		name        string
+		total       int
+		expectEmpty bool
+	}{
+		{"no samples", 0, true},
+		{"too few samples", 200, false},
+		{"wide confidence margin", 1500, false},
+		{"enough samples", 10000, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warning := significanceWarning(tt.total, top)
+			if (warning == "") != tt.expectEmpty {
+				t.Errorf("significanceWarning(%d) = %q", tt.total, warning)
+			}
+		})
+	}
+
+	// 50% over 1000 samples is accurate to about ±3.1 points
+	if margin := confidenceMargin(50, 1000); margin < 3.0 || margin > 3.2 {
+		t.Errorf("confidenceMargin(50, 1000) = %.2f, want ~3.1", margin)
+	}
+}
+
+func TestAnnotationFileName(t *testing.T) {
+	tests := []struct {
+		rank     int
+		function string
+		expected string
+	}{
+		{1, "do_command", "01-do_command.txt"},
+		{2, "std::vector<int>::push_back", "02-std_vector_int_push_back.txt"},
+		{12, "__lll_lock_wait", "12-__lll_lock_wait.txt"},
+	}
+	for _, tt := range tests {
+		if got := annotationFileName(tt.rank, tt.function); got != tt.expected {
+			t.Errorf("annotationFileName(%d, %q) = %q, want %q", tt.rank, tt.function, got, tt.expected)
+		}
+	}
+}
+
+func TestComputeCPUStability(t *testing.T) {
+	makeSamples := func(counts ...int) []*parser.Sample {
+		samples := make([]*parser.Sample, 0)
+		for window, count := range counts {
+			for i := 0; i < count; i++ {
+				samples = append(samples, &parser.Sample{Timestamp: float64(window) + float64(i)/float64(count+1)})
+			}
+		}
+		return samples
+	}
+
+	steady := computeCPUStability(makeSamples(100, 100, 100, 100), 1.0)
+	if steady == nil || steady.Classification != "steady" || steady.CoefficientOfVariation != 0 || steady.MeanSamples != 100 {
+		t.Errorf("Expected steady load, got %+v", steady)
+	}
+
+	bursty := computeCPUStability(makeSamples(10, 10, 200, 10), 1.0)
+	if bursty == nil || bursty.Classification != "bursty" {
+		t.Errorf("Expected bursty load, got %+v", bursty)
+	}
+
+	if single := computeCPUStability(makeSamples(50), 1.0); single != nil {
+		t.Errorf("Expected nil for a single window, got %+v", single)
 	}
 }
 
+func TestFindCallers(t *testing.T) {
+	stack := func(symbols ...string) *parser.Sample {
+		sample := &parser.Sample{}
+		for _, symbol := range symbols {
+			sample.Stack = append(sample.Stack, parser.StackFrame{Symbol: symbol})
+		}
+		return sample
+	}
+	samples := []*parser.Sample{
+		stack("malloc", "alloc_row", "do_command"),
+		stack("_int_malloc", "malloc", "alloc_row", "do_command"),
+		stack("malloc", "parse_query", "do_command"),
+		stack("malloc"),
+		stack("free", "alloc_row"),
+	}
+
+	report := findCallers(samples, "malloc")
+
+	if report.Samples != 4 {
+		t.Errorf("Expected 4 samples with malloc, got %d", report.Samples)
+	}
+	expected := []CallerStats{
+		{Name: "alloc_row", Samples: 2, Percentage: 50},
+		{Name: "[root]", Samples: 1, Percentage: 25},
+		{Name: "parse_query", Samples: 1, Percentage: 25},
+	}
+	if len(report.Callers) != len(expected) {
+		t.Fatalf("Expected %d callers, got %+v", len(expected), report.Callers)
+	}
+	for i, caller := range expected {
+		if report.Callers[i] != caller {
+			t.Errorf("Caller %d = %+v, want %+v", i, report.Callers[i], caller)
+		}
+	}
+
+	if missing := findCallers(samples, "nonexistent"); missing.Samples != 0 || len(missing.Callers) != 0 {
+		t.Errorf("Expected empty report, got %+v", missing)
+	}
+}
+
+func TestCompareEvents(t *testing.T) {
+	sample := func(event, symbol string) *parser.Sample {
+		return &parser.Sample{Event: event, Stack: []parser.StackFrame{{Symbol: symbol}}}
+	}
+
+	// Single event: no comparison
+	if comparison := compareEvents([]*parser.Sample{sample("cycles", "a"), sample("cycles", "b")}); comparison != nil {
+		t.Errorf("Expected nil comparison for one event, got %+v", comparison)
+	}
+
+	samples := []*parser.Sample{
+		sample("cycles", "compute"), sample("cycles", "compute"), sample("cycles", "compute"), sample("cycles", "hash_lookup"),
+		sample("cache-misses", "hash_lookup"), sample("cache-misses", "hash_lookup"), sample("cache-misses", "hash_lookup"), sample("cache-misses", "hash_lookup"),
+	}
+	comparison := compareEvents(samples)
+	if comparison == nil {
+		t.Fatal("Expected a comparison for two events")
+	}
+	if strings.Join(comparison.Events, ",") != "cache-misses,cycles" {
+		t.Errorf("Unexpected events: %v", comparison.Events)
+	}
+	if len(comparison.Rows) != 2 || comparison.Rows[0].Function != "hash_lookup" {
+		t.Fatalf("Expected hash_lookup first, got %+v", comparison.Rows)
+	}
+	if comparison.Rows[0].Percentages["cache-misses"] != 100 || comparison.Rows[0].Percentages["cycles"] != 25 {
+		t.Errorf("Unexpected hash_lookup percentages: %v", comparison.Rows[0].Percentages)
+	}
+
+	table := formatEventComparison(comparison)
+	if !strings.Contains(table, "hash_lookup") || !strings.Contains(table, "100.00%") {
+		t.Errorf("Unexpected table:\n%s", table)
+	}
+}
+
+func TestGenerateReportEventHeatmaps(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "perf.txt")
+	var script strings.Builder
+	for i := 0; i < 6; i++ {
+		event := "cycles"
+		if i%3 == 0 {
+			event = "cache-misses:u"
+		}
+		fmt.Fprintf(&script, "app 100/101 [000] 1000.%d00000:     1000 %s: \n\t    55555560abcd compute+0x10 (/usr/bin/app)\n\n", i, event)
+	}
+	if err := os.WriteFile(path, []byte(script.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := GenerateReport(&ReportConfig{ScriptPath: path, OutputDir: dir, GenerateHeatmap: true, HeatmapWindowSize: 0.1, QuietMode: true})
+	if err != nil {
+		t.Fatalf("GenerateReport failed: %v", err)
+	}
+	if strings.Join(result.EventHeatmaps, ",") != "heatmap-cache-misses_u" {
+		t.Fatalf("Expected a heatmap for the secondary event, got %v", result.EventHeatmaps)
+	}
+
+	// cycles has the most samples and drives the main heatmap
+	for file, event := range map[string]string{
+		"heatmap.html": "cycles",
+		filepath.Join("heatmap-cache-misses_u", "heatmap.html"): "cache-misses:u",
+	} {
+		content, err := os.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			t.Fatalf("Expected %s: %v", file, err)
+		}
+		if !strings.Contains(string(content), "Event: "+event) {
+			t.Errorf("Expected %s to be titled with event %s", file, event)
+		}
+	}
+}
+
+func TestFormatCPUList(t *testing.T) {
+	tests := map[string][]int{
+		"":          nil,
+		"5":         {5},
+		"0-3,8":     {0, 1, 2, 3, 8},
+		"1,3,10-12": {1, 3, 10, 11, 12},
+	}
+	for expected, cpus := range tests {
+		if got := formatCPUList(cpus); got != expected {
+			t.Errorf("formatCPUList(%v) = %q, want %q", cpus, got, expected)
+		}
+	}
+}
+
+func TestGenerateReportCPUFilter(t *testing.T) {
+	stubFlamegraph(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "perf.txt")
+	script := "mysqld 12345/12346 [001] 100.000000:     999999 cpu-clock: \n" +
+		"\t    55555560abcd handle_connection+0x123 (/usr/sbin/mysqld)\n\n" +
+		"mysqld 12345/12347 [007] 100.001000:     999999 cpu-clock: \n" +
+		"\t    55555560beef irq_thread_fn+0x10 (/usr/sbin/mysqld)\n\n"
+	if err := os.WriteFile(path, []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := GenerateReport(&ReportConfig{ScriptPath: path, OutputDir: dir, GenerateFlamegraph: true,
+		GeneratePprof: true, CPUFilter: []int{0, 1, 2, 3}, QuietMode: true})
+	if err != nil {
+		t.Fatalf("GenerateReport failed: %v", err)
+	}
+	if result.Summary.TotalSamples != 1 {
+		t.Errorf("Expected 1 sample on CPUs 0-3, got %d", result.Summary.TotalSamples)
+	}
+
+	folded, err := os.ReadFile(filepath.Join(dir, "perf.folded"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(folded), "irq_thread_fn") || !strings.Contains(string(folded), "handle_connection") {
+		t.Errorf("perf.folded not restricted to CPUs 0-3: %q", folded)
+	}
+
+	file, err := os.Open(filepath.Join(dir, PprofFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(profile, []byte("irq_thread_fn")) || !bytes.Contains(profile, []byte("handle_connection")) {
+		t.Error("pprof profile not restricted to CPUs 0-3")
+	}
+}
+
+func TestFlamegraphOptions(t *testing.T) {
+	args := strings.Join(FlamegraphOptions{}.args("CPU Flame Graph"), " ")
+	if args != "--title CPU Flame Graph --countname samples" {
+		t.Errorf("Unexpected default args: %s", args)
+	}
+
+	opts := FlamegraphOptions{Title: "checkout", Width: 2400, Height: 12, MinWidth: "0.5%"}
+	args = strings.Join(opts.args("CPU Flame Graph"), " ")
+	if args != "--title checkout --countname samples --width 2400 --height 12 --minwidth 0.5%" {
+		t.Errorf("Unexpected args: %s", args)
+	}
+	args = strings.Join(FlamegraphOptions{}.invertedArgs(), " ")
+	if args != "--title CPU Flame Graph (Inverted) --countname samples --reverse --inverted" {
+		t.Errorf("Unexpected inverted args: %s", args)
+	}
+	args = strings.Join(FlamegraphOptions{WeightByPeriod: true}.args("CPU Flame Graph"), " ")
+	if args != "--title CPU Flame Graph --countname events" {
+		t.Errorf("Unexpected period-weighted args: %s", args)
+	}
+
+	for _, minWidth := range []string{"2", "0.1", "0.5%"} {
+		if err := (FlamegraphOptions{MinWidth: minWidth}).Validate(); err != nil {
+			t.Errorf("Expected min width %q to be valid: %v", minWidth, err)
+		}
+	}
+	for _, invalid := range []FlamegraphOptions{{MinWidth: "wide"}, {MinWidth: "-1"}, {Width: -5}, {Height: -1}} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", invalid)
+		}
+	}
+}
+
+func TestCgroupBreakdown(t *testing.T) {
+	samples := []*parser.Sample{
+		{PID: 10}, {PID: 10}, {PID: 11}, {PID: 20}, {PID: 99},
+	}
+	cgroups := map[int]string{10: "/docker/web", 11: "/docker/web", 20: "/docker/db"}
+	lookup := func(pid int) (string, error) {
+		if cgroup, ok := cgroups[pid]; ok {
+			return cgroup, nil
+		}
+		return "", fmt.Errorf("no such process")
+	}
+
+	breakdown := cgroupBreakdown(samples, lookup)
+	if len(breakdown) != 3 {
+		t.Fatalf("Expected 3 cgroups, got %+v", breakdown)
+	}
+	if breakdown[0].Cgroup != "/docker/web" || breakdown[0].Samples != 3 || breakdown[0].Processes != 2 || breakdown[0].Percentage != 60 {
+		t.Errorf("Unexpected top cgroup: %+v", breakdown[0])
+	}
+	if breakdown[2].Cgroup != unknownCgroup || breakdown[2].Samples != 1 {
+		t.Errorf("Expected the exited PID under %s, got %+v", unknownCgroup, breakdown[2])
+	}
+
+	none := func(pid int) (string, error) { return "", fmt.Errorf("no such process") }
+	if breakdown := cgroupBreakdown(samples, none); breakdown != nil {
+		t.Errorf("Expected no breakdown when no PID resolves, got %+v", breakdown)
+	}
+}
+
+func TestProcessBreakdown(t *testing.T) {
+	samples := []*parser.Sample{
+		{Command: "nginx", PID: 10}, {Command: "nginx", PID: 11}, {Command: "nginx", PID: 10},
+		{Command: "mysqld", PID: 20}, {Command: "java", PID: 30},
+	}
+
+	breakdown := processBreakdown(samples)
+	if len(breakdown) != 3 {
+		t.Fatalf("Expected 3 processes, got %+v", breakdown)
+	}
+	if breakdown[0].Command != "nginx" || breakdown[0].Samples != 3 || breakdown[0].PIDs != 2 || breakdown[0].Percentage != 60 {
+		t.Errorf("Unexpected top process: %+v", breakdown[0])
+	}
+	if breakdown[1].Command != "java" || breakdown[2].Command != "mysqld" {
+		t.Errorf("Expected ties in name order, got %+v", breakdown)
+	}
+
+	summary := SummaryStats{TotalSamples: 5, UserlandPercent: 100, SystemWide: true, PerProcess: breakdown}
+	if verdict := buildVerdict(summary, nil, nil); verdict != "system-wide: 100% userland, busiest=nginx (60%)" {
+		t.Errorf("Unexpected verdict %q", verdict)
+	}
+
+	summary.SystemWide, summary.Cgroup = false, "/docker/3f4e5d6c7b8a"
+	if verdict := buildVerdict(summary, nil, nil); verdict != "cgroup /docker/3f4e5d6c7b8a: 100% userland, busiest=nginx (60%)" {
+		t.Errorf("Unexpected cgroup verdict %q", verdict)
+	}
+	if text := generateSummaryText(summary, nil, nil, 0); !strings.Contains(text, "Process: all in cgroup /docker/3f4e5d6c7b8a\n") {
+		t.Errorf("Summary does not name the cgroup:\n%s", text)
+	}
+}
+
+func TestModuleBreakdown(t *testing.T) {
+	leaf := func(symbol, module string) *parser.Sample {
+		return &parser.Sample{Stack: []parser.StackFrame{{Symbol: symbol, Module: module}, {Symbol: "main", Module: "/usr/bin/app"}}}
+	}
+	samples := []*parser.Sample{
+		leaf("memcpy", "/usr/lib/libc.so.6"), leaf("strlen", "/usr/lib/libc.so.6"), leaf("malloc", "/usr/lib/libc.so.6"),
+		leaf("do_syscall_64", "[kernel.kallsyms]"), leaf("0x7f00", ""), {},
+	}
+
+	modules := moduleBreakdown(samples, false)
+	if len(modules) != 3 {
+		t.Fatalf("Expected 3 modules, got %+v", modules)
+	}
+	if modules[0].Module != "/usr/lib/libc.so.6" || modules[0].Samples != 3 || modules[0].Percentage != 50 {
+		t.Errorf("Expected libc first with its three leaf samples, got %+v", modules[0])
+	}
+	if modules[1].Module != "[kernel.kallsyms]" || modules[2].Module != "[unknown]" {
+		t.Errorf("Expected ties in name order and unmapped frames as [unknown], got %+v", modules)
+	}
+	for _, module := range modules {
+		if module.Module == "/usr/bin/app" {
+			t.Errorf("Callers must not count as self samples, got %+v", modules)
+		}
+	}
+
+	text := generateSummaryText(SummaryStats{TotalSamples: 6}, nil, modules, 0)
+	if !strings.Contains(text, "Top Modules:\n1. /usr/lib/libc.so.6 (50.00%, 3 samples)\n") {
+		t.Errorf("Summary does not list the modules:\n%s", text)
+	}
+
+	// Every module stays in module_stats; the text lists the topN busiest
+	samples = nil
+	for i := 0; i < 30; i++ {
+		samples = append(samples, leaf("f", fmt.Sprintf("/usr/lib/lib%02d.so", i)))
+	}
+	modules = moduleBreakdown(samples, false)
+	if len(modules) != 30 {
+		t.Fatalf("Expected all 30 modules, got %d", len(modules))
+	}
+	text = generateSummaryText(SummaryStats{TotalSamples: 30}, nil, modules, 20)
+	if !strings.Contains(text, "20. /usr/lib/lib19.so") || strings.Contains(text, "21. ") {
+		t.Errorf("Expected the text to list 20 modules:\n%s", text)
+	}
+}
+
+func TestKernelModuleBreakdown(t *testing.T) {
+	driver := func(symbol, module string) parser.StackFrame {
+		return parser.StackFrame{Symbol: symbol, Module: module, Type: parser.FrameTypeKernelDriver, IsKernel: true}
+	}
+	core := parser.StackFrame{Symbol: "_raw_spin_lock", Module: "[kernel.kallsyms]", Type: parser.FrameTypeKernelCore, IsKernel: true}
+	samples := []*parser.Sample{
+		{Stack: []parser.StackFrame{core, driver("nvme_queue_rq", "[nvme]"), driver("nvme_submit_cmd", "[nvme]")}},
+		{Stack: []parser.StackFrame{driver("nvme_irq", "[nvme]")}},
+		{Stack: []parser.StackFrame{driver("e1000_clean", "[e1000]"), core}},
+		{Stack: []parser.StackFrame{core}},
+	}
+
+	modules := kernelModuleBreakdown(samples, false)
+	if len(modules) != 2 {
+		t.Fatalf("Expected 2 kernel modules, got %+v", modules)
+	}
+	if modules[0].Module != "nvme" || modules[0].Samples != 2 || modules[0].Percentage != 50 {
+		t.Errorf("Expected nvme on 2 of 4 stacks, counted once per stack, got %+v", modules[0])
+	}
+	if modules[1].Module != "e1000" || modules[1].Samples != 1 {
+		t.Errorf("Expected e1000 second, got %+v", modules[1])
+	}
+
+	text := generateSummaryText(SummaryStats{TotalSamples: 4, KernelModules: modules}, nil, nil, 0)
+	if !strings.Contains(text, "1. nvme (50.00%, 2 samples)\n2. e1000 (25.00%, 1 samples)\n") {
+		t.Errorf("Summary does not list the kernel modules:\n%s", text)
+	}
+
+	// Every driver stays in kernel_modules; the text lists the topN busiest
+	samples = nil
+	for i := 0; i < 30; i++ {
+		samples = append(samples, &parser.Sample{Stack: []parser.StackFrame{driver("irq", fmt.Sprintf("[drv%02d]", i))}})
+	}
+	modules = kernelModuleBreakdown(samples, false)
+	if len(modules) != 30 {
+		t.Fatalf("Expected all 30 kernel modules, got %d", len(modules))
+	}
+	text = generateSummaryText(SummaryStats{TotalSamples: 30, KernelModules: modules}, nil, nil, 20)
+	if !strings.Contains(text, "20. drv19 ") || strings.Contains(text, "21. ") {
+		t.Errorf("Expected the text to list 20 kernel modules:\n%s", text)
+	}
+}
+
+func TestComputeStatCounters(t *testing.T) {
+	stats := computeStatCounters(map[string]float64{
+		"cycles":           4000,
+		"instructions":     6000,
+		"cache-references": 200,
+		"cache-misses":     50,
+		"context-switches": 300,
+	}, 10)
+
+	if stats.IPC != 1.5 || stats.CacheMissPercent != 25 || stats.ContextSwitchesPerSec != 30 {
+		t.Errorf("Unexpected ratios: %+v", stats)
+	}
+	if stats.BranchMissPercent != 0 {
+		t.Errorf("Expected no branch miss rate without branch counters, got %f", stats.BranchMissPercent)
+	}
+
+	text := formatStatCounters(stats)
+	if !strings.Contains(text, "IPC: 1.50") || !strings.Contains(text, "Cache miss rate: 25.00%") || strings.Contains(text, "Branch miss rate") {
+		t.Errorf("Unexpected counters section:\n%s", text)
+	}
+}
+
+func TestComputeCaptureTiming(t *testing.T) {
+	if timing := computeCaptureTiming(&ReportConfig{Duration: 10}, nil, time.Now()); timing != nil {
+		t.Errorf("Expected no timing without a capture start, got %+v", timing)
+	}
+
+	launch := time.Now().Add(-20 * time.Second)
+	config := &ReportConfig{
+		Duration:     10,
+		LaunchTime:   launch,
+		CaptureStart: launch.Add(2 * time.Second),
+		LaunchClock:  1000,
+	}
+	samples := []*parser.Sample{{Timestamp: 1007}, {Timestamp: 1002.5}, {Timestamp: 1004}}
+
+	timing := computeCaptureTiming(config, samples, launch.Add(15*time.Second))
+	if timing.PerfStartSeconds != 2 || timing.FirstSampleSeconds != 2.5 || timing.FirstSampleAfterPerfSeconds != 0.5 {
+		t.Errorf("Unexpected startup latency: %+v", timing)
+	}
+	if timing.SampledSeconds != 4.5 || timing.CoveragePercent != 45 {
+		t.Errorf("Unexpected sampled span: %+v", timing)
+	}
+
+	// Timestamps from another clock can't be placed on the capture timeline
+	config.LaunchClock = 5000
+	timing = computeCaptureTiming(config, samples, launch.Add(15*time.Second))
+	if timing.FirstSampleSeconds != 0 || timing.SampledSeconds != 4.5 {
+		t.Errorf("Expected the first sample to be left unplaced, got %+v", timing)
+	}
+	if text := formatCaptureTiming(timing); strings.Contains(text, "First sample") || !strings.Contains(text, "4.50s of 10s requested (45.0%)") {
+		t.Errorf("Unexpected timing section:\n%s", text)
+	}
+}
+
+func TestFoldSamples(t *testing.T) {
+	frame := func(symbol string) parser.StackFrame { return parser.StackFrame{Symbol: symbol} }
+	samples := []*parser.Sample{
+		{Stack: []parser.StackFrame{frame("fget_light"), frame("vfs_read")}},
+		{Stack: []parser.StackFrame{frame("ktime_get")}},
+		{Stack: []parser.StackFrame{frame("fget_light"), frame("vfs_read")}},
+		{},
+	}
+
+	if folded := foldSamples(samples, false, false); folded != "ktime_get 1\nvfs_read;fget_light 2\n" {
+		t.Errorf("Unexpected folded stacks: %q", folded)
+	}
+
+	samples[0].Command, samples[0].TID = "worker", 11
+	samples[1].Command, samples[1].TID = "worker", 12
+	samples[2].Command, samples[2].TID = "worker", 12
+	if folded := foldSamples(samples, true, false); folded != "worker-11;vfs_read;fget_light 1\nworker-12;ktime_get 1\nworker-12;vfs_read;fget_light 1\n" {
+		t.Errorf("Unexpected per-thread folded stacks: %q", folded)
+	}
+
+	samples[0].Period, samples[1].Period, samples[2].Period = 1000, 250, 1000
+	if folded := foldSamples(samples, false, true); folded != "ktime_get 250\nvfs_read;fget_light 2000\n" {
+		t.Errorf("Unexpected period-weighted folded stacks: %q", folded)
+	}
+}
+
+func TestBuildRecommendations(t *testing.T) {
+	topFunctions := []FunctionStats{
+		{Name: "__lll_lock_wait", Percentage: 15},
+		{Name: "do_command", Percentage: 10},
+		{Name: "futex_wait", Percentage: 8},
+	}
+	patterns := &heatmap.PatternDetection{Anomalies: []heatmap.Anomaly{
+		{Type: "single_thread_bottleneck"},
+		{Type: "single_thread_bottleneck"},
+	}}
+	summary := SummaryStats{
+		MMPressurePercent: 30,
+		CallersOf:         &CallerReport{Function: "__lll_lock_wait", Callers: []CallerStats{{Name: "trx_commit"}}},
+	}
+
+	recommendations := buildRecommendations(summary, topFunctions, patterns)
+	rules := make([]string, len(recommendations))
+	for i, recommendation := range recommendations {
+		rules[i] = recommendation.Rule
+	}
+	if strings.Join(rules, ",") != "lock_contention,single_thread_bottleneck,mm_pressure" {
+		t.Fatalf("Unexpected rules: %v", rules)
+	}
+	if message := recommendations[0].Message; !strings.Contains(message, "23.0% of samples in lock functions, mostly __lll_lock_wait") || !strings.Contains(message, "trx_commit") {
+		t.Errorf("Unexpected lock recommendation: %s", message)
+	}
+	if !strings.Contains(recommendations[1].Message, "2 windows") || !strings.Contains(recommendations[2].Message, "huge pages") {
+		t.Errorf("Unexpected recommendations: %+v", recommendations[1:])
+	}
+
+	// Without a heatmap or strong signals nothing is recommended
+	if recommendations := buildRecommendations(SummaryStats{}, topFunctions[1:2], nil); len(recommendations) != 0 {
+		t.Errorf("Expected no recommendations, got %+v", recommendations)
+	}
+
+	unknown := buildRecommendations(SummaryStats{}, []FunctionStats{{Name: "[unknown]", Percentage: 80}}, nil)
+	if len(unknown) != 1 || unknown[0].Rule != "unknown_symbols" || !strings.Contains(unknown[0].Message, "--debug-dir") {
+		t.Errorf("Expected the debug symbol recommendation, got %+v", unknown)
+	}
+}
+
+func TestCompareThreads(t *testing.T) {
+	samples := make([]*parser.Sample, 0)
+	add := func(tid, count int, function string) {
+		for i := 0; i < count; i++ {
+			samples = append(samples, &parser.Sample{Command: "worker", TID: tid, Stack: []parser.StackFrame{{Symbol: function}}})
+		}
+	}
+	// Four workers split between parse and execute; 3204 mostly spins on a lock
+	for _, tid := range []int{3201, 3202, 3203} {
+		add(tid, 60, "parse")
+		add(tid, 38, "execute")
+		add(tid, 2, "spin_lock")
+	}
+	add(3204, 30, "parse")
+	add(3204, 30, "execute")
+	add(3204, 40, "spin_lock")
+	add(3205, 10, "other") // Too few samples to compare
+
+	comparison := compareThreads(samples)
+	if comparison == nil {
+		t.Fatal("Expected a thread comparison")
+	}
+	if comparison.Threads != 4 {
+		t.Errorf("Expected 4 compared threads, got %d", comparison.Threads)
+	}
+	if len(comparison.Outliers) != 1 {
+		t.Fatalf("Expected 1 outlier, got %+v", comparison.Outliers)
+	}
+	outlier := comparison.Outliers[0]
+	if outlier.TID != 3204 || outlier.Function != "spin_lock" || outlier.ThreadPercent != 40 || outlier.PeerPercent != 2 {
+		t.Errorf("Unexpected outlier: %+v", outlier)
+	}
+
+	if compareThreads(samples[:200]) != nil {
+		t.Error("Expected no comparison with only two threads")
+	}
+}
+
+func TestMetric(t *testing.T) {
+	for _, spec := range []string{"bogus", "function_self_percent", "kernel_percent:malloc"} {
+		if _, err := ParseMetric(spec); err == nil {
+			t.Errorf("ParseMetric(%q) expected error", spec)
+		}
+	}
+
+	result := &AnalysisResult{
+		TopFunctions: []FunctionStats{{Name: "memcpy", Percentage: 40}, {Name: "malloc", Percentage: 10}},
+		Summary: SummaryStats{
+			TotalSamples:  200,
+			KernelPercent: 25.5,
+			CallersOf:     &CallerReport{Function: "malloc", Samples: 50},
+		},
+	}
+
+	tests := map[string]string{
+		"kernel_percent":                "25.50",
+		"total_samples":                 "200",
+		"top_function_percent":          "40.00",
+		"function_self_percent:malloc":  "10.00",
+		"function_self_percent:absent":  "0.00",
+		"function_total_percent:malloc": "25.00",
+	}
+	for spec, expected := range tests {
+		metric, err := ParseMetric(spec)
+		if err != nil {
+			t.Fatalf("ParseMetric(%q) error: %v", spec, err)
+		}
+		value, err := metric.Value(result)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", spec, err)
+			continue
+		}
+		if got := metric.Format(value); got != expected {
+			t.Errorf("%s = %s, want %s", spec, got, expected)
+		}
+	}
+
+	metric, _ := ParseMetric("function_total_percent:free")
+	if _, err := metric.Value(result); err == nil {
+		t.Error("Expected error when the callers of the metric's function were not analyzed")
+	}
+}
+
+func TestCompareFunctions(t *testing.T) {
+	before := &capturedFunctions{totalSamples: 100, functions: []FunctionStats{
+		{Name: "malloc", SelfSamples: 10, Percentage: 10},
+		{Name: "memcpy", SelfSamples: 20, Percentage: 20},
+		{Name: "old_path", SelfSamples: 5, Percentage: 5},
+		{Name: "steady", SelfSamples: 30, Percentage: 30},
+		{Name: "main", ChildrenSamples: 100, TotalPercentage: 100},
+	}}
+	after := &capturedFunctions{totalSamples: 200, functions: []FunctionStats{
+		{Name: "malloc", SelfSamples: 60, Percentage: 30},
+		{Name: "memcpy", SelfSamples: 20, Percentage: 10},
+		{Name: "new_path", SelfSamples: 8, Percentage: 4},
+		{Name: "steady", SelfSamples: 61, Percentage: 30.5},
+	}}
+
+	comparison := compareFunctions(before, after, 1.0)
+
+	want := []struct {
+		name   string
+		change string
+		delta  float64
+	}{
+		{"malloc", ChangeGrew, 20},
+		{"new_path", ChangeNew, 4},
+		{"steady", ChangeUnchanged, 0.5},
+		{"old_path", ChangeGone, -5},
+		{"memcpy", ChangeShrank, -10},
+	}
+	if len(comparison.Functions) != len(want) {
+		t.Fatalf("got %d functions, want %d (callers without self samples left out): %+v", len(comparison.Functions), len(want), comparison.Functions)
+	}
+	for i, w := range want {
+		fn := comparison.Functions[i]
+		if fn.Name != w.name || fn.Change != w.change || fn.DeltaPercent != w.delta {
+			t.Errorf("function %d = %s %s %+.1f, want %s %s %+.1f", i, fn.Name, fn.Change, fn.DeltaPercent, w.name, w.change, w.delta)
+		}
+	}
+	if comparison.Functions[0].DeltaSamples != 50 {
+		t.Errorf("malloc delta samples = %d, want 50", comparison.Functions[0].DeltaSamples)
+	}
+
+	text := comparison.Text()
+	if !strings.Contains(text, "malloc") || strings.Contains(text, "steady") {
+		t.Errorf("diff.txt should list malloc and leave out steady:\n%s", text)
+	}
+}
+
+func TestCompareCapturesFromSummaries(t *testing.T) {
+	dirs := make([]string, 2)
+	for i, percent := range []float64{10, 40} {
+		dirs[i] = t.TempDir()
+		result := &AnalysisResult{
+			Summary:      SummaryStats{TotalSamples: 100},
+			TopFunctions: []FunctionStats{{Name: "spin_lock", SelfSamples: int(percent), Percentage: percent}},
+		}
+		if err := writeSummary(dirs[i], result, 0); err != nil {
+			t.Fatalf("writeSummary: %v", err)
+		}
+	}
+
+	comparison, err := CompareCaptures(dirs[0], dirs[1], DefaultCompareThreshold)
+	if err != nil {
+		t.Fatalf("CompareCaptures: %v", err)
+	}
+	if len(comparison.Functions) != 1 || comparison.Functions[0].DeltaPercent != 30 {
+		t.Fatalf("unexpected comparison: %+v", comparison.Functions)
+	}
+
+	outputDir := t.TempDir()
+	if err := WriteComparison(outputDir, comparison); err != nil {
+		t.Fatalf("WriteComparison: %v", err)
+	}
+	for _, name := range []string{"diff.json", "diff.txt"} {
+		if _, err := os.Stat(filepath.Join(outputDir, name)); err != nil {
+			t.Errorf("%s not written: %v", name, err)
+		}
+	}
+}
+
+func TestExportPprof(t *testing.T) {
+	samples := []*parser.Sample{
+		{Command: "mysqld", TID: 11, Timestamp: 1.0, Period: 250000, Event: "cpu-clock", Stack: []parser.StackFrame{
+			{Symbol: "malloc", Module: "/lib/libc.so"},
+			{Symbol: "do_command", Module: "/usr/sbin/mysqld"},
+		}},
+		{Command: "mysqld", TID: 12, Timestamp: 2.0, Period: 250000, Event: "cpu-clock", Stack: []parser.StackFrame{
+			{Symbol: "do_command", Module: "/usr/sbin/mysqld"},
+		}},
+	}
+
+	path := filepath.Join(t.TempDir(), PprofFileName)
+	if err := ExportPprof(samples, path, false); err != nil {
+		t.Fatalf("ExportPprof: %v", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("profile is not gzip-compressed: %v", err)
+	}
+	profile, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"samples", "cpu", "nanoseconds", "malloc", "do_command", "/usr/sbin/mysqld", "comm", "mysqld"} {
+		if !bytes.Contains(profile, []byte(want)) {
+			t.Errorf("string table misses %q", want)
+		}
+	}
+	// do_command is interned once, as a single function and location
+	if n := bytes.Count(profile, []byte("do_command")); n != 1 {
+		t.Errorf("do_command appears %d times, want 1", n)
+	}
+
+	// default_sample_type (field 14) is the last field: "samples" (string 1)
+	// unless weighted by period, then "cpu" (string 3)
+	if got := buildPprof(samples, false); !bytes.HasSuffix(got, []byte{14 << 3, 1}) {
+		t.Errorf("Expected samples as the default sample type, profile ends with %x", got[len(got)-2:])
+	}
+	if got := buildPprof(samples, true); !bytes.HasSuffix(got, []byte{14 << 3, 3}) {
+		t.Errorf("Expected cpu as the default sample type, profile ends with %x", got[len(got)-2:])
+	}
+}
+
+func TestProtoBufferVarint(t *testing.T) {
+	var p protoBuffer
+	p.uint64Field(1, 300)
+	if got, want := p.Bytes(), []byte{0x08, 0xac, 0x02}; !bytes.Equal(got, want) {
+		t.Errorf("field 1 = 300 encoded as %x, want %x", got, want)
+	}
+}
+
+func TestSummaryTextListsAllMatchingPIDs(t *testing.T) {
+	summary := SummaryStats{TotalSamples: 10, ProcessName: "nginx", PID: 1200, PIDs: profiledPIDs([]int{1200, 1201, 1202})}
+	text := generateSummaryText(summary, nil, nil, 0)
+	if !strings.Contains(text, "Process: nginx (3 PIDs: 1200, 1201, 1202)") {
+		t.Errorf("Summary does not list the profiled PIDs:\n%s", text)
+	}
+
+	if pids := profiledPIDs([]int{1200}); pids != nil {
+		t.Errorf("Expected no PID list for a single process, got %v", pids)
+	}
+}
+
+func TestParsePerfReportGoRuntime(t *testing.T) {
+	samples := []*parser.Sample{
+		{Stack: []parser.StackFrame{{Symbol: "runtime.mallocgc", Type: parser.FrameTypeGoRuntime, IsUserland: true}}},
+		{Stack: []parser.StackFrame{{Symbol: "runtime.gcBgMarkWorker", Type: parser.FrameTypeGoRuntime, IsUserland: true}}},
+		{Stack: []parser.StackFrame{{Symbol: "main.handle", Type: parser.FrameTypeApplication, IsUserland: true}}},
+		{Stack: []parser.StackFrame{{Symbol: "do_syscall_64", Type: parser.FrameTypeKernelCore, IsKernel: true}}},
+	}
+	result := parsePerfReport("", samples, false)
+	if result.Summary.GoRuntimePercent != 50 || result.Summary.UserlandPercent != 75 {
+		t.Errorf("Expected 50%% Go runtime within 75%% userland, got %+v", result.Summary)
+	}
+	if result.Summary.AllocatorPercent != 0 {
+		t.Errorf("Expected no allocator share, got %f", result.Summary.AllocatorPercent)
+	}
+	if text := generateSummaryText(result.Summary, result.TopFunctions, nil, 0); !strings.Contains(text, "Go runtime (GC, allocation, scheduler): 50.00%") {
+		t.Errorf("Summary does not report the Go runtime share:\n%s", text)
+	}
+}
+
+func TestParsePerfReportAllocator(t *testing.T) {
+	samples := []*parser.Sample{
+		{Stack: []parser.StackFrame{{Symbol: "je_malloc", Type: parser.FrameTypeAllocator, IsUserland: true}}},
+		{Stack: []parser.StackFrame{{Symbol: "do_command", Type: parser.FrameTypeApplication, IsUserland: true}}},
+	}
+	result := parsePerfReport("", samples, false)
+	if result.Summary.AllocatorPercent != 50 {
+		t.Errorf("Expected 50%% in the allocator, got %+v", result.Summary)
+	}
+	if text := generateSummaryText(result.Summary, result.TopFunctions, nil, 0); !strings.Contains(text, "Memory allocator: 50.00%") {
+		t.Errorf("Summary does not report the allocator share:\n%s", text)
+	}
+}
+
+func TestParsePerfReportJIT(t *testing.T) {
+	samples := []*parser.Sample{
+		{Stack: []parser.StackFrame{{Symbol: "Lcom/example/Handler;::handle", Module: "/tmp/perf-1234.map", Type: parser.FrameTypeJIT, IsUserland: true}}},
+		{Stack: []parser.StackFrame{{Symbol: "JVM_Sleep", Module: "/usr/lib/jvm/lib/server/libjvm.so", Type: parser.FrameTypeUnknown, IsUserland: true}}},
+		{Stack: []parser.StackFrame{{Symbol: "futex_wait", Module: "[kernel.kallsyms]", Type: parser.FrameTypeKernelCore, IsKernel: true}}},
+		{Stack: []parser.StackFrame{{Symbol: "Ljava/lang/String;::hashCode", Module: "/tmp/perf-1234.map", Type: parser.FrameTypeJIT, IsUserland: true}}},
+	}
+	result := parsePerfReport("", samples, false)
+	if result.Summary.JITPercent != 50 || result.Summary.UserlandPercent != 75 {
+		t.Errorf("Expected 50%% JIT within 75%% userland, got %+v", result.Summary)
+	}
+	if text := generateSummaryText(result.Summary, result.TopFunctions, nil, 0); !strings.Contains(text, "JIT-compiled code: 50.00%") {
+		t.Errorf("Summary does not report the JIT share:\n%s", text)
+	}
+}
+
+func TestHottestThreads(t *testing.T) {
+	byThread := make(map[int][]*parser.Sample)
+	for tid, count := range map[int]int{101: 5, 102: 150, 103: 300, 104: 150, 105: 120} {
+		for i := 0; i < count; i++ {
+			byThread[tid] = append(byThread[tid], &parser.Sample{TID: tid})
+		}
+	}
+
+	tids, skipped := hottestThreads(byThread, 100, 3)
+	if fmt.Sprint(tids) != "[103 102 104]" || skipped != 1 {
+		t.Errorf("hottestThreads() = %v, %d skipped, want [103 102 104], 1 skipped", tids, skipped)
+	}
+
+	tids, skipped = hottestThreads(byThread, 200, 3)
+	if fmt.Sprint(tids) != "[103]" || skipped != 0 {
+		t.Errorf("hottestThreads() with 200 samples = %v, %d skipped, want [103], 0 skipped", tids, skipped)
+	}
+}
+
+func TestFlamegraphScript(t *testing.T) {
+	originalURL, originalSum := flamegraphURL, flamegraphSHA256
+	defer func() {
+		flamegraphURL, flamegraphSHA256 = originalURL, originalSum
+		SetNoDownload(false)
+	}()
+
+	// No flamegraph.pl in PATH, an empty cache and a local "upstream" copy
+	curl, err := exec.LookPath("curl")
+	if err != nil {
+		t.Skip("curl not available")
+	}
+	dir := t.TempDir()
+	t.Setenv("PATH", filepath.Dir(curl))
+	if _, err := exec.LookPath("flamegraph.pl"); err == nil {
+		t.Skip("flamegraph.pl is installed next to curl")
+	}
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(dir, "cache"))
+	upstream := filepath.Join(dir, "flamegraph.pl")
+	if err := os.WriteFile(upstream, []byte("#!/usr/bin/perl\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(flamegraphURL, "/"+flamegraphCommit+"/") {
+		t.Errorf("flamegraph.pl URL not pinned to commit %s: %s", flamegraphCommit, flamegraphURL)
+	}
+	flamegraphURL = "file://" + upstream
+	flamegraphSHA256 = "ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"
+	logf := func(string, ...interface{}) {}
+	cachePath := filepath.Join(dir, "cache", "blc-perf-analyzer", "flamegraph.pl")
+
+	SetNoDownload(true)
+	if _, err := flamegraphScript(logf); err == nil || !strings.Contains(err.Error(), "--no-download") {
+		t.Errorf("Expected a --no-download error, got %v", err)
+	}
+	SetNoDownload(false)
+
+	if _, err := flamegraphScript(logf); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("Expected a checksum mismatch, got %v", err)
+	}
+	if _, err := os.Stat(cachePath); !os.IsNotExist(err) {
+		t.Error("A download with the wrong checksum was kept in the cache")
+	}
+
+	sum := sha256.Sum256([]byte("#!/usr/bin/perl\n"))
+	flamegraphSHA256 = hex.EncodeToString(sum[:])
+	path, err := flamegraphScript(logf)
+	if err != nil || path != cachePath {
+		t.Fatalf("flamegraphScript() = %q, %v, want %q", path, err, cachePath)
+	}
+
+	// The cached copy is reused without downloading, and verified again
+	flamegraphURL = "file://" + filepath.Join(dir, "missing.pl")
+	if path, err := flamegraphScript(logf); err != nil || path != cachePath {
+		t.Errorf("Cached flamegraph.pl not reused: %q, %v", path, err)
+	}
+	if err := os.WriteFile(cachePath, []byte("#!/bin/sh\nrm -rf ~\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := flamegraphScript(logf); err == nil || !strings.Contains(err.Error(), "refusing") {
+		t.Errorf("Expected a tampered cache to be refused, got %v", err)
+	}
+}
+
+func TestGenerateSummaryMarkdown(t *testing.T) {
+	summary := SummaryStats{
+		ProcessName:     "test_process",
+		PID:             12345,
+		CaptureDuration: 60,
+		TotalSamples:    1000,
+		UserlandPercent: 30.5,
+		KernelPercent:   9.3,
+		UnknownPercent:  60.2,
+		NoSymbolPercent: 60.2,
+	}
+	topFunctions := []FunctionStats{
+		{Name: "[unknown]", Percentage: 60.2, TotalPercentage: 60.2},
+		{Name: "operator|", Percentage: 12.3, TotalPercentage: 20.1},
+	}
+
+	md := generateSummaryMarkdown(summary, topFunctions, 0)
+
+	for _, required := range []string{
+		"# Performance Analysis Summary",
+		"- **Process:** `test_process` (PID 12345)",
+		"| Category | % |\n|---|---:|\n",
+		"| Userland | 30.50 |",
+		"| # | Function | Self % | With callees % |\n|---:|---|---:|---:|\n",
+		"| 1 | `[unknown]` | 60.20 | 60.20 |",
+		"| 2 | `operator\\|` | 12.30 | 20.10 |",
+		"> ⚠️  High percentage of [unknown] symbols detected!",
+		">   • Binary is stripped (compiled without debug symbols)",
+	} {
+		if !strings.Contains(md, required) {
+			t.Errorf("Markdown summary missing %q:\n%s", required, md)
+		}
+	}
+
+	topFunctions[0] = FunctionStats{Name: "main", Percentage: 60.2}
+	if md := generateSummaryMarkdown(summary, topFunctions, 0); strings.Contains(md, "stripped") {
+		t.Errorf("Unexpected symbol guidance without [unknown] frames:\n%s", md)
+	}
+}
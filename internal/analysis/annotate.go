@@ -0,0 +1,75 @@
+package analysis
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/santiagolertora/blc-perf-analyzer/internal/detector"
+)
+
+// Annotation is the per-instruction annotation of a hot function
+type Annotation struct {
+	Function string `json:"function"`
+	File     string `json:"file,omitempty"`  // Relative to the output directory
+	Error    string `json:"error,omitempty"` // Why the function could not be annotated
+}
+
+// annotationsDir is the output subdirectory holding the annotation files
+const annotationsDir = "annotations"
+
+// unsafeFileChars matches characters not kept in annotation file names
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// generateAnnotations runs `perf annotate` for the top n functions and saves
+// each listing under annotations/. Functions that cannot be annotated (no
+// symbol or debug info) are reported with the reason instead of failing;
+// warnings go to logf.
+func generateAnnotations(perfDataPath, outputDir string, functions []FunctionStats, n int, logf func(format string, args ...interface{})) []Annotation {
+	dir := filepath.Join(outputDir, annotationsDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		logf("Warning: Could not create annotations directory: %v\n", err)
+		return nil
+	}
+
+	annotations := make([]Annotation, 0, n)
+	for _, fn := range functions {
+		if len(annotations) >= n {
+			break
+		}
+		if fn.Name == "" || strings.Contains(fn.Name, "unknown") {
+			continue
+		}
+
+		annotation := Annotation{Function: fn.Name}
+		cmd := exec.Command(detector.PerfBinary(), detector.PerfArgs("annotate", "-i", perfDataPath, "--stdio", "-s", fn.Name)...)
+		output, err := cmd.Output()
+		if err != nil || len(strings.TrimSpace(string(output))) == 0 {
+			annotation.Error = "no annotation available (missing symbols or debug info?)"
+			annotations = append(annotations, annotation)
+			continue
+		}
+
+		file := filepath.Join(annotationsDir, annotationFileName(len(annotations)+1, fn.Name))
+		if err := os.WriteFile(filepath.Join(outputDir, file), output, 0644); err != nil {
+			annotation.Error = fmt.Sprintf("error saving annotation: %v", err)
+		} else {
+			annotation.File = file
+		}
+		annotations = append(annotations, annotation)
+	}
+
+	return annotations
+}
+
+// annotationFileName builds a file name like "01-do_command.txt"
+func annotationFileName(rank int, function string) string {
+	name := unsafeFileChars.ReplaceAllString(function, "_")
+	if len(name) > 100 {
+		name = name[:100]
+	}
+	return fmt.Sprintf("%02d-%s.txt", rank, name)
+}
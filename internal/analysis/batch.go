@@ -0,0 +1,50 @@
+package analysis
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// GenerateReports runs GenerateReport for several independent inputs using at
+// most jobs concurrent workers, which also bounds the number of perf and
+// flamegraph.pl subprocesses. Results are returned in the order of configs;
+// the entry of a failed input is nil and its error is included in the
+// returned (joined) error.
+func GenerateReports(configs []*ReportConfig, jobs int) ([]*AnalysisResult, error) {
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	results := make([]*AnalysisResult, len(configs))
+	errs := make([]error, len(configs))
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, jobs)
+	for i, config := range configs {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, config *ReportConfig) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			result, err := GenerateReport(config)
+			if err != nil {
+				errs[i] = fmt.Errorf("%s: %v", reportInput(config), err)
+				return
+			}
+			results[i] = result
+		}(i, config)
+	}
+	wg.Wait()
+
+	return results, errors.Join(errs...)
+}
+
+// reportInput names the input of a report for error messages
+func reportInput(config *ReportConfig) string {
+	if config.ScriptPath != "" {
+		return config.ScriptPath
+	}
+	return config.PerfDataPath
+}
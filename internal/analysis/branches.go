@@ -0,0 +1,137 @@
+package analysis
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/santiagolertora/blc-perf-analyzer/internal/detector"
+	"github.com/santiagolertora/blc-perf-analyzer/internal/parser"
+)
+
+// BranchStats contains misprediction statistics for a single branch source
+type BranchStats struct {
+	Source         string  `json:"source"`
+	Mispredicted   int     `json:"mispredicted"`
+	Total          int     `json:"total"`
+	MispredictRate float64 `json:"mispredict_rate_percent"`
+}
+
+// maxBranchSources is the number of misprediction sources kept in the report
+const maxBranchSources = 20
+
+// branchStackRecorded reports whether perf evlist -v output shows events
+// sampled with their taken branches (perf record -j/-b). LBR call stacks
+// (--call-graph lbr) also use the branch stack, but only for calls and
+// returns, so their branch_sample_type with CALL_STACK does not count.
+func branchStackRecorded(evlist string) bool {
+	for _, line := range strings.Split(evlist, "\n") {
+		idx := strings.Index(line, "branch_sample_type: ")
+		if idx < 0 {
+			continue
+		}
+		branchType := strings.Fields(line[idx+len("branch_sample_type: "):])
+		if len(branchType) > 0 && !strings.Contains(branchType[0], "CALL_STACK") {
+			return true
+		}
+	}
+	return false
+}
+
+// branchMispredictions reads the branch records of perf.data and returns the
+// top misprediction sources. It returns nil stats when perf.data has no
+// branch stack, or only LBR call stacks.
+func branchMispredictions(perfDataPath string, redact *parser.Redactor) ([]BranchStats, error) {
+	evlist, err := exec.Command(detector.PerfBinary(), detector.PerfArgs("evlist", "-v", "-i", perfDataPath)...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("error running perf evlist: %v", err)
+	}
+	if !branchStackRecorded(string(evlist)) {
+		return nil, nil
+	}
+
+	cmd := exec.Command(detector.PerfBinary(), detector.PerfArgs("script", "-i", perfDataPath, "-F", "brstacksym")...)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error running perf script for branch stacks: %v", err)
+	}
+
+	records, err := parser.ParseBranchStack(string(output))
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	stats := analyzeBranches(records)
+	for i := range stats {
+		stats[i].Source = redactBranchSource(stats[i].Source, redact)
+	}
+	return stats, nil
+}
+
+// writeBranchReport saves the misprediction sources as
+// branch-mispredictions.json
+func writeBranchReport(outputDir string, stats []BranchStats) error {
+	branchJSON, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling branch stats: %v", err)
+	}
+	branchPath := filepath.Join(outputDir, "branch-mispredictions.json")
+	if err := os.WriteFile(branchPath, branchJSON, 0644); err != nil {
+		return fmt.Errorf("error saving branch stats: %v", err)
+	}
+	return nil
+}
+
+// analyzeBranches aggregates branch records by source and returns the sources
+// with the most mispredictions first
+func analyzeBranches(records []parser.BranchRecord) []BranchStats {
+	bySource := make(map[string]*BranchStats)
+	for _, record := range records {
+		stats, exists := bySource[record.From]
+		if !exists {
+			stats = &BranchStats{Source: record.From}
+			bySource[record.From] = stats
+		}
+		stats.Total++
+		if record.Mispredicted {
+			stats.Mispredicted++
+		}
+	}
+
+	result := make([]BranchStats, 0, len(bySource))
+	for _, stats := range bySource {
+		if stats.Mispredicted == 0 {
+			continue
+		}
+		stats.MispredictRate = float64(stats.Mispredicted) / float64(stats.Total) * 100
+		result = append(result, *stats)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Mispredicted != result[j].Mispredicted {
+			return result[i].Mispredicted > result[j].Mispredicted
+		}
+		return result[i].Source < result[j].Source
+	})
+
+	if len(result) > maxBranchSources {
+		result = result[:maxBranchSources]
+	}
+	return result
+}
+
+// redactBranchSource redacts the symbol of a "symbol+0xoffset" branch source,
+// keeping the offset
+func redactBranchSource(source string, redact *parser.Redactor) string {
+	if idx := strings.LastIndex(source, "+0x"); idx > 0 {
+		return redact.Name(source[:idx]) + source[idx:]
+	}
+	return redact.Name(source)
+}
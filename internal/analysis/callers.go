@@ -0,0 +1,67 @@
+package analysis
+
+import (
+	"sort"
+
+	"github.com/santiagolertora/blc-perf-analyzer/internal/parser"
+)
+
+// CallerReport lists the immediate callers of a function
+type CallerReport struct {
+	Function string        `json:"function"`
+	Samples  int           `json:"samples"` // Samples with the function on the stack
+	Callers  []CallerStats `json:"callers"`
+}
+
+// CallerStats is one immediate caller of the inspected function
+type CallerStats struct {
+	Name       string  `json:"name"`
+	Samples    int     `json:"samples"`
+	Percentage float64 `json:"percentage"` // Share of the function's samples
+}
+
+// maxCallers is the number of callers kept in the report
+const maxCallers = 10
+
+// findCallers walks every stack from the leaf up and, at the first frame of
+// function, counts the frame just below it (its caller). Samples where the
+// function is the root of the stack are counted as "[root]".
+func findCallers(samples []*parser.Sample, function string) *CallerReport {
+	report := &CallerReport{Function: function, Callers: make([]CallerStats, 0)}
+
+	counts := make(map[string]int)
+	for _, sample := range samples {
+		for i, frame := range sample.Stack {
+			if frame.Symbol != function {
+				continue
+			}
+			caller := "[root]"
+			if i+1 < len(sample.Stack) {
+				caller = sample.Stack[i+1].Symbol
+			}
+			counts[caller]++
+			report.Samples++
+			break
+		}
+	}
+
+	for name, count := range counts {
+		report.Callers = append(report.Callers, CallerStats{
+			Name:       name,
+			Samples:    count,
+			Percentage: float64(count) / float64(report.Samples) * 100,
+		})
+	}
+
+	sort.Slice(report.Callers, func(i, j int) bool {
+		if report.Callers[i].Samples != report.Callers[j].Samples {
+			return report.Callers[i].Samples > report.Callers[j].Samples
+		}
+		return report.Callers[i].Name < report.Callers[j].Name
+	})
+
+	if len(report.Callers) > maxCallers {
+		report.Callers = report.Callers[:maxCallers]
+	}
+	return report
+}
@@ -0,0 +1,78 @@
+package analysis
+
+import (
+	"sort"
+
+	"github.com/santiagolertora/blc-perf-analyzer/internal/parser"
+)
+
+// CgroupStats is the CPU share of one cgroup (a container, pod or systemd unit)
+type CgroupStats struct {
+	Cgroup     string  `json:"cgroup"`
+	Samples    int     `json:"samples"`
+	Percentage float64 `json:"percentage"`
+	Processes  int     `json:"processes"` // Distinct PIDs sampled in the cgroup
+}
+
+// maxCgroups is the number of cgroups kept in the summary
+const maxCgroups = 10
+
+// unknownCgroup groups samples of processes whose cgroup could not be read,
+// typically because they exited before the analysis
+const unknownCgroup = "[unknown]"
+
+// cgroupBreakdown attributes samples to cgroups through the PID of each
+// sample and returns the busiest cgroups first. lookup resolves a PID to its
+// cgroup (process.CgroupOf for live captures). It returns nil when no PID
+// could be resolved.
+func cgroupBreakdown(samples []*parser.Sample, lookup func(pid int) (string, error)) []CgroupStats {
+	if len(samples) == 0 {
+		return nil
+	}
+
+	cgroupOf := make(map[int]string)
+	byCgroup := make(map[string]*CgroupStats)
+	resolved := false
+	for _, sample := range samples {
+		cgroup, seen := cgroupOf[sample.PID]
+		if !seen {
+			var err error
+			if cgroup, err = lookup(sample.PID); err != nil {
+				cgroup = unknownCgroup
+			} else {
+				resolved = true
+			}
+			cgroupOf[sample.PID] = cgroup
+		}
+
+		stats, exists := byCgroup[cgroup]
+		if !exists {
+			stats = &CgroupStats{Cgroup: cgroup}
+			byCgroup[cgroup] = stats
+		}
+		stats.Samples++
+		if !seen {
+			stats.Processes++
+		}
+	}
+	if !resolved {
+		return nil
+	}
+
+	result := make([]CgroupStats, 0, len(byCgroup))
+	for _, stats := range byCgroup {
+		stats.Percentage = float64(stats.Samples) / float64(len(samples)) * 100
+		result = append(result, *stats)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Samples != result[j].Samples {
+			return result[i].Samples > result[j].Samples
+		}
+		return result[i].Cgroup < result[j].Cgroup
+	})
+
+	if len(result) > maxCgroups {
+		result = result[:maxCgroups]
+	}
+	return result
+}
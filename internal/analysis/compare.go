@@ -0,0 +1,219 @@
+package analysis
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefaultCompareThreshold is the change, in percentage points of all
+// samples, above which a function is reported as grown or shrunk
+const DefaultCompareThreshold = 1.0
+
+// Function changes between two captures
+const (
+	ChangeGrew      = "grew"
+	ChangeShrank    = "shrank"
+	ChangeNew       = "new"  // Only in the after capture
+	ChangeGone      = "gone" // Only in the before capture
+	ChangeUnchanged = "unchanged"
+)
+
+// Comparison is the per-function difference between two captures, as written
+// to diff.json
+type Comparison struct {
+	Before        string          `json:"before"`
+	After         string          `json:"after"`
+	BeforeSamples int             `json:"before_samples"`
+	AfterSamples  int             `json:"after_samples"`
+	Threshold     float64         `json:"threshold_percent"`
+	Functions     []FunctionDelta `json:"functions"` // Largest regression first
+}
+
+// FunctionDelta is the change of one function's self samples. A function
+// missing from one side counts as 0 there.
+type FunctionDelta struct {
+	Name          string  `json:"name"`
+	BeforePercent float64 `json:"before_percent"`
+	AfterPercent  float64 `json:"after_percent"`
+	DeltaPercent  float64 `json:"delta_percent"` // Percentage points, positive when the function got hotter
+	BeforeSamples int     `json:"before_samples"`
+	AfterSamples  int     `json:"after_samples"`
+	DeltaSamples  int     `json:"delta_samples"`
+	Change        string  `json:"change"` // "grew", "shrank", "new", "gone" or "unchanged"
+}
+
+// capturedFunctions are the function statistics of one side of a comparison
+type capturedFunctions struct {
+	totalSamples int
+	functions    []FunctionStats
+}
+
+// CompareCaptures compares the self samples of every function in two
+// captures. Each path may be a result directory, whose summary.json is used
+// when it lists the functions, a perf.data file or saved perf script text.
+// Functions are aligned by name; threshold is in percentage points.
+func CompareCaptures(beforePath, afterPath string, threshold float64) (*Comparison, error) {
+	before, err := loadCapturedFunctions(beforePath)
+	if err != nil {
+		return nil, fmt.Errorf("error loading before capture: %v", err)
+	}
+	after, err := loadCapturedFunctions(afterPath)
+	if err != nil {
+		return nil, fmt.Errorf("error loading after capture: %v", err)
+	}
+
+	comparison := compareFunctions(before, after, threshold)
+	comparison.Before = beforePath
+	comparison.After = afterPath
+	return comparison, nil
+}
+
+// loadCapturedFunctions reads the function statistics of a capture
+func loadCapturedFunctions(path string) (*capturedFunctions, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		summaryPath := filepath.Join(path, "summary.json")
+		if content, err := os.ReadFile(summaryPath); err == nil {
+			var saved savedSummary
+			if err := json.Unmarshal(content, &saved); err != nil {
+				return nil, fmt.Errorf("error reading %s: %v", summaryPath, err)
+			}
+			// Summaries written before the functions were saved fall back to perf.data
+			if len(saved.TopFunctions) > 0 || saved.TotalSamples == 0 {
+				return &capturedFunctions{totalSamples: saved.TotalSamples, functions: saved.TopFunctions}, nil
+			}
+		}
+		path = filepath.Join(path, "perf.data")
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("no summary.json with functions or perf.data in %s", filepath.Dir(path))
+		}
+	}
+
+	samples, err := LoadSamples(path)
+	if err != nil {
+		return nil, err
+	}
+	stats := parsePerfReport("", samples, false)
+	return &capturedFunctions{totalSamples: len(samples), functions: stats.TopFunctions}, nil
+}
+
+// compareFunctions aligns the functions of both sides by name and sorts them
+// by change, largest regression first
+func compareFunctions(before, after *capturedFunctions, threshold float64) *Comparison {
+	comparison := &Comparison{
+		BeforeSamples: before.totalSamples,
+		AfterSamples:  after.totalSamples,
+		Threshold:     threshold,
+		Functions:     make([]FunctionDelta, 0),
+	}
+
+	deltas := make(map[string]*FunctionDelta)
+	delta := func(name string) *FunctionDelta {
+		if deltas[name] == nil {
+			deltas[name] = &FunctionDelta{Name: name}
+		}
+		return deltas[name]
+	}
+	for _, fn := range before.functions {
+		if fn.SelfSamples > 0 {
+			d := delta(fn.Name)
+			d.BeforeSamples, d.BeforePercent = fn.SelfSamples, fn.Percentage
+		}
+	}
+	for _, fn := range after.functions {
+		if fn.SelfSamples > 0 {
+			d := delta(fn.Name)
+			d.AfterSamples, d.AfterPercent = fn.SelfSamples, fn.Percentage
+		}
+	}
+
+	for _, d := range deltas {
+		d.DeltaPercent = d.AfterPercent - d.BeforePercent
+		d.DeltaSamples = d.AfterSamples - d.BeforeSamples
+		switch {
+		case d.BeforeSamples == 0:
+			d.Change = ChangeNew
+		case d.AfterSamples == 0:
+			d.Change = ChangeGone
+		case d.DeltaPercent >= threshold:
+			d.Change = ChangeGrew
+		case d.DeltaPercent <= -threshold:
+			d.Change = ChangeShrank
+		default:
+			d.Change = ChangeUnchanged
+		}
+		comparison.Functions = append(comparison.Functions, *d)
+	}
+
+	sort.Slice(comparison.Functions, func(i, j int) bool {
+		a, b := comparison.Functions[i], comparison.Functions[j]
+		if a.DeltaPercent != b.DeltaPercent {
+			return a.DeltaPercent > b.DeltaPercent
+		}
+		return a.Name < b.Name
+	})
+	return comparison
+}
+
+// Text formats the comparison as written to diff.txt: the functions that
+// changed beyond the threshold, largest regression first
+func (c *Comparison) Text() string {
+	var text strings.Builder
+
+	text.WriteString("Capture Comparison\n")
+	text.WriteString("==================\n\n")
+	text.WriteString(fmt.Sprintf("Before: %s (%d samples)\n", c.Before, c.BeforeSamples))
+	text.WriteString(fmt.Sprintf("After:  %s (%d samples)\n", c.After, c.AfterSamples))
+	text.WriteString(fmt.Sprintf("Threshold: %.1f percentage points of self samples\n\n", c.Threshold))
+
+	unchanged := 0
+	var rows strings.Builder
+	for _, fn := range c.Functions {
+		// Functions that appeared or vanished with a tiny share are noise
+		if math.Abs(fn.DeltaPercent) < c.Threshold {
+			unchanged++
+			continue
+		}
+		rows.WriteString(fmt.Sprintf("%-8s %+7.2f%%  %6.2f%% -> %6.2f%%  (%+d samples)  %s\n",
+			fn.Change, fn.DeltaPercent, fn.BeforePercent, fn.AfterPercent, fn.DeltaSamples, fn.Name))
+	}
+
+	if rows.Len() == 0 {
+		text.WriteString("No function changed beyond the threshold.\n")
+	} else {
+		text.WriteString("Changed Functions:\n")
+		text.WriteString(rows.String())
+	}
+	if unchanged > 0 {
+		text.WriteString(fmt.Sprintf("\n%d other functions changed by less than %.1f points.\n", unchanged, c.Threshold))
+	}
+	return text.String()
+}
+
+// WriteComparison saves the comparison as diff.json and diff.txt in outputDir
+func WriteComparison(outputDir string, c *Comparison) error {
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("error creating output directory: %v", err)
+	}
+
+	content, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling comparison: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(outputDir, "diff.json"), content, 0644); err != nil {
+		return fmt.Errorf("error saving diff.json: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(outputDir, "diff.txt"), []byte(c.Text()), 0644); err != nil {
+		return fmt.Errorf("error saving diff.txt: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package analysis
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/santiagolertora/blc-perf-analyzer/internal/parser"
+)
+
+// StatCounters are the top-line hardware and software counters collected by
+// perf stat during the capture (--with-stat). Ratios are 0 when one of their
+// counters is not available (common in virtual machines).
+type StatCounters struct {
+	Cycles                uint64  `json:"cycles"`
+	Instructions          uint64  `json:"instructions"`
+	CacheReferences       uint64  `json:"cache_references"`
+	CacheMisses           uint64  `json:"cache_misses"`
+	Branches              uint64  `json:"branches"`
+	BranchMisses          uint64  `json:"branch_misses"`
+	ContextSwitches       uint64  `json:"context_switches"`
+	IPC                   float64 `json:"ipc"` // Instructions per cycle
+	CacheMissPercent      float64 `json:"cache_miss_percent"`
+	BranchMissPercent     float64 `json:"branch_miss_percent"`
+	ContextSwitchesPerSec float64 `json:"context_switches_per_sec"`
+}
+
+// loadStatCounters reads the perf stat CSV written during a capture of
+// duration seconds
+func loadStatCounters(path string, duration int) (*StatCounters, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading perf stat output: %v", err)
+	}
+	counters := parser.ParsePerfStat(string(content))
+	if len(counters) == 0 {
+		return nil, fmt.Errorf("no counters in perf stat output %s", path)
+	}
+	return computeStatCounters(counters, duration), nil
+}
+
+// computeStatCounters derives the efficiency ratios from raw counter values
+func computeStatCounters(counters map[string]float64, duration int) *StatCounters {
+	stats := &StatCounters{
+		Cycles:          uint64(counters["cycles"]),
+		Instructions:    uint64(counters["instructions"]),
+		CacheReferences: uint64(counters["cache-references"]),
+		CacheMisses:     uint64(counters["cache-misses"]),
+		Branches:        uint64(counters["branches"]),
+		BranchMisses:    uint64(counters["branch-misses"]),
+		ContextSwitches: uint64(counters["context-switches"]),
+	}
+
+	if stats.Cycles > 0 {
+		stats.IPC = float64(stats.Instructions) / float64(stats.Cycles)
+	}
+	if stats.CacheReferences > 0 {
+		stats.CacheMissPercent = float64(stats.CacheMisses) / float64(stats.CacheReferences) * 100
+	}
+	if stats.Branches > 0 {
+		stats.BranchMissPercent = float64(stats.BranchMisses) / float64(stats.Branches) * 100
+	}
+	if duration > 0 {
+		stats.ContextSwitchesPerSec = float64(stats.ContextSwitches) / float64(duration)
+	}
+	return stats
+}
+
+// formatStatCounters renders the counters section of summary.txt, skipping
+// ratios whose counters were not available
+func formatStatCounters(stats *StatCounters) string {
+	var text strings.Builder
+	text.WriteString("\nHardware Counters (perf stat):\n")
+	if stats.Cycles > 0 && stats.Instructions > 0 {
+		text.WriteString(fmt.Sprintf("  IPC: %.2f (%d instructions / %d cycles)\n", stats.IPC, stats.Instructions, stats.Cycles))
+	}
+	if stats.CacheReferences > 0 {
+		text.WriteString(fmt.Sprintf("  Cache miss rate: %.2f%% (%d of %d references)\n", stats.CacheMissPercent, stats.CacheMisses, stats.CacheReferences))
+	}
+	if stats.Branches > 0 {
+		text.WriteString(fmt.Sprintf("  Branch miss rate: %.2f%% (%d of %d branches)\n", stats.BranchMissPercent, stats.BranchMisses, stats.Branches))
+	}
+	text.WriteString(fmt.Sprintf("  Context switches: %d (%.1f/s)\n", stats.ContextSwitches, stats.ContextSwitchesPerSec))
+	return text.String()
+}
@@ -0,0 +1,24 @@
+package analysis
+
+import (
+	"fmt"
+	"strings"
+)
+
+// formatCPUList renders sorted CPUs in cpulist notation, e.g. "0-3,8"
+func formatCPUList(cpus []int) string {
+	parts := make([]string, 0, len(cpus))
+	for i := 0; i < len(cpus); {
+		j := i
+		for j+1 < len(cpus) && cpus[j+1] == cpus[j]+1 {
+			j++
+		}
+		if j == i {
+			parts = append(parts, fmt.Sprintf("%d", cpus[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", cpus[i], cpus[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,101 @@
+package analysis
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/santiagolertora/blc-perf-analyzer/internal/detector"
+)
+
+// buildIDEntry is a DSO recorded in perf.data together with its build-id
+type buildIDEntry struct {
+	BuildID string
+	Path    string
+}
+
+// RegisterDebugSymbols makes split debug files from debugDir available to perf.
+//
+// perf looks up separate debuginfo through its build-id cache, so every DSO
+// hit in the capture is matched against debugDir using both common layouts
+// (.build-id/xx/rest.debug and the path-mirrored <dir>/usr/sbin/app.debug)
+// and the matches are added with `perf buildid-cache --add` to a temporary
+// cache, leaving the user's ~/.debug untouched. Subsequent perf
+// script/report/annotate calls resolve symbols from it until cleanup, which
+// the caller must run once the analysis is done, removes it. It returns the
+// number of debug files registered. Warnings go to progress
+// unless it is nil.
+func RegisterDebugSymbols(perfDataPath, debugDir string, progress io.Writer) (int, func(), error) {
+	if info, err := os.Stat(debugDir); err != nil || !info.IsDir() {
+		return 0, nil, fmt.Errorf("debug directory %s is not accessible", debugDir)
+	}
+
+	cmd := exec.Command(detector.PerfBinary(), "buildid-list", "-i", perfDataPath)
+	output, err := cmd.Output()
+	if err != nil {
+		return 0, nil, fmt.Errorf("error running perf buildid-list: %v", err)
+	}
+
+	cacheDir, err := os.MkdirTemp("", "blc-buildid-")
+	if err != nil {
+		return 0, nil, fmt.Errorf("error creating build-id cache: %v", err)
+	}
+	detector.SetBuildIDDir(cacheDir)
+	cleanup := func() {
+		detector.SetBuildIDDir("")
+		os.RemoveAll(cacheDir)
+	}
+
+	registered := 0
+	for _, entry := range parseBuildIDList(string(output)) {
+		for _, candidate := range debugFileCandidates(debugDir, entry) {
+			if _, err := os.Stat(candidate); err != nil {
+				continue
+			}
+			add := exec.Command(detector.PerfBinary(), detector.PerfArgs("buildid-cache", "--add", candidate)...)
+			if err := add.Run(); err != nil {
+				if progress != nil {
+					fmt.Fprintf(progress, "Warning: Could not register debug file %s: %v\n", candidate, err)
+				}
+				continue
+			}
+			registered++
+			break
+		}
+	}
+
+	return registered, cleanup, nil
+}
+
+// parseBuildIDList parses `perf buildid-list` output ("<build-id> <path>" lines)
+func parseBuildIDList(output string) []buildIDEntry {
+	entries := make([]buildIDEntry, 0)
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || len(fields[0]) < 3 {
+			continue
+		}
+		entries = append(entries, buildIDEntry{BuildID: fields[0], Path: fields[1]})
+	}
+	return entries
+}
+
+// debugFileCandidates returns the possible locations of the debug file for a DSO
+func debugFileCandidates(debugDir string, entry buildIDEntry) []string {
+	candidates := []string{
+		filepath.Join(debugDir, ".build-id", entry.BuildID[:2], entry.BuildID[2:]+".debug"),
+	}
+	// Kernel and anonymous maps ([kernel.kallsyms], [vdso]) have no file path
+	if strings.HasPrefix(entry.Path, "/") {
+		candidates = append(candidates,
+			filepath.Join(debugDir, entry.Path+".debug"),
+			filepath.Join(debugDir, entry.Path),
+		)
+	}
+	return candidates
+}
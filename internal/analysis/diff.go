@@ -0,0 +1,131 @@
+package analysis
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GenerateDiffFlamegraph folds two captures and renders a differential
+// flamegraph. Frames that got hotter in the after capture are drawn red and
+// frames that got colder are drawn blue. Each input may be a perf.data file,
+// a perf.folded file, or a result directory containing either of them.
+// It returns the path of the generated SVG. Progress messages go to progress
+// unless it is nil.
+func GenerateDiffFlamegraph(beforePath, afterPath, outputDir string, opts FlamegraphOptions, progress io.Writer) (string, error) {
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return "", fmt.Errorf("error creating output directory: %v", err)
+	}
+
+	before, err := loadFoldedStacks(beforePath, opts.WeightByPeriod)
+	if err != nil {
+		return "", fmt.Errorf("error loading before capture: %v", err)
+	}
+	after, err := loadFoldedStacks(afterPath, opts.WeightByPeriod)
+	if err != nil {
+		return "", fmt.Errorf("error loading after capture: %v", err)
+	}
+
+	// flamegraph.pl renders a differential graph when each line carries two counts
+	diffPath := filepath.Join(outputDir, "diff.folded")
+	if err := os.WriteFile(diffPath, []byte(diffFoldedStacks(before, after)), 0644); err != nil {
+		return "", fmt.Errorf("error writing differential folded stacks: %v", err)
+	}
+
+	svgPath := filepath.Join(outputDir, "flamegraph-diff.svg")
+	if err := renderFlamegraph(diffPath, svgPath, opts.args("Differential Flame Graph"), progress); err != nil {
+		return "", err
+	}
+
+	return svgPath, nil
+}
+
+// loadFoldedStacks returns the folded stack counts for a capture, perf.data
+// folded with each sample counting for its period when byPeriod is set
+func loadFoldedStacks(path string, byPeriod bool) (map[string]int, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		foldedPath := filepath.Join(path, "perf.folded")
+		if _, err := os.Stat(foldedPath); err == nil {
+			path = foldedPath
+		} else {
+			path = filepath.Join(path, "perf.data")
+		}
+	}
+
+	if strings.HasSuffix(path, ".folded") {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		stacks, err := parseFoldedStacks(f)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %v", path, err)
+		}
+		return stacks, nil
+	}
+
+	// Folded like the perf.folded of a report, from the parsed samples
+	samples, err := parsePerfScriptData(&ReportConfig{PerfDataPath: path, QuietMode: true})
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", path, err)
+	}
+	return parseFoldedStacks(strings.NewReader(foldSamples(samples, false, byPeriod)))
+}
+
+// parseFoldedStacks parses "frame;frame;frame count" lines. A read error or a
+// line over the 1 MiB limit is returned rather than yielding a partial profile.
+func parseFoldedStacks(r io.Reader) (map[string]int, error) {
+	stacks := make(map[string]int)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		sep := strings.LastIndex(line, " ")
+		if sep <= 0 {
+			continue
+		}
+		count, err := strconv.Atoi(line[sep+1:])
+		if err != nil {
+			continue
+		}
+		stacks[line[:sep]] += count
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return stacks, nil
+}
+
+// diffFoldedStacks merges two folded profiles into the two-column format
+// understood by flamegraph.pl ("stack before after"), sorted by stack
+func diffFoldedStacks(before, after map[string]int) string {
+	stacks := make([]string, 0, len(before)+len(after))
+	for stack := range before {
+		stacks = append(stacks, stack)
+	}
+	for stack := range after {
+		if _, exists := before[stack]; !exists {
+			stacks = append(stacks, stack)
+		}
+	}
+	sort.Strings(stacks)
+
+	var diff strings.Builder
+	for _, stack := range stacks {
+		diff.WriteString(fmt.Sprintf("%s %d %d\n", stack, before[stack], after[stack]))
+	}
+	return diff.String()
+}
@@ -0,0 +1,150 @@
+package analysis
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/santiagolertora/blc-perf-analyzer/internal/parser"
+)
+
+// EventComparison puts side by side the share of each function under every
+// event recorded in the capture (e.g. cycles vs cache-misses)
+type EventComparison struct {
+	Events []string             `json:"events"`
+	Rows   []EventComparisonRow `json:"rows"`
+}
+
+// EventComparisonRow is one function with its percentage of each event's samples
+type EventComparisonRow struct {
+	Function    string             `json:"function"`
+	Percentages map[string]float64 `json:"percentages"`
+}
+
+// maxEventComparisonRows is the number of functions kept in the comparison
+const maxEventComparisonRows = 15
+
+// compareEvents builds the cross-event table from the leaf functions of the
+// samples. It returns nil when fewer than two events were recorded. Functions
+// are ranked by their highest share under any event, so a function that is
+// cache-bound shows up even if it is moderate in cycles.
+func compareEvents(samples []*parser.Sample) *EventComparison {
+	totals := make(map[string]int)
+	counts := make(map[string]map[string]int) // function -> event -> samples
+	for _, sample := range samples {
+		frame := sample.GetTopFrame()
+		if frame == nil || sample.Event == "" {
+			continue
+		}
+		totals[sample.Event]++
+		if counts[frame.Symbol] == nil {
+			counts[frame.Symbol] = make(map[string]int)
+		}
+		counts[frame.Symbol][sample.Event]++
+	}
+	if len(totals) < 2 {
+		return nil
+	}
+
+	comparison := &EventComparison{Events: make([]string, 0, len(totals))}
+	for event := range totals {
+		comparison.Events = append(comparison.Events, event)
+	}
+	sort.Strings(comparison.Events)
+
+	peak := make(map[string]float64)
+	for function, byEvent := range counts {
+		row := EventComparisonRow{Function: function, Percentages: make(map[string]float64)}
+		for _, event := range comparison.Events {
+			percentage := float64(byEvent[event]) / float64(totals[event]) * 100
+			row.Percentages[event] = percentage
+			if percentage > peak[function] {
+				peak[function] = percentage
+			}
+		}
+		comparison.Rows = append(comparison.Rows, row)
+	}
+
+	sort.Slice(comparison.Rows, func(i, j int) bool {
+		a, b := comparison.Rows[i].Function, comparison.Rows[j].Function
+		if peak[a] != peak[b] {
+			return peak[a] > peak[b]
+		}
+		return a < b
+	})
+	if len(comparison.Rows) > maxEventComparisonRows {
+		comparison.Rows = comparison.Rows[:maxEventComparisonRows]
+	}
+
+	return comparison
+}
+
+// formatEventComparison renders the comparison as a fixed-width text table
+func formatEventComparison(comparison *EventComparison) string {
+	var table strings.Builder
+
+	table.WriteString(fmt.Sprintf("%-40s", "Function"))
+	for _, event := range comparison.Events {
+		table.WriteString(fmt.Sprintf(" %14s", truncate(event, 14)))
+	}
+	table.WriteString("\n")
+
+	for _, row := range comparison.Rows {
+		table.WriteString(fmt.Sprintf("%-40s", truncate(row.Function, 40)))
+		for _, event := range comparison.Events {
+			table.WriteString(fmt.Sprintf(" %13.2f%%", row.Percentages[event]))
+		}
+		table.WriteString("\n")
+	}
+
+	return table.String()
+}
+
+// truncate shortens s to at most n characters, marking the cut with "..."
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-3] + "..."
+}
+
+// eventsBySamples returns the events recorded in the samples, the one with
+// the most samples first (ties in name order). Samples without an event name
+// are not counted.
+func eventsBySamples(samples []*parser.Sample) []string {
+	totals := make(map[string]int)
+	for _, sample := range samples {
+		if sample.Event != "" {
+			totals[sample.Event]++
+		}
+	}
+
+	events := make([]string, 0, len(totals))
+	for event := range totals {
+		events = append(events, event)
+	}
+	sort.Slice(events, func(i, j int) bool {
+		if totals[events[i]] != totals[events[j]] {
+			return totals[events[i]] > totals[events[j]]
+		}
+		return events[i] < events[j]
+	})
+	return events
+}
+
+// samplesOfEvent returns the samples recorded for event
+func samplesOfEvent(samples []*parser.Sample, event string) []*parser.Sample {
+	selected := make([]*parser.Sample, 0)
+	for _, sample := range samples {
+		if sample.Event == event {
+			selected = append(selected, sample)
+		}
+	}
+	return selected
+}
+
+// eventHeatmapDir is the output subdirectory holding the heatmap of a
+// secondary event, e.g. "heatmap-cache-misses"
+func eventHeatmapDir(event string) string {
+	return "heatmap-" + unsafeFileChars.ReplaceAllString(event, "_")
+}
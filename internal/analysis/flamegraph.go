@@ -0,0 +1,71 @@
+package analysis
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// FlamegraphOptions controls how flamegraph.pl renders the SVG. Zero values
+// keep the flamegraph.pl defaults.
+type FlamegraphOptions struct {
+	Title  string // Replaces the default title when set
+	Width  int    // Image width in pixels (flamegraph.pl default: 1200)
+	Height int    // Frame height in pixels (flamegraph.pl default: 16)
+	// MinWidth omits frames narrower than this, in pixels ("2") or as a
+	// percentage of all samples ("0.5%")
+	MinWidth string
+	// WeightByPeriod counts each sample for its period when perf.data is
+	// folded, and labels the counts as events
+	WeightByPeriod bool
+}
+
+// Validate checks the option values
+func (o FlamegraphOptions) Validate() error {
+	if o.Width < 0 {
+		return fmt.Errorf("flamegraph width must be positive")
+	}
+	if o.Height < 0 {
+		return fmt.Errorf("flamegraph height must be positive")
+	}
+	if o.MinWidth != "" {
+		value, err := strconv.ParseFloat(strings.TrimSuffix(o.MinWidth, "%"), 64)
+		if err != nil || value < 0 {
+			return fmt.Errorf("invalid flamegraph min width %q (expected pixels, e.g. 2, or a percentage, e.g. 0.5%%)", o.MinWidth)
+		}
+	}
+	return nil
+}
+
+// args returns the flamegraph.pl arguments, using defaultTitle when no
+// title was set
+func (o FlamegraphOptions) args(defaultTitle string) []string {
+	title := o.Title
+	if title == "" {
+		title = defaultTitle
+	}
+
+	countName := "samples"
+	if o.WeightByPeriod {
+		countName = "events"
+	}
+	args := []string{"--title", title, "--countname", countName}
+	if o.Width > 0 {
+		args = append(args, "--width", strconv.Itoa(o.Width))
+	}
+	if o.Height > 0 {
+		args = append(args, "--height", strconv.Itoa(o.Height))
+	}
+	if o.MinWidth != "" {
+		args = append(args, "--minwidth", o.MinWidth)
+	}
+	return args
+}
+
+// invertedArgs returns the flamegraph.pl arguments of the inverted
+// flamegraph: stacks are merged from the leaf (--reverse) and drawn top-down
+// as an icicle graph (--inverted), so the widest frames at the top are the
+// functions doing the work, whoever called them
+func (o FlamegraphOptions) invertedArgs() []string {
+	return append(o.args("CPU Flame Graph (Inverted)"), "--reverse", "--inverted")
+}
@@ -0,0 +1,135 @@
+package analysis
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// flamegraphCommit is the FlameGraph revision whose flamegraph.pl is
+// downloaded when none is in PATH, and flamegraphSHA256 the SHA-256 of that
+// file. Both are pinned here so that every build fetches and verifies the same
+// script; bump them together (make flamegraph-sha256 pins the checksum of the
+// file at flamegraphCommit, and CI refuses to build without one). Nothing is
+// downloaded or run from the cache without a checksum.
+const flamegraphCommit = "cd9ee4c4449775a2f867acf31c84b7fe4b132ad5"
+
+var (
+	flamegraphURL    = "https://raw.githubusercontent.com/brendangregg/FlameGraph/" + flamegraphCommit + "/flamegraph.pl"
+	flamegraphSHA256 = ""
+)
+
+var (
+	// flamegraphMu serializes the lookup, so parallel reports download once
+	flamegraphMu sync.Mutex
+
+	// noDownload makes a missing flamegraph.pl an error instead of a download
+	noDownload bool
+)
+
+// SetNoDownload forbids downloading flamegraph.pl, for air-gapped hosts:
+// it has to be in PATH or already in the cache
+func SetNoDownload(disabled bool) {
+	flamegraphMu.Lock()
+	defer flamegraphMu.Unlock()
+	noDownload = disabled
+}
+
+// flamegraphCachePath is where the downloaded flamegraph.pl is kept across
+// runs, ~/.cache/blc-perf-analyzer/flamegraph.pl by default
+func flamegraphCachePath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("no cache directory for flamegraph.pl: %v", err)
+	}
+	return filepath.Join(dir, "blc-perf-analyzer", "flamegraph.pl"), nil
+}
+
+// flamegraphScript returns the flamegraph.pl to run: the one in PATH, else
+// the cached download, verified against the expected checksum, else a new
+// download into the cache. logf prints progress messages.
+func flamegraphScript(logf func(format string, args ...interface{})) (string, error) {
+	flamegraphMu.Lock()
+	defer flamegraphMu.Unlock()
+
+	if path, err := exec.LookPath("flamegraph.pl"); err == nil {
+		return path, nil
+	}
+
+	cachePath, err := flamegraphCachePath()
+	if err != nil {
+		return "", err
+	}
+	if flamegraphSHA256 == "" {
+		return "", fmt.Errorf("flamegraph.pl is not in PATH and this build pins no checksum to verify a download: install FlameGraph (https://github.com/brendangregg/FlameGraph)")
+	}
+	if _, err := os.Stat(cachePath); err == nil {
+		// Verified on every run: the cache is writable by the user
+		if err := verifySHA256(cachePath, flamegraphSHA256); err != nil {
+			return "", fmt.Errorf("refusing to run cached %s: %v (delete it to download it again)", cachePath, err)
+		}
+		return cachePath, nil
+	}
+
+	if noDownload {
+		return "", fmt.Errorf("flamegraph.pl is not in PATH nor in %s and downloads are disabled (--no-download): install FlameGraph (https://github.com/brendangregg/FlameGraph) or copy its flamegraph.pl at commit %s there", cachePath, flamegraphCommit)
+	}
+
+	logf("flamegraph.pl not found, downloading to %s...\n", cachePath)
+	if err := downloadFlamegraph(cachePath, flamegraphSHA256); err != nil {
+		return "", fmt.Errorf("error downloading flamegraph.pl: %v", err)
+	}
+	return cachePath, nil
+}
+
+// downloadFlamegraph fetches flamegraph.pl into path. The script is
+// downloaded next to path and only moved into place, executable, once its
+// checksum matches.
+func downloadFlamegraph(path, expected string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating cache directory: %v", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "flamegraph-*.pl")
+	if err != nil {
+		return fmt.Errorf("error creating download file: %v", err)
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	cmd := exec.Command("curl", "-fsSL", flamegraphURL, "-o", tmp.Name())
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("curl %s: %v: %s", flamegraphURL, err, strings.TrimSpace(string(output)))
+	}
+	if err := verifySHA256(tmp.Name(), expected); err != nil {
+		return fmt.Errorf("refusing to run the download: %v", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return fmt.Errorf("error making flamegraph.pl executable: %v", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// verifySHA256 checks that the SHA-256 of the file at path is expected
+// (lowercase hex)
+func verifySHA256(path, expected string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != expected {
+		return fmt.Errorf("checksum mismatch: SHA-256 is %s, expected %s", sum, expected)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package analysis
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/santiagolertora/blc-perf-analyzer/internal/parser"
+)
+
+// Input formats accepted for ReportConfig.ScriptPath
+const (
+	InputFormatPerfScript = "perfscript" // Text output of perf script
+	InputFormatFtrace     = "ftrace"     // trace-cmd report output or a raw ftrace trace file
+)
+
+// InputFormats lists the supported input formats
+var InputFormats = []string{InputFormatPerfScript, InputFormatFtrace}
+
+// foldSamples builds folded stacks ("root;...;leaf count" lines) from parsed
+// samples, as stackcollapse-perf.pl does from perf script text: perf prints
+// the leaf first, so the frames are reversed to make the root caller the base
+// of the flame. Every folded output goes through here, so the two sides of a
+// diff fold the same way. With byThread every stack starts with a
+// "<comm>-<tid>" frame; with byPeriod each sample counts for its period.
+func foldSamples(samples []*parser.Sample, byThread, byPeriod bool) string {
+	counts := make(map[string]int)
+	for _, sample := range samples {
+		if len(sample.Stack) == 0 {
+			continue
+		}
+		frames := make([]string, 0, len(sample.Stack)+1)
+		if byThread {
+			frames = append(frames, fmt.Sprintf("%s-%d", sample.Command, sample.TID))
+		}
+		for i := len(sample.Stack) - 1; i >= 0; i-- {
+			frames = append(frames, sample.Stack[i].Symbol)
+		}
+		counts[strings.Join(frames, ";")] += sample.Weight(byPeriod)
+	}
+
+	stacks := make([]string, 0, len(counts))
+	for stack := range counts {
+		stacks = append(stacks, stack)
+	}
+	sort.Strings(stacks)
+
+	var folded strings.Builder
+	for _, stack := range stacks {
+		folded.WriteString(fmt.Sprintf("%s %d\n", stack, counts[stack]))
+	}
+	return folded.String()
+}
@@ -0,0 +1,141 @@
+package analysis
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SummaryMarkdown returns the summary as Markdown, as saved in summary.md,
+// listing the topN hottest functions (DefaultTopN when topN is 0)
+func (r *AnalysisResult) SummaryMarkdown(topN int) string {
+	return generateSummaryMarkdown(r.Summary, r.TopFunctions, topN)
+}
+
+// generateSummaryMarkdown renders the summary for incident tickets and pull
+// requests: the time distribution and the topN functions as tables, the top
+// stacks and recommendations as lists, and the [unknown] symbols guidance as
+// a blockquote when it applies
+func generateSummaryMarkdown(summary SummaryStats, topFunctions []FunctionStats, topN int) string {
+	var md strings.Builder
+	if topN <= 0 {
+		topN = DefaultTopN
+	}
+
+	md.WriteString("# Performance Analysis Summary\n\n")
+	if summary.Verdict != "" {
+		md.WriteString(fmt.Sprintf("**Verdict:** %s\n\n", summary.Verdict))
+	}
+
+	switch {
+	case summary.SystemWide:
+		md.WriteString("- **Process:** all (system-wide)\n")
+	case summary.Cgroup != "":
+		md.WriteString(fmt.Sprintf("- **Process:** all in cgroup %s\n", markdownCode(summary.Cgroup)))
+	case len(summary.PIDs) > 1:
+		md.WriteString(fmt.Sprintf("- **Process:** %s (%d PIDs: %s)\n", markdownCode(summary.ProcessName), len(summary.PIDs), formatPIDs(summary.PIDs)))
+	default:
+		md.WriteString(fmt.Sprintf("- **Process:** %s (PID %d)\n", markdownCode(summary.ProcessName), summary.PID))
+	}
+	if summary.Target != nil && len(summary.Target.Cmdline) > 0 {
+		md.WriteString(fmt.Sprintf("- **Command:** %s\n", markdownCode(strings.Join(summary.Target.Cmdline, " "))))
+	}
+	md.WriteString(fmt.Sprintf("- **Duration:** %d seconds\n", summary.CaptureDuration))
+	md.WriteString(fmt.Sprintf("- **Total samples:** %d\n", summary.TotalSamples))
+	if summary.CPUFilter != "" {
+		md.WriteString(fmt.Sprintf("- **CPUs:** %s\n", summary.CPUFilter))
+	}
+	if summary.WeightedByPeriod {
+		md.WriteString("- Percentages are weighted by sample period\n")
+	}
+	md.WriteString("\n")
+
+	if summary.SampleWarning != "" {
+		md.WriteString(fmt.Sprintf("> ⚠️ %s\n\n", summary.SampleWarning))
+	}
+
+	md.WriteString("## Time Distribution\n\n")
+	md.WriteString("| Category | % |\n")
+	md.WriteString("|---|---:|\n")
+	md.WriteString(fmt.Sprintf("| Userland | %.2f |\n", summary.UserlandPercent))
+	if summary.JITPercent > 0 {
+		md.WriteString(fmt.Sprintf("| ↳ JIT-compiled code | %.2f |\n", summary.JITPercent))
+	}
+	if summary.GoRuntimePercent > 0 {
+		md.WriteString(fmt.Sprintf("| ↳ Go runtime | %.2f |\n", summary.GoRuntimePercent))
+	}
+	if summary.AllocatorPercent > 0 {
+		md.WriteString(fmt.Sprintf("| ↳ Memory allocator | %.2f |\n", summary.AllocatorPercent))
+	}
+	md.WriteString(fmt.Sprintf("| Kernel | %.2f |\n", summary.KernelPercent))
+	md.WriteString(fmt.Sprintf("| Unknown | %.2f |\n", summary.UnknownPercent))
+	if summary.IdlePercent > 0 {
+		md.WriteString(fmt.Sprintf("| Idle CPU (not in profile) | %.2f |\n", summary.IdlePercent))
+	}
+	if summary.MMPressurePercent > 0 {
+		md.WriteString(fmt.Sprintf("| Page-fault/MM pressure | %.2f |\n", summary.MMPressurePercent))
+	}
+	md.WriteString("\n")
+
+	md.WriteString("## Top Functions\n\n")
+	if len(topFunctions) == 0 {
+		md.WriteString("No samples.\n")
+	} else {
+		md.WriteString("| # | Function | Self % | With callees % |\n")
+		md.WriteString("|---:|---|---:|---:|\n")
+		for i, fn := range topFunctions {
+			if i >= topN {
+				break
+			}
+			md.WriteString(fmt.Sprintf("| %d | %s | %.2f | %.2f |\n", i+1, markdownCell(markdownCode(fn.Name)), fn.Percentage, fn.TotalPercentage))
+		}
+	}
+
+	if len(summary.TopStacks) > 0 {
+		md.WriteString("\n## Top Repeated Stacks\n\n")
+		for i, stack := range summary.TopStacks {
+			md.WriteString(fmt.Sprintf("%d. %.2f%% (%d samples): %s\n", i+1, stack.Percentage, stack.Samples, markdownCode(formatStack(stack.Stack, 6))))
+		}
+	}
+
+	if len(summary.Recommendations) > 0 {
+		md.WriteString("\n## Recommendations\n\n")
+		for _, recommendation := range summary.Recommendations {
+			md.WriteString(fmt.Sprintf("- **%s:** %s\n", recommendation.Severity, recommendation.Message))
+		}
+	}
+
+	if mostlyUnknown(topFunctions) {
+		md.WriteString("\n")
+		md.WriteString(markdownQuote(strings.TrimSpace(unknownSymbolsGuidance(summary))))
+	}
+
+	return md.String()
+}
+
+// markdownCode formats s as an inline code span, with a longer fence when s
+// contains backticks
+func markdownCode(s string) string {
+	if !strings.Contains(s, "`") {
+		return "`" + s + "`"
+	}
+	return "`` " + s + " ``"
+}
+
+// markdownCell escapes the pipes of s, which would end the table cell even
+// inside a code span
+func markdownCell(s string) string {
+	return strings.ReplaceAll(s, "|", `\|`)
+}
+
+// markdownQuote prefixes every line of text with "> "
+func markdownQuote(text string) string {
+	var quoted strings.Builder
+	for _, line := range strings.Split(text, "\n") {
+		if line == "" {
+			quoted.WriteString(">\n")
+			continue
+		}
+		quoted.WriteString("> " + line + "\n")
+	}
+	return quoted.String()
+}
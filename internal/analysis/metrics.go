@@ -0,0 +1,118 @@
+package analysis
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Metric selects a single number from an analysis result, for harnesses that
+// compare runs across commits
+type Metric struct {
+	Name     string
+	Function string // For the function_* metrics
+}
+
+// metricNames lists the supported metrics; function_* metrics take the
+// function as "name:function"
+var metricNames = []string{
+	"kernel_percent",
+	"userland_percent",
+	"unknown_percent",
+	"idle_percent",
+	"mm_pressure_percent",
+	"jit_percent",
+	"go_runtime_percent",
+	"allocator_percent",
+	"total_samples",
+	"top_function_percent",
+	"function_self_percent",
+	"function_total_percent",
+}
+
+// ParseMetric parses a metric such as "kernel_percent" or
+// "function_total_percent:malloc"
+func ParseMetric(spec string) (Metric, error) {
+	name, function, _ := strings.Cut(spec, ":")
+	known := false
+	for _, candidate := range metricNames {
+		if name == candidate {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return Metric{}, fmt.Errorf("unknown metric %q (supported: %s)", name, strings.Join(metricNames, ", "))
+	}
+
+	needsFunction := strings.HasPrefix(name, "function_")
+	if needsFunction && function == "" {
+		return Metric{}, fmt.Errorf("metric %s needs a function, e.g. %s:malloc", name, name)
+	}
+	if !needsFunction && function != "" {
+		return Metric{}, fmt.Errorf("metric %s does not take a function", name)
+	}
+	return Metric{Name: name, Function: function}, nil
+}
+
+// NeedsCallers reports whether the metric needs ReportConfig.CallersOf set to
+// the metric's function
+func (m Metric) NeedsCallers() bool {
+	return m.Name == "function_total_percent"
+}
+
+// Value extracts the metric from result
+func (m Metric) Value(result *AnalysisResult) (float64, error) {
+	summary := result.Summary
+	switch m.Name {
+	case "kernel_percent":
+		return summary.KernelPercent, nil
+	case "userland_percent":
+		return summary.UserlandPercent, nil
+	case "unknown_percent":
+		return summary.UnknownPercent, nil
+	case "idle_percent":
+		return summary.IdlePercent, nil
+	case "mm_pressure_percent":
+		return summary.MMPressurePercent, nil
+	case "jit_percent":
+		return summary.JITPercent, nil
+	case "go_runtime_percent":
+		return summary.GoRuntimePercent, nil
+	case "allocator_percent":
+		return summary.AllocatorPercent, nil
+	case "total_samples":
+		return float64(summary.TotalSamples), nil
+	case "top_function_percent":
+		if len(result.TopFunctions) == 0 {
+			return 0, nil
+		}
+		return result.TopFunctions[0].Percentage, nil
+	case "function_self_percent":
+		for _, fn := range result.TopFunctions {
+			if fn.Name == m.Function {
+				return fn.Percentage, nil
+			}
+		}
+		return 0, nil
+	case "function_total_percent":
+		callers := summary.CallersOf
+		if callers == nil || callers.Function != m.Function {
+			return 0, fmt.Errorf("metric %s:%s needs the callers of %s to be analyzed", m.Name, m.Function, m.Function)
+		}
+		if summary.TotalSamples == 0 {
+			return 0, nil
+		}
+		return float64(callers.Samples) / float64(summary.TotalSamples) * 100, nil
+	}
+	return 0, fmt.Errorf("unknown metric %q", m.Name)
+}
+
+// Format renders a metric value: sample counts as integers, percentages with
+// two decimals
+func (m Metric) Format(value float64) string {
+	if m.Name == "total_samples" {
+		return strconv.FormatFloat(value, 'f', 0, 64)
+	}
+	return strconv.FormatFloat(value, 'f', 2, 64)
+}
@@ -0,0 +1,86 @@
+package analysis
+
+import (
+	"sort"
+
+	"github.com/santiagolertora/blc-perf-analyzer/internal/parser"
+)
+
+// ModuleStat is the self time of one module (binary, shared library or
+// kernel), the sum of the self samples of all its functions
+type ModuleStat struct {
+	Module     string  `json:"module"` // Path as reported by perf, "[unknown]" when not mapped
+	Samples    int     `json:"samples"`
+	Percentage float64 `json:"percentage"`
+}
+
+// moduleBreakdown sums the samples of every leaf frame by module and returns
+// the busiest modules first, so that a shared library that dominates the
+// profile stands out even when no single symbol does
+func moduleBreakdown(samples []*parser.Sample, byPeriod bool) []ModuleStat {
+	counts := make(map[string]int)
+	total := 0
+	for _, sample := range samples {
+		weight := sample.Weight(byPeriod)
+		total += weight
+		frame := sample.GetTopFrame()
+		if frame == nil {
+			continue
+		}
+		module := frame.Module
+		if module == "" {
+			module = "[unknown]"
+		}
+		counts[module] += weight
+	}
+	if total == 0 {
+		return nil
+	}
+	return rankModules(counts, total)
+}
+
+// kernelModuleBreakdown counts, for every kernel module (driver), the samples
+// with one of its functions anywhere on the stack, so that a driver stalling
+// in core kernel functions such as spinlocks is still credited. Modules are
+// named without brackets, nvme for [nvme].
+func kernelModuleBreakdown(samples []*parser.Sample, byPeriod bool) []ModuleStat {
+	counts := make(map[string]int)
+	total := 0
+	for _, sample := range samples {
+		weight := sample.Weight(byPeriod)
+		total += weight
+		seen := make(map[string]bool)
+		for i := range sample.Stack {
+			module := sample.Stack[i].KernelModule()
+			if module == "" || seen[module] {
+				continue
+			}
+			seen[module] = true
+			counts[module] += weight
+		}
+	}
+	if total == 0 {
+		return nil
+	}
+	return rankModules(counts, total)
+}
+
+// rankModules turns per-module counts into modules, busiest first and ties
+// in name order
+func rankModules(counts map[string]int, total int) []ModuleStat {
+	modules := make([]ModuleStat, 0, len(counts))
+	for module, count := range counts {
+		modules = append(modules, ModuleStat{
+			Module:     module,
+			Samples:    count,
+			Percentage: float64(count) / float64(total) * 100,
+		})
+	}
+	sort.Slice(modules, func(i, j int) bool {
+		if modules[i].Samples != modules[j].Samples {
+			return modules[i].Samples > modules[j].Samples
+		}
+		return modules[i].Module < modules[j].Module
+	})
+	return modules
+}
@@ -0,0 +1,257 @@
+package analysis
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+
+	"github.com/santiagolertora/blc-perf-analyzer/internal/parser"
+)
+
+// PprofFileName is the pprof profile written with --generate-pprof
+const PprofFileName = "profile.pb.gz"
+
+// ExportPprof writes the samples as a gzip-compressed pprof profile
+// (profile.proto), readable by `go tool pprof` and `pprof -http`.
+//
+// Every sample has two values: 1 sample, and its period. The period is CPU
+// time in nanoseconds for the cpu-clock and task-clock events, so it is
+// exported as "cpu"; for hardware events it is an event count, exported as
+// "events". pprof shows the period by default with byPeriod, the sample count
+// otherwise, as the summary and flamegraphs count them. Frames become one
+// location per symbol and module, already symbolized, with leaf first as
+// pprof expects. Each sample is labeled with its command and thread.
+func ExportPprof(samples []*parser.Sample, path string, byPeriod bool) error {
+	var out bytes.Buffer
+	gz := gzip.NewWriter(&out)
+	if _, err := gz.Write(buildPprof(samples, byPeriod)); err != nil {
+		return fmt.Errorf("error compressing pprof profile: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("error compressing pprof profile: %v", err)
+	}
+	if err := os.WriteFile(path, out.Bytes(), 0644); err != nil {
+		return fmt.Errorf("error writing pprof profile: %v", err)
+	}
+	return nil
+}
+
+// pprofBuilder interns strings, mappings, functions and locations while the
+// samples are encoded. IDs start at 1 and index 0 of the string table is "".
+type pprofBuilder struct {
+	strings   []string
+	stringIDs map[string]int64
+	mappings  map[string]uint64 // module -> mapping ID
+	functions map[string]uint64 // symbol -> function ID
+	locations map[[2]string]uint64
+	profile   protoBuffer
+	tables    protoBuffer // Mappings, locations and functions, written after the samples
+}
+
+// buildPprof encodes the profile message
+func buildPprof(samples []*parser.Sample, byPeriod bool) []byte {
+	b := &pprofBuilder{
+		strings:   []string{""},
+		stringIDs: map[string]int64{"": 0},
+		mappings:  make(map[string]uint64),
+		functions: make(map[string]uint64),
+		locations: make(map[[2]string]uint64),
+	}
+
+	valueType, valueUnit := "cpu", "nanoseconds"
+	for _, sample := range samples {
+		if sample.Event != "" && !isClockEvent(sample.Event) {
+			valueType, valueUnit = "events", "count"
+			break
+		}
+	}
+
+	// sample_type (1) and period_type (11)
+	b.profile.message(1, b.valueType("samples", "count"))
+	b.profile.message(1, b.valueType(valueType, valueUnit))
+	b.profile.message(11, b.valueType(valueType, valueUnit))
+
+	var first, last float64
+	seen := false
+	for _, sample := range samples {
+		if len(sample.Stack) == 0 {
+			continue
+		}
+		if !seen || sample.Timestamp < first {
+			first = sample.Timestamp
+		}
+		if !seen || sample.Timestamp > last {
+			last = sample.Timestamp
+		}
+		seen = true
+
+		var s protoBuffer
+		ids := make([]uint64, len(sample.Stack))
+		for j := range sample.Stack {
+			ids[j] = b.location(&sample.Stack[j])
+		}
+		s.packedUint64(1, ids)
+		s.packedInt64(2, []int64{1, int64(sample.Weight(true))})
+		s.message(3, b.label("comm", sample.Command, 0))
+		s.message(3, b.label("tid", "", int64(sample.TID)))
+		b.profile.message(2, s.Bytes())
+	}
+
+	b.profile.Write(b.tables.Bytes())
+	for _, str := range b.strings {
+		b.profile.stringField(6, str)
+	}
+	if last > first {
+		b.profile.int64Field(10, int64((last-first)*1e9))
+	}
+	defaultType := "samples"
+	if byPeriod {
+		defaultType = valueType
+	}
+	b.profile.int64Field(14, b.str(defaultType)) // default_sample_type
+	return b.profile.Bytes()
+}
+
+// isClockEvent reports whether the period of event is in nanoseconds
+func isClockEvent(event string) bool {
+	switch event {
+	case "cpu-clock", "task-clock":
+		return true
+	}
+	return false
+}
+
+// str returns the string table index of s
+func (b *pprofBuilder) str(s string) int64 {
+	if id, ok := b.stringIDs[s]; ok {
+		return id
+	}
+	id := int64(len(b.strings))
+	b.strings = append(b.strings, s)
+	b.stringIDs[s] = id
+	return id
+}
+
+func (b *pprofBuilder) valueType(typ, unit string) []byte {
+	var m protoBuffer
+	m.int64Field(1, b.str(typ))
+	m.int64Field(2, b.str(unit))
+	return m.Bytes()
+}
+
+func (b *pprofBuilder) label(key, str string, num int64) []byte {
+	var m protoBuffer
+	m.int64Field(1, b.str(key))
+	if str != "" {
+		m.int64Field(2, b.str(str))
+	}
+	m.int64Field(3, num)
+	return m.Bytes()
+}
+
+// location returns the ID of the location of frame, adding it and its
+// mapping and function to the tables the first time
+func (b *pprofBuilder) location(frame *parser.StackFrame) uint64 {
+	key := [2]string{frame.Module, frame.Symbol}
+	if id, ok := b.locations[key]; ok {
+		return id
+	}
+
+	mappingID, ok := b.mappings[frame.Module]
+	if !ok {
+		mappingID = uint64(len(b.mappings) + 1)
+		b.mappings[frame.Module] = mappingID
+		var m protoBuffer
+		m.uint64Field(1, mappingID)
+		m.int64Field(5, b.str(frame.Module))
+		m.uint64Field(7, 1) // has_functions: frames are already symbolized
+		b.tables.message(3, m.Bytes())
+	}
+
+	functionID, ok := b.functions[frame.Symbol]
+	if !ok {
+		functionID = uint64(len(b.functions) + 1)
+		b.functions[frame.Symbol] = functionID
+		var f protoBuffer
+		f.uint64Field(1, functionID)
+		f.int64Field(2, b.str(frame.Symbol))
+		f.int64Field(3, b.str(frame.Symbol))
+		b.tables.message(5, f.Bytes())
+	}
+
+	id := uint64(len(b.locations) + 1)
+	b.locations[key] = id
+	var line protoBuffer
+	line.uint64Field(1, functionID)
+	var l protoBuffer
+	l.uint64Field(1, id)
+	l.uint64Field(2, mappingID)
+	l.message(4, line.Bytes())
+	b.tables.message(4, l.Bytes())
+	return id
+}
+
+// protoBuffer encodes protobuf fields. Zero scalars are omitted, as proto3
+// does.
+type protoBuffer struct {
+	bytes.Buffer
+}
+
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+func (p *protoBuffer) varint(v uint64) {
+	for v >= 0x80 {
+		p.WriteByte(byte(v) | 0x80)
+		v >>= 7
+	}
+	p.WriteByte(byte(v))
+}
+
+func (p *protoBuffer) key(field, wireType int) {
+	p.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+func (p *protoBuffer) uint64Field(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	p.key(field, wireVarint)
+	p.varint(v)
+}
+
+func (p *protoBuffer) int64Field(field int, v int64) {
+	p.uint64Field(field, uint64(v))
+}
+
+// stringField writes a string even when empty: string_table[0] must be ""
+func (p *protoBuffer) stringField(field int, s string) {
+	p.key(field, wireBytes)
+	p.varint(uint64(len(s)))
+	p.WriteString(s)
+}
+
+func (p *protoBuffer) message(field int, m []byte) {
+	p.key(field, wireBytes)
+	p.varint(uint64(len(m)))
+	p.Write(m)
+}
+
+func (p *protoBuffer) packedUint64(field int, values []uint64) {
+	var packed protoBuffer
+	for _, v := range values {
+		packed.varint(v)
+	}
+	p.message(field, packed.Bytes())
+}
+
+func (p *protoBuffer) packedInt64(field int, values []int64) {
+	var packed protoBuffer
+	for _, v := range values {
+		packed.varint(uint64(v))
+	}
+	p.message(field, packed.Bytes())
+}
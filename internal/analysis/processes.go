@@ -0,0 +1,58 @@
+package analysis
+
+import (
+	"sort"
+
+	"github.com/santiagolertora/blc-perf-analyzer/internal/parser"
+)
+
+// ProcessStats is the CPU share of one command in a system-wide capture
+type ProcessStats struct {
+	Command    string  `json:"command"`
+	Samples    int     `json:"samples"`
+	Percentage float64 `json:"percentage"`
+	PIDs       int     `json:"pids"` // Distinct PIDs sampled under the command
+}
+
+// maxProcesses is the number of commands kept in the summary
+const maxProcesses = 15
+
+// processBreakdown groups samples by command name (perf's comm) and returns
+// the busiest commands first, so that a system-wide capture shows which
+// processes dominated. Several PIDs running the same program are merged.
+func processBreakdown(samples []*parser.Sample) []ProcessStats {
+	if len(samples) == 0 {
+		return nil
+	}
+
+	byCommand := make(map[string]*ProcessStats)
+	pids := make(map[string]map[int]bool)
+	for _, sample := range samples {
+		stats, exists := byCommand[sample.Command]
+		if !exists {
+			stats = &ProcessStats{Command: sample.Command}
+			byCommand[sample.Command] = stats
+			pids[sample.Command] = make(map[int]bool)
+		}
+		stats.Samples++
+		pids[sample.Command][sample.PID] = true
+	}
+
+	result := make([]ProcessStats, 0, len(byCommand))
+	for command, stats := range byCommand {
+		stats.Percentage = float64(stats.Samples) / float64(len(samples)) * 100
+		stats.PIDs = len(pids[command])
+		result = append(result, *stats)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Samples != result[j].Samples {
+			return result[i].Samples > result[j].Samples
+		}
+		return result[i].Command < result[j].Command
+	})
+
+	if len(result) > maxProcesses {
+		result = result[:maxProcesses]
+	}
+	return result
+}
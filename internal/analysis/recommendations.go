@@ -0,0 +1,101 @@
+package analysis
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/santiagolertora/blc-perf-analyzer/internal/heatmap"
+)
+
+// LockContentionPercent is the share of samples in lock and futex functions
+// above which lock contention is recommended for investigation, even when no
+// single heatmap window crossed the lock_contention threshold
+const LockContentionPercent = 20.0
+
+// Recommendation is a suggested next step derived from the summary and the
+// detected anomalies
+type Recommendation struct {
+	Rule     string `json:"rule"`     // Signal that triggered it, e.g. "lock_contention"
+	Severity string `json:"severity"` // "high" or "medium", as for anomalies
+	Message  string `json:"message"`
+}
+
+// buildRecommendations turns the signals of an analysis into targeted advice.
+// patterns is nil when no heatmap was generated; the rules that only have a
+// per-window signal (single-thread bottleneck) are skipped then.
+func buildRecommendations(summary SummaryStats, topFunctions []FunctionStats, patterns *heatmap.PatternDetection) []Recommendation {
+	recommendations := make([]Recommendation, 0)
+	anomalies := make(map[string]int)
+	if patterns != nil {
+		for _, anomaly := range patterns.Anomalies {
+			anomalies[anomaly.Type]++
+		}
+	}
+
+	if lockFn, lockPercent := lockShare(topFunctions); lockPercent > LockContentionPercent || (lockFn != "" && anomalies["lock_contention"] > 0) {
+		message := fmt.Sprintf("Lock contention: %.1f%% of samples in lock functions, mostly %s.", lockPercent, lockFn)
+		if callers := summary.CallersOf; callers != nil && callers.Function == lockFn && len(callers.Callers) > 0 {
+			message += fmt.Sprintf(" Most of it comes from %s;", callers.Callers[0].Name)
+		} else {
+			message += fmt.Sprintf(" Find the contended mutex with --callers-of %s;", lockFn)
+		}
+		message += " consider sharding it, shortening its critical sections or a read-mostly lock."
+		recommendations = append(recommendations, Recommendation{Rule: "lock_contention", Severity: "high", Message: message})
+	}
+
+	if n := anomalies["single_thread_bottleneck"]; n > 0 {
+		noun := "windows"
+		if n == 1 {
+			noun = "window"
+		}
+		recommendations = append(recommendations, Recommendation{
+			Rule:     "single_thread_bottleneck",
+			Severity: "high",
+			Message: fmt.Sprintf("Work is serialized: one thread is saturated while most cores are idle in %d %s. "+
+				"Look for a global lock or a single-threaded loop on that thread; adding cores will not help.", n, noun),
+		})
+	}
+
+	if summary.MMPressurePercent > heatmap.MMPressureThreshold || anomalies["mm_pressure"] > 0 {
+		recommendations = append(recommendations, Recommendation{
+			Rule:     "mm_pressure",
+			Severity: "medium",
+			Message: fmt.Sprintf("Memory management overhead: %.1f%% of samples in page faults and page-table walks. "+
+				"Consider huge pages (THP or hugetlbfs) for large mappings, pre-faulting them (MAP_POPULATE) and reusing buffers instead of remapping.", summary.MMPressurePercent),
+		})
+	}
+
+	if mostlyUnknown(topFunctions) {
+		message := "Most samples have no symbol: install debug symbols for the process or point --debug-dir at split debug files."
+		if summary.UnmappedPercent > 0 {
+			message = "Most samples have no symbol: JIT runtimes need a perf map (/tmp/perf-<pid>.map), stripped binaries need debug symbols (--debug-dir)."
+		}
+		recommendations = append(recommendations, Recommendation{Rule: "unknown_symbols", Severity: "medium", Message: message})
+	}
+
+	return recommendations
+}
+
+// lockShare returns the hottest lock function and the combined share of all
+// lock functions among topFunctions
+func lockShare(topFunctions []FunctionStats) (string, float64) {
+	hottest := ""
+	total := 0.0
+	for _, fn := range topFunctions {
+		for _, symbol := range heatmap.LockSymbols {
+			if strings.Contains(fn.Name, symbol) {
+				if hottest == "" {
+					hottest = fn.Name
+				}
+				total += fn.Percentage
+				break
+			}
+		}
+	}
+	return hottest, total
+}
+
+// mostlyUnknown reports whether the profile is dominated by [unknown] frames
+func mostlyUnknown(topFunctions []FunctionStats) bool {
+	return len(topFunctions) > 0 && topFunctions[0].Name == "[unknown]" && topFunctions[0].Percentage > 50
+}
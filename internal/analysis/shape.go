@@ -0,0 +1,57 @@
+package analysis
+
+import "math"
+
+// ProfileShape describes how concentrated the samples are across leaf functions.
+// A spiky profile is dominated by a few hotspots; a flat profile spreads the
+// cost across many functions and usually points to a systemic issue.
+type ProfileShape struct {
+	Top1Percent       float64 `json:"top1_percent"`
+	Top5Percent       float64 `json:"top5_percent"`
+	Top10Percent      float64 `json:"top10_percent"`
+	NormalizedEntropy float64 `json:"normalized_entropy"` // 0 = single function, 1 = perfectly even
+	UniqueFunctions   int     `json:"unique_functions"`
+	Classification    string  `json:"classification"` // "spiky", "moderate" or "flat"
+}
+
+// computeProfileShape computes the concentration metrics from functions sorted
+// by samples descending
+func computeProfileShape(functions []FunctionStats, totalSamples int) *ProfileShape {
+	shape := &ProfileShape{UniqueFunctions: len(functions)}
+	if totalSamples == 0 || len(functions) == 0 {
+		return shape
+	}
+
+	var entropy float64
+	for i, fn := range functions {
+		if i < 1 {
+			shape.Top1Percent += fn.Percentage
+		}
+		if i < 5 {
+			shape.Top5Percent += fn.Percentage
+		}
+		if i < 10 {
+			shape.Top10Percent += fn.Percentage
+		}
+
+		p := float64(fn.SelfSamples) / float64(totalSamples)
+		if p > 0 {
+			entropy -= p * math.Log(p)
+		}
+	}
+
+	if len(functions) > 1 {
+		shape.NormalizedEntropy = entropy / math.Log(float64(len(functions)))
+	}
+
+	switch {
+	case shape.Top1Percent >= 50 || shape.Top5Percent >= 80:
+		shape.Classification = "spiky"
+	case shape.Top10Percent < 30:
+		shape.Classification = "flat"
+	default:
+		shape.Classification = "moderate"
+	}
+
+	return shape
+}
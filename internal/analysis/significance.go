@@ -0,0 +1,44 @@
+package analysis
+
+import (
+	"fmt"
+	"math"
+)
+
+// minSignificantSamples is the sample count below which percentages are
+// considered unstable
+const minSignificantSamples = 1000
+
+// maxConfidenceMargin is the largest acceptable 95% confidence margin, in
+// percentage points, for the share of the hottest function
+const maxConfidenceMargin = 2.0
+
+// confidenceMargin returns the 95% confidence margin (normal approximation),
+// in percentage points, of a function observed at percentage of total samples
+func confidenceMargin(percentage float64, totalSamples int) float64 {
+	if totalSamples == 0 {
+		return 0
+	}
+	p := percentage / 100
+	return 1.96 * math.Sqrt(p*(1-p)/float64(totalSamples)) * 100
+}
+
+// significanceWarning returns a warning when the capture has too few samples
+// for its percentages to be trusted, or an empty string otherwise
+func significanceWarning(totalSamples int, topFunctions []FunctionStats) string {
+	if totalSamples == 0 {
+		return ""
+	}
+	if totalSamples < minSignificantSamples {
+		return fmt.Sprintf("Only %d samples captured (< %d): percentages may not be statistically meaningful. Use a longer --duration or a higher sampling frequency.",
+			totalSamples, minSignificantSamples)
+	}
+	if len(topFunctions) > 0 {
+		margin := confidenceMargin(topFunctions[0].Percentage, totalSamples)
+		if margin > maxConfidenceMargin {
+			return fmt.Sprintf("%s at %.1f%% is only accurate to ±%.1f points with %d samples. Use a longer --duration or a higher sampling frequency.",
+				topFunctions[0].Name, topFunctions[0].Percentage, margin, totalSamples)
+		}
+	}
+	return ""
+}
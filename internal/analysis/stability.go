@@ -0,0 +1,62 @@
+package analysis
+
+import (
+	"math"
+
+	"github.com/santiagolertora/blc-perf-analyzer/internal/parser"
+)
+
+// CPUStability describes how much the CPU usage (samples per time window)
+// varies over the capture. A high coefficient of variation means bursty load.
+type CPUStability struct {
+	WindowSize             float64 `json:"window_size_seconds"`
+	Windows                int     `json:"windows"`
+	MeanSamples            float64 `json:"mean_samples"`
+	StdDevSamples          float64 `json:"stddev_samples"`
+	CoefficientOfVariation float64 `json:"coefficient_of_variation"`
+	Classification         string  `json:"classification"` // "steady", "variable" or "bursty"
+}
+
+// computeCPUStability partitions the samples into windows of windowSize seconds
+// and computes the mean, standard deviation and coefficient of variation of the
+// per-window sample counts. It returns nil when there are fewer than two windows.
+func computeCPUStability(samples []*parser.Sample, windowSize float64) *CPUStability {
+	if windowSize <= 0 {
+		windowSize = 1.0
+	}
+
+	windows := parser.PartitionByTime(samples, windowSize)
+	if len(windows) < 2 {
+		return nil
+	}
+
+	stability := &CPUStability{WindowSize: windowSize, Windows: len(windows)}
+
+	var sum float64
+	for _, window := range windows {
+		sum += float64(len(window.Samples))
+	}
+	stability.MeanSamples = sum / float64(len(windows))
+
+	var variance float64
+	for _, window := range windows {
+		diff := float64(len(window.Samples)) - stability.MeanSamples
+		variance += diff * diff
+	}
+	stability.StdDevSamples = math.Sqrt(variance / float64(len(windows)))
+
+	if stability.MeanSamples > 0 {
+		stability.CoefficientOfVariation = stability.StdDevSamples / stability.MeanSamples
+	}
+
+	switch {
+	case stability.CoefficientOfVariation < 0.25:
+		stability.Classification = "steady"
+	case stability.CoefficientOfVariation < 0.75:
+		stability.Classification = "variable"
+	default:
+		stability.Classification = "bursty"
+	}
+
+	return stability
+}
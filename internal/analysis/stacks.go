@@ -0,0 +1,69 @@
+package analysis
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/santiagolertora/blc-perf-analyzer/internal/parser"
+)
+
+// StackStats contains how often one complete call stack was sampled
+type StackStats struct {
+	Stack      string  `json:"stack"` // leaf;caller;...;root as returned by GetFullStack
+	Samples    int     `json:"samples"`
+	Percentage float64 `json:"percentage"`
+}
+
+// maxRepeatedStacks is the number of repeated stacks kept in the summary
+const maxRepeatedStacks = 5
+
+// dominantStackPercent is the share above which a single stack is called out
+const dominantStackPercent = 50.0
+
+// findRepeatedStacks returns the complete stacks sampled more than once, most
+// frequent first. Unlike top functions, identical leaves reached through
+// different callers are counted separately, so a tight polling loop shows up
+// as one stack with a large share.
+func findRepeatedStacks(samples []*parser.Sample, limit int) []StackStats {
+	counts := make(map[string]int)
+	for _, sample := range samples {
+		if len(sample.Stack) == 0 {
+			continue
+		}
+		counts[sample.GetFullStack()]++
+	}
+
+	stacks := make([]StackStats, 0)
+	for stack, count := range counts {
+		if count < 2 {
+			continue
+		}
+		stacks = append(stacks, StackStats{
+			Stack:      stack,
+			Samples:    count,
+			Percentage: float64(count) / float64(len(samples)) * 100,
+		})
+	}
+
+	sort.Slice(stacks, func(i, j int) bool {
+		if stacks[i].Samples != stacks[j].Samples {
+			return stacks[i].Samples > stacks[j].Samples
+		}
+		return stacks[i].Stack < stacks[j].Stack
+	})
+
+	if len(stacks) > limit {
+		stacks = stacks[:limit]
+	}
+	return stacks
+}
+
+// formatStack renders a folded stack as "leaf <- caller <- ..." keeping at
+// most maxFrames frames
+func formatStack(stack string, maxFrames int) string {
+	frames := strings.Split(stack, ";")
+	if len(frames) > maxFrames {
+		frames = append(frames[:maxFrames], "...")
+	}
+	return strings.Join(frames, " <- ")
+}
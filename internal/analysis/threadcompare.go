@@ -0,0 +1,144 @@
+package analysis
+
+import (
+	"math"
+	"sort"
+
+	"github.com/santiagolertora/blc-perf-analyzer/internal/parser"
+)
+
+const (
+	// minComparedThreadSamples is the number of samples a thread needs to be
+	// compared; smaller profiles are mostly noise
+	minComparedThreadSamples = 50
+	// ThreadOutlierSimilarity is the cosine similarity to the other threads'
+	// profile below which a thread is reported as an outlier
+	ThreadOutlierSimilarity = 0.8
+	// maxThreadOutliers is the number of outliers kept in the report
+	maxThreadOutliers = 10
+)
+
+// ThreadComparison compares the function distribution of the threads of a
+// capture, to find a worker that behaves differently from its peers
+type ThreadComparison struct {
+	Threads        int             `json:"threads"`         // Threads with enough samples to compare
+	MeanSimilarity float64         `json:"mean_similarity"` // Average similarity of each thread to its peers (1 = identical)
+	Outliers       []ThreadOutlier `json:"outliers"`
+}
+
+// ThreadOutlier is a thread whose profile diverges from the other threads.
+// Function is where the difference is largest.
+type ThreadOutlier struct {
+	TID           int     `json:"tid"`
+	Name          string  `json:"name"`
+	Samples       int     `json:"samples"`
+	Similarity    float64 `json:"similarity"`
+	Function      string  `json:"function"`
+	ThreadPercent float64 `json:"thread_percent"` // Share of the thread's samples in Function
+	PeerPercent   float64 `json:"peer_percent"`   // Average share in Function across the other threads
+}
+
+// compareThreads builds a leaf-function distribution per thread and compares
+// each one with the average distribution of the other threads (cosine
+// similarity). Leaving the thread out of the average keeps a single odd
+// thread from pulling the reference towards itself. It returns nil when
+// fewer than three threads have enough samples.
+func compareThreads(samples []*parser.Sample) *ThreadComparison {
+	type threadProfile struct {
+		tid     int
+		name    string
+		samples int
+		shares  map[string]float64
+	}
+
+	counts := make(map[int]map[string]int)
+	names := make(map[int]string)
+	totals := make(map[int]int)
+	for _, sample := range samples {
+		top := sample.GetTopFrame()
+		if top == nil {
+			continue
+		}
+		if counts[sample.TID] == nil {
+			counts[sample.TID] = make(map[string]int)
+		}
+		counts[sample.TID][top.Symbol]++
+		totals[sample.TID]++
+		names[sample.TID] = sample.Command
+	}
+
+	profiles := make([]threadProfile, 0)
+	for tid, functions := range counts {
+		if totals[tid] < minComparedThreadSamples {
+			continue
+		}
+		shares := make(map[string]float64, len(functions))
+		for fn, count := range functions {
+			shares[fn] = float64(count) / float64(totals[tid])
+		}
+		profiles = append(profiles, threadProfile{tid: tid, name: names[tid], samples: totals[tid], shares: shares})
+	}
+	if len(profiles) < 3 {
+		return nil
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].tid < profiles[j].tid })
+
+	// Sum of all distributions; a thread's peers are the sum minus itself
+	sum := make(map[string]float64)
+	for _, profile := range profiles {
+		for fn, share := range profile.shares {
+			sum[fn] += share
+		}
+	}
+	peerCount := float64(len(profiles) - 1)
+
+	comparison := &ThreadComparison{Threads: len(profiles), Outliers: make([]ThreadOutlier, 0)}
+	for _, profile := range profiles {
+		peers := make(map[string]float64, len(sum))
+		for fn, total := range sum {
+			peers[fn] = (total - profile.shares[fn]) / peerCount
+		}
+		similarity := cosineSimilarity(profile.shares, peers)
+		comparison.MeanSimilarity += similarity
+
+		if similarity >= ThreadOutlierSimilarity {
+			continue
+		}
+		outlier := ThreadOutlier{TID: profile.tid, Name: profile.name, Samples: profile.samples, Similarity: similarity}
+		largest := -1.0
+		for fn, share := range profile.shares {
+			if diff := share - peers[fn]; diff > largest || (diff == largest && fn < outlier.Function) {
+				largest = diff
+				outlier.Function = fn
+				outlier.ThreadPercent = share * 100
+				outlier.PeerPercent = peers[fn] * 100
+			}
+		}
+		comparison.Outliers = append(comparison.Outliers, outlier)
+	}
+	comparison.MeanSimilarity /= float64(len(profiles))
+
+	sort.SliceStable(comparison.Outliers, func(i, j int) bool {
+		return comparison.Outliers[i].Similarity < comparison.Outliers[j].Similarity
+	})
+	if len(comparison.Outliers) > maxThreadOutliers {
+		comparison.Outliers = comparison.Outliers[:maxThreadOutliers]
+	}
+	return comparison
+}
+
+// cosineSimilarity compares two sparse vectors; 1 means the same direction
+func cosineSimilarity(a, b map[string]float64) float64 {
+	var dot, normA, normB float64
+	for key, value := range a {
+		dot += value * b[key]
+		normA += value * value
+	}
+	for _, value := range b {
+		normB += value * value
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
@@ -0,0 +1,85 @@
+package analysis
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/santiagolertora/blc-perf-analyzer/internal/parser"
+)
+
+// CaptureTiming describes when the capture actually sampled, measured from
+// the moment the capture started (before any --delay-start). Together with
+// the requested duration it shows how much of the window perf really covered,
+// which matters for short or delayed captures.
+type CaptureTiming struct {
+	PerfStartSeconds            float64 `json:"perf_start_seconds"`                        // Capture start to perf record launch
+	FirstSampleSeconds          float64 `json:"first_sample_seconds,omitempty"`            // Capture start to the first sample
+	FirstSampleAfterPerfSeconds float64 `json:"first_sample_after_perf_seconds,omitempty"` // perf record launch to the first sample
+	RequestedSeconds            int     `json:"requested_seconds,omitempty"`
+	SampledSeconds              float64 `json:"sampled_seconds"`            // First to last sample
+	CoveragePercent             float64 `json:"coverage_percent,omitempty"` // SampledSeconds relative to RequestedSeconds
+}
+
+// computeCaptureTiming derives the capture timing from the parsed samples.
+// It returns nil when the capture start is unknown (existing perf.data or
+// perf script input). The first sample is only placed when launchClock
+// (CLOCK_MONOTONIC at capture start) is known and the sample timestamps fall
+// between the capture start and now; otherwise perf used a different clock.
+func computeCaptureTiming(config *ReportConfig, samples []*parser.Sample, now time.Time) *CaptureTiming {
+	if config.LaunchTime.IsZero() {
+		return nil
+	}
+
+	timing := &CaptureTiming{RequestedSeconds: config.Duration}
+	if !config.CaptureStart.IsZero() {
+		timing.PerfStartSeconds = config.CaptureStart.Sub(config.LaunchTime).Seconds()
+	}
+	if len(samples) == 0 {
+		return timing
+	}
+
+	first, last := samples[0].Timestamp, samples[0].Timestamp
+	for _, sample := range samples {
+		if sample.Timestamp < first {
+			first = sample.Timestamp
+		}
+		if sample.Timestamp > last {
+			last = sample.Timestamp
+		}
+	}
+	timing.SampledSeconds = last - first
+	if timing.RequestedSeconds > 0 {
+		timing.CoveragePercent = timing.SampledSeconds / float64(timing.RequestedSeconds) * 100
+	}
+
+	if config.LaunchClock > 0 {
+		offset := first - config.LaunchClock
+		if offset >= 0 && offset <= now.Sub(config.LaunchTime).Seconds() {
+			timing.FirstSampleSeconds = offset
+			if offset > timing.PerfStartSeconds {
+				timing.FirstSampleAfterPerfSeconds = offset - timing.PerfStartSeconds
+			}
+		}
+	}
+
+	return timing
+}
+
+// formatCaptureTiming renders the capture timing section of summary.txt
+func formatCaptureTiming(timing *CaptureTiming) string {
+	var text strings.Builder
+	text.WriteString("\nCapture Timing:\n")
+	text.WriteString(fmt.Sprintf("- perf started after: %.3fs\n", timing.PerfStartSeconds))
+	if timing.FirstSampleSeconds > 0 {
+		text.WriteString(fmt.Sprintf("- First sample after: %.3fs (%.3fs after perf started)\n",
+			timing.FirstSampleSeconds, timing.FirstSampleAfterPerfSeconds))
+	}
+	if timing.RequestedSeconds > 0 {
+		text.WriteString(fmt.Sprintf("- Sampled span: %.2fs of %ds requested (%.1f%%)\n",
+			timing.SampledSeconds, timing.RequestedSeconds, timing.CoveragePercent))
+	} else {
+		text.WriteString(fmt.Sprintf("- Sampled span: %.2fs\n", timing.SampledSeconds))
+	}
+	return text.String()
+}
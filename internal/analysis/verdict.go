@@ -0,0 +1,64 @@
+package analysis
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/santiagolertora/blc-perf-analyzer/internal/heatmap"
+)
+
+// buildVerdict summarizes a run in one sentence suitable for chat alerts, e.g.
+// "mariadbd: 62% userland, hottest=do_command (18%), 2 anomalies (lock_contention, cpu_spike)".
+// The anomaly part is only present when a heatmap was generated.
+func buildVerdict(summary SummaryStats, topFunctions []FunctionStats, patterns *heatmap.PatternDetection) string {
+	target := summary.ProcessName
+	if target == "" && summary.SystemWide {
+		target = "system-wide"
+	} else if target == "" && summary.Cgroup != "" {
+		target = "cgroup " + summary.Cgroup
+	} else if target == "" {
+		target = fmt.Sprintf("PID %d", summary.PID)
+	}
+
+	if summary.TotalSamples == 0 {
+		return fmt.Sprintf("%s: no samples captured", target)
+	}
+
+	parts := make([]string, 0, 3)
+	if summary.KernelPercent > summary.UserlandPercent {
+		parts = append(parts, fmt.Sprintf("%.0f%% kernel", summary.KernelPercent))
+	} else {
+		parts = append(parts, fmt.Sprintf("%.0f%% userland", summary.UserlandPercent))
+	}
+
+	if len(summary.PerProcess) > 0 {
+		parts = append(parts, fmt.Sprintf("busiest=%s (%.0f%%)", summary.PerProcess[0].Command, summary.PerProcess[0].Percentage))
+	}
+
+	if len(topFunctions) > 0 {
+		parts = append(parts, fmt.Sprintf("hottest=%s (%.0f%%)", topFunctions[0].Name, topFunctions[0].Percentage))
+	}
+
+	if patterns != nil {
+		switch n := len(patterns.Anomalies); n {
+		case 0:
+			parts = append(parts, "no anomalies")
+		default:
+			types := make([]string, 0)
+			seen := make(map[string]bool)
+			for _, anomaly := range patterns.Anomalies {
+				if !seen[anomaly.Type] {
+					seen[anomaly.Type] = true
+					types = append(types, anomaly.Type)
+				}
+			}
+			noun := "anomalies"
+			if n == 1 {
+				noun = "anomaly"
+			}
+			parts = append(parts, fmt.Sprintf("%d %s (%s)", n, noun, strings.Join(types, ", ")))
+		}
+	}
+
+	return fmt.Sprintf("%s: %s", target, strings.Join(parts, ", "))
+}
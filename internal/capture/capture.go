@@ -3,12 +3,15 @@ package capture
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/santiagolertora/blc-perf-analyzer/internal/detector"
 	"github.com/santiagolertora/blc-perf-analyzer/internal/process"
 )
 
@@ -20,51 +23,126 @@ type CaptureConfig struct {
 	DelayStart  int
 	OutputDir   string
 	QuietMode   bool
+	Progress    io.Writer // Where progress messages and warnings go (nil: stdout)
+	CallGraph   string    // "fp" (default), "dwarf", "dwarf,<stack dump size>" or "lbr"
+	BranchStack bool      // Also record the taken user branches (perf record -j any,u) for the branch misprediction report
+	Frequency   int       // Sampling frequency in Hz passed to perf record -F (0 keeps perf's default)
+	Events      []string  // perf events to record, one -e each (empty keeps perf's default, cycles)
+	SystemWide  bool      // Record every CPU (perf record -a) instead of one process; PID and ProcessName are ignored
+	Cgroup      string    // Record every CPU but only the tasks of this cgroup (perf record -a -G), e.g. /system.slice/docker-<id>.scope
+	SampleCount int       // When > 0, capture until this many samples instead of for Duration
+	Snapshot    bool      // Record into a rolling buffer until triggered instead of for Duration
+	TriggerFile string    // In snapshot mode, a file whose creation triggers the snapshot
+	Guest       bool      // Record guest (KVM virtual machine) samples through perf kvm
+	Host        bool      // Record host samples through perf kvm
+	Launch      string    // Shell command started under perf and profiled until it exits, instead of attaching to a process
+	WithStat    bool      // Also count hardware events with perf stat for Duration (timed captures only)
+	TriggerCPU  float64   // When > 0, wait until the target's CPU usage exceeds this percentage before recording
+	AllMatching bool      // Attach to every process matching ProcessName; without it several matches are an error
+	ExactMatch  bool      // ProcessName must be the whole process name (pgrep -x), not a substring of it
+	Log         *EventLog // Lifecycle events, written to capture.log (nil disables)
+}
+
+// logf prints a progress message or warning unless config.QuietMode is set
+func (config *CaptureConfig) logf(format string, args ...interface{}) {
+	if config.QuietMode {
+		return
+	}
+	if config.Progress == nil {
+		fmt.Printf(format, args...)
+		return
+	}
+	fmt.Fprintf(config.Progress, format, args...)
 }
 
 // CaptureResult contains the results of the capture
 type CaptureResult struct {
-	PerfDataPath string
-	OutputDir    string
-	StartTime    time.Time
-	EndTime      time.Time
-	Error        error
+	PerfDataPath    string
+	OutputDir       string
+	StartTime       time.Time
+	StartClock      float64   // CLOCK_MONOTONIC seconds at StartTime, comparable with perf sample timestamps (0 if unknown)
+	RecordStartTime time.Time // When perf record was launched, after any delay
+	EndTime         time.Time
+	Target          *process.TargetInfo // Command line and environment of the target, if readable
+	StatPath        string              // perf stat CSV output, only set with WithStat
+	Interrupted     bool                // SIGINT or SIGTERM stopped the recording early; perf.data holds what was captured until then
+	PIDs            []int               // Processes perf attached to; empty for system-wide and launched captures
+	Error           error
 }
 
 // Capture executes perf capture according to the configuration
 func Capture(config *CaptureConfig) (*CaptureResult, error) {
 	result := &CaptureResult{
-		StartTime: time.Now(),
-		OutputDir: config.OutputDir,
+		StartTime:  time.Now(),
+		StartClock: monotonicSeconds(),
+		OutputDir:  config.OutputDir,
+	}
+
+	if config.Frequency > 0 {
+		warnFrequency(config)
+	}
+
+	if config.Launch != "" {
+		return captureLaunch(config, result)
 	}
 
 	// Validate configuration
-	if config.Duration <= 0 {
+	if config.Duration <= 0 && config.SampleCount <= 0 && !config.Snapshot {
 		return nil, fmt.Errorf("duration must be greater than 0")
 	}
 
-	var targetPID int
-
-	if config.PID > 0 {
-		targetPID = config.PID
+	// targetPIDs stays empty for system-wide captures. targetPID is the first
+	// one: the process whose command line is recorded and whose CPU usage
+	// --trigger-cpu watches
+	var targetPIDs []int
+
+	if config.SystemWide {
+		config.Log.Printf("system-wide capture, no target process")
+	} else if config.Cgroup != "" {
+		config.Log.Printf("cgroup capture: %s", config.Cgroup)
+		config.logf("Profiling cgroup %s on every CPU\n", config.Cgroup)
+	} else if config.PID > 0 {
+		targetPIDs = []int{config.PID}
+		config.Log.Printf("target PID %d", config.PID)
 		// Verify that the process exists
 		if _, err := os.Stat(fmt.Sprintf("/proc/%d", config.PID)); err != nil {
-			return nil, fmt.Errorf("process with PID %d does not exist: %v", config.PID, err)
+			return nil, fmt.Errorf("%w: PID %d does not exist: %v", process.ErrProcessNotFound, config.PID, err)
 		}
 	} else if config.ProcessName != "" {
 		// Lookup PID by process name
-		pid, err := process.GetPidByName(config.ProcessName)
+		config.Log.Printf("looking up process '%s'", config.ProcessName)
+		matches, err := process.FindProcesses(config.ProcessName, config.ExactMatch)
 		if err != nil {
-			return nil, fmt.Errorf("could not find PID for process '%s': %v", config.ProcessName, err)
+			return nil, fmt.Errorf("could not find PID for process '%s': %w", config.ProcessName, err)
 		}
-		targetPID = pid
-		if !config.QuietMode {
-			fmt.Printf("Found process '%s' with PID: %d\n", config.ProcessName, targetPID)
+		if len(matches) > 1 && !config.AllMatching {
+			return nil, fmt.Errorf("%w\nChoose one with --pid, match the whole name with --exact-match, or profile all of them with --all-matching",
+				process.MultipleProcessesError(config.ProcessName, matches))
 		}
+		for _, match := range matches {
+			targetPIDs = append(targetPIDs, match.PID)
+		}
+		config.Log.Printf("found process '%s' with PID %s", config.ProcessName, formatPIDs(targetPIDs))
+		config.logf("Found process '%s' with %s\n", config.ProcessName, targetLabel(targetPIDs))
 	} else {
 		return nil, fmt.Errorf("either PID or process name must be provided")
 	}
 
+	targetPID := 0
+	if len(targetPIDs) > 0 {
+		targetPID = targetPIDs[0]
+	}
+
+	// Record how the target was launched so the report documents its configuration
+	if targetPID > 0 {
+		if target, err := process.GetTargetInfo(targetPID); err == nil {
+			result.Target = target
+			warnJVM(config, target, targetPID)
+		} else {
+			config.logf("Warning: Could not read target command line: %v\n", err)
+		}
+	}
+
 	// Create output directory if it doesn't exist
 	if err := os.MkdirAll(config.OutputDir, 0755); err != nil {
 		return nil, fmt.Errorf("error creating output directory: %v", err)
@@ -72,9 +150,8 @@ func Capture(config *CaptureConfig) (*CaptureResult, error) {
 
 	// Handle delay start
 	if config.DelayStart > 0 {
-		if !config.QuietMode {
-			fmt.Printf("Waiting %d seconds before starting capture...\n", config.DelayStart)
-		}
+		config.Log.Printf("delay-start sleeping %ds", config.DelayStart)
+		config.logf("Waiting %d seconds before starting capture...\n", config.DelayStart)
 
 		// Wait with periodic process liveness checks
 		ticker := time.NewTicker(1 * time.Second)
@@ -84,13 +161,14 @@ func Capture(config *CaptureConfig) (*CaptureResult, error) {
 		for range ticker.C {
 			elapsed++
 
-			// Check if process is still alive
-			if _, err := os.Stat(fmt.Sprintf("/proc/%d", targetPID)); err != nil {
-				return nil, fmt.Errorf("process terminated during delay period (after %d seconds)", elapsed)
+			// Check if the process (or any of them) is still alive
+			if len(targetPIDs) > 0 && len(livePIDs(targetPIDs)) == 0 {
+				config.Log.Printf("process %s terminated during delay-start after %ds", formatPIDs(targetPIDs), elapsed)
+				return nil, fmt.Errorf("%w: process terminated during delay period (after %d seconds)", process.ErrProcessNotFound, elapsed)
 			}
 
-			if !config.QuietMode && elapsed%5 == 0 {
-				fmt.Printf("  ... %d/%d seconds elapsed\n", elapsed, config.DelayStart)
+			if elapsed%5 == 0 {
+				config.logf("  ... %d/%d seconds elapsed\n", elapsed, config.DelayStart)
 			}
 
 			if elapsed >= config.DelayStart {
@@ -98,56 +176,113 @@ func Capture(config *CaptureConfig) (*CaptureResult, error) {
 			}
 		}
 
-		if !config.QuietMode {
-			fmt.Println("Starting capture now...")
+		config.Log.Printf("delay-start finished")
+		config.logf("Starting capture now...\n")
+	}
+
+	// Wait for the CPU spike, after any delay
+	if config.TriggerCPU > 0 {
+		if err := waitForCPUTrigger(config, targetPID); err != nil {
+			return nil, err
 		}
 	}
 
-	// Final liveness check before capture
-	if _, err := os.Stat(fmt.Sprintf("/proc/%d", targetPID)); err != nil {
-		return nil, fmt.Errorf("process with PID %d no longer exists: %v", targetPID, err)
+	// Final liveness check before capture. perf record -p fails on a PID
+	// that is gone, so workers that exited in the meantime are left out
+	if len(targetPIDs) > 0 {
+		live := livePIDs(targetPIDs)
+		if len(live) == 0 {
+			config.Log.Printf("process %s no longer exists before recording", formatPIDs(targetPIDs))
+			return nil, fmt.Errorf("%w: %s no longer exists", process.ErrProcessNotFound, targetLabel(targetPIDs))
+		}
+		targetPIDs = live
 	}
+	result.PIDs = targetPIDs
 
 	// Build perf command
-	args := []string{"record", "-g", "-p", strconv.Itoa(targetPID), "--", "sleep", strconv.Itoa(config.Duration)}
+	args := buildRecordArgs(config, targetPIDs)
+	result.RecordStartTime = time.Now()
+
+	if config.SampleCount > 0 {
+		return captureSampleCount(config, args, targetPIDs, result)
+	}
+	if config.Snapshot {
+		return captureSnapshot(config, args, targetPIDs, result)
+	}
+
+	config.logf("Capturing CPU profile for %d seconds (%s)...\n", config.Duration, targetLabel(targetPIDs))
 
-	if !config.QuietMode {
-		fmt.Printf("Capturing CPU profile for %d seconds (PID: %d)...\n", config.Duration, targetPID)
+	// perf stat counts the same process for the same duration; its failure
+	// (e.g. no hardware counters in a VM) does not fail the capture
+	if config.WithStat {
+		statCmd, statPath, err := startStat(config, targetPIDs)
+		if err != nil {
+			config.logf("Warning: %v\n", err)
+		} else {
+			defer func() {
+				if err := statCmd.Wait(); err != nil {
+					config.logf("Warning: perf stat failed: %v\n", err)
+					return
+				}
+				result.StatPath = statPath
+			}()
+		}
 	}
 
 	// Run perf
 	stderr := make([]byte, 0)
 
-	// Add timeout context
+	// Add timeout context. It starts after --delay-start and --trigger-cpu
+	// have elapsed, so the overall bound is DelayStart + Duration + 5 seconds.
+	// Both the timeout and a Ctrl-C cancel it, which interrupts perf so it
+	// still writes perf.data; perf is killed if it doesn't exit in time
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.Duration+5)*time.Second)
 	defer cancel()
-	cmd := exec.CommandContext(ctx, "perf", args...)
+	cmd := exec.CommandContext(ctx, detector.PerfBinary(), args...)
 	cmd.Dir = config.OutputDir
 	cmd.Stderr = &stderrWriter{buf: &stderr}
-
-	if err := cmd.Run(); err != nil {
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error { return interruptProcessGroup(cmd) }
+	cmd.WaitDelay = perfFinalizeTimeout
+
+	config.Log.perfStarted(args)
+	interrupt := watchInterrupt(config, cancel)
+	runErr := cmd.Run()
+	interrupt.Stop()
+	config.Log.perfExited(runErr, time.Since(result.RecordStartTime), string(stderr))
+	if interrupt.Interrupted() {
+		return interruptedCapture(config, result, string(stderr), targetPIDs)
+	}
+	if err := runErr; err != nil {
 		errMsg := string(stderr)
 		if errMsg == "" {
 			errMsg = err.Error()
 		}
 		if ctx.Err() == context.DeadlineExceeded {
+			config.Log.Printf("perf timed out after %ds", config.Duration+5)
 			return nil, fmt.Errorf("perf command timed out after %d seconds", config.Duration+5)
 		}
 
+		// perf creates perf.data before opening the events, so a denied
+		// attach or a rejected event leaves an empty file behind that is
+		// not a usable capture
+		if isPermissionError(errMsg) || isEventError(errMsg) {
+			result.Error = perfError("", errMsg, targetPIDs)
+			return result, result.Error
+		}
+
 		// Check if it's just warnings (perf.data was still generated)
 		perfDataPath := filepath.Join(config.OutputDir, "perf.data")
 		if _, statErr := os.Stat(perfDataPath); statErr == nil {
 			// perf.data exists, so warnings are non-fatal
-			if !config.QuietMode {
-				fmt.Printf("Warning: perf had warnings but capture succeeded:\n%s\n", errMsg)
-			}
+			config.logf("Warning: perf had warnings but capture succeeded:\n%s\n", errMsg)
 			result.PerfDataPath = perfDataPath
 			result.EndTime = time.Now()
 			return result, nil
 		}
 
 		// Real error - perf.data was not generated
-		result.Error = fmt.Errorf("error running perf: %s", errMsg)
+		result.Error = perfError("", errMsg, targetPIDs)
 		return result, result.Error
 	}
 
@@ -161,13 +296,279 @@ func Capture(config *CaptureConfig) (*CaptureResult, error) {
 	result.PerfDataPath = perfDataPath
 	result.EndTime = time.Now()
 
-	if !config.QuietMode {
-		fmt.Printf("Capture completed successfully.\n")
+	config.logf("Capture completed successfully.\n")
+
+	return result, nil
+}
+
+// interruptedCapture returns the result of a recording stopped by a signal:
+// whatever perf wrote to perf.data before it exited
+func interruptedCapture(config *CaptureConfig, result *CaptureResult, stderr string, targetPIDs []int) (*CaptureResult, error) {
+	result.EndTime = time.Now()
+	result.Interrupted = true
+	elapsed := result.EndTime.Sub(result.RecordStartTime).Seconds()
+	config.Log.Printf("capture interrupted after %.1fs", elapsed)
+
+	perfDataPath := filepath.Join(config.OutputDir, "perf.data")
+	if info, err := os.Stat(perfDataPath); err != nil || info.Size() == 0 {
+		if stderr == "" {
+			stderr = "no data recorded"
+		}
+		result.Error = perfError("capture interrupted before perf wrote perf.data", stderr, targetPIDs)
+		return result, result.Error
 	}
+	result.PerfDataPath = perfDataPath
 
+	config.logf("Capture interrupted after %.1f seconds; analyzing the partial capture.\n", elapsed)
 	return result, nil
 }
 
+// permissionMarkers identify perf failures caused by missing privileges:
+// kernel.perf_event_paranoid restrictions or a target owned by another user
+var permissionMarkers = []string{
+	"Permission denied",
+	"not permitted",
+	"No permission",
+	"perf_event_paranoid",
+	"Access to performance monitoring",
+}
+
+// isPermissionError reports whether perf's stderr describes a permission problem
+func isPermissionError(stderr string) bool {
+	for _, marker := range permissionMarkers {
+		if strings.Contains(stderr, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// eventMarkers identify perf failures caused by an --event that perf does
+// not know or the hardware does not support
+var eventMarkers = []string{
+	"event syntax error",
+	"invalid or unsupported event",
+	"event is not supported",
+	"(Operation not supported) for event",
+}
+
+// isEventError reports whether perf's stderr describes a rejected event
+func isEventError(stderr string) bool {
+	for _, marker := range eventMarkers {
+		if strings.Contains(stderr, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// perfError describes a failed perf run from its stderr. Permission problems
+// wrap detector.ErrPermissionDenied so callers can tell them apart, and
+// explain how to get access to targetPIDs (empty when perf launched the target).
+func perfError(detail, stderr string, targetPIDs []int) error {
+	msg := stderr
+	if detail != "" {
+		msg = detail + ": " + stderr
+	}
+	if isPermissionError(stderr) {
+		target := "the target process"
+		if len(targetPIDs) > 0 {
+			target = strings.Replace(targetLabel(targetPIDs), ":", "", 1)
+		}
+		return fmt.Errorf("%w: no permission to attach to %s.\n"+
+			"The process may belong to another user, or kernel.perf_event_paranoid restricts profiling.\n"+
+			"Run with sudo, or allow it with: sudo sysctl -w kernel.perf_event_paranoid=1\n"+
+			"perf output: %s", detector.ErrPermissionDenied, target, strings.TrimSpace(msg))
+	}
+	if isEventError(stderr) {
+		return fmt.Errorf("perf rejected an --event: %s\n"+
+			"List the events supported on this host with: perf list", strings.TrimSpace(msg))
+	}
+	return fmt.Errorf("error running perf: %s", msg)
+}
+
+// warnFrequency warns when config.Frequency is above
+// kernel.perf_event_max_sample_rate, where perf record refuses to start
+func warnFrequency(config *CaptureConfig) {
+	maxRate, err := detector.MaxSampleRate()
+	if err != nil || config.Frequency <= maxRate || config.QuietMode {
+		return
+	}
+	config.logf("Warning: --frequency %d Hz exceeds kernel.perf_event_max_sample_rate (%d Hz); perf may refuse to record.\n"+
+		"Lower --frequency or raise the limit with: sudo sysctl -w kernel.perf_event_max_sample_rate=%d\n",
+		config.Frequency, maxRate, config.Frequency)
+}
+
+// perfMapPath is where JIT runtimes list the symbols of the code they generate
+func perfMapPath(pid int) string {
+	return fmt.Sprintf("/tmp/perf-%d.map", pid)
+}
+
+// jvmMissing returns what a JVM target lacks for perf to walk and name its
+// Java frames: frame pointers in JIT-compiled code and a perf map. A map only
+// written at exit (-XX:+DumpPerfMapAtExit) counts as present.
+func jvmMissing(target *process.TargetInfo, pid int) []string {
+	var missing []string
+	if !target.HasArg("-XX:+PreserveFramePointer") {
+		missing = append(missing, "-XX:+PreserveFramePointer")
+	}
+	if _, err := os.Stat(perfMapPath(pid)); err != nil && !target.HasArg("-XX:+DumpPerfMapAtExit") {
+		missing = append(missing, "a perf map ("+perfMapPath(pid)+")")
+	}
+	return missing
+}
+
+// warnJVM warns when the target is a JVM that perf can't profile properly:
+// its Java frames would be cut short or reported as raw addresses
+func warnJVM(config *CaptureConfig, target *process.TargetInfo, pid int) {
+	if !target.IsJVM() {
+		return
+	}
+	missing := jvmMissing(target, pid)
+	if len(missing) == 0 {
+		return
+	}
+	config.Log.Printf("PID %d is a JVM without %s", pid, strings.Join(missing, " and "))
+	if config.QuietMode {
+		return
+	}
+	config.logf("Warning: PID %d is a JVM without %s; Java frames will be truncated or shown as raw addresses.\n"+
+		"Start it with -XX:+PreserveFramePointer and attach a perf map agent (e.g. perf-map-agent) so JIT code is named.\n",
+		pid, strings.Join(missing, " and "))
+}
+
+// buildRecordArgs builds the perf record arguments for the given configuration
+func buildRecordArgs(config *CaptureConfig, targetPIDs []int) []string {
+	if config.SystemWide || config.Cgroup != "" {
+		targetPIDs = nil
+	}
+	args := []string{}
+	kvm := config.Guest || config.Host
+	if kvm {
+		// perf kvm tags samples as guest or host; its record defaults to perf.data.kvm
+		args = append(args, "kvm")
+		if config.Host {
+			args = append(args, "--host")
+		}
+		if config.Guest {
+			args = append(args, "--guest")
+		}
+	}
+	args = append(args, "record")
+
+	switch {
+	case config.CallGraph == "lbr":
+		// LBR call stacks are cheap and exact on Intel; the LBR then only
+		// holds calls and returns, not every taken branch
+		args = append(args, "--call-graph", "lbr")
+	case strings.HasPrefix(config.CallGraph, "dwarf"):
+		// DWARF unwinding copies the user stack with every sample and
+		// unwinds it in perf script, so binaries built without frame
+		// pointers still get complete stacks
+		args = append(args, "--call-graph", config.CallGraph)
+	default:
+		args = append(args, "-g")
+	}
+	if config.BranchStack {
+		args = append(args, "-j", "any,u")
+	}
+	if config.Frequency > 0 {
+		args = append(args, "-F", strconv.Itoa(config.Frequency))
+	}
+	for _, event := range config.Events {
+		args = append(args, "-e", event)
+	}
+	if config.Cgroup != "" {
+		// -G filters the events given before it, so the default one is named
+		if len(config.Events) == 0 {
+			args = append(args, "-e", "cycles")
+		}
+		args = append(args, cgroupArgs(config.Cgroup)...)
+	}
+
+	if config.Launch != "" {
+		if kvm {
+			args = append(args, "-o", "perf.data")
+		}
+		args = append(args, "--", "sh", "-c", config.Launch)
+		return args
+	}
+
+	if config.Snapshot {
+		// Keep only the most recent data in the ring buffer; it is written on exit
+		args = append(args, "--overwrite")
+		args = append(args, targetArgs(targetPIDs)...)
+		if kvm {
+			args = append(args, "-o", "perf.data")
+		}
+		return args
+	}
+
+	if config.SampleCount > 0 {
+		// Stream the data to stdout so samples can be counted while recording
+		args = append(args, targetArgs(targetPIDs)...)
+		args = append(args, "-o", "-")
+		return args
+	}
+
+	if kvm {
+		args = append(args, "-o", "perf.data")
+	}
+
+	args = append(args, targetArgs(targetPIDs)...)
+	args = append(args, "--", "sleep", strconv.Itoa(config.Duration))
+	return args
+}
+
+// targetArgs selects what perf records: targetPIDs, or every CPU when there
+// are none (system-wide capture)
+func targetArgs(targetPIDs []int) []string {
+	if len(targetPIDs) == 0 {
+		return []string{"-a"}
+	}
+	return []string{"-p", formatPIDs(targetPIDs)}
+}
+
+// cgroupArgs restricts a per-CPU perf record or stat to the tasks of
+// cgroup. A single -G applies to every event before it.
+func cgroupArgs(cgroup string) []string {
+	if cgroup == "" {
+		return nil
+	}
+	return []string{"-G", cgroup}
+}
+
+// targetLabel describes what is recorded, for progress messages
+func targetLabel(targetPIDs []int) string {
+	switch len(targetPIDs) {
+	case 0:
+		return "system-wide"
+	case 1:
+		return fmt.Sprintf("PID: %d", targetPIDs[0])
+	}
+	return fmt.Sprintf("PIDs: %s", formatPIDs(targetPIDs))
+}
+
+// formatPIDs joins PIDs with commas, as perf record -p expects
+func formatPIDs(pids []int) string {
+	list := make([]string, len(pids))
+	for i, pid := range pids {
+		list[i] = strconv.Itoa(pid)
+	}
+	return strings.Join(list, ",")
+}
+
+// livePIDs returns the PIDs that still exist
+func livePIDs(pids []int) []int {
+	var live []int
+	for _, pid := range pids {
+		if _, err := os.Stat(fmt.Sprintf("/proc/%d", pid)); err == nil {
+			live = append(live, pid)
+		}
+	}
+	return live
+}
+
 // stderrWriter is a helper to capture stderr output
 type stderrWriter struct {
 	buf *[]byte
@@ -185,7 +586,7 @@ func ProcessCapture(result *CaptureResult) error {
 	}
 
 	// Run perf script to process the data
-	cmd := exec.Command("perf", "script", "-i", result.PerfDataPath)
+	cmd := exec.Command(detector.PerfBinary(), detector.PerfArgs("script", "-i", result.PerfDataPath)...)
 	outputPath := filepath.Join(result.OutputDir, "perf-output.txt")
 
 	output, err := cmd.Output()
@@ -1,9 +1,19 @@
 package capture
 
 import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/santiagolertora/blc-perf-analyzer/internal/detector"
+	"github.com/santiagolertora/blc-perf-analyzer/internal/process"
 )
 
 func TestCaptureConfig_Validation(t *testing.T) {
@@ -198,3 +208,450 @@ func BenchmarkStderrWriter(b *testing.B) {
 		writer.Write(data)
 	}
 }
+
+func TestBuildRecordArgs(t *testing.T) {
+	tests := []struct {
+		name   string
+		config *CaptureConfig
+		want   string
+	}{
+		{
+			name:   "default frame pointer call graph",
+			config: &CaptureConfig{Duration: 10},
+			want:   "record -g -p 42 -- sleep 10",
+		},
+		{
+			name:   "explicit fp call graph",
+			config: &CaptureConfig{Duration: 5, CallGraph: "fp"},
+			want:   "record -g -p 42 -- sleep 5",
+		},
+		{
+			name:   "lbr call graph",
+			config: &CaptureConfig{Duration: 10, CallGraph: "lbr"},
+			want:   "record --call-graph lbr -p 42 -- sleep 10",
+		},
+		{
+			name:   "branch stack",
+			config: &CaptureConfig{Duration: 10, BranchStack: true},
+			want:   "record -g -j any,u -p 42 -- sleep 10",
+		},
+		{
+			name:   "dwarf call graph",
+			config: &CaptureConfig{Duration: 10, CallGraph: "dwarf"},
+			want:   "record --call-graph dwarf -p 42 -- sleep 10",
+		},
+		{
+			name:   "dwarf call graph with stack size",
+			config: &CaptureConfig{Duration: 10, CallGraph: "dwarf,16384"},
+			want:   "record --call-graph dwarf,16384 -p 42 -- sleep 10",
+		},
+		{
+			name:   "custom frequency",
+			config: &CaptureConfig{Duration: 10, Frequency: 99},
+			want:   "record -g -F 99 -p 42 -- sleep 10",
+		},
+		{
+			name:   "custom frequency with lbr",
+			config: &CaptureConfig{SampleCount: 1000, CallGraph: "lbr", Frequency: 9999},
+			want:   "record --call-graph lbr -F 9999 -p 42 -o -",
+		},
+		{
+			name:   "custom events",
+			config: &CaptureConfig{Duration: 10, Events: []string{"cache-misses", "context-switches"}},
+			want:   "record -g -e cache-misses -e context-switches -p 42 -- sleep 10",
+		},
+		{
+			name:   "system-wide",
+			config: &CaptureConfig{Duration: 10, SystemWide: true},
+			want:   "record -g -a -- sleep 10",
+		},
+		{
+			name:   "cgroup names the default event before -G",
+			config: &CaptureConfig{Duration: 10, Cgroup: "/system.slice/docker-abc123.scope"},
+			want:   "record -g -e cycles -G /system.slice/docker-abc123.scope -a -- sleep 10",
+		},
+		{
+			name:   "cgroup with custom events",
+			config: &CaptureConfig{Duration: 10, Cgroup: "/docker/abc123", Events: []string{"cache-misses"}},
+			want:   "record -g -e cache-misses -G /docker/abc123 -a -- sleep 10",
+		},
+		{
+			name:   "snapshot uses an overwritable buffer",
+			config: &CaptureConfig{Snapshot: true},
+			want:   "record -g --overwrite -p 42",
+		},
+		{
+			name:   "sample count streams to stdout",
+			config: &CaptureConfig{SampleCount: 1000},
+			want:   "record -g -p 42 -o -",
+		},
+		{
+			name:   "guest and host go through perf kvm",
+			config: &CaptureConfig{Duration: 10, Guest: true, Host: true},
+			want:   "kvm --host --guest record -g -o perf.data -p 42 -- sleep 10",
+		},
+		{
+			name:   "launched command",
+			config: &CaptureConfig{Launch: "./bench --iterations 10"},
+			want:   "record -g -- sh -c ./bench --iterations 10",
+		},
+		{
+			name:   "guest snapshot",
+			config: &CaptureConfig{Snapshot: true, Guest: true},
+			want:   "kvm --guest record -g --overwrite -p 42 -o perf.data",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := strings.Join(buildRecordArgs(tt.config, []int{42}), " ")
+			if got != tt.want {
+				t.Errorf("buildRecordArgs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildRecordArgsAllMatching(t *testing.T) {
+	got := strings.Join(buildRecordArgs(&CaptureConfig{Duration: 10, AllMatching: true}, []int{42, 43, 57}), " ")
+	want := "record -g -p 42,43,57 -- sleep 10"
+	if got != want {
+		t.Errorf("buildRecordArgs() = %q, want %q", got, want)
+	}
+	if label := targetLabel([]int{42, 43, 57}); label != "PIDs: 42,43,57" {
+		t.Errorf("targetLabel() = %q", label)
+	}
+	if label := targetLabel([]int{42}); label != "PID: 42" {
+		t.Errorf("targetLabel() = %q", label)
+	}
+}
+
+func TestCountSamples(t *testing.T) {
+	input := "  1234\n\n  1235\n  1234\n  1236\n"
+
+	stopped := false
+	count := countSamples(strings.NewReader(input), 3, func() { stopped = true })
+	if count != 3 {
+		t.Errorf("countSamples() = %d, want 3", count)
+	}
+	if !stopped {
+		t.Error("Expected stop to be called once the limit was reached")
+	}
+
+	stopped = false
+	count = countSamples(strings.NewReader(input), 10, func() { stopped = true })
+	if count != 4 {
+		t.Errorf("countSamples() = %d, want 4", count)
+	}
+	if stopped {
+		t.Error("Expected stop not to be called when input ends before the limit")
+	}
+}
+
+func TestCaptureMissingProcess(t *testing.T) {
+	_, err := Capture(&CaptureConfig{PID: 1 << 30, Duration: 1, OutputDir: t.TempDir(), QuietMode: true})
+	if !errors.Is(err, process.ErrProcessNotFound) {
+		t.Errorf("Expected ErrProcessNotFound, got %v", err)
+	}
+}
+
+func TestPerfError(t *testing.T) {
+	err := perfError("", "Error:\nAccess to performance monitoring and observability operations is limited.", nil)
+	if !errors.Is(err, detector.ErrPermissionDenied) {
+		t.Errorf("Expected ErrPermissionDenied, got %v", err)
+	}
+
+	// Attaching to another user's process
+	err = perfError("", "Error:\nNo permission to enable cpu-clock event.\n\nYou may not have permission to collect stats.\n",[]int{4321})
+	if !errors.Is(err, detector.ErrPermissionDenied) {
+		t.Errorf("Expected ErrPermissionDenied, got %v", err)
+	}
+	for _, hint := range []string{"no permission to attach to PID 4321", "sudo", "perf_event_paranoid", "No permission to enable cpu-clock event"} {
+		if !strings.Contains(err.Error(), hint) {
+			t.Errorf("Expected %q in the permission error, got %q", hint, err.Error())
+		}
+	}
+
+	// An --event perf does not know
+	err = perfError("", "event syntax error: 'cache-mises'\n                     \\___ parser error\nRun 'perf list' for a list of valid events\n",[]int{4321})
+	if errors.Is(err, detector.ErrPermissionDenied) {
+		t.Errorf("Unexpected ErrPermissionDenied for %v", err)
+	}
+	if !strings.HasPrefix(err.Error(), "perf rejected an --event: event syntax error: 'cache-mises'") || !strings.Contains(err.Error(), "perf list") {
+		t.Errorf("Unexpected event error message %q", err.Error())
+	}
+
+	err = perfError("no samples recorded", "failed to mmap with 12 (Cannot allocate memory)",[]int{4321})
+	if errors.Is(err, detector.ErrPermissionDenied) {
+		t.Errorf("Unexpected ErrPermissionDenied for %v", err)
+	}
+	if err.Error() != "error running perf: no samples recorded: failed to mmap with 12 (Cannot allocate memory)" {
+		t.Errorf("Unexpected message %q", err.Error())
+	}
+}
+
+func TestCapturePermissionDenied(t *testing.T) {
+	// A fake perf that fails like perf record -p on another user's process:
+	// perf.data is created before the events are opened
+	dir := t.TempDir()
+	fakePerf := filepath.Join(dir, "perf")
+	script := "#!/bin/sh\n: > perf.data\necho 'Error:' >&2\necho 'Failed to attach to PID: Operation not permitted' >&2\nexit 255\n"
+	if err := os.WriteFile(fakePerf, []byte(script), 0755); err != nil {
+		t.Fatalf("Failed to write fake perf: %v", err)
+	}
+	original := detector.PerfBinary()
+	if err := detector.SetPerfBinary(fakePerf); err != nil {
+		t.Fatalf("SetPerfBinary failed: %v", err)
+	}
+	defer detector.SetPerfBinary(original)
+
+	result, err := Capture(&CaptureConfig{PID: os.Getpid(), Duration: 1, OutputDir: filepath.Join(dir, "out"), QuietMode: true})
+	if !errors.Is(err, detector.ErrPermissionDenied) {
+		t.Fatalf("Expected ErrPermissionDenied, got %v", err)
+	}
+	if result.PerfDataPath != "" {
+		t.Errorf("Expected no usable perf.data, got %s", result.PerfDataPath)
+	}
+	if !strings.Contains(err.Error(), fmt.Sprintf("PID %d", os.Getpid())) {
+		t.Errorf("Expected the target PID in the error, got %q", err.Error())
+	}
+}
+
+func TestBuildStatArgs(t *testing.T) {
+	args := strings.Join(buildStatArgs([]int{1234}, "", 30, "/tmp/out/perf-stat.csv"), " ")
+	expected := "stat -x , -o /tmp/out/perf-stat.csv -e cycles,instructions,cache-references,cache-misses,branches,branch-misses,context-switches -p 1234 -- sleep 30"
+	if args != expected {
+		t.Errorf("buildStatArgs() = %q, want %q", args, expected)
+	}
+
+	args = strings.Join(buildStatArgs(nil, "/docker/abc123", 30, "/tmp/out/perf-stat.csv"), " ")
+	expected = "stat -x , -o /tmp/out/perf-stat.csv -e cycles,instructions,cache-references,cache-misses,branches,branch-misses,context-switches -G /docker/abc123 -a -- sleep 30"
+	if args != expected {
+		t.Errorf("buildStatArgs() with a cgroup = %q, want %q", args, expected)
+	}
+}
+
+func TestMonotonicSeconds(t *testing.T) {
+	first := monotonicSeconds()
+	if first <= 0 {
+		t.Fatalf("Expected a CLOCK_MONOTONIC reading, got %f", first)
+	}
+	if second := monotonicSeconds(); second < first {
+		t.Errorf("Expected a monotonic clock, got %f after %f", second, first)
+	}
+}
+
+func TestWaitForCPU(t *testing.T) {
+	config := &CaptureConfig{TriggerCPU: 150, QuietMode: true}
+
+	// Idle for a few polls, then a spike of ~10 cores
+	var cpu time.Duration
+	polls := 0
+	fakeCPU := func(pid int) (time.Duration, error) {
+		polls++
+		if polls > 3 {
+			cpu += 100 * time.Millisecond
+		}
+		return cpu, nil
+	}
+	if err := waitForCPU(config, 1, 10*time.Millisecond, fakeCPU); err != nil {
+		t.Fatalf("waitForCPU failed: %v", err)
+	}
+	if polls < 4 {
+		t.Errorf("Expected the trigger to wait for the spike, fired after %d polls", polls)
+	}
+
+	exited := func(pid int) (time.Duration, error) {
+		if polls++; polls > 6 {
+			return 0, errors.New("no such process")
+		}
+		return 0, nil
+	}
+	if err := waitForCPU(config, 1, 10*time.Millisecond, exited); !errors.Is(err, process.ErrProcessNotFound) {
+		t.Errorf("Expected ErrProcessNotFound when the target exits, got %v", err)
+	}
+}
+
+func TestCaptureEventLog(t *testing.T) {
+	dir := t.TempDir()
+	fakePerf := filepath.Join(dir, "perf")
+	script := "#!/bin/sh\n: > perf.data\necho 'Failed to attach to PID: Operation not permitted' >&2\nexit 3\n"
+	if err := os.WriteFile(fakePerf, []byte(script), 0755); err != nil {
+		t.Fatalf("Failed to write fake perf: %v", err)
+	}
+	original := detector.PerfBinary()
+	if err := detector.SetPerfBinary(fakePerf); err != nil {
+		t.Fatalf("SetPerfBinary failed: %v", err)
+	}
+	defer detector.SetPerfBinary(original)
+
+	outputDir := filepath.Join(dir, "out")
+	log := NewEventLog(outputDir)
+	Capture(&CaptureConfig{PID: os.Getpid(), Duration: 1, OutputDir: outputDir, QuietMode: true, Log: log})
+
+	content, err := os.ReadFile(filepath.Join(outputDir, EventLogName))
+	if err != nil {
+		t.Fatalf("Expected %s to be written: %v", EventLogName, err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	expected := []string{
+		fmt.Sprintf("target PID %d", os.Getpid()),
+		"perf started: perf record -g -p",
+		"perf exited code 3 after",
+		"perf stderr:",
+		"    Failed to attach to PID: Operation not permitted",
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d lines, got %d:\n%s", len(expected), len(lines), content)
+	}
+	for i, want := range expected {
+		if i == len(expected)-1 {
+			if lines[i] != want {
+				t.Errorf("Line %d = %q, want %q", i, lines[i], want)
+			}
+			continue
+		}
+		stamp, message, _ := strings.Cut(lines[i], " ")
+		if _, err := time.Parse(eventTimeFormat, stamp); err != nil {
+			t.Errorf("Line %d has no timestamp: %q", i, lines[i])
+		}
+		if !strings.HasPrefix(message, want) {
+			t.Errorf("Line %d = %q, want prefix %q", i, message, want)
+		}
+	}
+
+	// A nil log discards events
+	var none *EventLog
+	none.Printf("ignored")
+}
+
+func TestWatchInterrupt(t *testing.T) {
+	stopped := make(chan struct{})
+	w := watchInterrupt(&CaptureConfig{QuietMode: true}, func() { close(stopped) })
+	defer w.Stop()
+
+	self, _ := os.FindProcess(os.Getpid())
+	if err := self.Signal(os.Interrupt); err != nil {
+		t.Skipf("Cannot signal self: %v", err)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stop was not called on SIGINT")
+	}
+	if !w.Interrupted() {
+		t.Error("Expected Interrupted() after SIGINT")
+	}
+}
+
+func TestInterruptedCaptureKeepsPerfData(t *testing.T) {
+	dir := t.TempDir()
+	config := &CaptureConfig{OutputDir: dir, QuietMode: true}
+
+	if _, err := interruptedCapture(config, &CaptureResult{}, "", []int{42}); err == nil {
+		t.Error("Expected an error when perf wrote nothing")
+	}
+
+	os.WriteFile(filepath.Join(dir, "perf.data"), []byte("PERFILE2"), 0644)
+	result, err := interruptedCapture(config, &CaptureResult{RecordStartTime: time.Now()}, "", []int{42})
+	if err != nil {
+		t.Fatalf("interruptedCapture failed: %v", err)
+	}
+	if !result.Interrupted || result.PerfDataPath != filepath.Join(dir, "perf.data") {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+}
+
+func TestProcessCapturePerfArgs(t *testing.T) {
+	// A fake perf whose perf script output is its arguments
+	dir := t.TempDir()
+	fakePerf := filepath.Join(dir, "perf")
+	if err := os.WriteFile(fakePerf, []byte("#!/bin/sh\necho \"$@\"\n"), 0755); err != nil {
+		t.Fatalf("Failed to write fake perf: %v", err)
+	}
+	original := detector.PerfBinary()
+	if err := detector.SetPerfBinary(fakePerf); err != nil {
+		t.Fatalf("SetPerfBinary failed: %v", err)
+	}
+	defer detector.SetPerfBinary(original)
+	detector.SetBuildIDDir(filepath.Join(dir, "buildid"))
+	defer detector.SetBuildIDDir("")
+
+	perfData := filepath.Join(dir, "perf.data")
+	if err := ProcessCapture(&CaptureResult{PerfDataPath: perfData, OutputDir: dir}); err != nil {
+		t.Fatalf("ProcessCapture failed: %v", err)
+	}
+	output, err := os.ReadFile(filepath.Join(dir, "perf-output.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "--buildid-dir " + filepath.Join(dir, "buildid") + " script -i " + perfData + "\n"
+	if string(output) != expected {
+		t.Errorf("perf ran with %q, expected %q", output, expected)
+	}
+}
+
+func TestApplyPerfDataRetention(t *testing.T) {
+	dir := t.TempDir()
+	perfData := filepath.Join(dir, "perf.data")
+	content := []byte("PERFILE2 raw samples")
+
+	os.WriteFile(perfData, content, 0644)
+	result := &CaptureResult{PerfDataPath: perfData}
+	if err := ApplyPerfDataRetention(result, PerfDataKeep); err != nil || result.PerfDataPath != perfData {
+		t.Fatalf("keep: path %q, err %v", result.PerfDataPath, err)
+	}
+
+	if err := ApplyPerfDataRetention(result, PerfDataGzip); err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	if result.PerfDataPath != perfData+".gz" {
+		t.Errorf("Expected the result to point at perf.data.gz, got %q", result.PerfDataPath)
+	}
+	if _, err := os.Stat(perfData); !os.IsNotExist(err) {
+		t.Errorf("Expected perf.data to be replaced by perf.data.gz, stat: %v", err)
+	}
+	f, err := os.Open(result.PerfDataPath)
+	if err != nil {
+		t.Fatalf("open perf.data.gz: %v", err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("perf.data.gz is not gzip: %v", err)
+	}
+	if got, _ := io.ReadAll(zr); !bytes.Equal(got, content) {
+		t.Errorf("perf.data.gz decompresses to %q, expected %q", got, content)
+	}
+
+	os.WriteFile(perfData, content, 0644)
+	result = &CaptureResult{PerfDataPath: perfData}
+	if err := ApplyPerfDataRetention(result, PerfDataDelete); err != nil || result.PerfDataPath != "" {
+		t.Fatalf("delete: path %q, err %v", result.PerfDataPath, err)
+	}
+	if _, err := os.Stat(perfData); !os.IsNotExist(err) {
+		t.Errorf("Expected perf.data to be deleted, stat: %v", err)
+	}
+}
+
+func TestJVMMissing(t *testing.T) {
+	// No perf map is written for this PID
+	pid := 999999999
+
+	bare := &process.TargetInfo{Cmdline: []string{"/usr/lib/jvm/java-17/bin/java", "-jar", "app.jar"}}
+	if !bare.IsJVM() {
+		t.Fatal("Expected java to be detected as a JVM")
+	}
+	if missing := jvmMissing(bare, pid); len(missing) != 2 || missing[0] != "-XX:+PreserveFramePointer" {
+		t.Errorf("Expected frame pointers and perf map missing, got %v", missing)
+	}
+
+	ready := &process.TargetInfo{Cmdline: []string{"java", "-XX:+PreserveFramePointer", "-XX:+DumpPerfMapAtExit", "-jar", "app.jar"}}
+	if missing := jvmMissing(ready, pid); len(missing) != 0 {
+		t.Errorf("Expected nothing missing, got %v", missing)
+	}
+
+	if (&process.TargetInfo{Cmdline: []string{"/usr/sbin/mariadbd"}}).IsJVM() {
+		t.Error("mariadbd detected as a JVM")
+	}
+}
@@ -0,0 +1,20 @@
+package capture
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// clockMonotonic is CLOCK_MONOTONIC from <time.h>
+const clockMonotonic = 1
+
+// monotonicSeconds reads CLOCK_MONOTONIC, the clock perf sample timestamps
+// follow by default, in seconds. It returns 0 when the clock can't be read.
+func monotonicSeconds() float64 {
+	var ts syscall.Timespec
+	_, _, errno := syscall.RawSyscall(syscall.SYS_CLOCK_GETTIME, clockMonotonic, uintptr(unsafe.Pointer(&ts)), 0)
+	if errno != 0 {
+		return 0
+	}
+	return float64(ts.Sec) + float64(ts.Nsec)/1e9
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package capture
+
+// monotonicSeconds is only available on Linux, where perf runs
+func monotonicSeconds() float64 {
+	return 0
+}
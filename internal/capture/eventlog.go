@@ -0,0 +1,78 @@
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// EventLogName is the file in the output directory that records the capture
+// lifecycle
+const EventLogName = "capture.log"
+
+// eventTimeFormat has millisecond resolution so that short steps stay ordered
+const eventTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// EventLog appends timestamped lifecycle events (process lookup, delays,
+// triggers, perf start and exit, analysis) to capture.log, to reconstruct the
+// sequence of a capture after the fact. Every event is written as it happens,
+// so the log survives a crash or an interrupted run. Logging is best effort:
+// write errors are ignored, and a nil *EventLog discards events.
+type EventLog struct {
+	path string
+}
+
+// NewEventLog returns a log writing to capture.log in outputDir. The directory
+// is created on the first event.
+func NewEventLog(outputDir string) *EventLog {
+	return &EventLog{path: filepath.Join(outputDir, EventLogName)}
+}
+
+// Printf records one event, formatted as with fmt.Sprintf
+func (l *EventLog) Printf(format string, args ...interface{}) {
+	if l == nil {
+		return
+	}
+	l.write(time.Now(), fmt.Sprintf(format, args...))
+}
+
+func (l *EventLog) write(at time.Time, message string) {
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return
+	}
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	// Multi-line messages (perf's stderr) stay attached to their event
+	message = strings.ReplaceAll(strings.TrimRight(message, "\n"), "\n", "\n    ")
+	fmt.Fprintf(f, "%s %s\n", at.Format(eventTimeFormat), message)
+}
+
+// perfStarted records the perf command line
+func (l *EventLog) perfStarted(args []string) {
+	l.Printf("perf started: perf %s", strings.Join(args, " "))
+}
+
+// perfExited records how a perf run ended and how long it took, with its
+// stderr when it failed
+func (l *EventLog) perfExited(err error, elapsed time.Duration, stderr string) {
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		l.Printf("perf exited code 0 after %.1fs", elapsed.Seconds())
+	case errors.As(err, &exitErr) && exitErr.ExitCode() >= 0:
+		l.Printf("perf exited code %d after %.1fs", exitErr.ExitCode(), elapsed.Seconds())
+	default:
+		l.Printf("perf exited after %.1fs: %v", elapsed.Seconds(), err)
+	}
+	if err != nil && strings.TrimSpace(stderr) != "" {
+		l.Printf("perf stderr:\n%s", stderr)
+	}
+}
@@ -0,0 +1,56 @@
+package capture
+
+import (
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// perfFinalizeTimeout bounds how long perf may take to write perf.data after
+// it is interrupted, before it is killed
+const perfFinalizeTimeout = 10 * time.Second
+
+// interruptWatcher turns the first SIGINT or SIGTERM received during a
+// recording into a clean stop: perf is interrupted so it finalizes perf.data,
+// and the capture returns what was recorded so far for analysis. A second
+// signal terminates the program as usual.
+type interruptWatcher struct {
+	signals     chan os.Signal
+	done        chan struct{}
+	interrupted atomic.Bool
+}
+
+// watchInterrupt starts watching for SIGINT and SIGTERM; stop must make perf
+// exit cleanly. Call Stop once perf has exited.
+func watchInterrupt(config *CaptureConfig, stop func()) *interruptWatcher {
+	w := &interruptWatcher{
+		signals: make(chan os.Signal, 1),
+		done:    make(chan struct{}),
+	}
+	signal.Notify(w.signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-w.signals:
+			w.interrupted.Store(true)
+			signal.Stop(w.signals)
+			config.Log.Printf("%v received, stopping perf", sig)
+			config.logf("\nCapture interrupted, finalizing...\n")
+			stop()
+		case <-w.done:
+		}
+	}()
+	return w
+}
+
+// Interrupted reports whether a signal stopped the recording
+func (w *interruptWatcher) Interrupted() bool {
+	return w.interrupted.Load()
+}
+
+// Stop restores the default signal handling
+func (w *interruptWatcher) Stop() {
+	signal.Stop(w.signals)
+	close(w.done)
+}
@@ -0,0 +1,56 @@
+package capture
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/santiagolertora/blc-perf-analyzer/internal/detector"
+)
+
+// captureLaunch starts config.Launch under perf record and profiles it until
+// it exits. The command's own output is passed through to this process's
+// stdout and stderr. A command that fails is reported as an error, since its
+// profile would not describe a complete run.
+func captureLaunch(config *CaptureConfig, result *CaptureResult) (*CaptureResult, error) {
+	if err := os.MkdirAll(config.OutputDir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating output directory: %v", err)
+	}
+
+	config.logf("Launching and profiling: %s\n", config.Launch)
+
+	stderr := make([]byte, 0)
+	cmd := exec.Command(detector.PerfBinary(), buildRecordArgs(config, nil)...)
+	cmd.Dir = config.OutputDir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = io.MultiWriter(os.Stderr, &stderrWriter{buf: &stderr})
+
+	result.RecordStartTime = time.Now()
+	config.Log.perfStarted(cmd.Args[1:])
+	runErr := cmd.Run()
+	result.EndTime = time.Now()
+	config.Log.perfExited(runErr, result.EndTime.Sub(result.RecordStartTime), string(stderr))
+
+	perfDataPath := filepath.Join(config.OutputDir, "perf.data")
+	if _, err := os.Stat(perfDataPath); err != nil {
+		errMsg := string(stderr)
+		if errMsg == "" && runErr != nil {
+			errMsg = runErr.Error()
+		}
+		result.Error = perfError("", errMsg, nil)
+		return result, result.Error
+	}
+	if runErr != nil {
+		result.Error = fmt.Errorf("launched command failed: %v", runErr)
+		return result, result.Error
+	}
+
+	result.PerfDataPath = perfDataPath
+
+	config.logf("Command finished after %.1f seconds.\n", result.EndTime.Sub(result.RecordStartTime).Seconds())
+
+	return result, nil
+}
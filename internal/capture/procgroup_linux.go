@@ -0,0 +1,19 @@
+package capture
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup starts cmd in its own process group, so that a Ctrl-C in
+// the terminal reaches this process only and perf is stopped by
+// interruptProcessGroup instead
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// interruptProcessGroup sends SIGINT to perf and the workload it started
+// (sleep), which makes perf finalize perf.data and exit
+func interruptProcessGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGINT)
+}
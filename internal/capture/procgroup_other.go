@@ -0,0 +1,17 @@
+//go:build !linux
+
+package capture
+
+import (
+	"os"
+	"os/exec"
+)
+
+// setProcessGroup is a no-op where perf does not run
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// interruptProcessGroup interrupts perf alone where process groups are not
+// available
+func interruptProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Signal(os.Interrupt)
+}
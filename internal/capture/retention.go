@@ -0,0 +1,73 @@
+package capture
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+)
+
+// What to do with perf.data once the analysis succeeded, selected with
+// --perf-data
+const (
+	PerfDataKeep   = "keep"   // Leave perf.data in the output directory, the default
+	PerfDataDelete = "delete" // Remove perf.data
+	PerfDataGzip   = "gzip"   // Replace perf.data with perf.data.gz
+)
+
+// PerfDataModes lists the supported perf.data retention modes
+var PerfDataModes = []string{PerfDataKeep, PerfDataDelete, PerfDataGzip}
+
+// ApplyPerfDataRetention deletes or compresses the recorded perf.data
+// according to mode and updates result.PerfDataPath: empty once deleted,
+// the .gz file once compressed. It must only be called after a successful
+// analysis, so that a failed run keeps perf.data for debugging.
+func ApplyPerfDataRetention(result *CaptureResult, mode string) error {
+	switch mode {
+	case PerfDataKeep:
+		return nil
+	case PerfDataDelete:
+		if err := os.Remove(result.PerfDataPath); err != nil {
+			return fmt.Errorf("could not delete %s: %v", result.PerfDataPath, err)
+		}
+		result.PerfDataPath = ""
+		return nil
+	case PerfDataGzip:
+		compressed := result.PerfDataPath + ".gz"
+		if err := gzipFile(result.PerfDataPath, compressed); err != nil {
+			os.Remove(compressed)
+			return fmt.Errorf("could not compress %s: %v", result.PerfDataPath, err)
+		}
+		if err := os.Remove(result.PerfDataPath); err != nil {
+			return fmt.Errorf("could not delete %s after compressing it: %v", result.PerfDataPath, err)
+		}
+		result.PerfDataPath = compressed
+		return nil
+	default:
+		return fmt.Errorf("unknown perf.data retention mode %q", mode)
+	}
+}
+
+// gzipFile writes a gzip-compressed copy of src to dst
+func gzipFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,129 @@
+package capture
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/santiagolertora/blc-perf-analyzer/internal/detector"
+)
+
+// captureSampleCount records until config.SampleCount samples have been seen.
+//
+// perf record writes pipe-mode data to stdout, which is teed into perf.data and
+// into `perf script -i -`. Each sample is printed on its own line, and once
+// enough lines have been counted perf record is interrupted. A few extra
+// samples may still be flushed after the interrupt; analysis trims them.
+func captureSampleCount(config *CaptureConfig, args []string, targetPIDs []int, result *CaptureResult) (*CaptureResult, error) {
+	config.logf("Capturing %d samples (%s)...\n", config.SampleCount, targetLabel(targetPIDs))
+
+	perfDataPath := filepath.Join(config.OutputDir, "perf.data")
+	dataFile, err := os.Create(perfDataPath)
+	if err != nil {
+		return nil, fmt.Errorf("error creating perf.data: %v", err)
+	}
+	defer dataFile.Close()
+
+	stderr := make([]byte, 0)
+	record := exec.Command(detector.PerfBinary(), args...)
+	record.Dir = config.OutputDir
+	record.Stderr = &stderrWriter{buf: &stderr}
+	setProcessGroup(record)
+	recordOut, err := record.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("error creating perf record pipe: %v", err)
+	}
+
+	script := exec.Command(detector.PerfBinary(), "script", "-i", "-", "-F", "tid")
+	setProcessGroup(script)
+	scriptIn, err := script.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("error creating perf script pipe: %v", err)
+	}
+	scriptOut, err := script.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("error creating perf script pipe: %v", err)
+	}
+
+	if err := record.Start(); err != nil {
+		return nil, fmt.Errorf("error starting perf record: %v", err)
+	}
+	config.Log.perfStarted(args)
+	if err := script.Start(); err != nil {
+		record.Process.Kill()
+		record.Wait()
+		return nil, fmt.Errorf("error starting perf script: %v", err)
+	}
+
+	// A Ctrl-C stops perf record early; the samples counted so far are kept
+	interrupt := watchInterrupt(config, func() { record.Process.Signal(os.Interrupt) })
+
+	copyDone := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.MultiWriter(dataFile, scriptIn), recordOut)
+		scriptIn.Close()
+		copyDone <- err
+	}()
+
+	count := countSamples(scriptOut, config.SampleCount, func() {
+		config.Log.Printf("sample count reached %d, stopping perf", config.SampleCount)
+		record.Process.Signal(os.Interrupt)
+	})
+	io.Copy(io.Discard, scriptOut)
+
+	copyErr := <-copyDone
+	script.Wait()
+	recordErr := record.Wait()
+	interrupt.Stop()
+	result.EndTime = time.Now()
+	result.Interrupted = interrupt.Interrupted()
+	config.Log.perfExited(recordErr, result.EndTime.Sub(result.RecordStartTime), string(stderr))
+	config.Log.Printf("counted %d samples while recording", count)
+
+	if count == 0 {
+		errMsg := string(stderr)
+		if errMsg == "" && recordErr != nil {
+			errMsg = recordErr.Error()
+		}
+		result.Error = perfError("no samples recorded", errMsg, targetPIDs)
+		return result, result.Error
+	}
+	if copyErr != nil {
+		result.Error = fmt.Errorf("error writing perf.data: %v", copyErr)
+		return result, result.Error
+	}
+
+	result.PerfDataPath = perfDataPath
+
+	if result.Interrupted {
+		config.logf("Capture interrupted after %d of %d samples; analyzing the partial capture.\n", count, config.SampleCount)
+	} else if count < config.SampleCount {
+		config.logf("Warning: perf stopped after %d of %d samples (process exited?)\n", count, config.SampleCount)
+	}
+	config.logf("Capture completed successfully (%d samples in %.1f seconds).\n", count, result.EndTime.Sub(result.StartTime).Seconds())
+
+	return result, nil
+}
+
+// countSamples counts non-empty lines from r and calls stop once limit is
+// reached. It returns the number of samples seen, capped at limit.
+func countSamples(r io.Reader, limit int, stop func()) int {
+	count := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		count++
+		if count >= limit {
+			stop()
+			break
+		}
+	}
+	return count
+}
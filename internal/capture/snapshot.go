@@ -0,0 +1,115 @@
+package capture
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/santiagolertora/blc-perf-analyzer/internal/detector"
+)
+
+// captureSnapshot records into perf's overwritable ring buffer until a trigger
+// arrives: SIGUSR1 sent to this process, or config.TriggerFile being created.
+// perf record is then interrupted, which writes the buffer (the lead-up to the
+// trigger) to perf.data. A Ctrl-C (SIGINT or SIGTERM) writes the snapshot too.
+func captureSnapshot(config *CaptureConfig, args []string, targetPIDs []int, result *CaptureResult) (*CaptureResult, error) {
+	config.logf("Snapshot mode: profiling (%s) into a rolling buffer.\n", targetLabel(targetPIDs))
+	config.logf("Send SIGUSR1 to PID %d to write the snapshot", os.Getpid())
+	if config.TriggerFile != "" {
+		config.logf(" (or create %s)", config.TriggerFile)
+	}
+	config.logf("...\n")
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	stderr := make([]byte, 0)
+	cmd := exec.Command(detector.PerfBinary(), args...)
+	cmd.Dir = config.OutputDir
+	cmd.Stderr = &stderrWriter{buf: &stderr}
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("error starting perf record: %v", err)
+	}
+	config.Log.perfStarted(args)
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	var waitErr error
+	perfRunning := true
+	reason := ""
+	for reason == "" {
+		select {
+		case sig := <-signals:
+			reason = "SIGUSR1 received"
+			if sig != syscall.SIGUSR1 {
+				// A second Ctrl-C terminates the program as usual
+				signal.Stop(signals)
+				result.Interrupted = true
+				reason = "interrupted"
+			}
+		case <-ticker.C:
+			if triggered(config.TriggerFile) {
+				os.Remove(config.TriggerFile)
+				reason = "trigger file found"
+			}
+		case waitErr = <-exited:
+			perfRunning = false
+			reason = "perf exited (target process gone?)"
+		}
+	}
+
+	config.Log.Printf("snapshot triggered: %s", reason)
+	if result.Interrupted {
+		config.logf("\nCapture interrupted, finalizing...\n")
+	}
+	config.logf("Snapshot triggered: %s\n", reason)
+	if perfRunning {
+		cmd.Process.Signal(os.Interrupt)
+		waitErr = <-exited
+	}
+	result.EndTime = time.Now()
+	config.Log.perfExited(waitErr, result.EndTime.Sub(result.RecordStartTime), string(stderr))
+
+	// A denied attach or a rejected event leaves an empty perf.data behind
+	if waitErr != nil && (isPermissionError(string(stderr)) || isEventError(string(stderr))) {
+		result.Error = perfError("snapshot not written", string(stderr), targetPIDs)
+		return result, result.Error
+	}
+
+	perfDataPath := filepath.Join(config.OutputDir, "perf.data")
+	if _, err := os.Stat(perfDataPath); err != nil {
+		errMsg := string(stderr)
+		if errMsg == "" && waitErr != nil {
+			errMsg = waitErr.Error()
+		}
+		result.Error = perfError("snapshot not written", errMsg, targetPIDs)
+		return result, result.Error
+	}
+
+	result.PerfDataPath = perfDataPath
+
+	config.logf("Snapshot written after %.1f seconds of profiling.\n", result.EndTime.Sub(result.RecordStartTime).Seconds())
+
+	return result, nil
+}
+
+// triggered reports whether the trigger file exists
+func triggered(path string) bool {
+	if path == "" {
+		return false
+	}
+	_, err := os.Stat(path)
+	return err == nil
+}
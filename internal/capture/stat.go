@@ -0,0 +1,45 @@
+package capture
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/santiagolertora/blc-perf-analyzer/internal/detector"
+)
+
+// statEvents are the counters collected by `perf stat` with --with-stat
+var statEvents = []string{
+	"cycles", "instructions",
+	"cache-references", "cache-misses",
+	"branches", "branch-misses",
+	"context-switches",
+}
+
+// statFileName is the perf stat CSV output written next to perf.data
+const statFileName = "perf-stat.csv"
+
+// buildStatArgs builds the perf stat arguments counting pids (every CPU when
+// empty, only the tasks of cgroup when set) for duration seconds; perf stat
+// stops when the sleep command exits
+func buildStatArgs(pids []int, cgroup string, duration int, outputPath string) []string {
+	args := []string{
+		"stat", "-x", ",", "-o", outputPath,
+		"-e", strings.Join(statEvents, ","),
+	}
+	args = append(args, cgroupArgs(cgroup)...)
+	args = append(args, targetArgs(pids)...)
+	return append(args, "--", "sleep", strconv.Itoa(duration))
+}
+
+// startStat starts perf stat on pids alongside perf record
+func startStat(config *CaptureConfig, pids []int) (*exec.Cmd, string, error) {
+	outputPath := filepath.Join(config.OutputDir, statFileName)
+	cmd := exec.Command(detector.PerfBinary(), buildStatArgs(pids, config.Cgroup, config.Duration, outputPath)...)
+	if err := cmd.Start(); err != nil {
+		return nil, "", fmt.Errorf("error starting perf stat: %v", err)
+	}
+	return cmd, outputPath, nil
+}
@@ -0,0 +1,58 @@
+package capture
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/santiagolertora/blc-perf-analyzer/internal/process"
+)
+
+// triggerPollInterval is how often the target's CPU usage is sampled while
+// waiting for --trigger-cpu
+const triggerPollInterval = time.Second
+
+// waitForCPUTrigger blocks until the target's CPU usage over the last poll
+// interval exceeds config.TriggerCPU (percent of one core, as in top; a busy
+// multi-threaded process can exceed 100). It fails if the target exits.
+func waitForCPUTrigger(config *CaptureConfig, targetPID int) error {
+	return waitForCPU(config, targetPID, triggerPollInterval, process.CPUTime)
+}
+
+// waitForCPU implements waitForCPUTrigger with the poll interval and the CPU
+// time source as parameters
+func waitForCPU(config *CaptureConfig, targetPID int, interval time.Duration, cpuTime func(int) (time.Duration, error)) error {
+	config.Log.Printf("trigger-cpu waiting for PID %d to exceed %.0f%% CPU", targetPID, config.TriggerCPU)
+	config.logf("Waiting for PID %d to exceed %.0f%% CPU...\n", targetPID, config.TriggerCPU)
+
+	previous, err := cpuTime(targetPID)
+	if err != nil {
+		return err
+	}
+	last := time.Now()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	polls := 0
+	for range ticker.C {
+		polls++
+		current, err := cpuTime(targetPID)
+		if err != nil {
+			config.Log.Printf("process %d terminated while waiting for the CPU trigger", targetPID)
+			return fmt.Errorf("%w: process terminated while waiting for the CPU trigger (after %d polls)", process.ErrProcessNotFound, polls)
+		}
+		now := time.Now()
+		usage := float64(current-previous) / float64(now.Sub(last)) * 100
+		previous, last = current, now
+
+		if usage > config.TriggerCPU {
+			config.Log.Printf("trigger-cpu fired at %.0f%% CPU", usage)
+			config.logf("CPU usage reached %.0f%% (threshold %.0f%%), starting capture now...\n", usage, config.TriggerCPU)
+			return nil
+		}
+		if polls%10 == 0 {
+			config.logf("  ... still waiting, CPU at %.0f%%\n", usage)
+		}
+	}
+	return nil
+}
@@ -1,27 +1,79 @@
 package detector
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
 )
 
+// Errores que los llamadores pueden distinguir con errors.Is
+var (
+	// ErrPerfNotInstalled indica que no hay un binario de perf utilizable
+	ErrPerfNotInstalled = errors.New("perf is not installed")
+	// ErrPermissionDenied indica que el kernel no permite a perf leer los eventos
+	ErrPermissionDenied = errors.New("insufficient permissions for perf")
+)
+
 // SystemInfo contiene la información del sistema detectada
 type SystemInfo struct {
 	OS            string
-	Distro        string
+	Distro        string   // ID de /etc/os-release
+	DistroLike    []string // ID_LIKE de /etc/os-release, las distribuciones de las que deriva
+	InContainer   bool     // Se ejecuta dentro de un contenedor (Docker, Podman, Kubernetes, LXC)
 	PerfInstalled bool
 	PerfVersion   string
 }
 
+// perfBinary es el binario de perf usado en todas las invocaciones
+var perfBinary = "perf"
+
+// PerfBinary returns the perf binary used for every perf invocation
+func PerfBinary() string {
+	return perfBinary
+}
+
+// SetPerfBinary overrides the perf binary used for every perf invocation.
+// The path must point to an existing executable file.
+func SetPerfBinary(path string) error {
+	resolved, err := exec.LookPath(path)
+	if err != nil {
+		return fmt.Errorf("%w: perf binary %q is not an executable: %v", ErrPerfNotInstalled, path, err)
+	}
+	perfBinary = resolved
+	return nil
+}
+
+// buildIDDir es el build-id cache que usan las invocaciones de perf sobre
+// perf.data; vacío usa el de perf (~/.debug)
+var buildIDDir string
+
+// SetBuildIDDir makes the perf invocations built with PerfArgs use dir as
+// their build-id cache instead of ~/.debug ("" restores the default)
+func SetBuildIDDir(dir string) {
+	buildIDDir = dir
+}
+
+// PerfArgs returns the arguments of a perf subcommand preceded by the global
+// options in effect (--buildid-dir)
+func PerfArgs(args ...string) []string {
+	if buildIDDir == "" {
+		return args
+	}
+	return append([]string{"--buildid-dir", buildIDDir}, args...)
+}
+
 // DetectSystem detecta información del sistema operativo y distribución
 func DetectSystem() (*SystemInfo, error) {
 	info := &SystemInfo{}
 
 	// Detectar OS
 	info.OS = "linux" // Por ahora asumimos Linux, podríamos expandir después
+	info.InContainer = InContainer()
 
 	// Detectar distribución
 	if _, err := os.Stat("/etc/os-release"); err == nil {
@@ -31,14 +83,18 @@ func DetectSystem() (*SystemInfo, error) {
 			return nil, fmt.Errorf("error leyendo /etc/os-release: %v", err)
 		}
 
-		// Parsear ID de distribución
-		lines := strings.Split(string(output), "\n")
-		for _, line := range lines {
-			if strings.HasPrefix(line, "ID=") {
-				info.Distro = strings.Trim(strings.TrimPrefix(line, "ID="), "\"")
-				break
-			}
+		info.Distro, info.DistroLike = parseOSRelease(string(output))
+	}
+
+	// Si se especificó un binario de perf explícito, no hace falta detectarlo
+	if perfBinary != "perf" {
+		info.PerfInstalled = true
+		output, err := exec.Command(perfBinary, "--version").Output()
+		if err != nil {
+			return nil, fmt.Errorf("%w: error running %s --version: %v", ErrPerfNotInstalled, perfBinary, err)
 		}
+		info.PerfVersion = strings.TrimSpace(string(output))
+		return info, nil
 	}
 
 	// Verificar si perf está instalado para el kernel actual
@@ -61,7 +117,10 @@ func DetectSystem() (*SystemInfo, error) {
 		}
 	} else {
 		info.PerfInstalled = false
-		return nil, fmt.Errorf("perf is not installed for your kernel (%s). Please run: sudo apt-get install linux-tools-%s linux-cloud-tools-%s", kernelVersion, kernelVersion, kernelVersion)
+		if info.InContainer {
+			return nil, fmt.Errorf("%w in this container. perf has to match the host kernel (%s): install the host distribution's perf package in the image, or profile from the host", ErrPerfNotInstalled, kernelVersion)
+		}
+		return nil, fmt.Errorf("%w for your kernel (%s). Please run: sudo apt-get install linux-tools-%s linux-cloud-tools-%s", ErrPerfNotInstalled, kernelVersion, kernelVersion, kernelVersion)
 	}
 
 	return info, nil
@@ -74,32 +133,169 @@ func CheckPermissions() error {
 	if err != nil {
 		return fmt.Errorf("could not read /proc/sys/kernel/perf_event_paranoid: %v", err)
 	}
-	value := strings.TrimSpace(string(contents))
-	if value != "-1" && value != "0" && value != "1" {
-		return fmt.Errorf("Your system restricts performance monitoring (perf_event_paranoid=%s).\nTo allow perf, run: sudo sysctl -w kernel.perf_event_paranoid=1\nFor more info: https://www.kernel.org/doc/html/latest/admin-guide/perf-security.html", value)
+	return checkParanoid(strings.TrimSpace(string(contents)), InContainer())
+}
+
+// checkParanoid valida el valor de perf_event_paranoid. Dentro de un
+// contenedor el sysctl no se puede cambiar y la guía apunta al host y a las
+// opciones del contenedor.
+func checkParanoid(value string, inContainer bool) error {
+	if value == "-1" || value == "0" || value == "1" {
+		return nil
+	}
+	if inContainer {
+		return fmt.Errorf("%w: the host restricts performance monitoring (perf_event_paranoid=%s) and this process runs in a container.\nEither run the container with --cap-add SYS_ADMIN (or --cap-add PERFMON on kernel 5.8+) and --security-opt seccomp=unconfined, or with --privileged,\nor lower the limit on the host: sudo sysctl -w kernel.perf_event_paranoid=1\nperf inside the container must match the host kernel; installing it on the host and profiling from there is often simpler.", ErrPermissionDenied, value)
+	}
+	return fmt.Errorf("%w: your system restricts performance monitoring (perf_event_paranoid=%s).\nTo allow perf, run: sudo sysctl -w kernel.perf_event_paranoid=1\nFor more info: https://www.kernel.org/doc/html/latest/admin-guide/perf-security.html", ErrPermissionDenied, value)
+}
+
+// Archivos que delatan un contenedor: los crean Docker y Podman, y el
+// cgroup de PID 1 nombra al runtime
+var (
+	dockerEnvPath    = "/.dockerenv"
+	containerEnvPath = "/run/.containerenv"
+	initCgroupPath   = "/proc/1/cgroup"
+)
+
+// containerCgroupMarkers son fragmentos de las rutas de cgroup que crean los
+// runtimes de contenedores
+var containerCgroupMarkers = []string{"docker", "kubepods", "containerd", "crio", "libpod", "lxc"}
+
+// InContainer indica si el proceso se ejecuta dentro de un contenedor. Con
+// cgroup v2 y un namespace de cgroups propio, /proc/1/cgroup es "0::/" y
+// solo quedan los archivos de Docker y Podman para detectarlo.
+func InContainer() bool {
+	for _, path := range []string{dockerEnvPath, containerEnvPath} {
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+	}
+	content, err := ioutil.ReadFile(initCgroupPath)
+	if err != nil {
+		return false
+	}
+	return isContainerCgroup(string(content))
+}
+
+// isContainerCgroup indica si el contenido de /proc/<pid>/cgroup pertenece
+// a un contenedor
+func isContainerCgroup(content string) bool {
+	for _, line := range strings.Split(content, "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		for _, marker := range containerCgroupMarkers {
+			if strings.Contains(parts[2], marker) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// cgroupRoot es donde se monta el sistema de archivos de cgroups
+var cgroupRoot = "/sys/fs/cgroup"
+
+// CheckCgroup verifica que perf pueda filtrar por cgroup (perf record -G):
+// que el controlador perf_event esté disponible y que exista el cgroup, dado
+// como ruta desde la raíz de la jerarquía (por ejemplo, /docker/<id>).
+func CheckCgroup(cgroup string) error {
+	hierarchy, err := perfEventHierarchy()
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(filepath.Join(hierarchy, cgroup))
+	if err != nil || !info.IsDir() {
+		return fmt.Errorf("cgroup %s not found under %s", cgroup, hierarchy)
 	}
 	return nil
 }
 
-// InstallPerf instala perf si no está presente
-func InstallPerf(distro string) error {
-	var cmd *exec.Cmd
+// perfEventHierarchy devuelve el punto de montaje de la jerarquía con el
+// controlador perf_event. Con cgroup v2 (hay cgroup.controllers en la raíz)
+// perf_event siempre está habilitado en la jerarquía unificada; con v1 tiene
+// que estar montado aparte.
+func perfEventHierarchy() (string, error) {
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err == nil {
+		return cgroupRoot, nil
+	}
+	v1 := filepath.Join(cgroupRoot, "perf_event")
+	if info, err := os.Stat(v1); err == nil && info.IsDir() {
+		return v1, nil
+	}
+	return "", fmt.Errorf("the perf_event cgroup controller is not available: %s is neither a cgroup v2 hierarchy nor has a perf_event (v1) mount", cgroupRoot)
+}
+
+// MaxSampleRate devuelve kernel.perf_event_max_sample_rate, la frecuencia
+// máxima de muestreo (Hz) que el kernel permite antes de limitar a perf
+func MaxSampleRate() (int, error) {
+	contents, err := ioutil.ReadFile("/proc/sys/kernel/perf_event_max_sample_rate")
+	if err != nil {
+		return 0, fmt.Errorf("could not read /proc/sys/kernel/perf_event_max_sample_rate: %v", err)
+	}
+	rate, err := strconv.Atoi(strings.TrimSpace(string(contents)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid perf_event_max_sample_rate %q: %v", strings.TrimSpace(string(contents)), err)
+	}
+	return rate, nil
+}
+
+// parseOSRelease extrae ID e ID_LIKE del contenido de /etc/os-release
+func parseOSRelease(content string) (string, []string) {
+	var id string
+	var like []string
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "ID="):
+			id = strings.Trim(strings.TrimPrefix(line, "ID="), "\"'")
+		case strings.HasPrefix(line, "ID_LIKE="):
+			like = strings.Fields(strings.Trim(strings.TrimPrefix(line, "ID_LIKE="), "\"'"))
+		}
+	}
+	return id, like
+}
 
-	switch distro {
-	case "ubuntu", "debian":
-		cmd = exec.Command("sudo", "apt-get", "update")
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("error actualizando repositorios: %v", err)
+// perfInstallCommands devuelve los comandos que instalan perf en distro o,
+// si no se conoce, en la primera distribución conocida de distroLike
+func perfInstallCommands(distro string, distroLike []string) ([][]string, error) {
+	for _, id := range append([]string{distro}, distroLike...) {
+		switch {
+		case id == "ubuntu" || id == "debian":
+			return [][]string{
+				{"sudo", "apt-get", "update"},
+				{"sudo", "apt-get", "install", "-y", "linux-tools-common", "linux-tools-generic"},
+			}, nil
+		case id == "fedora" || id == "rhel" || id == "centos":
+			return [][]string{{"sudo", "dnf", "install", "-y", "perf"}}, nil
+		case id == "alpine":
+			return [][]string{{"sudo", "apk", "add", "perf"}}, nil
+		case id == "arch" || id == "manjaro":
+			return [][]string{{"sudo", "pacman", "-S", "--noconfirm", "perf"}}, nil
+		case strings.HasPrefix(id, "opensuse") || id == "sles" || id == "suse":
+			return [][]string{{"sudo", "zypper", "--non-interactive", "install", "perf"}}, nil
 		}
-		cmd = exec.Command("sudo", "apt-get", "install", "-y", "linux-tools-common", "linux-tools-generic")
-	case "fedora", "rhel", "centos":
-		cmd = exec.Command("sudo", "dnf", "install", "-y", "perf")
-	default:
-		return fmt.Errorf("distribución no soportada: %s", distro)
+	}
+	return nil, fmt.Errorf("distribución no soportada: %s", distro)
+}
+
+// InstallPerf instala perf si no está presente, con el gestor de paquetes de
+// distro o de las distribuciones de las que deriva (ID_LIKE)
+func InstallPerf(distro string, distroLike []string) error {
+	commands, err := perfInstallCommands(distro, distroLike)
+	if err != nil {
+		return err
 	}
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("error instalando perf: %v", err)
+	for i, args := range commands {
+		if err := exec.Command(args[0], args[1:]...).Run(); err != nil {
+			// Solo el último comando instala; los anteriores actualizan los repositorios
+			if i < len(commands)-1 {
+				return fmt.Errorf("error actualizando repositorios: %v", err)
+			}
+			return fmt.Errorf("error instalando perf: %v", err)
+		}
 	}
 
 	return nil
@@ -0,0 +1,190 @@
+package detector
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSetPerfBinary(t *testing.T) {
+	defer func() { perfBinary = "perf" }()
+
+	dir := t.TempDir()
+	executable := filepath.Join(dir, "perf")
+	if err := os.WriteFile(executable, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatalf("Failed to create fake perf: %v", err)
+	}
+	notExecutable := filepath.Join(dir, "perf.txt")
+	if err := os.WriteFile(notExecutable, []byte("text"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	if err := SetPerfBinary(executable); err != nil {
+		t.Errorf("SetPerfBinary(%q) error = %v, want nil", executable, err)
+	}
+	if PerfBinary() != executable {
+		t.Errorf("PerfBinary() = %q, want %q", PerfBinary(), executable)
+	}
+
+	if err := SetPerfBinary(notExecutable); !errors.Is(err, ErrPerfNotInstalled) {
+		t.Errorf("Expected ErrPerfNotInstalled for non-executable file, got %v", err)
+	}
+	if err := SetPerfBinary(filepath.Join(dir, "missing")); err == nil {
+		t.Error("Expected error for missing file")
+	}
+	if err := SetPerfBinary(dir); err == nil {
+		t.Error("Expected error for directory")
+	}
+}
+
+func TestCheckParanoid(t *testing.T) {
+	for _, value := range []string{"-1", "0", "1"} {
+		if err := checkParanoid(value, false); err != nil {
+			t.Errorf("checkParanoid(%q) error = %v, want nil", value, err)
+		}
+	}
+	if err := checkParanoid("2", false); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("checkParanoid(\"2\") error = %v, want ErrPermissionDenied", err)
+	}
+
+	err := checkParanoid("2", true)
+	if !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("checkParanoid(\"2\") in a container error = %v, want ErrPermissionDenied", err)
+	}
+	if err != nil && !strings.Contains(err.Error(), "--cap-add SYS_ADMIN") {
+		t.Errorf("Container guidance missing from %q", err)
+	}
+}
+
+func TestInContainer(t *testing.T) {
+	originals := []string{dockerEnvPath, containerEnvPath, initCgroupPath}
+	defer func() { dockerEnvPath, containerEnvPath, initCgroupPath = originals[0], originals[1], originals[2] }()
+
+	dir := t.TempDir()
+	dockerEnvPath = filepath.Join(dir, ".dockerenv")
+	containerEnvPath = filepath.Join(dir, ".containerenv")
+	initCgroupPath = filepath.Join(dir, "cgroup")
+
+	for _, tt := range []struct {
+		cgroup string
+		want   bool
+	}{
+		{"0::/init.scope\n", false},
+		{"0::/\n", false},
+		{"0::/system.slice/docker-3f4e5d6c7b8a.scope\n", true},
+		{"12:cpu,cpuacct:/docker/3f4e5d6c7b8a\n1:name=systemd:/docker/3f4e5d6c7b8a\n", true},
+		{"0::/kubepods/burstable/pod1234/cri-containerd-abcd.scope\n", true},
+		{"0::/machine.slice/libpod-abcd.scope\n", true},
+	} {
+		if err := os.WriteFile(initCgroupPath, []byte(tt.cgroup), 0644); err != nil {
+			t.Fatalf("Failed to write fake cgroup: %v", err)
+		}
+		if got := InContainer(); got != tt.want {
+			t.Errorf("InContainer() with cgroup %q = %v, want %v", tt.cgroup, got, tt.want)
+		}
+	}
+
+	// Docker's marker file is enough when the cgroup is namespaced
+	if err := os.WriteFile(dockerEnvPath, nil, 0644); err != nil {
+		t.Fatalf("Failed to create .dockerenv: %v", err)
+	}
+	if !InContainer() {
+		t.Error("InContainer() = false with /.dockerenv present")
+	}
+}
+
+func TestCheckCgroup(t *testing.T) {
+	original := cgroupRoot
+	defer func() { cgroupRoot = original }()
+
+	// cgroup v2: a single unified hierarchy
+	cgroupRoot = t.TempDir()
+	if err := CheckCgroup("/docker/abc123"); err == nil {
+		t.Error("Expected an error without a perf_event hierarchy")
+	}
+	if err := os.WriteFile(filepath.Join(cgroupRoot, "cgroup.controllers"), []byte("cpu io memory pids\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(cgroupRoot, "docker", "abc123"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckCgroup("/docker/abc123"); err != nil {
+		t.Errorf("Expected the v2 cgroup to be found: %v", err)
+	}
+	if err := CheckCgroup("/docker/missing"); err == nil {
+		t.Error("Expected an error for a missing cgroup")
+	}
+
+	// cgroup v1: perf_event mounted on its own
+	cgroupRoot = t.TempDir()
+	if err := os.MkdirAll(filepath.Join(cgroupRoot, "perf_event", "docker", "abc123"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckCgroup("/docker/abc123"); err != nil {
+		t.Errorf("Expected the v1 cgroup to be found: %v", err)
+	}
+}
+
+func TestPerfInstallCommands(t *testing.T) {
+	tests := []struct {
+		distro  string
+		like    []string
+		want    string // The command that installs perf
+		wantErr bool
+	}{
+		{distro: "ubuntu", want: "sudo apt-get install -y linux-tools-common linux-tools-generic"},
+		{distro: "debian", want: "sudo apt-get install -y linux-tools-common linux-tools-generic"},
+		{distro: "linuxmint", like: []string{"ubuntu", "debian"}, want: "sudo apt-get install -y linux-tools-common linux-tools-generic"},
+		{distro: "fedora", want: "sudo dnf install -y perf"},
+		{distro: "rocky", like: []string{"rhel", "centos", "fedora"}, want: "sudo dnf install -y perf"},
+		{distro: "alpine", want: "sudo apk add perf"},
+		{distro: "arch", want: "sudo pacman -S --noconfirm perf"},
+		{distro: "manjaro", like: []string{"arch"}, want: "sudo pacman -S --noconfirm perf"},
+		{distro: "endeavouros", like: []string{"arch"}, want: "sudo pacman -S --noconfirm perf"},
+		{distro: "opensuse-leap", like: []string{"suse", "opensuse"}, want: "sudo zypper --non-interactive install perf"},
+		{distro: "opensuse-tumbleweed", want: "sudo zypper --non-interactive install perf"},
+		{distro: "sles", like: []string{"suse"}, want: "sudo zypper --non-interactive install perf"},
+		{distro: "gentoo", wantErr: true},
+		{distro: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		commands, err := perfInstallCommands(tt.distro, tt.like)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("perfInstallCommands(%q, %v) expected an error, got %v", tt.distro, tt.like, commands)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("perfInstallCommands(%q, %v) error = %v", tt.distro, tt.like, err)
+			continue
+		}
+		if got := strings.Join(commands[len(commands)-1], " "); got != tt.want {
+			t.Errorf("perfInstallCommands(%q, %v) = %q, want %q", tt.distro, tt.like, got, tt.want)
+		}
+	}
+}
+
+func TestParseOSRelease(t *testing.T) {
+	content := `NAME="openSUSE Leap"
+VERSION="15.5"
+ID="opensuse-leap"
+ID_LIKE="suse opensuse"
+VERSION_ID="15.5"
+`
+	id, like := parseOSRelease(content)
+	if id != "opensuse-leap" {
+		t.Errorf("ID = %q, want opensuse-leap", id)
+	}
+	if len(like) != 2 || like[0] != "suse" || like[1] != "opensuse" {
+		t.Errorf("ID_LIKE = %v, want [suse opensuse]", like)
+	}
+
+	id, like = parseOSRelease("ID=alpine\nVERSION_ID=3.19.1\n")
+	if id != "alpine" || len(like) != 0 {
+		t.Errorf("parseOSRelease(alpine) = %q, %v", id, like)
+	}
+}
@@ -0,0 +1,55 @@
+package heatmap
+
+import "fmt"
+
+// animationTopFunctions is the number of functions in the animated chart, the
+// same top 30 the heatmap chart shows
+const animationTopFunctions = 30
+
+// animation is the data of the function distribution chart added with
+// HeatmapConfig.Animate: one frame and one slider step per time window
+type animation struct {
+	Functions []string         `json:"functions"` // Busiest last, so that it is drawn at the top
+	Frames    []animationFrame `json:"frames"`
+	Steps     []sliderStep     `json:"steps"`
+}
+
+// animationFrame is one time window: the share of its samples of each of
+// the animation's functions, in the same order
+type animationFrame struct {
+	Name   string    `json:"name"`
+	Title  string    `json:"title"`
+	Shares []float64 `json:"shares"`
+}
+
+// sliderStep is a slider position that jumps to the frame of the same name
+type sliderStep struct {
+	Label string `json:"label"`
+	Frame string `json:"frame"`
+}
+
+// buildAnimation returns the frames of the function distribution chart.
+// Windows without samples get all-zero shares.
+func buildAnimation(windows []*TimeWindowData) *animation {
+	functions := rankFunctions(windows, animationTopFunctions)
+	for i, j := 0, len(functions)-1; i < j; i, j = i+1, j-1 {
+		functions[i], functions[j] = functions[j], functions[i]
+	}
+
+	anim := &animation{Functions: functions}
+	for i, window := range windows {
+		frame := animationFrame{
+			Name:   fmt.Sprintf("W%d", i),
+			Title:  fmt.Sprintf("Window %d (+%.1fs)", i, window.StartTime-windows[0].StartTime),
+			Shares: make([]float64, len(functions)),
+		}
+		if window.SampleCount > 0 {
+			for j, fn := range functions {
+				frame.Shares[j] = float64(window.FunctionCounts[fn]) / float64(window.SampleCount) * 100
+			}
+		}
+		anim.Frames = append(anim.Frames, frame)
+		anim.Steps = append(anim.Steps, sliderStep{Label: frame.Name, Frame: frame.Name})
+	}
+	return anim
+}
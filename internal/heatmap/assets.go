@@ -0,0 +1,46 @@
+package heatmap
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"regexp"
+)
+
+//go:generate go run fetch_plotly.go https://cdn.plot.ly/plotly-2.26.0.min.js assets/plotly-2.26.0.min.js
+
+// plotlyCDN is where heatmap.html loads Plotly from by default
+const plotlyCDN = "https://cdn.plot.ly/plotly-2.26.0.min.js"
+
+// plotlyAsset is the embedded copy of the same build, inlined by OfflineAssets.
+// It is only fetched when its SHA-256 is pinned in plotlyAsset + ".sha256".
+const plotlyAsset = "assets/plotly-2.26.0.min.js"
+
+//go:embed assets
+var assets embed.FS
+
+// readPlotly returns the embedded Plotly library. It is a variable so that
+// tests don't depend on the asset being fetched.
+var readPlotly = func() ([]byte, error) {
+	return assets.ReadFile(plotlyAsset)
+}
+
+// scriptEnd matches a closing script tag, which would end the inline element
+var scriptEnd = regexp.MustCompile(`(?i)</script`)
+
+// OfflineAssetsAvailable reports whether Plotly was embedded in this build,
+// which OfflineAssets requires
+func OfflineAssetsAvailable() bool {
+	_, err := readPlotly()
+	return err == nil
+}
+
+// inlinePlotly returns the embedded Plotly library for an inline <script>
+// element
+func inlinePlotly() (template.JS, error) {
+	library, err := readPlotly()
+	if err != nil {
+		return "", fmt.Errorf("the Plotly library is not embedded in this build (run 'make assets' before building): %v", err)
+	}
+	return template.JS(scriptEnd.ReplaceAll(library, []byte(`<\/script`))), nil
+}
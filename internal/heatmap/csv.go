@@ -0,0 +1,90 @@
+package heatmap
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// CSVFileName is the per-window table written next to heatmap-data.json
+// with HeatmapConfig.CSV
+const CSVFileName = "heatmap-data.csv"
+
+// csvTopFunctions is the number of functions given a count column, the same
+// top 30 the heatmap chart shows
+const csvTopFunctions = 30
+
+// ExportCSV writes the time windows as CSV, one row per window, for loading
+// into pandas or a spreadsheet. The columns are the window index, its start
+// and end time (perf timestamps, as in heatmap-data.json), sample count,
+// kernel and userland percentages, top function and its share, followed by
+// one column per top function with its count in the window. Windows without
+// samples have no top function and zero percentages.
+func ExportCSV(data *HeatmapData, path string) error {
+	functions := rankFunctions(data.TimeWindows, csvTopFunctions)
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating heatmap CSV: %v", err)
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	header := []string{"window", "start_time", "end_time", "sample_count", "kernel_percent", "userland_percent", "top_function", "top_function_percent"}
+	w.Write(append(header, functions...))
+
+	for _, window := range data.TimeWindows {
+		row := []string{
+			strconv.Itoa(window.WindowIndex),
+			formatFloat(window.StartTime, 6),
+			formatFloat(window.EndTime, 6),
+			strconv.Itoa(window.SampleCount),
+			formatFloat(window.KernelPercent, 2),
+			formatFloat(window.UserlandPercent, 2),
+			window.TopFunction,
+			formatFloat(window.TopFunctionPercent, 2),
+		}
+		for _, fn := range functions {
+			row = append(row, strconv.Itoa(window.FunctionCounts[fn]))
+		}
+		w.Write(row)
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("error writing heatmap CSV: %v", err)
+	}
+	return f.Close()
+}
+
+// rankFunctions returns up to limit functions with the most samples across
+// all windows, ties in name order
+func rankFunctions(windows []*TimeWindowData, limit int) []string {
+	totals := make(map[string]int)
+	for _, window := range windows {
+		for fn, count := range window.FunctionCounts {
+			totals[fn] += count
+		}
+	}
+
+	functions := make([]string, 0, len(totals))
+	for fn := range totals {
+		functions = append(functions, fn)
+	}
+	sort.Slice(functions, func(i, j int) bool {
+		if totals[functions[i]] != totals[functions[j]] {
+			return totals[functions[i]] > totals[functions[j]]
+		}
+		return functions[i] < functions[j]
+	})
+	if len(functions) > limit {
+		functions = functions[:limit]
+	}
+	return functions
+}
+
+func formatFloat(v float64, decimals int) string {
+	return strconv.FormatFloat(v, 'f', decimals, 64)
+}
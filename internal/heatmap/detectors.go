@@ -0,0 +1,278 @@
+package heatmap
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+// AnomalyDetector finds anomalies across the time windows of a capture.
+// Detectors must not modify the windows.
+type AnomalyDetector interface {
+	// Name identifies the detector, e.g. "lock_contention"
+	Name() string
+	// Detect returns the anomalies found in windows
+	Detect(windows []*TimeWindowData) []Anomaly
+}
+
+var (
+	detectorsMu sync.RWMutex
+
+	// userDetectors run in registration order after the built-in ones
+	userDetectors []AnomalyDetector
+)
+
+// RegisterDetector adds a custom anomaly detector that runs on every heatmap
+// after the built-in detectors
+func RegisterDetector(detector AnomalyDetector) {
+	detectorsMu.Lock()
+	defer detectorsMu.Unlock()
+	userDetectors = append(userDetectors, detector)
+}
+
+// Default thresholds of the lock_contention, high_syscall and cpu_spike
+// detectors
+const (
+	DefaultLockContentionPct = 50.0
+	DefaultSyscallPct        = 70.0
+	DefaultSpikeMultiplier   = 1.5
+)
+
+// PatternThresholds are the sensitivities of the built-in detectors. Zero
+// fields use the defaults.
+type PatternThresholds struct {
+	LockContentionPct float64 // % of a window's samples in lock functions flagged as lock_contention
+	SyscallPct        float64 // % of a window's samples in the kernel flagged as high_syscall
+	SpikeMultiplier   float64 // Window samples, relative to the average, flagged as cpu_spike
+}
+
+// DefaultPatternThresholds returns the thresholds used when none are set
+func DefaultPatternThresholds() PatternThresholds {
+	return PatternThresholds{
+		LockContentionPct: DefaultLockContentionPct,
+		SyscallPct:        DefaultSyscallPct,
+		SpikeMultiplier:   DefaultSpikeMultiplier,
+	}
+}
+
+// withDefaults fills the unset thresholds with their defaults
+func (t PatternThresholds) withDefaults() PatternThresholds {
+	defaults := DefaultPatternThresholds()
+	if t.LockContentionPct <= 0 {
+		t.LockContentionPct = defaults.LockContentionPct
+	}
+	if t.SyscallPct <= 0 {
+		t.SyscallPct = defaults.SyscallPct
+	}
+	if t.SpikeMultiplier <= 0 {
+		t.SpikeMultiplier = defaults.SpikeMultiplier
+	}
+	return t
+}
+
+// defaultDetectors returns the built-in detectors
+func defaultDetectors(thresholds PatternThresholds, ratioShiftDelta float64, hostCores int) []AnomalyDetector {
+	thresholds = thresholds.withDefaults()
+	return []AnomalyDetector{
+		lockContentionDetector{pct: thresholds.LockContentionPct},
+		highSyscallDetector{pct: thresholds.SyscallPct},
+		cpuSpikeDetector{multiplier: thresholds.SpikeMultiplier},
+		ratioShiftDetector{delta: ratioShiftDelta},
+		mmPressureDetector{},
+		singleThreadDetector{cores: hostCores},
+	}
+}
+
+// detectors returns the built-in detectors followed by the registered ones
+func detectors(thresholds PatternThresholds, ratioShiftDelta float64, hostCores int) []AnomalyDetector {
+	detectorsMu.RLock()
+	defer detectorsMu.RUnlock()
+	return append(defaultDetectors(thresholds, ratioShiftDelta, hostCores), userDetectors...)
+}
+
+// LockSymbols are substrings of the lock and futex functions counted as lock
+// contention
+var LockSymbols = []string{"pthread_mutex", "futex", "rwlock", "__lll_lock"}
+
+// lockContentionDetector flags windows dominated by pthread/futex activity
+type lockContentionDetector struct {
+	pct float64
+}
+
+func (lockContentionDetector) Name() string { return "lock_contention" }
+
+func (d lockContentionDetector) Detect(windows []*TimeWindowData) []Anomaly {
+	anomalies := make([]Anomaly, 0)
+	for i, window := range windows {
+		lockCount := 0
+		for fn, count := range window.FunctionCounts {
+			if containsAny(fn, LockSymbols) {
+				lockCount += count
+			}
+		}
+
+		if float64(lockCount)*100 > d.pct*float64(window.SampleCount) {
+			anomalies = append(anomalies, Anomaly{
+				WindowIndex: i,
+				Type:        "lock_contention",
+				Description: fmt.Sprintf("High lock contention detected: %d%% of samples", lockCount*100/window.SampleCount),
+				Severity:    "high",
+				Value:       float64(lockCount) / float64(window.SampleCount) * 100,
+			})
+		}
+	}
+	return anomalies
+}
+
+// highSyscallDetector flags windows spending most of their time in the kernel
+type highSyscallDetector struct {
+	pct float64
+}
+
+func (highSyscallDetector) Name() string { return "high_syscall" }
+
+func (d highSyscallDetector) Detect(windows []*TimeWindowData) []Anomaly {
+	anomalies := make([]Anomaly, 0)
+	for i, window := range windows {
+		syscallCount, exists := window.CategoryCounts["kernel_core"]
+		if exists && float64(syscallCount)*100 > d.pct*float64(window.SampleCount) {
+			anomalies = append(anomalies, Anomaly{
+				WindowIndex: i,
+				Type:        "high_syscall",
+				Description: fmt.Sprintf("High kernel/syscall activity: %.1f%%", window.KernelPercent),
+				Severity:    "medium",
+				Value:       window.KernelPercent,
+			})
+		}
+	}
+	return anomalies
+}
+
+// cpuSpikeDetector flags windows with significantly more samples than average
+type cpuSpikeDetector struct {
+	multiplier float64
+}
+
+func (cpuSpikeDetector) Name() string { return "cpu_spike" }
+
+func (d cpuSpikeDetector) Detect(windows []*TimeWindowData) []Anomaly {
+	anomalies := make([]Anomaly, 0)
+	if len(windows) == 0 {
+		return anomalies
+	}
+
+	var totalSamples int
+	for _, w := range windows {
+		totalSamples += w.SampleCount
+	}
+	avgSamples := float64(totalSamples) / float64(len(windows))
+
+	for i, window := range windows {
+		if float64(window.SampleCount) > avgSamples*d.multiplier {
+			anomalies = append(anomalies, Anomaly{
+				WindowIndex: i,
+				Type:        "cpu_spike",
+				Description: fmt.Sprintf("CPU usage spike: %d samples (avg: %.0f)", window.SampleCount, avgSamples),
+				Severity:    "medium",
+				Value:       float64(window.SampleCount),
+			})
+		}
+	}
+	return anomalies
+}
+
+// ratioShiftDetector flags kernel/userland ratio swings between adjacent windows
+type ratioShiftDetector struct {
+	delta float64
+}
+
+func (ratioShiftDetector) Name() string { return "ratio_shift" }
+
+func (d ratioShiftDetector) Detect(windows []*TimeWindowData) []Anomaly {
+	anomalies := make([]Anomaly, 0)
+	for i := 1; i < len(windows); i++ {
+		window := windows[i]
+		if windows[i-1].SampleCount == 0 || window.SampleCount == 0 {
+			continue
+		}
+
+		previous := windows[i-1].KernelPercent
+		if math.Abs(window.KernelPercent-previous) > d.delta {
+			direction := "userland to kernel"
+			if window.KernelPercent < previous {
+				direction = "kernel to userland"
+			}
+			anomalies = append(anomalies, Anomaly{
+				WindowIndex:   i,
+				Type:          "ratio_shift",
+				Description:   fmt.Sprintf("Execution shifted from %s: kernel %.1f%% -> %.1f%%", direction, previous, window.KernelPercent),
+				Severity:      "medium",
+				Value:         window.KernelPercent,
+				PreviousValue: &previous,
+			})
+		}
+	}
+	return anomalies
+}
+
+// MMPressureThreshold is the share of samples on the page-fault/page-table
+// path above which memory management is reported as a problem
+const MMPressureThreshold = 25.0
+
+// mmPressureDetector flags windows spending much of their time handling page
+// faults and walking page tables (common with large mmapped files)
+type mmPressureDetector struct{}
+
+func (mmPressureDetector) Name() string { return "mm_pressure" }
+
+func (mmPressureDetector) Detect(windows []*TimeWindowData) []Anomaly {
+	anomalies := make([]Anomaly, 0)
+	for i, window := range windows {
+		if window.MMPressurePercent > MMPressureThreshold {
+			anomalies = append(anomalies, Anomaly{
+				WindowIndex: i,
+				Type:        "mm_pressure",
+				Description: fmt.Sprintf("Page-fault/MM pressure: %.1f%% of samples in page-fault or page-table code", window.MMPressurePercent),
+				Severity:    "medium",
+				Value:       window.MMPressurePercent,
+			})
+		}
+	}
+	return anomalies
+}
+
+// SingleThreadBusyThreshold is the share of a window a thread must spend on
+// CPU to be considered saturated
+const SingleThreadBusyThreshold = 90.0
+
+// singleThreadDetector flags windows where one thread is saturated while the
+// process uses at most half of the host's cores: the work is serialized on
+// that thread (a global lock or a single-threaded hot loop) and adding cores
+// will not help
+type singleThreadDetector struct {
+	cores int
+}
+
+func (singleThreadDetector) Name() string { return "single_thread_bottleneck" }
+
+func (d singleThreadDetector) Detect(windows []*TimeWindowData) []Anomaly {
+	anomalies := make([]Anomaly, 0)
+	if d.cores < 2 {
+		return anomalies
+	}
+
+	for i, window := range windows {
+		if window.BusiestThreadPercent < SingleThreadBusyThreshold || window.CoresBusy > float64(d.cores)/2 {
+			continue
+		}
+		anomalies = append(anomalies, Anomaly{
+			WindowIndex: i,
+			Type:        "single_thread_bottleneck",
+			Description: fmt.Sprintf("Single-thread bottleneck: TID %d is %.0f%% busy while the process uses %.1f of %d cores; a global lock or single-threaded loop is likely limiting throughput",
+				window.BusiestThread, window.BusiestThreadPercent, window.CoresBusy, d.cores),
+			Severity: "high",
+			Value:    window.BusiestThreadPercent,
+		})
+	}
+	return anomalies
+}
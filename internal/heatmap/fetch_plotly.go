@@ -0,0 +1,77 @@
+//go:build ignore
+
+// fetch_plotly downloads the Plotly build embedded for --offline-assets and
+// keeps it only if its SHA-256 matches the checksum pinned next to it in
+// assets/<file>.sha256. Run through go generate (make assets).
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	if len(os.Args) != 3 {
+		fmt.Fprintln(os.Stderr, "usage: go run fetch_plotly.go <url> <path>")
+		os.Exit(2)
+	}
+	if err := fetch(os.Args[1], os.Args[2]); err != nil {
+		fmt.Fprintf(os.Stderr, "fetch_plotly: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// fetch downloads url into path, verified against path + ".sha256"
+func fetch(url, path string) error {
+	expected, err := pinnedSHA256(path + ".sha256")
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+
+	// Written next to path and only moved into place once verified
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, hash), resp.Body); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error downloading %s: %v", url, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != expected {
+		return fmt.Errorf("checksum mismatch for %s: SHA-256 is %s, expected %s", url, sum, expected)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// pinnedSHA256 reads the checksum from a file in sha256sum format
+func pinnedSHA256(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("no pinned checksum to verify the download: %v", err)
+	}
+	fields := strings.Fields(string(content))
+	if len(fields) == 0 || len(fields[0]) != sha256.Size*2 {
+		return "", fmt.Errorf("%s does not hold a SHA-256", path)
+	}
+	return strings.ToLower(fields[0]), nil
+}
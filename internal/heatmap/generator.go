@@ -4,9 +4,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"html/template"
+	"io"
+	"math"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sort"
+	"time"
 
 	"github.com/santiagolertora/blc-perf-analyzer/internal/parser"
 )
@@ -22,6 +26,13 @@ type HeatmapData struct {
 	ProcessName      string            `json:"process_name"`
 	PID              int               `json:"pid"`
 	CaptureTimestamp string            `json:"capture_timestamp"`
+	FunctionTypes    map[string]string `json:"function_types"` // Frame category of each function
+	HostCores        int               `json:"host_cores"`
+	SampleInterval   float64           `json:"sample_interval_seconds"` // Estimated, 0 when unknown
+	Markers          []*Marker         `json:"markers,omitempty"`
+	Event            string            `json:"event,omitempty"`              // Set when the capture recorded several events
+	Processes        []string          `json:"processes,omitempty"`          // Commands by total samples, only set with ByProcess
+	WeightedByPeriod bool              `json:"weighted_by_period,omitempty"` // Window counts are sums of sample periods
 }
 
 // TimeWindowData represents aggregated data for a time window
@@ -32,11 +43,17 @@ type TimeWindowData struct {
 	SampleCount        int                       `json:"sample_count"`
 	FunctionCounts     map[string]int            `json:"function_counts"`
 	ThreadCounts       map[int]int               `json:"thread_counts"`
+	ProcessCounts      map[string]int            `json:"process_counts,omitempty"` // Samples per command, only set with ByProcess
 	CategoryCounts     map[string]int            `json:"category_counts"`
 	TopFunction        string                    `json:"top_function"`
 	TopFunctionPercent float64                   `json:"top_function_percent"`
 	KernelPercent      float64                   `json:"kernel_percent"`
 	UserlandPercent    float64                   `json:"userland_percent"`
+	MMPressurePercent  float64                   `json:"mm_pressure_percent"` // Samples on the page-fault/page-table path
+	// Thread utilization, only set when the sampling interval could be estimated
+	BusiestThread        int     `json:"busiest_thread"`
+	BusiestThreadPercent float64 `json:"busiest_thread_percent"` // Share of the window the thread was on CPU
+	CoresBusy            float64 `json:"cores_busy"`             // Average number of cores the process kept busy
 }
 
 // PatternDetection contains detected patterns and anomalies
@@ -54,12 +71,82 @@ type Anomaly struct {
 	Description string  `json:"description"`
 	Severity    string  `json:"severity"`
 	Value       float64 `json:"value"`
+	// PreviousValue is the value in the preceding window, for anomalies
+	// that compare adjacent windows (ratio_shift)
+	PreviousValue *float64 `json:"previous_value,omitempty"`
 }
 
-// GenerateHeatmap creates a comprehensive heatmap analysis
-func GenerateHeatmap(samples []*parser.Sample, outputDir string, processName string, pid int, windowSize float64) error {
+// DefaultRatioShiftDelta is the kernel percentage swing between adjacent
+// windows reported as a ratio_shift anomaly
+const DefaultRatioShiftDelta = 50.0
+
+// HeatmapConfig contains the configuration for heatmap generation
+type HeatmapConfig struct {
+	OutputDir    string
+	ProcessName  string
+	PID          int
+	WindowSize   float64
+	CaptureStart time.Time // Wall-clock time of the first sample, used to place Markers
+	Markers      []*Marker
+	// RatioShiftDelta is the kernel percentage swing between adjacent
+	// windows flagged as ratio_shift (0 uses DefaultRatioShiftDelta)
+	RatioShiftDelta float64
+	// Thresholds are the sensitivities of the lock_contention, high_syscall
+	// and cpu_spike detectors (zero fields use the defaults)
+	Thresholds PatternThresholds
+	// HostCores is the number of cores the profiled service could use, for
+	// the single_thread_bottleneck detector (0 uses the local core count)
+	HostCores int
+	// Animate adds a chart that plays the function distribution window by
+	// window, with a slider to scrub through the capture
+	Animate bool
+	// QuietMode suppresses progress messages and warnings
+	QuietMode bool
+	// Progress is where progress messages and warnings go (nil: stdout)
+	Progress io.Writer
+	// Event names the perf event the samples belong to, shown in the title
+	// when a capture recorded several events
+	Event string
+	// ByProcess adds a chart of the samples per command (perf's comm), for
+	// system-wide captures where several processes compete for the CPU
+	ByProcess bool
+	// WeightByPeriod makes every sample count for its period instead of 1
+	// in the window counts, for events whose samples stand for unequal work
+	WeightByPeriod bool
+	// OfflineAssets inlines the embedded Plotly library into heatmap.html
+	// instead of loading it from the CDN, for hosts without network access
+	OfflineAssets bool
+	// Theme is the color theme of heatmap.html, ThemeDark or ThemeLight
+	// ("" uses ThemeDark)
+	Theme string
+
+	// CSV also writes the time windows to heatmap-data.csv
+	CSV bool
+}
+
+// logf prints a progress message or warning unless config.QuietMode is set
+func (config *HeatmapConfig) logf(format string, args ...interface{}) {
+	if config.QuietMode {
+		return
+	}
+	if config.Progress == nil {
+		fmt.Printf(format, args...)
+		return
+	}
+	fmt.Fprintf(config.Progress, format, args...)
+}
+
+// GenerateHeatmap creates a comprehensive heatmap analysis and returns the
+// patterns detected across its time windows
+func GenerateHeatmap(samples []*parser.Sample, config *HeatmapConfig) (*PatternDetection, error) {
 	if len(samples) == 0 {
-		return fmt.Errorf("no samples to analyze")
+		return nil, fmt.Errorf("no samples to analyze")
+	}
+	outputDir := config.OutputDir
+	span := sampleSpan(samples)
+	windowSize := fitWindowSize(config.WindowSize, span)
+	if windowSize != config.WindowSize {
+		config.logf("Warning: Heatmap window size %.2fs is larger than the %.2fs covered by samples; using %.3fs windows\n", config.WindowSize, span, windowSize)
 	}
 
 	// Partition samples into time windows
@@ -67,11 +154,15 @@ func GenerateHeatmap(samples []*parser.Sample, outputDir string, processName str
 	
 	// Extract unique functions and threads
 	functionsMap := make(map[string]bool)
+	functionTypes := make(map[string]string)
 	threadsMap := make(map[int]bool)
 	
 	for _, sample := range samples {
 		if frame := sample.GetTopFrame(); frame != nil {
 			functionsMap[frame.Symbol] = true
+			if _, exists := functionTypes[frame.Symbol]; !exists {
+				functionTypes[frame.Symbol] = string(frame.Type)
+			}
 		}
 		threadsMap[sample.TID] = true
 	}
@@ -89,6 +180,17 @@ func GenerateHeatmap(samples []*parser.Sample, outputDir string, processName str
 	}
 	sort.Ints(threads)
 	
+	hostCores := config.HostCores
+	if hostCores <= 0 {
+		hostCores = runtime.NumCPU()
+	}
+	sampleInterval := estimateSampleInterval(samples)
+	if config.WeightByPeriod {
+		// Thread utilization is derived from sample counts, which the
+		// weights replace
+		sampleInterval = 0
+	}
+	
 	// Calculate total duration
 	var totalDuration float64
 	if len(windows) > 0 {
@@ -102,28 +204,39 @@ func GenerateHeatmap(samples []*parser.Sample, outputDir string, processName str
 			WindowIndex:    i,
 			StartTime:      window.StartTime,
 			EndTime:        window.EndTime,
-			SampleCount:    len(window.Samples),
 			FunctionCounts: make(map[string]int),
 			ThreadCounts:   make(map[int]int),
 			CategoryCounts: make(map[string]int),
 		}
+		if config.ByProcess {
+			twd.ProcessCounts = make(map[string]int)
+		}
 		
 		// Count occurrences
-		var kernelCount, userlandCount int
+		var kernelCount, userlandCount, mmCount int
 		
 		for _, sample := range window.Samples {
+			weight := sample.Weight(config.WeightByPeriod)
+			twd.SampleCount += weight
+			
 			// Count by thread
-			twd.ThreadCounts[sample.TID]++
+			twd.ThreadCounts[sample.TID] += weight
+			if twd.ProcessCounts != nil {
+				twd.ProcessCounts[sample.Command] += weight
+			}
+			if sample.InMMFault() {
+				mmCount += weight
+			}
 			
 			// Count by function and category
 			if frame := sample.GetTopFrame(); frame != nil {
-				twd.FunctionCounts[frame.Symbol]++
-				twd.CategoryCounts[string(frame.Type)]++
+				twd.FunctionCounts[frame.Symbol] += weight
+				twd.CategoryCounts[string(frame.Type)] += weight
 				
 				if frame.IsKernel {
-					kernelCount++
+					kernelCount += weight
 				} else if frame.IsUserland {
-					userlandCount++
+					userlandCount += weight
 				}
 			}
 		}
@@ -132,6 +245,7 @@ func GenerateHeatmap(samples []*parser.Sample, outputDir string, processName str
 		if twd.SampleCount > 0 {
 			twd.KernelPercent = float64(kernelCount) / float64(twd.SampleCount) * 100
 			twd.UserlandPercent = float64(userlandCount) / float64(twd.SampleCount) * 100
+			twd.MMPressurePercent = float64(mmCount) / float64(twd.SampleCount) * 100
 			
 			// Find top function
 			maxCount := 0
@@ -143,117 +257,141 @@ func GenerateHeatmap(samples []*parser.Sample, outputDir string, processName str
 			}
 			twd.TopFunctionPercent = float64(maxCount) / float64(twd.SampleCount) * 100
 		}
+		fillThreadUtilization(twd, windowSize, sampleInterval)
 		
 		timeWindowsData[i] = twd
 	}
 	
 	// Create heatmap data structure
 	heatmapData := &HeatmapData{
-		TimeWindows:   timeWindowsData,
-		Functions:     functions,
-		Threads:       threads,
-		WindowSize:    windowSize,
-		TotalDuration: totalDuration,
-		TotalSamples:  len(samples),
-		ProcessName:   processName,
-		PID:           pid,
+		TimeWindows:      timeWindowsData,
+		Functions:        functions,
+		Threads:          threads,
+		WindowSize:       windowSize,
+		TotalDuration:    totalDuration,
+		TotalSamples:     len(samples),
+		ProcessName:      config.ProcessName,
+		Event:            config.Event,
+		PID:              config.PID,
+		FunctionTypes:    functionTypes,
+		HostCores:        hostCores,
+		SampleInterval:   sampleInterval,
+		WeightedByPeriod: config.WeightByPeriod,
+	}
+	if config.ByProcess {
+		heatmapData.Processes = rankProcesses(samples)
+	}
+	if !config.CaptureStart.IsZero() {
+		heatmapData.CaptureTimestamp = config.CaptureStart.Format(time.RFC3339Nano)
+	}
+
+	// Place wall-clock markers on the timeline
+	if len(config.Markers) > 0 {
+		heatmapData.Markers = resolveMarkers(config.Markers, config.CaptureStart, windowSize, totalDuration, config.logf)
 	}
 	
 	// Detect patterns
-	patterns := detectPatterns(timeWindowsData)
+	ratioShiftDelta := config.RatioShiftDelta
+	if ratioShiftDelta <= 0 {
+		ratioShiftDelta = DefaultRatioShiftDelta
+	}
+	patterns := detectPatterns(timeWindowsData, config.Thresholds, ratioShiftDelta, hostCores)
 	
 	// Generate HTML visualization
-	if err := generateHTMLHeatmap(heatmapData, patterns, outputDir); err != nil {
-		return fmt.Errorf("error generating HTML heatmap: %v", err)
+	if err := generateHTMLHeatmap(heatmapData, patterns, outputDir, config.Animate, config.OfflineAssets, config.Theme); err != nil {
+		return nil, fmt.Errorf("error generating HTML heatmap: %v", err)
 	}
+	config.logf("✓ Interactive heatmap saved to: %s\n", filepath.Join(outputDir, "heatmap.html"))
 	
 	// Save JSON data
 	jsonPath := filepath.Join(outputDir, "heatmap-data.json")
 	jsonData, err := json.MarshalIndent(heatmapData, "", "  ")
 	if err != nil {
-		return fmt.Errorf("error marshaling heatmap data: %v", err)
+		return nil, fmt.Errorf("error marshaling heatmap data: %v", err)
 	}
 	if err := os.WriteFile(jsonPath, jsonData, 0644); err != nil {
-		return fmt.Errorf("error writing heatmap JSON: %v", err)
+		return nil, fmt.Errorf("error writing heatmap JSON: %v", err)
+	}
+	if config.CSV {
+		csvPath := filepath.Join(outputDir, CSVFileName)
+		if err := ExportCSV(heatmapData, csvPath); err != nil {
+			return nil, err
+		}
+		config.logf("✓ Time windows saved to: %s\n", csvPath)
 	}
 	
 	// Save patterns JSON
 	patternsPath := filepath.Join(outputDir, "patterns.json")
 	patternsData, err := json.MarshalIndent(patterns, "", "  ")
 	if err != nil {
-		return fmt.Errorf("error marshaling patterns: %v", err)
+		return nil, fmt.Errorf("error marshaling patterns: %v", err)
 	}
 	if err := os.WriteFile(patternsPath, patternsData, 0644); err != nil {
-		return fmt.Errorf("error writing patterns JSON: %v", err)
+		return nil, fmt.Errorf("error writing patterns JSON: %v", err)
 	}
 	
-	return nil
+	return patterns, nil
 }
 
-// detectPatterns analyzes time windows to detect patterns
-func detectPatterns(windows []*TimeWindowData) *PatternDetection {
+// shortSpanWindows is the number of windows a capture is split into when the
+// requested window size does not fit in the time its samples cover
+const shortSpanWindows = 10
+
+// sampleSpan returns the time between the first and the last sample
+func sampleSpan(samples []*parser.Sample) float64 {
+	minTime, maxTime := samples[0].Timestamp, samples[0].Timestamp
+	for _, sample := range samples {
+		minTime = math.Min(minTime, sample.Timestamp)
+		maxTime = math.Max(maxTime, sample.Timestamp)
+	}
+	return maxTime - minTime
+}
+
+// fitWindowSize checks the requested window size against the time actually
+// covered by samples, which can be much shorter than the requested capture
+// duration (idle process, early exit). A window that would hold the whole
+// capture is shrunk so the heatmap still shows shortSpanWindows windows; the
+// caller warns about it.
+func fitWindowSize(windowSize, span float64) float64 {
+	if span <= 0 || windowSize <= span {
+		return windowSize
+	}
+	return span / shortSpanWindows
+}
+
+// detectPatterns runs the built-in and registered anomaly detectors over the
+// time windows. thresholds set the sensitivity of the lock, syscall and spike
+// detectors, ratioShiftDelta is the kernel percentage swing between adjacent
+// windows reported as ratio_shift and hostCores the core count used to spot
+// single-threaded bottlenecks.
+func detectPatterns(windows []*TimeWindowData, thresholds PatternThresholds, ratioShiftDelta float64, hostCores int) *PatternDetection {
 	patterns := &PatternDetection{
 		LockContentionWindows: make([]int, 0),
 		HighSyscallWindows:    make([]int, 0),
 		CPUSpikes:             make([]int, 0),
 		Anomalies:             make([]Anomaly, 0),
 	}
-	
-	// Calculate average samples per window
-	var totalSamples int
-	for _, w := range windows {
-		totalSamples += w.SampleCount
+
+	for _, detector := range detectors(thresholds, ratioShiftDelta, hostCores) {
+		patterns.Anomalies = append(patterns.Anomalies, detector.Detect(windows)...)
 	}
-	avgSamples := float64(totalSamples) / float64(len(windows))
-	
-	// Analyze each window
-	for i, window := range windows {
-		// Detect lock contention (high pthread/futex activity)
-		lockCount := 0
-		for fn, count := range window.FunctionCounts {
-			fnLower := fmt.Sprintf("%s", fn)
-			if containsAny(fnLower, []string{"pthread_mutex", "futex", "rwlock", "__lll_lock"}) {
-				lockCount += count
-			}
-		}
-		
-		if lockCount > window.SampleCount/2 { // More than 50% lock-related
-			patterns.LockContentionWindows = append(patterns.LockContentionWindows, i)
-			patterns.Anomalies = append(patterns.Anomalies, Anomaly{
-				WindowIndex: i,
-				Type:        "lock_contention",
-				Description: fmt.Sprintf("High lock contention detected: %d%% of samples", lockCount*100/window.SampleCount),
-				Severity:    "high",
-				Value:       float64(lockCount) / float64(window.SampleCount) * 100,
-			})
-		}
-		
-		// Detect high syscall activity
-		syscallCount, exists := window.CategoryCounts["kernel_core"]
-		if exists && syscallCount > window.SampleCount*70/100 { // More than 70% kernel
-			patterns.HighSyscallWindows = append(patterns.HighSyscallWindows, i)
-			patterns.Anomalies = append(patterns.Anomalies, Anomaly{
-				WindowIndex: i,
-				Type:        "high_syscall",
-				Description: fmt.Sprintf("High kernel/syscall activity: %.1f%%", window.KernelPercent),
-				Severity:    "medium",
-				Value:       window.KernelPercent,
-			})
-		}
-		
-		// Detect CPU spikes (sample count significantly above average)
-		if float64(window.SampleCount) > avgSamples*1.5 { // 50% above average
-			patterns.CPUSpikes = append(patterns.CPUSpikes, i)
-			patterns.Anomalies = append(patterns.Anomalies, Anomaly{
-				WindowIndex: i,
-				Type:        "cpu_spike",
-				Description: fmt.Sprintf("CPU usage spike: %d samples (avg: %.0f)", window.SampleCount, avgSamples),
-				Severity:    "medium",
-				Value:       float64(window.SampleCount),
-			})
+
+	// Report anomalies in timeline order, detectors in registration order within a window
+	sort.SliceStable(patterns.Anomalies, func(i, j int) bool {
+		return patterns.Anomalies[i].WindowIndex < patterns.Anomalies[j].WindowIndex
+	})
+
+	for _, anomaly := range patterns.Anomalies {
+		switch anomaly.Type {
+		case "lock_contention":
+			patterns.LockContentionWindows = append(patterns.LockContentionWindows, anomaly.WindowIndex)
+		case "high_syscall":
+			patterns.HighSyscallWindows = append(patterns.HighSyscallWindows, anomaly.WindowIndex)
+		case "cpu_spike":
+			patterns.CPUSpikes = append(patterns.CPUSpikes, anomaly.WindowIndex)
 		}
 	}
-	
+
 	return patterns
 }
 
@@ -284,34 +422,56 @@ func findSubstring(s, substr string) bool {
 	return false
 }
 
-// generateHTMLHeatmap creates an interactive HTML visualization
-func generateHTMLHeatmap(data *HeatmapData, patterns *PatternDetection, outputDir string) error {
+// generateHTMLHeatmap creates an interactive HTML visualization. With animate
+// an extra chart steps through the time windows as Plotly animation frames;
+// with offline Plotly is inlined instead of loaded from the CDN. theme names
+// the color theme ("" for ThemeDark).
+func generateHTMLHeatmap(data *HeatmapData, patterns *PatternDetection, outputDir string, animate, offline bool, theme string) error {
+	if theme == "" {
+		theme = ThemeDark
+	}
+	colors, ok := themes[theme]
+	if !ok {
+		return fmt.Errorf("unknown heatmap theme %q (use %s or %s)", theme, ThemeDark, ThemeLight)
+	}
+
 	htmlTemplate := `<!DOCTYPE html>
 <html lang="en">
 <head>
     <meta charset="UTF-8">
     <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>CPU Performance Heatmap - {{.ProcessName}}</title>
-    <script src="https://cdn.plot.ly/plotly-2.26.0.min.js"></script>
+    <title>CPU Performance Heatmap - {{if .Processes}}system-wide{{else}}{{.ProcessName}}{{end}}</title>
+    {{if .PlotlyJS}}<script>{{.PlotlyJS}}</script>{{else}}<script src="{{.PlotlyCDN}}"></script>{{end}}
     <style>
+        :root {
+            --background: {{.Theme.Background}};
+            --panel: {{.Theme.Panel}};
+            --inset: {{.Theme.Inset}};
+            --text: {{.Theme.Text}};
+            --muted: {{.Theme.Muted}};
+            --accent: {{.Theme.Accent}};
+            --alert: {{.Theme.Alert}};
+            --glow: {{.Theme.Glow}};
+            --title-glow: {{.Theme.TitleGlow}};
+        }
         * { margin: 0; padding: 0; box-sizing: border-box; }
         body {
             font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, Oxygen, Ubuntu, sans-serif;
-            background: #0f0f23;
-            color: #cccccc;
+            background: var(--background);
+            color: var(--text);
             padding: 20px;
         }
         .container { max-width: 1600px; margin: 0 auto; }
         h1 {
-            color: #00ff00;
+            color: var(--accent);
             text-align: center;
             margin-bottom: 10px;
             font-size: 2.5em;
-            text-shadow: 0 0 10px #00ff00;
+            text-shadow: var(--title-glow);
         }
         .subtitle {
             text-align: center;
-            color: #888;
+            color: var(--muted);
             margin-bottom: 30px;
             font-size: 1.1em;
         }
@@ -322,63 +482,63 @@ func generateHTMLHeatmap(data *HeatmapData, patterns *PatternDetection, outputDi
             margin-bottom: 30px;
         }
         .stat-card {
-            background: #1a1a2e;
-            border: 1px solid #00ff00;
+            background: var(--panel);
+            border: 1px solid var(--accent);
             border-radius: 8px;
             padding: 20px;
-            box-shadow: 0 0 20px rgba(0, 255, 0, 0.2);
+            box-shadow: 0 0 20px var(--glow);
         }
         .stat-label {
-            color: #888;
+            color: var(--muted);
             font-size: 0.9em;
             margin-bottom: 5px;
         }
         .stat-value {
-            color: #00ff00;
+            color: var(--accent);
             font-size: 2em;
             font-weight: bold;
         }
         .chart-container {
-            background: #1a1a2e;
-            border: 1px solid #00ff00;
+            background: var(--panel);
+            border: 1px solid var(--accent);
             border-radius: 8px;
             padding: 20px;
             margin-bottom: 30px;
-            box-shadow: 0 0 20px rgba(0, 255, 0, 0.2);
+            box-shadow: 0 0 20px var(--glow);
         }
         .chart-title {
-            color: #00ff00;
+            color: var(--accent);
             font-size: 1.5em;
             margin-bottom: 15px;
             text-align: center;
         }
         .anomalies {
-            background: #1a1a2e;
-            border: 1px solid #ff6b6b;
+            background: var(--panel);
+            border: 1px solid var(--alert);
             border-radius: 8px;
             padding: 20px;
             margin-top: 30px;
         }
         .anomaly-title {
-            color: #ff6b6b;
+            color: var(--alert);
             font-size: 1.5em;
             margin-bottom: 15px;
         }
         .anomaly-item {
-            background: #16213e;
-            border-left: 4px solid #ff6b6b;
+            background: var(--inset);
+            border-left: 4px solid var(--alert);
             padding: 15px;
             margin-bottom: 10px;
             border-radius: 4px;
         }
         .anomaly-type {
-            color: #ff6b6b;
+            color: var(--alert);
             font-weight: bold;
             text-transform: uppercase;
             font-size: 0.9em;
         }
         .anomaly-desc {
-            color: #cccccc;
+            color: var(--text);
             margin-top: 5px;
         }
         .severity-high { border-left-color: #ff0000; }
@@ -389,7 +549,7 @@ func generateHTMLHeatmap(data *HeatmapData, patterns *PatternDetection, outputDi
 <body>
     <div class="container">
         <h1>⚡ CPU Performance Heatmap</h1>
-        <div class="subtitle">Process: {{.ProcessName}} (PID: {{.PID}}) | Duration: {{printf "%.1f" .TotalDuration}}s | Window Size: {{printf "%.1f" .WindowSize}}s</div>
+        <div class="subtitle">Process: {{if .Processes}}all (system-wide){{else}}{{.ProcessName}} (PID: {{.PID}}){{end}} | Duration: {{printf "%.1f" .TotalDuration}}s | Window Size: {{printf "%.1f" .WindowSize}}s{{if .Event}} | Event: {{.Event}}{{end}}{{if .WeightedByPeriod}} | Weighted by period{{end}}</div>
         
         <div class="stats-grid">
             <div class="stat-card">
@@ -414,12 +574,26 @@ func generateHTMLHeatmap(data *HeatmapData, patterns *PatternDetection, outputDi
             <div class="chart-title">Function Activity Heatmap (Top 30 Functions over Time)</div>
             <div id="heatmap"></div>
         </div>
+        {{if .Animate}}
+
+        <div class="chart-container">
+            <div class="chart-title">Function Distribution Playback (Top 30 Functions, % of Window Samples)</div>
+            <div id="animated-chart"></div>
+        </div>
+        {{end}}
 
         <div class="chart-container">
             <div class="chart-title">Kernel vs Userland Distribution</div>
             <div id="kernel-userland-chart"></div>
         </div>
 
+        {{if .Processes}}
+        <div class="chart-container">
+            <div class="chart-title">Process Activity Over Time</div>
+            <div id="process-chart"></div>
+        </div>
+        {{end}}
+
         <div class="chart-container">
             <div class="chart-title">Thread Activity Over Time</div>
             <div id="thread-chart"></div>
@@ -446,9 +620,36 @@ func generateHTMLHeatmap(data *HeatmapData, patterns *PatternDetection, outputDi
     <script>
         const data = {{.DataJSON}};
         const patterns = {{.PatternsJSON}};
+        const theme = {{.PlotThemeJSON}};
 
-        // Prepare heatmap data - top 30 functions
-        function prepareHeatmapData() {
+        // Wall-clock markers as vertical lines; x is measured in windows
+        function markerLayout(offset) {
+            const markers = data.markers || [];
+            const position = m => m.offset_seconds / data.window_size_seconds + offset;
+            return {
+                shapes: markers.map(m => ({
+                    type: 'line',
+                    x0: position(m),
+                    x1: position(m),
+                    yref: 'paper',
+                    y0: 0,
+                    y1: 1,
+                    line: { color: theme.marker, width: 2, dash: 'dash' }
+                })),
+                annotations: markers.map(m => ({
+                    x: position(m),
+                    y: 1,
+                    yref: 'paper',
+                    yanchor: 'bottom',
+                    text: plotlyText(m.label + ' (' + m.clock + ')'),
+                    showarrow: false,
+                    font: { color: theme.marker }
+                }))
+            };
+        }
+
+        // Functions with the most samples across the whole capture
+        function topFunctions(limit) {
             const functionTotals = {};
             data.time_windows.forEach(window => {
                 for (const [fn, count] of Object.entries(window.function_counts || {})) {
@@ -456,10 +657,25 @@ func generateHTMLHeatmap(data *HeatmapData, patterns *PatternDetection, outputDi
                 }
             });
 
-            const sortedFunctions = Object.entries(functionTotals)
+            return Object.entries(functionTotals)
                 .sort((a, b) => b[1] - a[1])
-                .slice(0, 30)
+                .slice(0, limit)
                 .map(([fn]) => fn);
+        }
+
+        function shortName(fn) {
+            return fn.length > 50 ? fn.substring(0, 47) + "..." : fn;
+        }
+
+        // Plotly renders a subset of HTML in labels, so "<" in C++ template
+        // symbols (std::vector<int>) would be read as a tag; escape them
+        function plotlyText(text) {
+            return String(text).replace(/&/g, '&amp;').replace(/</g, '&lt;').replace(/>/g, '&gt;');
+        }
+
+        // Prepare heatmap data - top 30 functions
+        function prepareHeatmapData() {
+            const sortedFunctions = topFunctions(30);
 
             const zData = sortedFunctions.map(fn => {
                 return data.time_windows.map(window => window.function_counts[fn] || 0);
@@ -469,32 +685,103 @@ func generateHTMLHeatmap(data *HeatmapData, patterns *PatternDetection, outputDi
                 "W" + i + "<br>" + w.start_time.toFixed(1) + "s"
             );
 
+            // Per-cell hover details: window time, function category, share of the window
+            const firstStart = data.time_windows.length > 0 ? data.time_windows[0].start_time : 0;
+            const captureStart = data.capture_timestamp ? Date.parse(data.capture_timestamp) : NaN;
+            const windowTimes = data.time_windows.map(w => {
+                const offset = w.start_time - firstStart;
+                let label = '+' + offset.toFixed(1) + 's';
+                if (!isNaN(captureStart)) {
+                    label += ' (' + new Date(captureStart + offset * 1000).toLocaleTimeString() + ')';
+                }
+                return label;
+            });
+            const customData = sortedFunctions.map(fn => {
+                const category = plotlyText((data.function_types || {})[fn] || 'unknown');
+                return data.time_windows.map((window, i) => {
+                    const count = window.function_counts[fn] || 0;
+                    const share = window.sample_count > 0 ? count / window.sample_count * 100 : 0;
+                    return [windowTimes[i], category, share.toFixed(1)];
+                });
+            });
+
             return {
                 z: zData,
                 x: xLabels,
-                y: sortedFunctions.map(fn => fn.length > 50 ? fn.substring(0, 47) + "..." : fn),
+                y: sortedFunctions.map(fn => plotlyText(shortName(fn))),
                 type: 'heatmap',
-                colorscale: [
-                    [0, '#0f0f23'],
-                    [0.2, '#1a1a2e'],
-                    [0.4, '#16213e'],
-                    [0.6, '#0f4c75'],
-                    [0.8, '#3282b8'],
-                    [1, '#00ff00']
-                ],
-                hovertemplate: 'Function: %{y}<br>Window: %{x}<br>Samples: %{z}<extra></extra>'
+                colorscale: theme.colorscale,
+                customdata: customData,
+                hovertemplate: 'Function: %{y}<br>Category: %{customdata[1]}<br>Window: %{x}<br>' +
+                    'Time: %{customdata[0]}<br>Samples: %{z} (%{customdata[2]}% of window)<extra></extra>'
             };
         }
 
         // Plot function heatmap
         Plotly.newPlot('heatmap', [prepareHeatmapData()], {
-            paper_bgcolor: '#1a1a2e',
-            plot_bgcolor: '#1a1a2e',
-            font: { color: '#cccccc' },
-            xaxis: { title: 'Time Window', gridcolor: '#2a2a3e' },
-            yaxis: { title: 'Function', gridcolor: '#2a2a3e', automargin: true },
-            height: 800
+            paper_bgcolor: theme.background,
+            plot_bgcolor: theme.background,
+            font: { color: theme.text },
+            xaxis: { title: 'Time Window', gridcolor: theme.grid },
+            yaxis: { title: 'Function', gridcolor: theme.grid, automargin: true },
+            height: 800,
+            ...markerLayout(-0.5)
         }, {responsive: true});
+        {{if .Animate}}
+
+        // Animated function distribution: one frame per time window
+        (function() {
+            const animation = {{.AnimationJSON}};
+            const labels = animation.functions.map(fn => plotlyText(shortName(fn)));
+            const frames = animation.frames.map(f => ({
+                name: f.name,
+                data: [{ x: f.shares, y: labels }],
+                layout: { title: { text: f.title } }
+            }));
+            const maxShare = Math.max(1, ...frames.map(f => Math.max(...f.data[0].x)));
+            const frameArgs = duration => ({
+                mode: 'immediate',
+                frame: { duration: duration, redraw: true },
+                transition: { duration: Math.min(duration, 300) }
+            });
+
+            Plotly.newPlot('animated-chart', [{
+                x: frames[0].data[0].x,
+                y: labels,
+                type: 'bar',
+                orientation: 'h',
+                marker: { color: theme.accent },
+                hovertemplate: '%{y}: %{x:.1f}% of window<extra></extra>'
+            }], {
+                title: frames[0].layout.title,
+                paper_bgcolor: theme.background,
+                plot_bgcolor: theme.background,
+                font: { color: theme.text },
+                xaxis: { title: '% of Window Samples', range: [0, maxShare], gridcolor: theme.grid },
+                yaxis: { gridcolor: theme.grid, automargin: true },
+                height: 800,
+                updatemenus: [{
+                    type: 'buttons',
+                    showactive: false,
+                    x: 0,
+                    y: 0,
+                    xanchor: 'right',
+                    yanchor: 'top',
+                    pad: { t: 60, r: 10 },
+                    buttons: [
+                        { label: 'Play', method: 'animate', args: [null, { ...frameArgs(500), fromcurrent: true }] },
+                        { label: 'Pause', method: 'animate', args: [[null], frameArgs(0)] }
+                    ]
+                }],
+                sliders: [{
+                    active: 0,
+                    pad: { t: 50 },
+                    currentvalue: { prefix: 'Window: ' },
+                    steps: animation.steps.map(s => ({ label: s.label, method: 'animate', args: [[s.frame], frameArgs(300)] }))
+                }]
+            }, {responsive: true}).then(() => Plotly.addFrames('animated-chart', frames));
+        })();
+        {{end}}
 
         // Kernel vs Userland
         const kernelData = data.time_windows.map(w => w.kernel_percent);
@@ -508,7 +795,7 @@ func generateHTMLHeatmap(data *HeatmapData, patterns *PatternDetection, outputDi
                 name: 'Kernel',
                 type: 'scatter',
                 fill: 'tozeroy',
-                line: { color: '#ff6b6b' }
+                line: { color: theme.alert }
             },
             {
                 x: windowLabels,
@@ -516,17 +803,41 @@ func generateHTMLHeatmap(data *HeatmapData, patterns *PatternDetection, outputDi
                 name: 'Userland',
                 type: 'scatter',
                 fill: 'tozeroy',
-                line: { color: '#00ff00' }
+                line: { color: theme.accent }
             }
         ], {
-            paper_bgcolor: '#1a1a2e',
-            plot_bgcolor: '#1a1a2e',
-            font: { color: '#cccccc' },
-            xaxis: { title: 'Time Window', gridcolor: '#2a2a3e' },
-            yaxis: { title: 'Percentage %', gridcolor: '#2a2a3e' },
-            height: 400
+            paper_bgcolor: theme.background,
+            plot_bgcolor: theme.background,
+            font: { color: theme.text },
+            xaxis: { title: 'Time Window', gridcolor: theme.grid },
+            yaxis: { title: 'Percentage %', gridcolor: theme.grid },
+            height: 400,
+            ...markerLayout(0)
         }, {responsive: true});
 
+        // Process activity (system-wide captures)
+        if (data.processes) {
+            const processTraces = data.processes.slice(0, 10).map(command => {
+                return {
+                    x: windowLabels,
+                    y: data.time_windows.map(w => w.process_counts[command] || 0),
+                    name: plotlyText(command),
+                    type: 'scatter',
+                    mode: 'lines'
+                };
+            });
+
+            Plotly.newPlot('process-chart', processTraces, {
+                paper_bgcolor: theme.background,
+                plot_bgcolor: theme.background,
+                font: { color: theme.text },
+                xaxis: { title: 'Time Window', gridcolor: theme.grid },
+                yaxis: { title: 'Samples', gridcolor: theme.grid },
+                height: 400,
+                ...markerLayout(0)
+            }, {responsive: true});
+        }
+
         // Thread activity
         const threads = data.threads;
         const threadTraces = threads.slice(0, 10).map(tid => {
@@ -540,12 +851,13 @@ func generateHTMLHeatmap(data *HeatmapData, patterns *PatternDetection, outputDi
         });
 
         Plotly.newPlot('thread-chart', threadTraces, {
-            paper_bgcolor: '#1a1a2e',
-            plot_bgcolor: '#1a1a2e',
-            font: { color: '#cccccc' },
-            xaxis: { title: 'Time Window', gridcolor: '#2a2a3e' },
-            yaxis: { title: 'Samples', gridcolor: '#2a2a3e' },
-            height: 400
+            paper_bgcolor: theme.background,
+            plot_bgcolor: theme.background,
+            font: { color: theme.text },
+            xaxis: { title: 'Time Window', gridcolor: theme.grid },
+            yaxis: { title: 'Samples', gridcolor: theme.grid },
+            height: 400,
+            ...markerLayout(0)
         }, {responsive: true});
 
         // Samples per window
@@ -553,14 +865,15 @@ func generateHTMLHeatmap(data *HeatmapData, patterns *PatternDetection, outputDi
             x: windowLabels,
             y: data.time_windows.map(w => w.sample_count),
             type: 'bar',
-            marker: { color: '#00ff00' }
+            marker: { color: theme.accent }
         }], {
-            paper_bgcolor: '#1a1a2e',
-            plot_bgcolor: '#1a1a2e',
-            font: { color: '#cccccc' },
-            xaxis: { title: 'Time Window', gridcolor: '#2a2a3e' },
-            yaxis: { title: 'Sample Count', gridcolor: '#2a2a3e' },
-            height: 400
+            paper_bgcolor: theme.background,
+            plot_bgcolor: theme.background,
+            font: { color: theme.text },
+            xaxis: { title: 'Time Window', gridcolor: theme.grid },
+            yaxis: { title: 'Sample Count', gridcolor: theme.grid },
+            height: 400,
+            ...markerLayout(0)
         }, {responsive: true});
     </script>
 </body>
@@ -572,19 +885,55 @@ func generateHTMLHeatmap(data *HeatmapData, patterns *PatternDetection, outputDi
 	}
 
 	// Prepare data for template
-	dataJSON, _ := json.Marshal(data)
-	patternsJSON, _ := json.Marshal(patterns)
+	dataJSON, err := scriptJSON(data)
+	if err != nil {
+		return err
+	}
+	patternsJSON, err := scriptJSON(patterns)
+	if err != nil {
+		return err
+	}
+	plotThemeJSON, err := scriptJSON(colors.Plot)
+	if err != nil {
+		return err
+	}
+
+	var animationJSON template.JS
+	if animate {
+		if animationJSON, err = scriptJSON(buildAnimation(data.TimeWindows)); err != nil {
+			return err
+		}
+	}
+
+	var plotlyJS template.JS
+	if offline {
+		if plotlyJS, err = inlinePlotly(); err != nil {
+			return err
+		}
+	}
 
 	templateData := struct {
 		*HeatmapData
-		Anomalies    []Anomaly
-		DataJSON     template.JS
-		PatternsJSON template.JS
+		Anomalies     []Anomaly
+		DataJSON      template.JS
+		PatternsJSON  template.JS
+		Theme         *pageTheme
+		PlotThemeJSON template.JS
+		Animate       bool
+		AnimationJSON template.JS // Set with animate
+		PlotlyJS      template.JS // Set with offline
+		PlotlyCDN     string
 	}{
-		HeatmapData:  data,
-		Anomalies:    patterns.Anomalies,
-		DataJSON:     template.JS(dataJSON),
-		PatternsJSON: template.JS(patternsJSON),
+		HeatmapData:   data,
+		Anomalies:     patterns.Anomalies,
+		Animate:       animate,
+		AnimationJSON: animationJSON,
+		DataJSON:      dataJSON,
+		PatternsJSON:  patternsJSON,
+		Theme:         colors,
+		PlotThemeJSON: plotThemeJSON,
+		PlotlyJS:      plotlyJS,
+		PlotlyCDN:     plotlyCDN,
 	}
 
 	outputPath := filepath.Join(outputDir, "heatmap.html")
@@ -598,7 +947,18 @@ func generateHTMLHeatmap(data *HeatmapData, patterns *PatternDetection, outputDi
 		return fmt.Errorf("error executing template: %v", err)
 	}
 
-	fmt.Printf("✓ Interactive heatmap saved to: %s\n", outputPath)
 	return nil
 }
 
+// scriptJSON encodes v for inlining in a <script> element. json.Marshal
+// escapes <, > and & (so a symbol containing "</script>" can't close the
+// element), U+2028/U+2029 (line terminators in older JavaScript engines) and
+// replaces invalid UTF-8 with U+FFFD, which makes the result safe as template.JS.
+func scriptJSON(v interface{}) (template.JS, error) {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("error encoding heatmap data: %v", err)
+	}
+	return template.JS(encoded), nil
+}
+
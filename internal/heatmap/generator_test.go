@@ -1,9 +1,14 @@
 package heatmap
 
 import (
+	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/santiagolertora/blc-perf-analyzer/internal/parser"
 )
@@ -16,7 +21,7 @@ func TestGenerateHeatmap(t *testing.T) {
 	tempDir := t.TempDir()
 
 	// Generate heatmap
-	err := GenerateHeatmap(samples, tempDir, "test_process", 12345, 1.0)
+	_, err := GenerateHeatmap(samples, &HeatmapConfig{OutputDir: tempDir, ProcessName: "test_process", PID: 12345, WindowSize: 1.0})
 	if err != nil {
 		t.Fatalf("GenerateHeatmap failed: %v", err)
 	}
@@ -63,6 +68,123 @@ func TestGenerateHeatmap(t *testing.T) {
 	}
 }
 
+func TestGenerateHeatmapHoverData(t *testing.T) {
+	tempDir := t.TempDir()
+	start := time.Date(2024, 5, 1, 14, 32, 0, 0, time.UTC)
+
+	_, err := GenerateHeatmap(createTestSamples(), &HeatmapConfig{OutputDir: tempDir, WindowSize: 1.0, CaptureStart: start})
+	if err != nil {
+		t.Fatalf("GenerateHeatmap failed: %v", err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(tempDir, "heatmap-data.json"))
+	if err != nil {
+		t.Fatalf("Failed to read heatmap data: %v", err)
+	}
+	var data HeatmapData
+	if err := json.Unmarshal(content, &data); err != nil {
+		t.Fatalf("Failed to parse heatmap data: %v", err)
+	}
+
+	if data.CaptureTimestamp != "2024-05-01T14:32:00Z" {
+		t.Errorf("Expected capture timestamp 2024-05-01T14:32:00Z, got %q", data.CaptureTimestamp)
+	}
+	if got := data.FunctionTypes["pthread_mutex_lock"]; got != string(parser.FrameTypeLibPthread) {
+		t.Errorf("Expected pthread_mutex_lock category %s, got %q", parser.FrameTypeLibPthread, got)
+	}
+	if got := data.FunctionTypes["do_syscall_64"]; got != string(parser.FrameTypeKernelCore) {
+		t.Errorf("Expected do_syscall_64 category %s, got %q", parser.FrameTypeKernelCore, got)
+	}
+}
+
+func TestGenerateHeatmapByProcess(t *testing.T) {
+	samples := createTestSamples()
+	for i, sample := range samples {
+		sample.Command = []string{"nginx", "nginx", "mysqld"}[i%3]
+	}
+
+	tempDir := t.TempDir()
+	if _, err := GenerateHeatmap(samples, &HeatmapConfig{OutputDir: tempDir, WindowSize: 1.0, ByProcess: true}); err != nil {
+		t.Fatalf("GenerateHeatmap failed: %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(tempDir, "heatmap-data.json"))
+	if err != nil {
+		t.Fatalf("Failed to read heatmap data: %v", err)
+	}
+	var data HeatmapData
+	if err := json.Unmarshal(content, &data); err != nil {
+		t.Fatalf("Failed to decode heatmap data: %v", err)
+	}
+	if strings.Join(data.Processes, ",") != "nginx,mysqld" {
+		t.Errorf("Expected processes by samples, got %v", data.Processes)
+	}
+	total := 0
+	for _, window := range data.TimeWindows {
+		total += window.ProcessCounts["nginx"] + window.ProcessCounts["mysqld"]
+	}
+	if total != len(samples) {
+		t.Errorf("Expected every sample counted by process, got %d of %d", total, len(samples))
+	}
+
+	html, err := os.ReadFile(filepath.Join(tempDir, "heatmap.html"))
+	if err != nil {
+		t.Fatalf("Failed to read HTML file: %v", err)
+	}
+	if !strings.Contains(string(html), `id="process-chart"`) {
+		t.Error("Expected the process activity chart")
+	}
+}
+
+func TestGenerateHeatmapEscapesSymbols(t *testing.T) {
+	symbols := []string{
+		`std::vector<int>::push_back(int const&)`,
+		`evil</script><script>alert("x")</script>`,
+		`C:\\path\\to\\'quoted' "name"`,
+	}
+	samples := make([]*parser.Sample, 0, 30)
+	for i := 0; i < 30; i++ {
+		samples = append(samples, &parser.Sample{
+			PID:       1,
+			TID:       1,
+			Timestamp: 1000 + float64(i)*0.1,
+			Stack:     []parser.StackFrame{{Symbol: symbols[i%len(symbols)], Module: "/usr/bin/app", IsUserland: true}},
+		})
+	}
+
+	tempDir := t.TempDir()
+	if _, err := GenerateHeatmap(samples, &HeatmapConfig{OutputDir: tempDir, ProcessName: "<b>app</b>", WindowSize: 1.0}); err != nil {
+		t.Fatalf("GenerateHeatmap failed: %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(tempDir, "heatmap.html"))
+	if err != nil {
+		t.Fatalf("Failed to read HTML file: %v", err)
+	}
+	html := string(content)
+
+	for _, raw := range []string{"<script>alert", "</script><script>", "<b>app</b>", "vector<int>"} {
+		if strings.Contains(html, raw) {
+			t.Errorf("HTML contains unescaped %q", raw)
+		}
+	}
+
+	// The inlined data must still decode to the original symbols
+	start := strings.Index(html, "const data = ")
+	if start < 0 {
+		t.Fatal("Inlined heatmap data not found")
+	}
+	line := html[start+len("const data = "):]
+	line = line[:strings.Index(line, "\n")]
+	var data HeatmapData
+	if err := json.Unmarshal([]byte(strings.TrimSuffix(line, ";")), &data); err != nil {
+		t.Fatalf("Failed to decode inlined data: %v", err)
+	}
+	for _, symbol := range symbols {
+		if _, ok := data.FunctionTypes[symbol]; !ok {
+			t.Errorf("Symbol %q did not survive the round trip", symbol)
+		}
+	}
+}
+
 func TestDetectPatterns(t *testing.T) {
 	windows := []*TimeWindowData{
 		{
@@ -112,7 +234,8 @@ func TestDetectPatterns(t *testing.T) {
 		},
 	}
 
-	patterns := detectPatterns(windows)
+	thresholds := PatternThresholds{LockContentionPct: 50, SyscallPct: 70, SpikeMultiplier: 1.5}
+	patterns := detectPatterns(windows, thresholds, DefaultRatioShiftDelta, 1)
 
 	// Check lock contention detection
 	if len(patterns.LockContentionWindows) == 0 {
@@ -149,6 +272,14 @@ func TestDetectPatterns(t *testing.T) {
 	if !anomalyTypes["cpu_spike"] {
 		t.Error("Expected cpu_spike anomaly")
 	}
+
+	// Stricter thresholds than the windows above report none of them
+	strict := PatternThresholds{LockContentionPct: 60, SyscallPct: 80, SpikeMultiplier: 3}
+	patterns = detectPatterns(windows, strict, DefaultRatioShiftDelta, 1)
+	if len(patterns.LockContentionWindows) != 0 || len(patterns.HighSyscallWindows) != 0 || len(patterns.CPUSpikes) != 0 {
+		t.Errorf("Expected no lock, syscall or spike windows with strict thresholds, got %v %v %v",
+			patterns.LockContentionWindows, patterns.HighSyscallWindows, patterns.CPUSpikes)
+	}
 }
 
 func TestContainsAny(t *testing.T) {
@@ -222,7 +353,7 @@ func TestFindSubstring(t *testing.T) {
 
 func TestGenerateHeatmapEmptySamples(t *testing.T) {
 	tempDir := t.TempDir()
-	err := GenerateHeatmap([]*parser.Sample{}, tempDir, "test", 123, 1.0)
+	_, err := GenerateHeatmap([]*parser.Sample{}, &HeatmapConfig{OutputDir: tempDir, ProcessName: "test", PID: 123, WindowSize: 1.0})
 	if err == nil {
 		t.Error("Expected error when generating heatmap with empty samples")
 	}
@@ -354,7 +485,7 @@ func BenchmarkGenerateHeatmap(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_ = GenerateHeatmap(samples, tempDir, "test", 12345, 1.0)
+		_, _ = GenerateHeatmap(samples, &HeatmapConfig{OutputDir: tempDir, ProcessName: "test", PID: 12345, WindowSize: 1.0})
 	}
 }
 
@@ -382,7 +513,409 @@ func BenchmarkDetectPatterns(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_ = detectPatterns(windows)
+		_ = detectPatterns(windows, DefaultPatternThresholds(), DefaultRatioShiftDelta, 1)
+	}
+}
+
+func TestFitWindowSize(t *testing.T) {
+	if got := fitWindowSize(1.0, 30); got != 1.0 {
+		t.Errorf("Expected a window that fits to be kept, got %f", got)
+	}
+	if got := fitWindowSize(5.0, 2); got != 0.2 {
+		t.Errorf("Expected 2s of samples to be split into %d windows of 0.2s, got %f", shortSpanWindows, got)
+	}
+	if got := fitWindowSize(1.0, 0); got != 1.0 {
+		t.Errorf("Expected the window to be kept when all samples share a timestamp, got %f", got)
+	}
+
+	// The process went idle after 2s of a longer capture
+	samples := createTestSamples()[:20]
+	tempDir := t.TempDir()
+	if _, err := GenerateHeatmap(samples, &HeatmapConfig{OutputDir: tempDir, WindowSize: 5.0}); err != nil {
+		t.Fatalf("GenerateHeatmap failed: %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(tempDir, "heatmap-data.json"))
+	if err != nil {
+		t.Fatalf("Failed to read heatmap data: %v", err)
+	}
+	var data HeatmapData
+	if err := json.Unmarshal(content, &data); err != nil {
+		t.Fatalf("Failed to parse heatmap data: %v", err)
+	}
+	if len(data.TimeWindows) < 2 || data.WindowSize >= 5.0 {
+		t.Errorf("Expected the window size to be fitted to the samples, got %d windows of %.2fs", len(data.TimeWindows), data.WindowSize)
+	}
+}
+
+func TestParseMarker(t *testing.T) {
+	marker, err := ParseMarker("14:32:05=deploy v2")
+	if err != nil {
+		t.Fatalf("ParseMarker failed: %v", err)
+	}
+	if marker.Clock != "14:32:05" || marker.Label != "deploy v2" {
+		t.Errorf("Unexpected marker: %+v", marker)
+	}
+
+	invalid := []string{"14:32:05", "14:32=deploy", "25:00:00=x", "14:32:05="}
+	for _, spec := range invalid {
+		if _, err := ParseMarker(spec); err == nil {
+			t.Errorf("Expected error for marker %q", spec)
+		}
+	}
+}
+
+func TestResolveMarkers(t *testing.T) {
+	start := time.Date(2024, 5, 1, 14, 32, 0, 0, time.UTC)
+	inside, _ := ParseMarker("14:32:05=deploy")
+	outside, _ := ParseMarker("15:00:00=later")
+
+	resolved := resolveMarkers([]*Marker{inside, outside}, start, 2.0, 30.0, t.Logf)
+	if len(resolved) != 1 {
+		t.Fatalf("Expected 1 marker inside the capture, got %d", len(resolved))
+	}
+	if resolved[0].OffsetSeconds != 5 || resolved[0].WindowIndex != 2 {
+		t.Errorf("Unexpected placement: offset=%.1f window=%d", resolved[0].OffsetSeconds, resolved[0].WindowIndex)
+	}
+
+	// Capture spanning midnight
+	lateStart := time.Date(2024, 5, 1, 23, 59, 50, 0, time.UTC)
+	afterMidnight, _ := ParseMarker("00:00:05=rotate")
+	resolved = resolveMarkers([]*Marker{afterMidnight}, lateStart, 1.0, 60.0, t.Logf)
+	if len(resolved) != 1 || resolved[0].OffsetSeconds != 15 {
+		t.Errorf("Expected marker 15s after a pre-midnight start, got %+v", resolved)
+	}
+
+	// A marker at the very end of the capture is in the last window
+	atEnd, _ := ParseMarker("14:32:30=end")
+	resolved = resolveMarkers([]*Marker{atEnd}, start, 2.0, 30.0, t.Logf)
+	if len(resolved) != 1 || resolved[0].WindowIndex != 14 {
+		t.Errorf("Expected the marker at the end in window 14, got %+v", resolved)
+	}
+}
+
+func TestDetectRatioShift(t *testing.T) {
+	window := func(index int, kernelPercent float64) *TimeWindowData {
+		return &TimeWindowData{
+			WindowIndex:    index,
+			SampleCount:    100,
+			FunctionCounts: map[string]int{"normal_function": 100},
+			CategoryCounts: map[string]int{"application": 100},
+			KernelPercent:  kernelPercent,
+		}
+	}
+	windows := []*TimeWindowData{window(0, 10), window(1, 15), window(2, 75), window(3, 20)}
+
+	patterns := detectPatterns(windows, DefaultPatternThresholds(), 50, 1)
+
+	shifts := make([]Anomaly, 0)
+	for _, anomaly := range patterns.Anomalies {
+		if anomaly.Type == "ratio_shift" {
+			shifts = append(shifts, anomaly)
+		}
+	}
+	if len(shifts) != 2 {
+		t.Fatalf("Expected 2 ratio_shift anomalies, got %d", len(shifts))
+	}
+	if shifts[0].WindowIndex != 2 || shifts[0].Value != 75 || shifts[0].PreviousValue == nil || *shifts[0].PreviousValue != 15 {
+		t.Errorf("Unexpected first shift: %+v", shifts[0])
+	}
+	if shifts[1].WindowIndex != 3 || !contains(shifts[1].Description, "kernel to userland") {
+		t.Errorf("Unexpected second shift: %+v", shifts[1])
+	}
+
+	// A larger delta ignores the swings
+	patterns = detectPatterns(windows, DefaultPatternThresholds(), 70, 1)
+	for _, anomaly := range patterns.Anomalies {
+		if anomaly.Type == "ratio_shift" {
+			t.Errorf("Unexpected ratio_shift with delta 70: %+v", anomaly)
+		}
+	}
+}
+
+func TestDetectMMPressure(t *testing.T) {
+	windows := []*TimeWindowData{
+		{WindowIndex: 0, SampleCount: 100, MMPressurePercent: 5},
+		{WindowIndex: 1, SampleCount: 100, MMPressurePercent: 40},
+	}
+
+	anomalies := mmPressureDetector{}.Detect(windows)
+	if len(anomalies) != 1 || anomalies[0].WindowIndex != 1 || anomalies[0].Type != "mm_pressure" || anomalies[0].Value != 40 {
+		t.Errorf("Expected one mm_pressure anomaly in window 1, got %+v", anomalies)
+	}
+}
+
+// functionShareDetector flags windows where a function exceeds a share of samples
+type functionShareDetector struct {
+	function string
+	percent  float64
+}
+
+func (d functionShareDetector) Name() string { return "function_share" }
+
+func (d functionShareDetector) Detect(windows []*TimeWindowData) []Anomaly {
+	anomalies := make([]Anomaly, 0)
+	for i, window := range windows {
+		share := float64(window.FunctionCounts[d.function]) / float64(window.SampleCount) * 100
+		if share > d.percent {
+			anomalies = append(anomalies, Anomaly{WindowIndex: i, Type: "function_share", Value: share})
+		}
 	}
+	return anomalies
 }
 
+func TestDetectSingleThreadBottleneck(t *testing.T) {
+	// 1 thread sampled every 1ms for a whole 1s window, nothing else running
+	samples := make([]*parser.Sample, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		samples = append(samples, &parser.Sample{TID: 42, Timestamp: 100 + float64(i)*0.001})
+	}
+	interval := estimateSampleInterval(samples)
+	if interval < 0.00099 || interval > 0.00101 {
+		t.Fatalf("Expected a 1ms sampling interval, got %f", interval)
+	}
+
+	twd := &TimeWindowData{SampleCount: 1000, ThreadCounts: map[int]int{42: 1000}}
+	fillThreadUtilization(twd, 1.0, interval)
+	if twd.BusiestThread != 42 || twd.BusiestThreadPercent < 99 || twd.CoresBusy > 1.01 {
+		t.Fatalf("Unexpected utilization: %+v", twd)
+	}
+
+	anomalies := singleThreadDetector{cores: 16}.Detect([]*TimeWindowData{twd})
+	if len(anomalies) != 1 || anomalies[0].Type != "single_thread_bottleneck" {
+		t.Errorf("Expected a single_thread_bottleneck anomaly on 16 cores, got %+v", anomalies)
+	}
+	if anomalies := (singleThreadDetector{cores: 1}).Detect([]*TimeWindowData{twd}); len(anomalies) != 0 {
+		t.Errorf("Expected no anomaly on a single core host, got %+v", anomalies)
+	}
+
+	// All cores saturated is not a single-thread bottleneck
+	twd.CoresBusy = 12
+	if anomalies := (singleThreadDetector{cores: 16}).Detect([]*TimeWindowData{twd}); len(anomalies) != 0 {
+		t.Errorf("Expected no anomaly with 12 of 16 cores busy, got %+v", anomalies)
+	}
+}
+
+func TestGenerateHeatmapHostCores(t *testing.T) {
+	// 1 thread saturated for 2s: a bottleneck on a host with many cores,
+	// whatever the core count of the machine running the analysis
+	samples := make([]*parser.Sample, 0, 2000)
+	for i := 0; i < 2000; i++ {
+		samples = append(samples, &parser.Sample{TID: 42, Timestamp: 100 + float64(i)*0.001, Stack: []parser.StackFrame{{Symbol: "loop"}}})
+	}
+
+	for _, tt := range []struct {
+		cores  int
+		expect bool
+	}{
+		{runtime.NumCPU() + 16, true},
+		{1, false},
+	} {
+		patterns, err := GenerateHeatmap(samples, &HeatmapConfig{OutputDir: t.TempDir(), WindowSize: 1.0, HostCores: tt.cores, QuietMode: true})
+		if err != nil {
+			t.Fatalf("GenerateHeatmap failed: %v", err)
+		}
+		found := false
+		for _, anomaly := range patterns.Anomalies {
+			if anomaly.Type == "single_thread_bottleneck" {
+				found = true
+				if !strings.Contains(anomaly.Description, fmt.Sprintf("of %d cores", tt.cores)) {
+					t.Errorf("Expected the anomaly on %d cores, got %q", tt.cores, anomaly.Description)
+				}
+			}
+		}
+		if found != tt.expect {
+			t.Errorf("HostCores %d: single_thread_bottleneck found = %v, want %v", tt.cores, found, tt.expect)
+		}
+	}
+}
+
+func TestRegisterDetector(t *testing.T) {
+	defer func(saved []AnomalyDetector) { userDetectors = saved }(userDetectors)
+
+	RegisterDetector(functionShareDetector{function: "apply_raft_log", percent: 20})
+
+	windows := []*TimeWindowData{
+		{SampleCount: 100, FunctionCounts: map[string]int{"apply_raft_log": 10, "other": 90}},
+		{SampleCount: 100, FunctionCounts: map[string]int{"apply_raft_log": 30, "other": 70}},
+	}
+
+	patterns := detectPatterns(windows, DefaultPatternThresholds(), DefaultRatioShiftDelta, 1)
+
+	if len(patterns.Anomalies) != 1 {
+		t.Fatalf("Expected 1 anomaly, got %+v", patterns.Anomalies)
+	}
+	if patterns.Anomalies[0].Type != "function_share" || patterns.Anomalies[0].WindowIndex != 1 {
+		t.Errorf("Unexpected anomaly: %+v", patterns.Anomalies[0])
+	}
+}
+
+func TestGenerateHeatmapOfflineAssets(t *testing.T) {
+	stub := `var Plotly = {newPlot: function() {}}; /* </script> */`
+	original := readPlotly
+	readPlotly = func() ([]byte, error) { return []byte(stub), nil }
+	defer func() { readPlotly = original }()
+
+	tempDir := t.TempDir()
+	if _, err := GenerateHeatmap(createTestSamples(), &HeatmapConfig{OutputDir: tempDir, WindowSize: 1.0, OfflineAssets: true}); err != nil {
+		t.Fatalf("GenerateHeatmap failed: %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(tempDir, "heatmap.html"))
+	if err != nil {
+		t.Fatalf("Failed to read HTML file: %v", err)
+	}
+	html := string(content)
+
+	if !strings.Contains(html, "Plotly.newPlot") {
+		t.Error("HTML does not call Plotly.newPlot")
+	}
+	if strings.Contains(html, "cdn.plot.ly") {
+		t.Error("Offline HTML still references the CDN")
+	}
+	if !strings.Contains(html, `var Plotly = {newPlot: function() {}}; /* <\/script> */`) {
+		t.Error("Embedded library not inlined with its closing tags escaped")
+	}
+}
+
+func TestGenerateHeatmapOfflineAssetsMissing(t *testing.T) {
+	original := readPlotly
+	readPlotly = func() ([]byte, error) { return nil, os.ErrNotExist }
+	defer func() { readPlotly = original }()
+
+	if OfflineAssetsAvailable() {
+		t.Error("OfflineAssetsAvailable reported a missing asset as available")
+	}
+	if _, err := GenerateHeatmap(createTestSamples(), &HeatmapConfig{OutputDir: t.TempDir(), WindowSize: 1.0, OfflineAssets: true}); err == nil {
+		t.Error("Expected an error without the embedded library")
+	}
+}
+
+func TestGenerateHeatmapLoadsPlotlyFromCDN(t *testing.T) {
+	tempDir := t.TempDir()
+	if _, err := GenerateHeatmap(createTestSamples(), &HeatmapConfig{OutputDir: tempDir, WindowSize: 1.0}); err != nil {
+		t.Fatalf("GenerateHeatmap failed: %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(tempDir, "heatmap.html"))
+	if err != nil {
+		t.Fatalf("Failed to read HTML file: %v", err)
+	}
+	if !strings.Contains(string(content), `<script src="https://cdn.plot.ly/plotly-2.26.0.min.js"></script>`) {
+		t.Error("Default HTML does not load Plotly from the CDN")
+	}
+}
+
+func TestGenerateHeatmapLightTheme(t *testing.T) {
+	tempDir := t.TempDir()
+	if _, err := GenerateHeatmap(createTestSamples(), &HeatmapConfig{OutputDir: tempDir, WindowSize: 1.0, Theme: ThemeLight}); err != nil {
+		t.Fatalf("GenerateHeatmap failed: %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(tempDir, "heatmap.html"))
+	if err != nil {
+		t.Fatalf("Failed to read HTML file: %v", err)
+	}
+	html := string(content)
+
+	for _, want := range []string{"--background: #f5f5f5;", "--panel: #ffffff;", `"background":"#ffffff"`} {
+		if !strings.Contains(html, want) {
+			t.Errorf("Light theme HTML does not contain %q", want)
+		}
+	}
+	if strings.Contains(html, "#0f0f23") {
+		t.Error("Light theme HTML still uses the dark background")
+	}
+
+	if _, err := GenerateHeatmap(createTestSamples(), &HeatmapConfig{OutputDir: t.TempDir(), WindowSize: 1.0, Theme: "sepia"}); err == nil {
+		t.Error("Expected an error for an unknown theme")
+	}
+}
+
+func TestGenerateHeatmapAnimate(t *testing.T) {
+	tempDir := t.TempDir()
+	if _, err := GenerateHeatmap(createTestSamples(), &HeatmapConfig{OutputDir: tempDir, WindowSize: 1.0, Animate: true}); err != nil {
+		t.Fatalf("GenerateHeatmap failed: %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(tempDir, "heatmap.html"))
+	if err != nil {
+		t.Fatalf("Failed to read HTML file: %v", err)
+	}
+	html := string(content)
+	if !strings.Contains(html, `<div id="animated-chart"></div>`) {
+		t.Fatal("Animated HTML has no animated chart")
+	}
+
+	// The 10s of samples make 10 one-second windows, each a frame and a step
+	start := strings.Index(html, "const animation = ")
+	if start < 0 {
+		t.Fatal("Animated HTML has no animation data")
+	}
+	var anim animation
+	if err := json.NewDecoder(strings.NewReader(html[start+len("const animation = "):])).Decode(&anim); err != nil {
+		t.Fatalf("Invalid animation data: %v", err)
+	}
+	if len(anim.Frames) != 10 || len(anim.Steps) != 10 {
+		t.Fatalf("Expected 10 frames and slider steps, got %d and %d", len(anim.Frames), len(anim.Steps))
+	}
+	for i, step := range anim.Steps {
+		if step.Frame != anim.Frames[i].Name || step.Label != fmt.Sprintf("W%d", i) {
+			t.Errorf("Slider step %d %+v does not jump to frame %q", i, step, anim.Frames[i].Name)
+		}
+	}
+	if len(anim.Functions) != 5 || len(anim.Frames[0].Shares) != 5 {
+		t.Errorf("Expected a share of each of the 5 functions, got %v and %v", anim.Functions, anim.Frames[0].Shares)
+	}
+	if anim.Frames[3].Title != "Window 3 (+3.0s)" {
+		t.Errorf("Unexpected frame title %q", anim.Frames[3].Title)
+	}
+
+	if _, err := GenerateHeatmap(createTestSamples(), &HeatmapConfig{OutputDir: tempDir, WindowSize: 1.0}); err != nil {
+		t.Fatalf("GenerateHeatmap failed: %v", err)
+	}
+	if content, err = os.ReadFile(filepath.Join(tempDir, "heatmap.html")); err != nil || strings.Contains(string(content), "animated-chart") {
+		t.Errorf("Expected no animated chart without Animate (err %v)", err)
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	data := &HeatmapData{
+		TimeWindows: []*TimeWindowData{
+			{WindowIndex: 0, StartTime: 1.0, EndTime: 2.0, SampleCount: 4, FunctionCounts: map[string]int{"main": 3, "memcpy": 1},
+				TopFunction: "main", TopFunctionPercent: 75, KernelPercent: 25, UserlandPercent: 75},
+			{WindowIndex: 1, StartTime: 2.0, EndTime: 3.0, FunctionCounts: map[string]int{}},
+			{WindowIndex: 2, StartTime: 3.0, EndTime: 4.0, SampleCount: 2, FunctionCounts: map[string]int{"memcpy": 1, "a,b": 1},
+				TopFunction: "a,b", TopFunctionPercent: 50, UserlandPercent: 100},
+		},
+	}
+
+	path := filepath.Join(t.TempDir(), CSVFileName)
+	if err := ExportCSV(data, path); err != nil {
+		t.Fatalf("ExportCSV failed: %v", err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read CSV file: %v", err)
+	}
+
+	want := "window,start_time,end_time,sample_count,kernel_percent,userland_percent,top_function,top_function_percent,main,memcpy,\"a,b\"\n" +
+		"0,1.000000,2.000000,4,25.00,75.00,main,75.00,3,1,0\n" +
+		"1,2.000000,3.000000,0,0.00,0.00,,0.00,0,0,0\n" +
+		"2,3.000000,4.000000,2,0.00,100.00,\"a,b\",50.00,0,1,1\n"
+	if string(content) != want {
+		t.Errorf("Unexpected CSV:\n%s\nwant:\n%s", content, want)
+	}
+}
+
+func TestGenerateHeatmapCSV(t *testing.T) {
+	tempDir := t.TempDir()
+	if _, err := GenerateHeatmap(createTestSamples(), &HeatmapConfig{OutputDir: tempDir, WindowSize: 1.0}); err != nil {
+		t.Fatalf("GenerateHeatmap failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, CSVFileName)); !os.IsNotExist(err) {
+		t.Error("heatmap-data.csv written without CSV")
+	}
+
+	if _, err := GenerateHeatmap(createTestSamples(), &HeatmapConfig{OutputDir: tempDir, WindowSize: 1.0, CSV: true}); err != nil {
+		t.Fatalf("GenerateHeatmap failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, CSVFileName)); err != nil {
+		t.Errorf("heatmap-data.csv not written: %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package heatmap
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Marker is a wall-clock event (e.g. a deploy) drawn as a vertical line on
+// the time-series charts
+type Marker struct {
+	Clock         string  `json:"clock"` // HH:MM:SS as given by the user
+	Label         string  `json:"label"`
+	OffsetSeconds float64 `json:"offset_seconds"` // Relative to the first sample
+	WindowIndex   int     `json:"window_index"`
+	hour          int
+	minute        int
+	second        int
+}
+
+// ParseMarker parses a "HH:MM:SS=label" marker specification
+func ParseMarker(spec string) (*Marker, error) {
+	parts := strings.SplitN(spec, "=", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[1]) == "" {
+		return nil, fmt.Errorf("invalid marker %q (expected HH:MM:SS=label)", spec)
+	}
+
+	clock, err := time.Parse("15:04:05", strings.TrimSpace(parts[0]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid marker time %q (expected HH:MM:SS): %v", parts[0], err)
+	}
+
+	return &Marker{
+		Clock:  clock.Format("15:04:05"),
+		Label:  strings.TrimSpace(parts[1]),
+		hour:   clock.Hour(),
+		minute: clock.Minute(),
+		second: clock.Second(),
+	}, nil
+}
+
+// resolveMarkers maps marker clock times onto the capture timeline. A clock
+// time is taken on the day of captureStart, or the following day if that
+// places it before the capture (captures spanning midnight). Markers that
+// fall outside the captured time span are dropped with a warning, printed
+// with logf.
+func resolveMarkers(markers []*Marker, captureStart time.Time, windowSize, totalDuration float64, logf func(format string, args ...interface{})) []*Marker {
+	resolved := make([]*Marker, 0, len(markers))
+	if captureStart.IsZero() {
+		logf("Warning: Capture start time unknown, markers are not placed\n")
+		return resolved
+	}
+
+	for _, m := range markers {
+		at := time.Date(captureStart.Year(), captureStart.Month(), captureStart.Day(),
+			m.hour, m.minute, m.second, 0, captureStart.Location())
+		if at.Before(captureStart.Add(-12 * time.Hour)) {
+			at = at.Add(24 * time.Hour)
+		}
+
+		offset := at.Sub(captureStart).Seconds()
+		if offset < 0 || offset > totalDuration {
+			logf("Warning: Marker %s=%s is outside the captured time span\n", m.Clock, m.Label)
+			continue
+		}
+
+		placed := *m
+		placed.OffsetSeconds = offset
+		placed.WindowIndex = int(offset / windowSize)
+		// The last window is closed, so a marker at its very end belongs to it
+		if last := int(math.Round(totalDuration/windowSize)) - 1; placed.WindowIndex > last && last >= 0 {
+			placed.WindowIndex = last
+		}
+		resolved = append(resolved, &placed)
+	}
+
+	return resolved
+}
@@ -0,0 +1,28 @@
+package heatmap
+
+import (
+	"sort"
+
+	"github.com/santiagolertora/blc-perf-analyzer/internal/parser"
+)
+
+// rankProcesses returns the commands (perf's comm) of the samples, the one
+// with the most samples first
+func rankProcesses(samples []*parser.Sample) []string {
+	counts := make(map[string]int)
+	for _, sample := range samples {
+		counts[sample.Command]++
+	}
+
+	commands := make([]string, 0, len(counts))
+	for command := range counts {
+		commands = append(commands, command)
+	}
+	sort.Slice(commands, func(i, j int) bool {
+		if counts[commands[i]] != counts[commands[j]] {
+			return counts[commands[i]] > counts[commands[j]]
+		}
+		return commands[i] < commands[j]
+	})
+	return commands
+}
@@ -0,0 +1,98 @@
+package heatmap
+
+import "html/template"
+
+// Color themes of heatmap.html, selected with HeatmapConfig.Theme
+const (
+	ThemeDark  = "dark"  // Neon on black, the default
+	ThemeLight = "light" // Dark on white, for reports and printed PDFs
+)
+
+// pageTheme holds the colors of one theme. The page colors become CSS
+// variables; Plot is inlined as JSON for the Plotly layouts.
+type pageTheme struct {
+	Background template.CSS // Page background
+	Panel      template.CSS // Stat cards, charts and the anomaly list
+	Inset      template.CSS // Anomaly entries
+	Text       template.CSS
+	Muted      template.CSS // Labels and the subtitle
+	Accent     template.CSS // Titles, borders and stat values
+	Alert      template.CSS // Anomaly borders and titles
+	Glow       template.CSS // Card shadow
+	TitleGlow  template.CSS // Title text-shadow
+	Plot       plotTheme
+}
+
+// plotTheme holds the chart colors of a theme
+type plotTheme struct {
+	Background string           `json:"background"` // paper_bgcolor and plot_bgcolor
+	Text       string           `json:"text"`
+	Grid       string           `json:"grid"`
+	Accent     string           `json:"accent"` // Userland and sample count series
+	Alert      string           `json:"alert"`  // Kernel series
+	Marker     string           `json:"marker"` // --mark lines and labels
+	Colorscale [][2]interface{} `json:"colorscale"`
+}
+
+var themes = map[string]*pageTheme{
+	ThemeDark: {
+		Background: "#0f0f23",
+		Panel:      "#1a1a2e",
+		Inset:      "#16213e",
+		Text:       "#cccccc",
+		Muted:      "#888",
+		Accent:     "#00ff00",
+		Alert:      "#ff6b6b",
+		Glow:       "rgba(0, 255, 0, 0.2)",
+		TitleGlow:  "0 0 10px #00ff00",
+		Plot: plotTheme{
+			Background: "#1a1a2e",
+			Text:       "#cccccc",
+			Grid:       "#2a2a3e",
+			Accent:     "#00ff00",
+			Alert:      "#ff6b6b",
+			Marker:     "#ffaa00",
+			Colorscale: [][2]interface{}{
+				{0, "#0f0f23"},
+				{0.2, "#1a1a2e"},
+				{0.4, "#16213e"},
+				{0.6, "#0f4c75"},
+				{0.8, "#3282b8"},
+				{1, "#00ff00"},
+			},
+		},
+	},
+	ThemeLight: {
+		Background: "#f5f5f5",
+		Panel:      "#ffffff",
+		Inset:      "#fdf1f0",
+		Text:       "#222222",
+		Muted:      "#666",
+		Accent:     "#2e7d32",
+		Alert:      "#c62828",
+		Glow:       "rgba(0, 0, 0, 0.08)",
+		TitleGlow:  "none",
+		Plot: plotTheme{
+			Background: "#ffffff",
+			Text:       "#222222",
+			Grid:       "#e0e0e0",
+			Accent:     "#2e7d32",
+			Alert:      "#c62828",
+			Marker:     "#e65100",
+			Colorscale: [][2]interface{}{
+				{0, "#ffffff"},
+				{0.2, "#e8f5e9"},
+				{0.4, "#a5d6a7"},
+				{0.6, "#66bb6a"},
+				{0.8, "#2e7d32"},
+				{1, "#1b5e20"},
+			},
+		},
+	},
+}
+
+// ValidTheme reports whether name is a heatmap theme
+func ValidTheme(name string) bool {
+	_, ok := themes[name]
+	return ok
+}
@@ -0,0 +1,65 @@
+package heatmap
+
+import (
+	"sort"
+
+	"github.com/santiagolertora/blc-perf-analyzer/internal/parser"
+)
+
+// minIntervalGaps is the number of same-thread sample gaps needed before the
+// sampling interval estimate is trusted
+const minIntervalGaps = 20
+
+// estimateSampleInterval returns the time between two samples of a thread
+// that is running, in seconds, or 0 when there is too little data.
+//
+// perf samples a running thread once per period, so most gaps between
+// consecutive samples of the same thread equal the sampling interval; gaps
+// spanning a sleep are longer. The median gap is therefore a good estimate
+// that also holds when perf throttled the requested frequency.
+func estimateSampleInterval(samples []*parser.Sample) float64 {
+	if !sort.SliceIsSorted(samples, func(i, j int) bool { return samples[i].Timestamp < samples[j].Timestamp }) {
+		sorted := make([]*parser.Sample, len(samples))
+		copy(sorted, samples)
+		parser.SortByTimestamp(sorted)
+		samples = sorted
+	}
+
+	last := make(map[int]float64)
+	gaps := make([]float64, 0, len(samples))
+	for _, sample := range samples {
+		if previous, seen := last[sample.TID]; seen && sample.Timestamp > previous {
+			gaps = append(gaps, sample.Timestamp-previous)
+		}
+		last[sample.TID] = sample.Timestamp
+	}
+
+	if len(gaps) < minIntervalGaps {
+		return 0
+	}
+	sort.Float64s(gaps)
+	return gaps[len(gaps)/2]
+}
+
+// fillThreadUtilization sets the busiest thread and the number of cores in
+// use for a window, given the estimated sampling interval
+func fillThreadUtilization(twd *TimeWindowData, windowSize, sampleInterval float64) {
+	if sampleInterval <= 0 || windowSize <= 0 {
+		return
+	}
+
+	maxCount := 0
+	for tid, count := range twd.ThreadCounts {
+		if count > maxCount || (count == maxCount && tid < twd.BusiestThread) {
+			maxCount = count
+			twd.BusiestThread = tid
+		}
+	}
+
+	busy := float64(maxCount) * sampleInterval / windowSize * 100
+	if busy > 100 {
+		busy = 100
+	}
+	twd.BusiestThreadPercent = busy
+	twd.CoresBusy = float64(twd.SampleCount) * sampleInterval / windowSize
+}
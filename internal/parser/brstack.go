@@ -0,0 +1,58 @@
+package parser
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// BranchRecord represents a single LBR branch entry
+type BranchRecord struct {
+	From         string
+	To           string
+	Mispredicted bool
+	Predicted    bool
+	Cycles       int
+}
+
+// ParseBranchStack parses the output of `perf script -F brstack` or
+// `perf script -F brstacksym`.
+//
+// Each line holds the branch entries of one sample, separated by whitespace:
+//
+//	0x4005d0/0x400600/P/-/-/0  0x400612/0x4005c0/M/-/-/3
+//	main+0x20/compute+0x0/P/-/-/0  compute+0x32/main+0x25/M/-/-/3
+//
+// The third field is M (mispredicted), P (predicted) or - (unknown).
+// Tokens that are not branch entries are ignored.
+func ParseBranchStack(content string) ([]BranchRecord, error) {
+	records := make([]BranchRecord, 0)
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		for _, token := range strings.Fields(scanner.Text()) {
+			parts := strings.Split(token, "/")
+			if len(parts) < 6 || parts[0] == "" || parts[1] == "" {
+				continue
+			}
+
+			record := BranchRecord{
+				From:         parts[0],
+				To:           parts[1],
+				Mispredicted: parts[2] == "M",
+				Predicted:    parts[2] == "P",
+			}
+			record.Cycles, _ = strconv.Atoi(parts[len(parts)-1])
+
+			records = append(records, record)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error scanning branch stack output: %v", err)
+	}
+
+	return records, nil
+}
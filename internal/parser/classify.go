@@ -0,0 +1,307 @@
+package parser
+
+import (
+	"path"
+	"strings"
+	"sync"
+)
+
+// FrameType categorizes the frame
+type FrameType string
+
+const (
+	FrameTypeKernelCore   FrameType = "kernel_core"
+	FrameTypeKernelDriver FrameType = "kernel_driver"
+	FrameTypeLibC         FrameType = "libc"
+	FrameTypeLibPthread   FrameType = "libpthread"
+	FrameTypeLibMySQL     FrameType = "libmysql"
+	FrameTypeJIT          FrameType = "jit"
+	FrameTypeGoRuntime    FrameType = "go_runtime"
+	FrameTypeAllocator    FrameType = "allocator"
+	FrameTypeApplication  FrameType = "application"
+	FrameTypeGuest        FrameType = "guest"
+	FrameTypeUnknown      FrameType = "unknown"
+)
+
+// Classification is the category assigned to a stack frame
+type Classification struct {
+	Type     FrameType
+	Kernel   bool
+	Userland bool
+}
+
+// Classifier claims a frame by returning its classification and true.
+// module and symbol are the frame's Module and Symbol in lower case.
+type Classifier func(frame *StackFrame, module, symbol string) (Classification, bool)
+
+var (
+	registryMu sync.RWMutex
+
+	// userClassifiers run in registration order before the built-in ones
+	userClassifiers []Classifier
+
+	// frameTypes lists every known frame type, built-ins first
+	frameTypes = []FrameType{
+		FrameTypeKernelCore,
+		FrameTypeKernelDriver,
+		FrameTypeLibC,
+		FrameTypeLibPthread,
+		FrameTypeLibMySQL,
+		FrameTypeJIT,
+		FrameTypeGoRuntime,
+		FrameTypeAllocator,
+		FrameTypeApplication,
+		FrameTypeGuest,
+		FrameTypeUnknown,
+	}
+)
+
+// builtinClassifiers are tried in order; the first one to claim a frame wins
+var builtinClassifiers = []Classifier{
+	classifyGuest,
+	classifyKernelCore,
+	classifyKernelDriver,
+	classifyJIT,
+	classifyGoRuntime,
+	classifyAllocator,
+	classifyLibC,
+	classifyLibPthread,
+	classifyLibMySQL,
+	classifyApplication,
+	classifySharedLibrary,
+}
+
+// RegisterClassifier adds a classifier that is consulted before the built-in
+// ones, so it can claim frames the defaults would otherwise categorize.
+// types lists the frame types the classifier may return so that they are
+// reported by FrameTypes.
+func RegisterClassifier(c Classifier, types ...FrameType) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	userClassifiers = append(userClassifiers, c)
+	for _, t := range types {
+		known := false
+		for _, existing := range frameTypes {
+			if existing == t {
+				known = true
+				break
+			}
+		}
+		if !known {
+			frameTypes = append(frameTypes, t)
+		}
+	}
+}
+
+// FrameTypes returns every known frame type
+func FrameTypes() []FrameType {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	types := make([]FrameType, len(frameTypes))
+	copy(types, frameTypes)
+	return types
+}
+
+// ClassifyFrame determines the type and category of a stack frame
+func ClassifyFrame(frame *StackFrame) (FrameType, bool, bool) {
+	module := strings.ToLower(frame.Module)
+	symbol := strings.ToLower(frame.Symbol)
+
+	registryMu.RLock()
+	for _, classify := range userClassifiers {
+		if c, ok := classify(frame, module, symbol); ok {
+			registryMu.RUnlock()
+			return c.Type, c.Kernel, c.Userland
+		}
+	}
+	registryMu.RUnlock()
+
+	for _, classify := range builtinClassifiers {
+		if c, ok := classify(frame, module, symbol); ok {
+			return c.Type, c.Kernel, c.Userland
+		}
+	}
+
+	return FrameTypeUnknown, false, false
+}
+
+// classifyGuest matches frames from a KVM guest, which perf kvm reports in
+// [guest.kernel.kallsyms] and other [guest.*] maps. Guest kernel frames are
+// still flagged as kernel.
+func classifyGuest(frame *StackFrame, module, symbol string) (Classification, bool) {
+	if strings.HasPrefix(module, "[guest") {
+		kernel := strings.Contains(module, "kernel")
+		return Classification{Type: FrameTypeGuest, Kernel: kernel, Userland: !kernel}, true
+	}
+	return Classification{}, false
+}
+
+// classifyKernelCore matches the core kernel image
+func classifyKernelCore(frame *StackFrame, module, symbol string) (Classification, bool) {
+	if strings.Contains(module, "kernel.kallsyms") ||
+		strings.Contains(module, "[kernel") ||
+		strings.Contains(module, "vmlinux") {
+		return Classification{Type: FrameTypeKernelCore, Kernel: true}, true
+	}
+	return Classification{}, false
+}
+
+// processMaps are the bracketed names perf gives to mappings of the process
+// itself, which are not kernel modules
+var processMaps = map[string]bool{
+	"[unknown]":  true,
+	"[vdso]":     true,
+	"[vsyscall]": true,
+	"[heap]":     true,
+	"[stack]":    true,
+	"[anon]":     true,
+	"[uprobes]":  true,
+}
+
+// classifyKernelDriver matches kernel modules/drivers, reported as [module].
+// The module name stays in frame.Module, see StackFrame.KernelModule.
+func classifyKernelDriver(frame *StackFrame, module, symbol string) (Classification, bool) {
+	if strings.HasPrefix(module, "[") && strings.HasSuffix(module, "]") && !processMaps[module] {
+		return Classification{Type: FrameTypeKernelDriver, Kernel: true}, true
+	}
+	return Classification{}, false
+}
+
+// classifyJIT matches code compiled at run time by a JIT (JVM, Node.js,
+// .NET), which perf resolves through the runtime's /tmp/perf-<pid>.map, or
+// through jitted-<pid>-<n>.so after perf inject --jit. It runs before the
+// symbol-based classifiers: a Java method named after MySQL is still Java.
+func classifyJIT(frame *StackFrame, module, symbol string) (Classification, bool) {
+	base := path.Base(module)
+	if (strings.HasPrefix(base, "perf-") && strings.HasSuffix(base, ".map")) ||
+		strings.HasPrefix(base, "jitted-") {
+		return Classification{Type: FrameTypeJIT, Userland: true}, true
+	}
+	return Classification{}, false
+}
+
+// goRuntimePrefixes are the packages of the Go runtime: memory allocation,
+// garbage collection and the scheduler
+var goRuntimePrefixes = []string{"runtime.", "runtime/internal/", "internal/runtime/"}
+
+// classifyGoRuntime matches the Go runtime, e.g. runtime.mallocgc or
+// runtime.gcBgMarkWorker. Runtime assembly without a package is told apart
+// once the whole capture is parsed, see classifyGoAssembly.
+func classifyGoRuntime(frame *StackFrame, module, symbol string) (Classification, bool) {
+	for _, prefix := range goRuntimePrefixes {
+		if strings.HasPrefix(symbol, prefix) {
+			return Classification{Type: FrameTypeGoRuntime, Userland: true}, true
+		}
+	}
+	return Classification{}, false
+}
+
+// classifyGoAssembly marks the symbols without a package (gcWriteBarrier,
+// memeqbody, morestack) of Go binaries as Go runtime assembly. A binary is
+// taken for Go when runtime functions were sampled in it, rather than by
+// reading it on this host, where a capture recorded elsewhere may find a
+// different file or none at all.
+func classifyGoAssembly(samples []*Sample) {
+	goModules := make(map[string]bool)
+	for _, sample := range samples {
+		for _, frame := range sample.Stack {
+			if frame.Type == FrameTypeGoRuntime && frame.Module != "" {
+				goModules[frame.Module] = true
+			}
+		}
+	}
+	if len(goModules) == 0 {
+		return
+	}
+
+	for _, sample := range samples {
+		for i := range sample.Stack {
+			frame := &sample.Stack[i]
+			if frame.Type == FrameTypeApplication && goModules[frame.Module] &&
+				frame.Symbol != "" && !strings.ContainsAny(frame.Symbol, ".[(") {
+				frame.Type = FrameTypeGoRuntime
+			}
+		}
+	}
+}
+
+// allocatorMarkers identify memory allocator libraries and entry points in a
+// module or symbol
+var allocatorMarkers = []string{"jemalloc", "tcmalloc", "je_malloc", "tc_malloc", "operator new", "operator delete"}
+
+// allocatorSymbols are the C allocation functions, matched exactly so that
+// unrelated symbols containing e.g. "free" stay where they are, together
+// with the glibc internals they spend their time in
+var allocatorSymbols = map[string]bool{
+	"malloc": true, "calloc": true, "realloc": true, "reallocarray": true,
+	"free": true, "cfree": true, "posix_memalign": true, "aligned_alloc": true,
+	"memalign": true, "valloc": true, "pvalloc": true, "malloc_usable_size": true,
+	"__libc_malloc": true, "__libc_calloc": true, "__libc_realloc": true,
+	"__libc_free": true, "__libc_memalign": true, "__posix_memalign": true,
+	"_int_malloc": true, "_int_free": true, "_int_realloc": true,
+	"_int_memalign": true, "malloc_consolidate": true, "sysmalloc": true,
+	"tcache_init": true,
+}
+
+// classifyAllocator matches memory allocators (jemalloc, tcmalloc) and the
+// C++ and C allocation entry points, so allocator pressure is its own bucket
+// instead of being split between libc and unknown shared libraries
+func classifyAllocator(frame *StackFrame, module, symbol string) (Classification, bool) {
+	for _, marker := range allocatorMarkers {
+		if strings.Contains(module, marker) || strings.Contains(symbol, marker) {
+			return Classification{Type: FrameTypeAllocator, Userland: true}, true
+		}
+	}
+	if allocatorSymbols[symbol] {
+		return Classification{Type: FrameTypeAllocator, Userland: true}, true
+	}
+	return Classification{}, false
+}
+
+// classifyLibC matches the C library
+func classifyLibC(frame *StackFrame, module, symbol string) (Classification, bool) {
+	if strings.Contains(module, "libc") &&
+		(strings.Contains(module, ".so") || strings.Contains(module, "libc-")) {
+		return Classification{Type: FrameTypeLibC, Userland: true}, true
+	}
+	return Classification{}, false
+}
+
+// classifyLibPthread matches the POSIX threads library
+func classifyLibPthread(frame *StackFrame, module, symbol string) (Classification, bool) {
+	if strings.Contains(module, "libpthread") {
+		return Classification{Type: FrameTypeLibPthread, Userland: true}, true
+	}
+	return Classification{}, false
+}
+
+// classifyLibMySQL matches MySQL/MariaDB libraries and symbols
+func classifyLibMySQL(frame *StackFrame, module, symbol string) (Classification, bool) {
+	if strings.Contains(module, "mysql") ||
+		strings.Contains(module, "mariadb") ||
+		strings.Contains(symbol, "mysql") ||
+		strings.Contains(symbol, "maria") {
+		return Classification{Type: FrameTypeLibMySQL, Userland: true}, true
+	}
+	return Classification{}, false
+}
+
+// classifyApplication matches the application binary (not a shared library).
+// Frames without a module are left unknown.
+func classifyApplication(frame *StackFrame, module, symbol string) (Classification, bool) {
+	if module != "" && !strings.Contains(module, ".so") && !strings.HasPrefix(module, "[") {
+		return Classification{Type: FrameTypeApplication, Userland: true}, true
+	}
+	return Classification{}, false
+}
+
+// classifySharedLibrary matches any other shared library, and the vDSO the
+// kernel maps into every process, as userland unknown
+func classifySharedLibrary(frame *StackFrame, module, symbol string) (Classification, bool) {
+	if strings.Contains(module, ".so") || module == "[vdso]" || module == "[vsyscall]" {
+		return Classification{Type: FrameTypeUnknown, Userland: true}, true
+	}
+	return Classification{}, false
+}
@@ -0,0 +1,23 @@
+package parser
+
+// FilterCPUs keeps only the samples taken on one of cpus and returns them
+// together with the number of samples removed. Samples parsed from output
+// without a CPU column are attributed to CPU 0.
+func FilterCPUs(samples []*Sample, cpus []int) ([]*Sample, int) {
+	if len(cpus) == 0 {
+		return samples, 0
+	}
+
+	wanted := make(map[int]bool, len(cpus))
+	for _, cpu := range cpus {
+		wanted[cpu] = true
+	}
+
+	kept := make([]*Sample, 0, len(samples))
+	for _, sample := range samples {
+		if wanted[sample.CPU] {
+			kept = append(kept, sample)
+		}
+	}
+	return kept, len(samples) - len(kept)
+}
@@ -0,0 +1,92 @@
+package parser
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// cxxfilt is the demangler used for C++ symbols. It is a variable so that
+// tests can simulate a host without binutils.
+var cxxfilt = "c++filt"
+
+// isMangled reports whether symbol is an Itanium C++ ABI mangled name, the
+// scheme GCC and Clang use for ScyllaDB, MariaDB and most C++ code on Linux
+func isMangled(symbol string) bool {
+	return strings.HasPrefix(symbol, "_Z")
+}
+
+// Demangle returns the readable form of a mangled C++ symbol, e.g.
+// seastar::reactor::run() for _ZN7seastar7reactor3runEv. Other symbols, and
+// every symbol when c++filt is not installed, are returned unchanged.
+func Demangle(symbol string) string {
+	return demangleAll([]string{symbol})[symbol]
+}
+
+// DemangleSamples replaces the mangled C++ symbols of every frame with their
+// readable form, keeping the original in RawSymbol. perf script demangles on
+// its own when it is built with C++ support; this covers the builds and dumps
+// that are not. All the distinct symbols go through a single c++filt run.
+func DemangleSamples(samples []*Sample) {
+	var symbols []string
+	seen := make(map[string]bool)
+	for _, sample := range samples {
+		for _, frame := range sample.Stack {
+			if isMangled(frame.Symbol) && !seen[frame.Symbol] {
+				seen[frame.Symbol] = true
+				symbols = append(symbols, frame.Symbol)
+			}
+		}
+	}
+	if len(symbols) == 0 {
+		return
+	}
+
+	demangled := demangleAll(symbols)
+	for _, sample := range samples {
+		for i := range sample.Stack {
+			frame := &sample.Stack[i]
+			if readable, ok := demangled[frame.Symbol]; ok && readable != frame.Symbol {
+				frame.RawSymbol = frame.Symbol
+				frame.Symbol = readable
+			}
+		}
+	}
+}
+
+// demangleAll maps every symbol to its readable form. Symbols that are not
+// mangled or that c++filt can't decode map to themselves, and so does every
+// symbol when c++filt is missing or fails.
+func demangleAll(symbols []string) map[string]string {
+	result := make(map[string]string, len(symbols))
+	var mangled []string
+	for _, symbol := range symbols {
+		result[symbol] = symbol
+		// c++filt reads one name per line
+		if isMangled(symbol) && !strings.ContainsAny(symbol, " \t\n") {
+			mangled = append(mangled, symbol)
+		}
+	}
+	if len(mangled) == 0 {
+		return result
+	}
+
+	path, err := exec.LookPath(cxxfilt)
+	if err != nil {
+		return result
+	}
+	cmd := exec.Command(path)
+	cmd.Stdin = strings.NewReader(strings.Join(mangled, "\n") + "\n")
+	output, err := cmd.Output()
+	if err != nil {
+		return result
+	}
+
+	lines := strings.Split(strings.TrimRight(string(output), "\n"), "\n")
+	if len(lines) != len(mangled) {
+		return result
+	}
+	for i, symbol := range mangled {
+		result[symbol] = validName(lines[i])
+	}
+	return result
+}
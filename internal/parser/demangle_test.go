@@ -0,0 +1,75 @@
+package parser
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func TestDemangle(t *testing.T) {
+	if _, err := exec.LookPath("c++filt"); err != nil {
+		t.Skip("c++filt not installed")
+	}
+
+	tests := []struct {
+		symbol string
+		want   string
+	}{
+		{"_ZN7seastar7reactor3runEv", "seastar::reactor::run()"},
+		{"_ZN7seastar7reactor14run_some_tasksEv", "seastar::reactor::run_some_tasks()"},
+		{"_Z16dispatch_command19enum_server_commandP3THDPcjb", "dispatch_command(enum_server_command, THD*, char*, unsigned int, bool)"},
+		{"do_syscall_64", "do_syscall_64"},
+		{"[unknown]", "[unknown]"},
+	}
+	for _, tt := range tests {
+		if got := Demangle(tt.symbol); got != tt.want {
+			t.Errorf("Demangle(%q) = %q, want %q", tt.symbol, got, tt.want)
+		}
+	}
+}
+
+func TestParsePerfScriptDemangles(t *testing.T) {
+	if _, err := exec.LookPath("c++filt"); err != nil {
+		t.Skip("c++filt not installed")
+	}
+
+	input := `reactor-0 3202/3202 [000] 88019.498348:     124999 cycles:P:
+	    55555560abcd _ZN7seastar7reactor14run_some_tasksEv+0x1c (/opt/scylladb/libexec/scylla)
+	    55555560deed _ZN7seastar7reactor3runEv+0x2a (/opt/scylladb/libexec/scylla)
+	    55555560beef main+0x10 (/opt/scylladb/libexec/scylla)
+`
+	samples, err := ParsePerfScript(input)
+	if err != nil {
+		t.Fatalf("ParsePerfScript failed: %v", err)
+	}
+	if len(samples) != 1 || len(samples[0].Stack) != 3 {
+		t.Fatalf("expected 1 sample with 3 frames, got %d samples", len(samples))
+	}
+
+	stack := samples[0].Stack
+	if stack[0].Symbol != "seastar::reactor::run_some_tasks()" || stack[0].RawSymbol != "_ZN7seastar7reactor14run_some_tasksEv" {
+		t.Errorf("frame 0 = %q (raw %q)", stack[0].Symbol, stack[0].RawSymbol)
+	}
+	if stack[1].Symbol != "seastar::reactor::run()" {
+		t.Errorf("frame 1 = %q, want seastar::reactor::run()", stack[1].Symbol)
+	}
+	if stack[2].Symbol != "main" || stack[2].RawSymbol != "" {
+		t.Errorf("frame 2 = %q (raw %q), want main with no raw symbol", stack[2].Symbol, stack[2].RawSymbol)
+	}
+}
+
+func TestDemangleWithoutCxxfilt(t *testing.T) {
+	original := cxxfilt
+	cxxfilt = "c++filt-not-installed"
+	defer func() { cxxfilt = original }()
+
+	mangled := "_ZN7seastar7reactor3runEv"
+	if got := Demangle(mangled); got != mangled {
+		t.Errorf("Demangle without c++filt = %q, want the mangled name", got)
+	}
+
+	samples := []*Sample{{Stack: []StackFrame{{Symbol: mangled}}}}
+	DemangleSamples(samples)
+	if samples[0].Stack[0].Symbol != mangled || samples[0].Stack[0].RawSymbol != "" {
+		t.Errorf("frame changed without c++filt: %+v", samples[0].Stack[0])
+	}
+}
@@ -0,0 +1,227 @@
+package parser
+
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// kernelModule is the module perf reports for kernel symbols; ftrace only
+// traces the kernel, so its frames are given the same module
+const kernelModule = "[kernel.kallsyms]"
+
+var (
+	// Event header of `trace-cmd report` and of the raw ftrace trace file:
+	//	bash-1234  [002]  5678.901234: funcgraph_entry:  ...
+	//	bash-1234  (1200) [002] d..3  5678.901234: <stack trace>
+	ftraceHeaderRegex = regexp.MustCompile(`^\s*(.+?)-(\d+)\s+(?:\(\s*(\d+|-+)\)\s+)?\[(\d+)\]\s+(?:[\w.]{4,6}\s+)?(\d+\.\d+):\s*(.*)$`)
+
+	// Function-graph call, after the "|": "  vfs_read() {" or "    fget_light();"
+	ftraceCallRegex = regexp.MustCompile(`^\s*([\w.$]+)\(\)\s*([{;])`)
+)
+
+// ParseFtrace parses `trace-cmd report` output or a raw ftrace trace file
+// into samples, so that ftrace captures go through the same analysis as perf.
+//
+// Two kinds of events carry stacks:
+//
+//   - Stack traces (the stacktrace/userstacktrace options, or the
+//     kernel_stack/user_stack events of trace-cmd): each becomes one sample.
+//     A user stack following the kernel stack of the same task and timestamp
+//     is appended to it.
+//
+//     bash-1234  [002]  5678.901234: kernel_stack:  <stack trace>
+//     => __schedule (ffffffff81a0b1c2)
+//     => schedule (ffffffff81a0b5e1)
+//
+//   - Function-graph events (funcgraph_entry/funcgraph_exit in trace-cmd
+//     report): the nesting is followed per CPU and every leaf call becomes
+//     one sample with the calling functions as its stack. These samples
+//     count calls, not time.
+//
+//     bash-1234  [002]  5678.901234: funcgraph_entry:               |  vfs_read() {
+//     bash-1234  [002]  5678.901236: funcgraph_entry:    0.541 us   |    fget_light();
+//     bash-1234  [002]  5678.901240: funcgraph_exit:     3.902 us   |  }
+//
+// Other events and comment lines are ignored. The PID is the thread's TGID
+// when the trace records it, otherwise the thread ID.
+func ParseFtrace(content string) ([]*Sample, error) {
+	samples := make([]*Sample, 0)
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	graphs := make(map[int][]StackFrame) // Open function-graph calls per CPU, outermost first
+	var current *Sample                  // Stack trace sample whose "=>" frames are being read
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "=>") {
+			if current != nil {
+				if frame, ok := parseFtraceFrame(strings.TrimSpace(strings.TrimPrefix(trimmed, "=>"))); ok {
+					current.Stack = append(current.Stack, frame)
+				}
+			}
+			continue
+		}
+
+		matches := ftraceHeaderRegex.FindStringSubmatch(line)
+		if matches == nil {
+			continue
+		}
+		tid, _ := strconv.Atoi(matches[2])
+		pid := tid
+		if tgid, err := strconv.Atoi(matches[3]); err == nil {
+			pid = tgid
+		}
+		cpu, _ := strconv.Atoi(matches[4])
+		timestamp, _ := strconv.ParseFloat(matches[5], 64)
+		header := Sample{
+			Command:   strings.TrimSpace(matches[1]),
+			PID:       pid,
+			TID:       tid,
+			CPU:       cpu,
+			Timestamp: timestamp,
+		}
+
+		event, body := splitFtraceEvent(matches[6])
+		switch {
+		case strings.Contains(body, "<user stack trace>"):
+			// Continue the kernel stack of the same event, if any
+			if current != nil && current.TID == tid && current.Timestamp == timestamp {
+				continue
+			}
+			current = newFtraceSample(header, "user_stack")
+			samples = append(samples, current)
+
+		case strings.Contains(body, "<stack trace>"):
+			current = newFtraceSample(header, "kernel_stack")
+			samples = append(samples, current)
+
+		case event == "funcgraph_entry":
+			current = nil
+			call := ftraceCallRegex.FindStringSubmatch(graphBody(body))
+			if call == nil {
+				continue
+			}
+			frame := kernelFrame(call[1])
+			if call[2] == "{" {
+				graphs[cpu] = append(graphs[cpu], frame)
+				continue
+			}
+
+			sample := newFtraceSample(header, "funcgraph")
+			sample.Stack = append(sample.Stack, frame)
+			open := graphs[cpu]
+			for i := len(open) - 1; i >= 0; i-- {
+				sample.Stack = append(sample.Stack, open[i])
+			}
+			samples = append(samples, sample)
+
+		case event == "funcgraph_exit":
+			current = nil
+			if open := graphs[cpu]; len(open) > 0 {
+				graphs[cpu] = open[:len(open)-1]
+			}
+
+		default:
+			current = nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error scanning ftrace output: %v", err)
+	}
+
+	// Stack traces whose frames could not be read carry no information
+	withStack := samples[:0]
+	for _, sample := range samples {
+		if len(sample.Stack) > 0 {
+			withStack = append(withStack, sample)
+		}
+	}
+	DemangleSamples(withStack)
+	classifyGoAssembly(withStack)
+	return withStack, nil
+}
+
+// splitFtraceEvent splits the text after the timestamp into the event name
+// and its payload. Raw trace files have no event name for stack traces.
+func splitFtraceEvent(text string) (string, string) {
+	name, body, found := strings.Cut(text, ":")
+	if !found || strings.ContainsAny(name, " <") {
+		return "", text
+	}
+	return name, body
+}
+
+// graphBody returns the call part of a function-graph payload, after the
+// duration column
+func graphBody(body string) string {
+	if i := strings.Index(body, "|"); i >= 0 {
+		return body[i+1:]
+	}
+	return body
+}
+
+func newFtraceSample(header Sample, event string) *Sample {
+	sample := header
+	sample.Event = event
+	sample.Stack = make([]StackFrame, 0)
+	return &sample
+}
+
+// parseFtraceFrame parses the text after "=>" in a stack trace:
+//
+//	__schedule (ffffffff81a0b1c2)     kernel symbol with address
+//	__schedule+0x2f2/0x880            kernel symbol with offset (sym-offset option)
+//	/usr/lib/libc.so.6[+0x10b3c]      user frame mapped to a file (sym-userobj option)
+//	<00007f3c1a2b3c4d>                unresolved user address
+func parseFtraceFrame(text string) (StackFrame, bool) {
+	if text == "" || text == "<...>" {
+		return StackFrame{}, false
+	}
+
+	if strings.HasPrefix(text, "<") {
+		frame := StackFrame{
+			Address: strings.TrimLeft(strings.Trim(text, "<>"), "0"),
+			Symbol:  "[unknown]",
+			Module:  "[unknown]",
+		}
+		frame.Type, frame.IsKernel, frame.IsUserland = ClassifyFrame(&frame)
+		return frame, true
+	}
+
+	if strings.HasPrefix(text, "/") {
+		module, offset, _ := strings.Cut(text, "[+0x")
+		frame := StackFrame{
+			Symbol: "[unknown]",
+			Module: validName(module),
+			Offset: strings.TrimSuffix(offset, "]"),
+		}
+		frame.Type, frame.IsKernel, frame.IsUserland = ClassifyFrame(&frame)
+		return frame, true
+	}
+
+	symbol, address, _ := strings.Cut(text, " ")
+	frame := kernelFrame(symbol)
+	frame.Address = strings.Trim(strings.TrimSpace(address), "()")
+	return frame, true
+}
+
+// kernelFrame builds a classified kernel frame from "sym" or "sym+0x2f/0x80"
+func kernelFrame(symbol string) StackFrame {
+	name, offset, _ := strings.Cut(symbol, "+0x")
+	if i := strings.Index(offset, "/"); i >= 0 {
+		offset = offset[:i]
+	}
+	frame := StackFrame{Symbol: validName(name), Module: kernelModule, Offset: offset}
+	frame.Type, frame.IsKernel, frame.IsUserland = ClassifyFrame(&frame)
+	return frame
+}
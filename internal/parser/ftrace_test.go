@@ -0,0 +1,88 @@
+package parser
+
+import (
+	"testing"
+)
+
+func TestParseFtraceFunctionGraph(t *testing.T) {
+	input := `# tracer: function_graph
+#
+            bash-1234  [002]  5678.901234: funcgraph_entry:                   |  vfs_read() {
+            bash-1234  [002]  5678.901235: funcgraph_entry:                   |    rw_verify_area() {
+            bash-1234  [002]  5678.901236: funcgraph_entry:        0.541 us   |      security_file_permission();
+            bash-1234  [002]  5678.901237: funcgraph_exit:         1.902 us   |    }
+           sshd-999    [000]  5678.901238: funcgraph_entry:        0.200 us   |  ktime_get();
+            bash-1234  [002]  5678.901239: funcgraph_entry:      + 12.45 us   |    __fsnotify_parent();
+            bash-1234  [002]  5678.901250: funcgraph_exit:       + 16.02 us   |  }
+`
+	samples, err := ParseFtrace(input)
+	if err != nil {
+		t.Fatalf("ParseFtrace failed: %v", err)
+	}
+	if len(samples) != 3 {
+		t.Fatalf("Expected 3 leaf call samples, got %d", len(samples))
+	}
+
+	first := samples[0]
+	if first.Command != "bash" || first.PID != 1234 || first.CPU != 2 || first.Timestamp != 5678.901236 || first.Event != "funcgraph" {
+		t.Errorf("Unexpected sample header: %+v", first)
+	}
+	if stack := first.GetFullStack(); stack != "security_file_permission;rw_verify_area;vfs_read" {
+		t.Errorf("Unexpected stack: %s", stack)
+	}
+	if !first.Stack[0].IsKernel || first.Stack[0].Type != FrameTypeKernelCore {
+		t.Errorf("Expected a kernel frame, got %+v", first.Stack[0])
+	}
+
+	// Nesting is tracked per CPU, so the other CPU's call has no parents
+	if stack := samples[1].GetFullStack(); stack != "ktime_get" {
+		t.Errorf("Unexpected stack on CPU 0: %s", stack)
+	}
+	if stack := samples[2].GetFullStack(); stack != "__fsnotify_parent;vfs_read" {
+		t.Errorf("Expected rw_verify_area to be closed, got %s", stack)
+	}
+}
+
+func TestParseFtraceStackTraces(t *testing.T) {
+	input := `       mysqld-4321  (4300) [001] d..3  100.500000: <stack trace>
+ => __schedule+0x2f2/0x880
+ => schedule+0x46/0xb0
+ => futex_wait_queue_me
+       mysqld-4321  (4300) [001] d..3  100.500000: <user stack trace>
+ =>  /usr/lib/libc.so.6[+0x10b3c]
+ =>  <00007f3c1a2b3c4d>
+          <idle>-0     [003]  101.000000: kernel_stack:         <stack trace>
+=> intel_idle (ffffffff81a0b1c2)
+=> cpuidle_enter_state (ffffffff81a0b5e1)
+          <idle>-0     [003]  101.500000: sched_switch:         prev_comm=swapper/3
+`
+	samples, err := ParseFtrace(input)
+	if err != nil {
+		t.Fatalf("ParseFtrace failed: %v", err)
+	}
+	if len(samples) != 2 {
+		t.Fatalf("Expected 2 stack samples, got %d", len(samples))
+	}
+
+	mysqld := samples[0]
+	if mysqld.PID != 4300 || mysqld.TID != 4321 || mysqld.Timestamp != 100.5 {
+		t.Errorf("Unexpected sample header: %+v", mysqld)
+	}
+	if len(mysqld.Stack) != 5 {
+		t.Fatalf("Expected the user stack to be appended to the kernel stack, got %d frames", len(mysqld.Stack))
+	}
+	if frame := mysqld.Stack[0]; frame.Symbol != "__schedule" || frame.Offset != "2f2" || !frame.IsKernel {
+		t.Errorf("Unexpected kernel frame: %+v", frame)
+	}
+	if frame := mysqld.Stack[3]; frame.Module != "/usr/lib/libc.so.6" || frame.Offset != "10b3c" || frame.Type != FrameTypeLibC {
+		t.Errorf("Unexpected user frame: %+v", frame)
+	}
+	if frame := mysqld.Stack[4]; frame.Address != "7f3c1a2b3c4d" || !frame.IsUnresolved() {
+		t.Errorf("Unexpected unresolved frame: %+v", frame)
+	}
+
+	idle := samples[1]
+	if !idle.IsIdle() || idle.Event != "kernel_stack" || idle.Stack[0].Symbol != "intel_idle" || idle.Stack[0].Address != "ffffffff81a0b1c2" {
+		t.Errorf("Unexpected idle sample: %+v", idle)
+	}
+}
@@ -0,0 +1,61 @@
+package parser
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+)
+
+// gzipMagic are the first two bytes of every gzip stream
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// OpenInput opens a perf script text file for reading. Gzip-compressed files
+// are detected by their magic bytes and decompressed transparently, so both
+// perf-script.txt and perf-script.txt.gz are accepted.
+func OpenInput(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	buffered := bufio.NewReader(file)
+	magic, err := buffered.Peek(len(gzipMagic))
+	if err != nil && err != io.EOF {
+		file.Close()
+		return nil, fmt.Errorf("error reading %s: %v", path, err)
+	}
+
+	if len(magic) == len(gzipMagic) && magic[0] == gzipMagic[0] && magic[1] == gzipMagic[1] {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("error opening gzip stream %s: %v", path, err)
+		}
+		return &gzipInput{Reader: gz, file: file}, nil
+	}
+
+	return &plainInput{Reader: buffered, file: file}, nil
+}
+
+// plainInput is an uncompressed input file
+type plainInput struct {
+	io.Reader
+	file *os.File
+}
+
+func (p *plainInput) Close() error {
+	return p.file.Close()
+}
+
+// gzipInput is a gzip-compressed input file
+type gzipInput struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (g *gzipInput) Close() error {
+	g.Reader.Close()
+	return g.file.Close()
+}
@@ -0,0 +1,57 @@
+package parser
+
+import "strings"
+
+// mmFaultSymbols are kernel functions on the page-fault and page-table walk
+// paths. Time below them is memory-management overhead (minor faults, TLB
+// misses on large mmaps) rather than work done by the process.
+var mmFaultSymbols = []string{
+	"asm_exc_page_fault",
+	"exc_page_fault",
+	"do_page_fault",
+	"__do_page_fault",
+	"do_user_addr_fault",
+	"handle_mm_fault",
+	"__handle_mm_fault",
+	"handle_pte_fault",
+	"do_anonymous_page",
+	"do_huge_pmd_anonymous_page",
+	"do_fault",
+	"do_read_fault",
+	"do_cow_fault",
+	"do_shared_fault",
+	"do_wp_page",
+	"filemap_fault",
+	"filemap_map_pages",
+	"__pte_alloc",
+	"__pmd_alloc",
+	"walk_page_range",
+	"follow_page_mask",
+	"flush_tlb_mm_range",
+	"flush_tlb_func",
+	"native_flush_tlb_one_user",
+}
+
+// IsMMFault reports whether the frame is a kernel page-fault or page-table
+// walk function
+func (f *StackFrame) IsMMFault() bool {
+	if !f.IsKernel {
+		return false
+	}
+	for _, symbol := range mmFaultSymbols {
+		if f.Symbol == symbol {
+			return true
+		}
+	}
+	return strings.HasPrefix(f.Symbol, "page_fault") // Older kernels' entry point
+}
+
+// InMMFault reports whether any frame of the sample is on the page-fault path
+func (s *Sample) InMMFault() bool {
+	for i := range s.Stack {
+		if s.Stack[i].IsMMFault() {
+			return true
+		}
+	}
+	return false
+}
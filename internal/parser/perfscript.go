@@ -3,7 +3,10 @@ package parser
 import (
 	"bufio"
 	"fmt"
+	"io"
+	"math"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -16,6 +19,7 @@ type Sample struct {
 	TID       int
 	CPU       int
 	Timestamp float64
+	Period    uint64 // Events the sample stands for (e.g. ns of cpu-clock), 0 when unknown
 	Event     string
 	Stack     []StackFrame
 }
@@ -24,6 +28,7 @@ type Sample struct {
 type StackFrame struct {
 	Address    string
 	Symbol     string
+	RawSymbol  string // Mangled name when Symbol was demangled, empty otherwise
 	Module     string
 	Offset     string
 	Type       FrameType
@@ -31,35 +36,56 @@ type StackFrame struct {
 	IsUserland bool
 }
 
-// FrameType categorizes the frame
-type FrameType string
-
-const (
-	FrameTypeKernelCore   FrameType = "kernel_core"
-	FrameTypeKernelDriver FrameType = "kernel_driver"
-	FrameTypeLibC         FrameType = "libc"
-	FrameTypeLibPthread   FrameType = "libpthread"
-	FrameTypeLibMySQL     FrameType = "libmysql"
-	FrameTypeApplication  FrameType = "application"
-	FrameTypeUnknown      FrameType = "unknown"
-)
-
 // ParsePerfScript parses the output of `perf script`
 func ParsePerfScript(content string) ([]*Sample, error) {
+	return ParsePerfScriptReader(strings.NewReader(content))
+}
+
+// ParsePerfScriptReader parses `perf script` output read line by line from r,
+// so the text of multi-GB captures is never held in memory at once
+func ParsePerfScriptReader(r io.Reader) ([]*Sample, error) {
 	samples := make([]*Sample, 0)
-	scanner := bufio.NewScanner(strings.NewReader(content))
+	err := ParsePerfScriptStream(r, func(sample *Sample) error {
+		samples = append(samples, sample)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	DemangleSamples(samples)
+	classifyGoAssembly(samples)
+	return samples, nil
+}
+
+// ParsePerfScriptStream parses `perf script` output from r and calls fn with
+// each sample as soon as its stack is complete. Parsing stops at the first
+// error returned by fn. Symbols are passed as perf printed them: demangling
+// needs all of them at once, see DemangleSamples, and so does telling Go
+// runtime assembly apart.
+func ParsePerfScriptStream(r io.Reader, fn func(*Sample) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
 	
-	// Regex patterns for perf script output
+	// Regex patterns for perf script output. The command is the thread's comm,
+	// which can contain spaces (postgres: walwriter), so it is matched lazily
+	// up to the PID and timestamp that follow it rather than as one word.
 	// Format 1: mysqld 12345/12346 [001] 123456.789012:     999999 cpu-clock:
-	headerRegex1 := regexp.MustCompile(`^\s*(\S+)\s+(\d+)/(\d+)\s+\[(\d+)\]\s+(\d+\.\d+):\s+\d+\s+(\S+):`)
+	headerRegex1 := regexp.MustCompile(`^\s*(\S.*?)\s+(\d+)/(\d+)\s+\[(\d+)\]\s+(\d+\.\d+):\s+(\d+)\s+(\S+):`)
 	
 	// Format 2: reactor-4    3202 88019.498348:     124999 cycles:P:
-	headerRegex2 := regexp.MustCompile(`^\s*(\S+)\s+(\d+)\s+(\d+\.\d+):\s+\d+\s+(\S+):`)
+	headerRegex2 := regexp.MustCompile(`^\s*(\S.*?)\s+(\d+)\s+(\d+\.\d+):\s+(\d+)\s+(\S+):`)
 	
 	// Stack frame patterns:
 	// 	    7ffff7a0d000 __pthread_mutex_lock+0x0 (/lib/x86_64-linux-gnu/libpthread-2.31.so)
 	// 	    ffffffff81234567 do_syscall_64+0x57 ([kernel.kallsyms])
-	stackRegex := regexp.MustCompile(`^\s+([0-9a-fA-F]+)\s+([^\+\(]+)(?:\+0x([0-9a-fA-F]+))?\s+\(([^\)]+)\)`)
+	// The module is missing for some JIT code and unmapped addresses:
+	// 	    0x7f3a2c01d2e0 some_symbol+0x10
+	// Demangled C++ symbols carry their own parentheses, so the module is only
+	// the parenthesized group at the end of the line, which can itself end in
+	// " (deleted)":
+	// 	    55555560abcd Item_func::val_int(THD*) const+0x10 (/usr/sbin/mariadbd)
+	stackRegex := regexp.MustCompile(`^\s+(?:0x)?([0-9a-fA-F]+)\s+(.*?)(?:\+0x([0-9a-fA-F]+))?(?:\s+\(([^()]*(?:\([^()]*\))?)\))?\s*$`)
 	
 	var currentSample *Sample
 	
@@ -68,9 +94,11 @@ func ParsePerfScript(content string) ([]*Sample, error) {
 		
 		// Try format 1 first (with TID and CPU)
 		if matches := headerRegex1.FindStringSubmatch(line); matches != nil {
-			// Save previous sample if exists
+			// Emit previous sample if exists
 			if currentSample != nil {
-				samples = append(samples, currentSample)
+				if err := fn(currentSample); err != nil {
+					return err
+				}
 			}
 			
 			// Parse new sample header
@@ -78,6 +106,7 @@ func ParsePerfScript(content string) ([]*Sample, error) {
 			tid, _ := strconv.Atoi(matches[3])
 			cpu, _ := strconv.Atoi(matches[4])
 			timestamp, _ := strconv.ParseFloat(matches[5], 64)
+			period, _ := strconv.ParseUint(matches[6], 10, 64)
 			
 			currentSample = &Sample{
 				Command:   strings.TrimSpace(matches[1]),
@@ -85,7 +114,8 @@ func ParsePerfScript(content string) ([]*Sample, error) {
 				TID:       tid,
 				CPU:       cpu,
 				Timestamp: timestamp,
-				Event:     strings.TrimSpace(matches[6]),
+				Period:    period,
+				Event:     strings.TrimSpace(matches[7]),
 				Stack:     make([]StackFrame, 0),
 			}
 			continue
@@ -93,14 +123,17 @@ func ParsePerfScript(content string) ([]*Sample, error) {
 		
 		// Try format 2 (without TID/CPU in header)
 		if matches := headerRegex2.FindStringSubmatch(line); matches != nil {
-			// Save previous sample if exists
+			// Emit previous sample if exists
 			if currentSample != nil {
-				samples = append(samples, currentSample)
+				if err := fn(currentSample); err != nil {
+					return err
+				}
 			}
 			
 			// Parse new sample header
 			pid, _ := strconv.Atoi(matches[2])
 			timestamp, _ := strconv.ParseFloat(matches[3], 64)
+			period, _ := strconv.ParseUint(matches[4], 10, 64)
 			
 			currentSample = &Sample{
 				Command:   strings.TrimSpace(matches[1]),
@@ -108,7 +141,8 @@ func ParsePerfScript(content string) ([]*Sample, error) {
 				TID:       pid, // Use PID as TID when not available
 				CPU:       0,   // Unknown CPU
 				Timestamp: timestamp,
-				Event:     strings.TrimSpace(matches[4]),
+				Period:    period,
+				Event:     strings.TrimSpace(matches[5]),
 				Stack:     make([]StackFrame, 0),
 			}
 			continue
@@ -119,9 +153,9 @@ func ParsePerfScript(content string) ([]*Sample, error) {
 			if matches := stackRegex.FindStringSubmatch(line); matches != nil {
 				frame := StackFrame{
 					Address: matches[1],
-					Symbol:  strings.TrimSpace(matches[2]),
+					Symbol:  validName(matches[2]),
 					Offset:  matches[3],
-					Module:  strings.TrimSpace(matches[4]),
+					Module:  validName(matches[4]),
 				}
 				
 				// Classify the frame
@@ -132,66 +166,42 @@ func ParsePerfScript(content string) ([]*Sample, error) {
 		}
 	}
 	
-	// Don't forget the last sample
-	if currentSample != nil {
-		samples = append(samples, currentSample)
-	}
-	
 	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error scanning perf script output: %v", err)
+		return fmt.Errorf("error scanning perf script output: %v", err)
 	}
 	
-	return samples, nil
+	// Don't forget the last sample
+	if currentSample != nil {
+		return fn(currentSample)
+	}
+	return nil
 }
 
-// ClassifyFrame determines the type and category of a stack frame
-func ClassifyFrame(frame *StackFrame) (FrameType, bool, bool) {
-	module := strings.ToLower(frame.Module)
-	symbol := strings.ToLower(frame.Symbol)
-	
-	// Kernel detection
-	if strings.Contains(module, "kernel.kallsyms") || 
-	   strings.Contains(module, "[kernel") ||
-	   strings.Contains(module, "vmlinux") {
-		return FrameTypeKernelCore, true, false
-	}
-	
-	// Kernel modules/drivers
-	if strings.HasPrefix(module, "[") && strings.HasSuffix(module, "]") {
-		// Could be kernel module
-		return FrameTypeKernelDriver, true, false
-	}
-	
-	// LibC
-	if strings.Contains(module, "libc") && 
-	   (strings.Contains(module, ".so") || strings.Contains(module, "libc-")) {
-		return FrameTypeLibC, false, true
-	}
-	
-	// LibPthread
-	if strings.Contains(module, "libpthread") {
-		return FrameTypeLibPthread, false, true
-	}
-	
-	// MySQL/MariaDB libraries
-	if strings.Contains(module, "mysql") || 
-	   strings.Contains(module, "mariadb") ||
-	   strings.Contains(symbol, "mysql") ||
-	   strings.Contains(symbol, "maria") {
-		return FrameTypeLibMySQL, false, true
-	}
-	
-	// Application binary (not a shared library)
-	if !strings.Contains(module, ".so") && !strings.HasPrefix(module, "[") {
-		return FrameTypeApplication, false, true
-	}
-	
-	// Default: userland unknown
-	if strings.Contains(module, ".so") {
-		return FrameTypeUnknown, false, true
+// validName trims a symbol or module name and replaces invalid UTF-8 (from
+// mangled names or binary garbage in perf output) with U+FFFD, so that every
+// report format receives valid text
+func validName(name string) string {
+	return strings.ToValidUTF8(strings.TrimSpace(name), "\uFFFD")
+}
+
+// IsUnmapped reports whether perf could not map the frame's address to any
+// DSO (missing mmap event or a JIT region)
+func (f *StackFrame) IsUnmapped() bool {
+	return f.Module == "" || f.Module == "[unknown]"
+}
+
+// KernelModule returns the name of the kernel module a driver frame belongs
+// to, "nvme" for [nvme], or "" for any other frame
+func (f *StackFrame) KernelModule() string {
+	if f.Type != FrameTypeKernelDriver {
+		return ""
 	}
-	
-	return FrameTypeUnknown, false, false
+	return strings.TrimSuffix(strings.TrimPrefix(f.Module, "["), "]")
+}
+
+// IsUnresolved reports whether the frame has no symbol name
+func (f *StackFrame) IsUnresolved() bool {
+	return f.Symbol == "" || f.Symbol == "[unknown]"
 }
 
 // GetTopFrame returns the top frame of the stack (leaf function)
@@ -202,6 +212,21 @@ func (s *Sample) GetTopFrame() *StackFrame {
 	return nil
 }
 
+// Weight returns what the sample counts for: its period when byPeriod is set
+// and perf reported one, 1 otherwise
+func (s *Sample) Weight(byPeriod bool) int {
+	if byPeriod && s.Period > 0 {
+		return int(s.Period)
+	}
+	return 1
+}
+
+// IsIdle reports whether the sample was taken in the kernel idle task
+// (swapper, PID 0), which stands for unused CPU rather than work
+func (s *Sample) IsIdle() bool {
+	return s.PID == 0 || s.Command == "swapper" || strings.HasPrefix(s.Command, "swapper/")
+}
+
 // GetBottomFrame returns the bottom frame of the stack (root)
 func (s *Sample) GetBottomFrame() *StackFrame {
 	if len(s.Stack) > 0 {
@@ -227,11 +252,29 @@ type TimeWindow struct {
 	Samples   []*Sample
 }
 
-// PartitionByTime divides samples into time windows
+// SortByTimestamp orders samples by timestamp in place. The sort is stable,
+// so samples sharing a timestamp keep their original order.
+func SortByTimestamp(samples []*Sample) {
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].Timestamp < samples[j].Timestamp
+	})
+}
+
+// PartitionByTime divides samples into time windows. Samples may come in any
+// order (merged captures, some perf outputs); each window holds its samples
+// in timestamp order. Windows are [start, end) except the last, which is
+// [start, end]. The input slice is not modified.
 func PartitionByTime(samples []*Sample, windowSizeSeconds float64) []*TimeWindow {
 	if len(samples) == 0 {
 		return []*TimeWindow{}
 	}
+
+	if !sort.SliceIsSorted(samples, func(i, j int) bool { return samples[i].Timestamp < samples[j].Timestamp }) {
+		sorted := make([]*Sample, len(samples))
+		copy(sorted, samples)
+		SortByTimestamp(sorted)
+		samples = sorted
+	}
 	
 	// Find min and max timestamps
 	minTime := samples[0].Timestamp
@@ -246,9 +289,13 @@ func PartitionByTime(samples []*Sample, windowSizeSeconds float64) []*TimeWindow
 		}
 	}
 	
-	// Calculate number of windows needed
+	// Calculate number of windows needed. The last window ends at or after
+	// maxTime, so there is no empty window at the tail
 	totalDuration := maxTime - minTime
-	numWindows := int(totalDuration/windowSizeSeconds) + 1
+	numWindows := int(math.Ceil(totalDuration / windowSizeSeconds))
+	if numWindows < 1 {
+		numWindows = 1
+	}
 	
 	windows := make([]*TimeWindow, numWindows)
 	for i := 0; i < numWindows; i++ {
@@ -262,12 +309,23 @@ func PartitionByTime(samples []*Sample, windowSizeSeconds float64) []*TimeWindow
 		}
 	}
 	
-	// Assign samples to windows
+	// Assign samples to windows. Windows are half-open [start, end), except
+	// the last one, which is closed [start, end] so that a sample at exactly
+	// maxTime lands in it
+	last := numWindows - 1
 	for _, sample := range samples {
 		windowIndex := int((sample.Timestamp - minTime) / windowSizeSeconds)
-		if windowIndex >= 0 && windowIndex < numWindows {
-			windows[windowIndex].Samples = append(windows[windowIndex].Samples, sample)
+		// The division can round across a boundary; settle it against the
+		// window bounds themselves
+		if windowIndex > last {
+			windowIndex = last
+		}
+		if windowIndex > 0 && sample.Timestamp < windows[windowIndex].StartTime {
+			windowIndex--
+		} else if windowIndex < last && sample.Timestamp >= windows[windowIndex].EndTime {
+			windowIndex++
 		}
+		windows[windowIndex].Samples = append(windows[windowIndex].Samples, sample)
 	}
 	
 	return windows
@@ -1,6 +1,12 @@
 package parser
 
 import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -112,6 +118,190 @@ reactor-5    3204 88019.501997:     124999 cycles:P:
 	}
 }
 
+func TestParsePerfScriptPeriod(t *testing.T) {
+	testInput := `mysqld 12345/12346 [001] 123456.789012:     999999 cpu-clock:
+	    7ffff7a0d000 __pthread_mutex_lock+0x0 (/lib/x86_64-linux-gnu/libpthread-2.31.so)
+
+reactor-4    3202 88019.498348:     124999 cycles:P:
+	         1caa86e [unknown] (/opt/scylladb/libexec/scylla)
+`
+
+	samples, err := ParsePerfScript(testInput)
+	if err != nil {
+		t.Fatalf("ParsePerfScript failed: %v", err)
+	}
+	if len(samples) != 2 {
+		t.Fatalf("Expected 2 samples, got %d", len(samples))
+	}
+	if samples[0].Period != 999999 {
+		t.Errorf("Expected period 999999 (format 1), got %d", samples[0].Period)
+	}
+	if samples[1].Period != 124999 {
+		t.Errorf("Expected period 124999 (format 2), got %d", samples[1].Period)
+	}
+
+	if w := samples[1].Weight(false); w != 1 {
+		t.Errorf("Expected weight 1 without byPeriod, got %d", w)
+	}
+	if w := samples[1].Weight(true); w != 124999 {
+		t.Errorf("Expected weight 124999 by period, got %d", w)
+	}
+	if w := (&Sample{}).Weight(true); w != 1 {
+		t.Errorf("Expected weight 1 for a sample without period, got %d", w)
+	}
+}
+
+func TestParsePerfScriptStream(t *testing.T) {
+	input := `mysqld 12345/12346 [001] 123456.789012:     999999 cpu-clock:
+	    7ffff7a0d000 __pthread_mutex_lock+0x0 (/lib/x86_64-linux-gnu/libpthread-2.31.so)
+
+mysqld 12345/12347 [002] 123456.890123:     999999 cpu-clock:
+	    7ffff7b0e111 malloc+0x45 (/lib/x86_64-linux-gnu/libc-2.31.so)
+
+mysqld 12345/12348 [003] 123457.000000:     999999 cpu-clock:
+	    55555560abcd handle_connection+0x123 (/usr/sbin/mysqld)
+`
+
+	var tids []int
+	err := ParsePerfScriptStream(strings.NewReader(input), func(sample *Sample) error {
+		tids = append(tids, sample.TID)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ParsePerfScriptStream failed: %v", err)
+	}
+	if len(tids) != 3 || tids[0] != 12346 || tids[2] != 12348 {
+		t.Errorf("Expected samples of TIDs 12346..12348 in order, got %v", tids)
+	}
+
+	// An error from the callback stops the parse
+	stop := errors.New("stop")
+	calls := 0
+	err = ParsePerfScriptStream(strings.NewReader(input), func(sample *Sample) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("Expected the callback error after 1 call, got %v after %d", err, calls)
+	}
+
+	samples, err := ParsePerfScriptReader(strings.NewReader(input))
+	if err != nil || len(samples) != 3 {
+		t.Errorf("ParsePerfScriptReader: %d samples, err %v", len(samples), err)
+	}
+}
+
+func TestParsePerfScriptCommandWithSpaces(t *testing.T) {
+	testInput := "postgres: walwriter 4242/4243 [001] 5000.000001:     250000 cpu-clock:\n" +
+		"\t    7ffff7b0e111 XLogBackgroundFlush+0x45 (/usr/lib/postgresql/16/bin/postgres)\n" +
+		"\n" +
+		"   Chrome_ChildIOT 7 9 5000.000002:     250000 cpu-clock:\n" +
+		"\t    55555560abcd main+0x20 (/opt/google/chrome/chrome)\n" +
+		"\n" +
+		"kworker/u8:2 99 5000.000003:     250000 cpu-clock:\n" +
+		"\t    ffffffff81234567 worker_thread+0x57 ([kernel.kallsyms])\n"
+
+	samples, err := ParsePerfScript(testInput)
+	if err != nil {
+		t.Fatalf("ParsePerfScript failed: %v", err)
+	}
+	if len(samples) != 3 {
+		t.Fatalf("Expected 3 samples, got %d: %+v", len(samples), samples)
+	}
+
+	tests := []struct {
+		command string
+		pid     int
+		tid     int
+	}{
+		{"postgres: walwriter", 4242, 4243},
+		{"Chrome_ChildIOT 7", 9, 9},
+		{"kworker/u8:2", 99, 99},
+	}
+	for i, tt := range tests {
+		sample := samples[i]
+		if sample.Command != tt.command || sample.PID != tt.pid || sample.TID != tt.tid {
+			t.Errorf("Sample %d: expected %q %d/%d, got %q %d/%d", i, tt.command, tt.pid, tt.tid, sample.Command, sample.PID, sample.TID)
+		}
+		if len(sample.Stack) != 1 {
+			t.Errorf("Sample %d: expected 1 stack frame, got %d", i, len(sample.Stack))
+		}
+	}
+	if samples[0].CPU != 1 || samples[0].Event != "cpu-clock" {
+		t.Errorf("Expected CPU 1 and cpu-clock, got CPU %d and %q", samples[0].CPU, samples[0].Event)
+	}
+}
+
+func TestParsePerfScriptFrameWithoutModule(t *testing.T) {
+	testInput := "java 4242/4243 [000] 5000.000001:     250000 cpu-clock:\n" +
+		"\t  0x1234 some_symbol+0x10\n" +
+		"\t    7ffff7b0e111 [unknown]\n" +
+		"\t    55555560abcd main+0x20 (/usr/bin/java)\n"
+
+	samples, err := ParsePerfScript(testInput)
+	if err != nil {
+		t.Fatalf("ParsePerfScript failed: %v", err)
+	}
+	if len(samples) != 1 {
+		t.Fatalf("Expected 1 sample, got %d", len(samples))
+	}
+	stack := samples[0].Stack
+	if len(stack) != 3 {
+		t.Fatalf("Expected 3 stack frames, got %d: %+v", len(stack), stack)
+	}
+
+	frame := stack[0]
+	if frame.Symbol != "some_symbol" || frame.Offset != "10" || frame.Address != "1234" {
+		t.Errorf("Unexpected frame: symbol=%q offset=%q address=%q", frame.Symbol, frame.Offset, frame.Address)
+	}
+	if frame.Module != "" || frame.Type != FrameTypeUnknown {
+		t.Errorf("Expected an unknown frame without module, got module=%q type=%v", frame.Module, frame.Type)
+	}
+	if stack[1].Symbol != "[unknown]" || stack[1].Module != "" {
+		t.Errorf("Expected [unknown] without module, got %q (%q)", stack[1].Symbol, stack[1].Module)
+	}
+	if stack[2].Symbol != "main" || stack[2].Module != "/usr/bin/java" {
+		t.Errorf("Expected main in /usr/bin/java, got %q (%q)", stack[2].Symbol, stack[2].Module)
+	}
+}
+
+func TestParsePerfScriptDemangledFrames(t *testing.T) {
+	testInput := "mariadbd 4242/4243 [000] 5000.000001:     250000 cpu-clock:\n" +
+		"\t    55555560abcd Item_func::val_int(THD*) const+0x10 (/usr/sbin/mariadbd)\n" +
+		"\t    55555560bcde (anonymous namespace)::run_query(std::string const&)+0x2f (/usr/sbin/mariadbd)\n" +
+		"\t    7ffff7b0e111 operator+(int, int)+0x4 (/usr/lib/libfoo.so (deleted))\n" +
+		"\t    55555560cdef main+0x20 (/usr/sbin/mariadbd)\n"
+
+	samples, err := ParsePerfScript(testInput)
+	if err != nil {
+		t.Fatalf("ParsePerfScript failed: %v", err)
+	}
+	if len(samples) != 1 {
+		t.Fatalf("Expected 1 sample, got %d", len(samples))
+	}
+	stack := samples[0].Stack
+	if len(stack) != 4 {
+		t.Fatalf("Expected 4 stack frames, got %d: %+v", len(stack), stack)
+	}
+
+	tests := []struct {
+		symbol string
+		offset string
+		module string
+	}{
+		{"Item_func::val_int(THD*) const", "10", "/usr/sbin/mariadbd"},
+		{"(anonymous namespace)::run_query(std::string const&)", "2f", "/usr/sbin/mariadbd"},
+		{"operator+(int, int)", "4", "/usr/lib/libfoo.so (deleted)"},
+		{"main", "20", "/usr/sbin/mariadbd"},
+	}
+	for i, tt := range tests {
+		frame := stack[i]
+		if frame.Symbol != tt.symbol || frame.Offset != tt.offset || frame.Module != tt.module {
+			t.Errorf("Frame %d: expected %q+0x%s (%s), got %q+0x%s (%s)", i, tt.symbol, tt.offset, tt.module, frame.Symbol, frame.Offset, frame.Module)
+		}
+	}
+}
+
 func TestClassifyFrame(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -127,6 +317,13 @@ func TestClassifyFrame(t *testing.T) {
 			expectedKernel: true,
 			expectedUser:   false,
 		},
+		{
+			name:           "Guest kernel",
+			frame:          StackFrame{Symbol: "native_safe_halt", Module: "[guest.kernel.kallsyms]"},
+			expectedType:   FrameTypeGuest,
+			expectedKernel: true,
+			expectedUser:   false,
+		},
 		{
 			name:           "LibPthread",
 			frame:          StackFrame{Symbol: "pthread_mutex_lock", Module: "/lib/x86_64-linux-gnu/libpthread-2.31.so"},
@@ -136,7 +333,7 @@ func TestClassifyFrame(t *testing.T) {
 		},
 		{
 			name:           "LibC",
-			frame:          StackFrame{Symbol: "malloc", Module: "/lib/x86_64-linux-gnu/libc-2.31.so"},
+			frame:          StackFrame{Symbol: "memcpy", Module: "/lib/x86_64-linux-gnu/libc-2.31.so"},
 			expectedType:   FrameTypeLibC,
 			expectedKernel: false,
 			expectedUser:   true,
@@ -162,6 +359,111 @@ func TestClassifyFrame(t *testing.T) {
 			expectedKernel: false,
 			expectedUser:   true,
 		},
+		{
+			name:           "jemalloc module",
+			frame:          StackFrame{Symbol: "arena_malloc_hard", Module: "/usr/lib/x86_64-linux-gnu/libjemalloc.so.2"},
+			expectedType:   FrameTypeAllocator,
+			expectedKernel: false,
+			expectedUser:   true,
+		},
+		{
+			name:           "tcmalloc symbol",
+			frame:          StackFrame{Symbol: "tc_malloc", Module: "/usr/lib/libfoo.so"},
+			expectedType:   FrameTypeAllocator,
+			expectedKernel: false,
+			expectedUser:   true,
+		},
+		{
+			name:           "C++ operator new",
+			frame:          StackFrame{Symbol: "operator new(unsigned long)", Module: "/usr/lib/x86_64-linux-gnu/libstdc++.so.6"},
+			expectedType:   FrameTypeAllocator,
+			expectedKernel: false,
+			expectedUser:   true,
+		},
+		{
+			name:           "libc free",
+			frame:          StackFrame{Symbol: "free", Module: "/lib/x86_64-linux-gnu/libc-2.31.so"},
+			expectedType:   FrameTypeAllocator,
+			expectedKernel: false,
+			expectedUser:   true,
+		},
+		{
+			name:           "libc malloc",
+			frame:          StackFrame{Symbol: "malloc", Module: "/lib/x86_64-linux-gnu/libc-2.31.so"},
+			expectedType:   FrameTypeAllocator,
+			expectedKernel: false,
+			expectedUser:   true,
+		},
+		{
+			name:           "libc calloc",
+			frame:          StackFrame{Symbol: "calloc", Module: "/lib/x86_64-linux-gnu/libc-2.31.so"},
+			expectedType:   FrameTypeAllocator,
+			expectedKernel: false,
+			expectedUser:   true,
+		},
+		{
+			name:           "libc realloc",
+			frame:          StackFrame{Symbol: "realloc", Module: "/lib/x86_64-linux-gnu/libc-2.31.so"},
+			expectedType:   FrameTypeAllocator,
+			expectedKernel: false,
+			expectedUser:   true,
+		},
+		{
+			name:           "libc posix_memalign",
+			frame:          StackFrame{Symbol: "posix_memalign", Module: "/lib/x86_64-linux-gnu/libc-2.31.so"},
+			expectedType:   FrameTypeAllocator,
+			expectedKernel: false,
+			expectedUser:   true,
+		},
+		{
+			name:           "glibc malloc internals",
+			frame:          StackFrame{Symbol: "_int_malloc", Module: "/lib/x86_64-linux-gnu/libc-2.31.so"},
+			expectedType:   FrameTypeAllocator,
+			expectedKernel: false,
+			expectedUser:   true,
+		},
+		{
+			name:           "libc symbol containing free",
+			frame:          StackFrame{Symbol: "freeaddrinfo", Module: "/lib/x86_64-linux-gnu/libc-2.31.so"},
+			expectedType:   FrameTypeLibC,
+			expectedKernel: false,
+			expectedUser:   true,
+		},
+		{
+			name:           "Java perf map",
+			frame:          StackFrame{Symbol: "Lcom/example/Handler;::handle", Module: "/tmp/perf-1234.map"},
+			expectedType:   FrameTypeJIT,
+			expectedKernel: false,
+			expectedUser:   true,
+		},
+		{
+			name:           "perf inject --jit",
+			frame:          StackFrame{Symbol: "Lcom/mysql/cj/NativeSession;::execSQL", Module: "/root/.debug/jit/java-jit-20240101.XXabcd/jitted-1234-42.so"},
+			expectedType:   FrameTypeJIT,
+			expectedKernel: false,
+			expectedUser:   true,
+		},
+		{
+			name:           "Go runtime allocator",
+			frame:          StackFrame{Symbol: "runtime.mallocgc", Module: "/usr/local/bin/api-server"},
+			expectedType:   FrameTypeGoRuntime,
+			expectedKernel: false,
+			expectedUser:   true,
+		},
+		{
+			name:           "Go runtime futex",
+			frame:          StackFrame{Symbol: "runtime.futex", Module: "/usr/local/bin/api-server"},
+			expectedType:   FrameTypeGoRuntime,
+			expectedKernel: false,
+			expectedUser:   true,
+		},
+		{
+			name:           "Go application code",
+			frame:          StackFrame{Symbol: "main.handleRequest", Module: "/usr/local/bin/api-server"},
+			expectedType:   FrameTypeApplication,
+			expectedKernel: false,
+			expectedUser:   true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -180,6 +482,35 @@ func TestClassifyFrame(t *testing.T) {
 	}
 }
 
+func TestClassifyKernelModule(t *testing.T) {
+	tests := []struct {
+		frame        StackFrame
+		expectedType FrameType
+		kernel       bool
+		module       string
+	}{
+		{StackFrame{Symbol: "do_syscall_64", Module: "[kernel.kallsyms]"}, FrameTypeKernelCore, true, ""},
+		{StackFrame{Symbol: "nvme_queue_rq", Module: "[nvme]"}, FrameTypeKernelDriver, true, "nvme"},
+		{StackFrame{Symbol: "e1000_xmit_frame", Module: "[e1000]"}, FrameTypeKernelDriver, true, "e1000"},
+		{StackFrame{Symbol: "[unknown]", Module: "[unknown]"}, FrameTypeUnknown, false, ""},
+		{StackFrame{Symbol: "__vdso_clock_gettime", Module: "[vdso]"}, FrameTypeUnknown, false, ""},
+	}
+
+	for _, tt := range tests {
+		frame := tt.frame
+		frame.Type, frame.IsKernel, _ = ClassifyFrame(&frame)
+		if frame.Type != tt.expectedType || frame.IsKernel != tt.kernel {
+			t.Errorf("%s: expected %s (kernel %v), got %s (kernel %v)", frame.Module, tt.expectedType, tt.kernel, frame.Type, frame.IsKernel)
+		}
+		if got := frame.KernelModule(); got != tt.module {
+			t.Errorf("%s: expected kernel module %q, got %q", frame.Module, tt.module, got)
+		}
+		if frame.Module != tt.frame.Module {
+			t.Errorf("Classification changed the module from %q to %q", tt.frame.Module, frame.Module)
+		}
+	}
+}
+
 func TestPartitionByTime(t *testing.T) {
 	samples := []*Sample{
 		{Timestamp: 100.0, Command: "test", PID: 1, TID: 1},
@@ -220,6 +551,101 @@ func TestPartitionByTime(t *testing.T) {
 	}
 }
 
+func TestPartitionByTimeBoundaries(t *testing.T) {
+	samples := []*Sample{
+		{Timestamp: 100.0, Command: "start"},
+		{Timestamp: 101.0, Command: "edge"},
+		{Timestamp: 101.5, Command: "middle"},
+		{Timestamp: 103.0, Command: "max"},
+	}
+
+	windows := PartitionByTime(samples, 1.0)
+
+	// 3 seconds of data fill exactly 3 windows, with no empty tail window
+	if len(windows) != 3 {
+		t.Fatalf("Expected 3 windows, got %d", len(windows))
+	}
+	expected := []string{"start", "edge,middle", "max"}
+	for i, window := range windows {
+		got := make([]string, 0, len(window.Samples))
+		for _, sample := range window.Samples {
+			got = append(got, sample.Command)
+		}
+		if strings.Join(got, ",") != expected[i] {
+			t.Errorf("Window %d [%f, %f]: expected %s, got %v", i, window.StartTime, window.EndTime, expected[i], got)
+		}
+	}
+
+	// The sample at maxTime is in the last window and nowhere else
+	count := 0
+	for _, window := range windows {
+		for _, sample := range window.Samples {
+			if sample.Command == "max" {
+				count++
+			}
+		}
+	}
+	if count != 1 {
+		t.Errorf("Expected the sample at maxTime exactly once, found it %d times", count)
+	}
+
+	single := PartitionByTime([]*Sample{{Timestamp: 5.0}, {Timestamp: 5.0}}, 1.0)
+	if len(single) != 1 || len(single[0].Samples) != 2 {
+		t.Errorf("Expected one window with both samples for a zero-length capture, got %d windows", len(single))
+	}
+}
+
+func TestPartitionByTimeShuffled(t *testing.T) {
+	// Out-of-order input as produced by merged captures; the two samples at
+	// 101.0 must keep their relative order
+	samples := []*Sample{
+		{Timestamp: 102.5, Command: "c"},
+		{Timestamp: 100.2, Command: "a"},
+		{Timestamp: 101.0, Command: "b1"},
+		{Timestamp: 103.9, Command: "d"},
+		{Timestamp: 100.0, Command: "first"},
+		{Timestamp: 101.0, Command: "b2"},
+		{Timestamp: 102.1, Command: "c0"},
+	}
+
+	windows := PartitionByTime(samples, 1.0)
+
+	expected := [][]string{
+		{"first", "a"},
+		{"b1", "b2"},
+		{"c0", "c"},
+		{"d"},
+	}
+	if len(windows) != len(expected) {
+		t.Fatalf("Expected %d windows, got %d", len(expected), len(windows))
+	}
+	if windows[0].StartTime != 100.0 {
+		t.Errorf("Expected first window to start at 100.0, got %f", windows[0].StartTime)
+	}
+	for i, window := range windows {
+		got := make([]string, 0, len(window.Samples))
+		for _, sample := range window.Samples {
+			got = append(got, sample.Command)
+		}
+		if strings.Join(got, ",") != strings.Join(expected[i], ",") {
+			t.Errorf("Window %d: expected %v, got %v", i, expected[i], got)
+		}
+	}
+
+	if samples[0].Command != "c" {
+		t.Error("PartitionByTime should not reorder the input slice")
+	}
+
+	SortByTimestamp(samples)
+	order := make([]string, 0, len(samples))
+	for _, sample := range samples {
+		order = append(order, sample.Command)
+	}
+	if strings.Join(order, ",") != "first,a,b1,b2,c0,c,d" {
+		t.Errorf("Unexpected sorted order: %v", order)
+	}
+}
+
 func TestSampleMethods(t *testing.T) {
 	sample := &Sample{
 		Stack: []StackFrame{
@@ -369,3 +795,316 @@ func BenchmarkClassifyFrame(b *testing.B) {
 	}
 }
 
+func TestParseBranchStack(t *testing.T) {
+	input := `0x4005d0/0x400600/P/-/-/0  0x400612/0x4005c0/M/-/-/3
+main+0x20/compute+0x0/P/-/-/0 compute+0x32/main+0x25/M/-/-/12 garbage
+`
+
+	records, err := ParseBranchStack(input)
+	if err != nil {
+		t.Fatalf("ParseBranchStack failed: %v", err)
+	}
+
+	if len(records) != 4 {
+		t.Fatalf("Expected 4 branch records, got %d", len(records))
+	}
+
+	if records[0].From != "0x4005d0" || records[0].To != "0x400600" {
+		t.Errorf("Unexpected first record: %+v", records[0])
+	}
+	if !records[0].Predicted || records[0].Mispredicted {
+		t.Errorf("Expected first record to be predicted, got %+v", records[0])
+	}
+	if !records[1].Mispredicted {
+		t.Errorf("Expected second record to be mispredicted, got %+v", records[1])
+	}
+	if records[3].From != "compute+0x32" || records[3].Cycles != 12 {
+		t.Errorf("Unexpected symbolized record: %+v", records[3])
+	}
+}
+
+func TestRegisterClassifier(t *testing.T) {
+	builtinTypes := len(FrameTypes())
+	defer func() {
+		userClassifiers = nil
+		frameTypes = frameTypes[:builtinTypes]
+	}()
+
+	// User classifiers run before the built-in JIT one
+	const frameTypeNode FrameType = "nodejs"
+	RegisterClassifier(func(frame *StackFrame, module, symbol string) (Classification, bool) {
+		if strings.HasPrefix(symbol, "lazycompile:") {
+			return Classification{Type: frameTypeNode, Userland: true}, true
+		}
+		return Classification{}, false
+	}, frameTypeNode)
+
+	gotType, gotKernel, gotUser := ClassifyFrame(&StackFrame{Symbol: "LazyCompile:*handle /srv/app.js:10", Module: "/tmp/perf-1234.map"})
+	if gotType != frameTypeNode || gotKernel || !gotUser {
+		t.Errorf("Expected Node.js userland frame, got %s kernel=%v userland=%v", gotType, gotKernel, gotUser)
+	}
+
+	// Frames the user classifier does not claim fall through to the built-ins
+	gotType, _, _ = ClassifyFrame(&StackFrame{Symbol: "memcpy", Module: "/lib/x86_64-linux-gnu/libc-2.31.so"})
+	if gotType != FrameTypeLibC {
+		t.Errorf("Expected built-in libc classification, got %s", gotType)
+	}
+
+	types := FrameTypes()
+	if types[len(types)-1] != frameTypeNode {
+		t.Errorf("Expected registered type to be listed last, got %v", types)
+	}
+}
+
+func TestOpenInput(t *testing.T) {
+	content := "mysqld 12345/12346 [001] 123456.789012:     999999 cpu-clock: \n"
+	dir := t.TempDir()
+
+	plainPath := filepath.Join(dir, "perf-script.txt")
+	if err := os.WriteFile(plainPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Compressed input is detected by magic bytes, not by extension
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write([]byte(content))
+	gz.Close()
+	gzPath := filepath.Join(dir, "perf-script.dump")
+	if err := os.WriteFile(gzPath, compressed.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{plainPath, gzPath} {
+		input, err := OpenInput(path)
+		if err != nil {
+			t.Fatalf("OpenInput(%s) failed: %v", path, err)
+		}
+		got, err := io.ReadAll(input)
+		input.Close()
+		if err != nil {
+			t.Fatalf("reading %s failed: %v", path, err)
+		}
+		if string(got) != content {
+			t.Errorf("OpenInput(%s) = %q, want %q", path, got, content)
+		}
+	}
+
+	if _, err := OpenInput(filepath.Join(dir, "missing.txt")); err == nil {
+		t.Error("Expected error for missing file")
+	}
+}
+
+func TestExcludeThreads(t *testing.T) {
+	samples := []*Sample{
+		{Command: "mysqld", TID: 100},
+		{Command: "log-writer", TID: 101},
+		{Command: "log-flusher", TID: 102},
+		{Command: "metrics", TID: 103},
+		{Command: "mysqld", TID: 104},
+	}
+
+	byName, err := ParseThreadMatcher("log-*")
+	if err != nil {
+		t.Fatalf("ParseThreadMatcher failed: %v", err)
+	}
+	byTID, err := ParseThreadMatcher("103")
+	if err != nil {
+		t.Fatalf("ParseThreadMatcher failed: %v", err)
+	}
+	if byTID.TID != 103 || byName.Pattern != "log-*" {
+		t.Fatalf("Unexpected matchers: %+v %+v", byTID, byName)
+	}
+
+	kept, excluded := ExcludeThreads(samples, []*ThreadMatcher{byName, byTID})
+	if excluded != 3 {
+		t.Errorf("Expected 3 excluded samples, got %d", excluded)
+	}
+	for _, sample := range kept {
+		if sample.Command != "mysqld" {
+			t.Errorf("Unexpected sample kept: %+v", sample)
+		}
+	}
+
+	if kept, excluded := ExcludeThreads(samples, nil); excluded != 0 || len(kept) != len(samples) {
+		t.Error("Expected no samples excluded without matchers")
+	}
+
+	for _, spec := range []string{"", "0", "log-["} {
+		if _, err := ParseThreadMatcher(spec); err == nil {
+			t.Errorf("Expected error for selector %q", spec)
+		}
+	}
+}
+
+func TestSampleIsIdle(t *testing.T) {
+	tests := []struct {
+		sample   Sample
+		expected bool
+	}{
+		{Sample{Command: "swapper", PID: 0}, true},
+		{Sample{Command: "swapper/3", PID: 0}, true},
+		{Sample{Command: "mysqld", PID: 1234}, false},
+		{Sample{Command: "swapper-tool", PID: 4321}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.sample.IsIdle(); got != tt.expected {
+			t.Errorf("IsIdle(%s/%d) = %v, want %v", tt.sample.Command, tt.sample.PID, got, tt.expected)
+		}
+	}
+}
+
+func TestSampleInMMFault(t *testing.T) {
+	fault := &Sample{Stack: []StackFrame{
+		{Symbol: "clear_page_erms", IsKernel: true},
+		{Symbol: "__handle_mm_fault", IsKernel: true},
+		{Symbol: "exc_page_fault", IsKernel: true},
+		{Symbol: "memcpy", IsUserland: true},
+	}}
+	if !fault.InMMFault() {
+		t.Error("Expected a sample below __handle_mm_fault to be in MM fault code")
+	}
+
+	userland := &Sample{Stack: []StackFrame{{Symbol: "handle_mm_fault", IsUserland: true}}}
+	syscall := &Sample{Stack: []StackFrame{{Symbol: "do_syscall_64", IsKernel: true}}}
+	if userland.InMMFault() || syscall.InMMFault() {
+		t.Error("Only kernel page-fault frames should count as MM fault code")
+	}
+}
+
+func TestFilterCPUs(t *testing.T) {
+	samples := []*Sample{{CPU: 0}, {CPU: 3}, {CPU: 7}, {CPU: 8}, {CPU: 12}}
+
+	kept, removed := FilterCPUs(samples, []int{0, 1, 2, 3, 4, 5, 6, 7})
+	if len(kept) != 3 || removed != 2 {
+		t.Fatalf("Expected 3 kept and 2 removed, got %d and %d", len(kept), removed)
+	}
+	for _, sample := range kept {
+		if sample.CPU > 7 {
+			t.Errorf("Sample from CPU %d should have been filtered out", sample.CPU)
+		}
+	}
+
+	if all, removed := FilterCPUs(samples, nil); len(all) != len(samples) || removed != 0 {
+		t.Error("An empty CPU list should keep every sample")
+	}
+}
+
+func TestParsePerfStat(t *testing.T) {
+	output := "# started on Mon May  6 14:32:00 2024\n\n" +
+		"2000000,,cycles,1000000000,100.00,,\n" +
+		"3000000,,instructions:u,1000000000,100.00,1.50,insn per cycle\n" +
+		"<not supported>,,cache-misses,0,100.00,,\n" +
+		"1500,,cpu_core/branch-misses/,1000000000,100.00,,\n" +
+		"500,,cpu_atom/branch-misses/,1000000000,100.00,,\n" +
+		"42,,context-switches,1000000000,100.00,0.042,K/sec\n"
+
+	counters := ParsePerfStat(output)
+	expected := map[string]float64{
+		"cycles":           2000000,
+		"instructions":     3000000,
+		"branch-misses":    2000,
+		"context-switches": 42,
+	}
+	if len(counters) != len(expected) {
+		t.Errorf("Expected %d counters, got %v", len(expected), counters)
+	}
+	for event, value := range expected {
+		if counters[event] != value {
+			t.Errorf("Expected %s = %.0f, got %.0f", event, value, counters[event])
+		}
+	}
+}
+
+func TestRedactor(t *testing.T) {
+	if _, err := NewRedactor([]string{"("}); err == nil {
+		t.Error("Expected an invalid pattern to be rejected")
+	}
+	if r, err := NewRedactor(nil); r != nil || err != nil {
+		t.Errorf("Expected no redactor without patterns, got %v, %v", r, err)
+	}
+
+	r, err := NewRedactor([]string{"^acme::", "/opt/acme/"})
+	if err != nil {
+		t.Fatalf("NewRedactor failed: %v", err)
+	}
+
+	masked := r.Name("acme::billing::charge")
+	if !strings.HasPrefix(masked, redactedPrefix) || masked != r.Name("acme::billing::charge") {
+		t.Errorf("Expected a stable redacted token, got %q", masked)
+	}
+	if r.Name("malloc") != "malloc" {
+		t.Error("Expected non-matching names to be kept")
+	}
+	if r.Name(masked) != masked {
+		t.Error("Expected redacted tokens not to be redacted again")
+	}
+
+	samples := []*Sample{{Stack: []StackFrame{
+		{Symbol: "acme::billing::charge", Module: "/opt/acme/bin/billing"},
+		{Symbol: "main", Module: "/usr/bin/app"},
+	}}}
+	r.Samples(samples)
+	if samples[0].Stack[0].Symbol != masked || !strings.HasPrefix(samples[0].Stack[0].Module, redactedPrefix) {
+		t.Errorf("Expected the internal frame to be redacted, got %+v", samples[0].Stack[0])
+	}
+	if samples[0].Stack[1].Symbol != "main" || samples[0].Stack[1].Module != "/usr/bin/app" {
+		t.Errorf("Expected the other frame to be kept, got %+v", samples[0].Stack[1])
+	}
+
+	folded := r.Folded("main;acme::billing::charge 12\nmain;malloc 3\n")
+	if folded != "main;"+masked+" 12\nmain;malloc 3\n" {
+		t.Errorf("Unexpected folded stacks: %q", folded)
+	}
+
+	var none *Redactor
+	if none.Name("acme::billing::charge") != "acme::billing::charge" {
+		t.Error("Expected a nil redactor to keep names")
+	}
+}
+
+func TestParsePerfScriptInvalidUTF8(t *testing.T) {
+	input := "app 1/1 [000] 1.000000:     1 cpu-clock: \n" +
+		"\t    4005d0 bad\xffname+0x10 (/opt/app\xfe/bin/app)\n"
+
+	samples, err := ParsePerfScript(input)
+	if err != nil {
+		t.Fatalf("ParsePerfScript failed: %v", err)
+	}
+	if len(samples) != 1 || len(samples[0].Stack) != 1 {
+		t.Fatalf("Expected 1 sample with 1 frame, got %+v", samples)
+	}
+	frame := samples[0].Stack[0]
+	if frame.Symbol != "bad�name" || frame.Module != "/opt/app�/bin/app" {
+		t.Errorf("Expected invalid bytes to be replaced, got %q in %q", frame.Symbol, frame.Module)
+	}
+}
+
+func TestClassifyGoBinaryAssembly(t *testing.T) {
+	// A capture from another host: /opt/app/bin/api is not on this one.
+	// The runtime function sampled after it makes the first gcWriteBarrier
+	// Go runtime assembly too.
+	content := `api 100/101 [000] 1.000000:     1 cycles:
+	    4005d0 gcWriteBarrier+0x10 (/opt/app/bin/api)
+	    4005e0 main.handle+0x20 (/opt/app/bin/api)
+
+api 100/101 [000] 1.001000:     1 cycles:
+	    4005f0 runtime.mallocgc+0x30 (/opt/app/bin/api)
+
+nginx 200/201 [001] 1.002000:     1 cycles:
+	    5005d0 epoll_loop+0x10 (/usr/sbin/nginx)
+`
+	samples, err := ParsePerfScript(content)
+	if err != nil || len(samples) != 3 {
+		t.Fatalf("Expected 3 samples, got %d (%v)", len(samples), err)
+	}
+	if got := samples[0].Stack[0].Type; got != FrameTypeGoRuntime {
+		t.Errorf("Expected %s for runtime assembly in a Go binary, got %s", FrameTypeGoRuntime, got)
+	}
+	if got := samples[0].Stack[1].Type; got != FrameTypeApplication {
+		t.Errorf("Expected %s for Go application code, got %s", FrameTypeApplication, got)
+	}
+	if got := samples[2].Stack[0].Type; got != FrameTypeApplication {
+		t.Errorf("Expected a non-Go binary not to be classified as %s, got %s", FrameTypeGoRuntime, got)
+	}
+}
@@ -0,0 +1,52 @@
+package parser
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+)
+
+// ParsePerfStat parses the CSV output of `perf stat -x ,` and returns the
+// counter values by event name.
+//
+// Each counter is one line, value first and event name third:
+//
+//	1234567,,cycles,2003401122,100.00,,
+//	<not supported>,,cache-misses,0,100.00,,
+//
+// Counters that were not supported or not counted are left out. Event
+// modifiers (cycles:u) are dropped and PMU-qualified events on hybrid CPUs
+// (cpu_core/cycles/, cpu_atom/cycles/) are added up under the plain name.
+func ParsePerfStat(content string) map[string]float64 {
+	counters := make(map[string]float64)
+	scanner := bufio.NewScanner(strings.NewReader(content))
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) < 3 {
+			continue
+		}
+		value, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			continue
+		}
+
+		event := fields[2]
+		if parts := strings.Split(event, "/"); len(parts) >= 3 {
+			event = parts[1]
+		}
+		if idx := strings.Index(event, ":"); idx >= 0 {
+			event = event[:idx]
+		}
+		if event == "" {
+			continue
+		}
+		counters[event] += value
+	}
+
+	return counters
+}
@@ -0,0 +1,101 @@
+package parser
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// redactedPrefix starts every name replaced by a Redactor
+const redactedPrefix = "redacted_"
+
+// Redactor masks symbol and module names matching any of its patterns, so
+// that profiles can be shared without exposing internal code names. A name
+// is replaced by a short hash of itself: the same name always maps to the
+// same token, which keeps stacks, counts and percentages intact.
+//
+// A nil *Redactor leaves every name unchanged.
+type Redactor struct {
+	patterns []*regexp.Regexp
+}
+
+// NewRedactor compiles the patterns (regular expressions, e.g. "^acme::" or
+// "/opt/acme/") into a Redactor. It returns nil when there are no patterns.
+func NewRedactor(patterns []string) (*Redactor, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	r := &Redactor{}
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redact pattern %q: %v", pattern, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+// Name returns name, or its redacted token when it matches a pattern
+func (r *Redactor) Name(name string) string {
+	if r == nil || name == "" || strings.HasPrefix(name, redactedPrefix) {
+		return name
+	}
+	for _, re := range r.patterns {
+		if re.MatchString(name) {
+			sum := sha256.Sum256([]byte(name))
+			return redactedPrefix + hex.EncodeToString(sum[:4])
+		}
+	}
+	return name
+}
+
+// Samples redacts the symbol and module of every stack frame in place
+func (r *Redactor) Samples(samples []*Sample) {
+	if r == nil {
+		return
+	}
+	for _, sample := range samples {
+		for i := range sample.Stack {
+			frame := &sample.Stack[i]
+			symbol := r.Name(frame.Symbol)
+			if frame.RawSymbol != "" {
+				// A pattern written against the demangled name hides the
+				// mangled one too
+				if symbol != frame.Symbol {
+					frame.RawSymbol = symbol
+				} else {
+					frame.RawSymbol = r.Name(frame.RawSymbol)
+				}
+			}
+			frame.Symbol = symbol
+			frame.Module = r.Name(frame.Module)
+		}
+	}
+}
+
+// Folded redacts the frames of folded stacks ("frame;frame;frame count")
+func (r *Redactor) Folded(folded string) string {
+	if r == nil {
+		return folded
+	}
+
+	var out strings.Builder
+	for _, line := range strings.SplitAfter(folded, "\n") {
+		body := strings.TrimSuffix(line, "\n")
+		sep := strings.LastIndex(body, " ")
+		if sep <= 0 {
+			out.WriteString(line)
+			continue
+		}
+		frames := strings.Split(body[:sep], ";")
+		for i, frame := range frames {
+			frames[i] = r.Name(frame)
+		}
+		out.WriteString(strings.Join(frames, ";"))
+		out.WriteString(line[sep:])
+	}
+	return out.String()
+}
@@ -0,0 +1,67 @@
+package parser
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// ThreadMatcher selects samples by thread ID or by thread name (comm) pattern
+type ThreadMatcher struct {
+	TID     int    // Matches this TID when > 0
+	Pattern string // Shell glob matched against the sample command, e.g. "log-*"
+}
+
+// ParseThreadMatcher parses a thread selector. A number is taken as a TID,
+// anything else as a glob pattern on the thread name.
+func ParseThreadMatcher(spec string) (*ThreadMatcher, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, fmt.Errorf("empty thread selector")
+	}
+
+	if tid, err := strconv.Atoi(spec); err == nil {
+		if tid <= 0 {
+			return nil, fmt.Errorf("invalid thread ID %d", tid)
+		}
+		return &ThreadMatcher{TID: tid}, nil
+	}
+
+	if _, err := path.Match(spec, ""); err != nil {
+		return nil, fmt.Errorf("invalid thread pattern %q: %v", spec, err)
+	}
+	return &ThreadMatcher{Pattern: spec}, nil
+}
+
+// Matches reports whether the sample belongs to the selected thread(s)
+func (m *ThreadMatcher) Matches(sample *Sample) bool {
+	if m.TID > 0 {
+		return sample.TID == m.TID
+	}
+	matched, _ := path.Match(m.Pattern, sample.Command)
+	return matched
+}
+
+// ExcludeThreads drops the samples matching any of the matchers and returns
+// the remaining samples together with the number of samples removed
+func ExcludeThreads(samples []*Sample, matchers []*ThreadMatcher) ([]*Sample, int) {
+	if len(matchers) == 0 {
+		return samples, 0
+	}
+
+	kept := make([]*Sample, 0, len(samples))
+	for _, sample := range samples {
+		excluded := false
+		for _, m := range matchers {
+			if m.Matches(sample) {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			kept = append(kept, sample)
+		}
+	}
+	return kept, len(samples) - len(kept)
+}
@@ -0,0 +1,50 @@
+package process
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxCPUs es el tamaño de la máscara de afinidad (igual que CPU_SETSIZE)
+const maxCPUs = 1024
+
+// ParseCPUList parsea una lista de CPUs en el formato de taskset/cpuset
+// ("0-3,8,10-11") y devuelve las CPUs ordenadas y sin duplicados.
+func ParseCPUList(list string) ([]int, error) {
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(list, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			return nil, fmt.Errorf("invalid CPU list %q: empty entry", list)
+		}
+
+		first, last := part, part
+		if dash := strings.Index(part, "-"); dash >= 0 {
+			first, last = part[:dash], part[dash+1:]
+		}
+		start, err := strconv.Atoi(first)
+		if err != nil || start < 0 {
+			return nil, fmt.Errorf("invalid CPU list %q: bad CPU %q", list, first)
+		}
+		end, err := strconv.Atoi(last)
+		if err != nil || end < start {
+			return nil, fmt.Errorf("invalid CPU list %q: bad range %q", list, part)
+		}
+		if end >= maxCPUs {
+			return nil, fmt.Errorf("invalid CPU list %q: CPU %d out of range", list, end)
+		}
+
+		for cpu := start; cpu <= end; cpu++ {
+			seen[cpu] = true
+		}
+	}
+
+	cpus := make([]int, 0, len(seen))
+	for cpu := range seen {
+		cpus = append(cpus, cpu)
+	}
+	sort.Ints(cpus)
+	return cpus, nil
+}
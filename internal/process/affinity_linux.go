@@ -0,0 +1,37 @@
+package process
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// SetSelfAffinity fija el analizador a las CPUs indicadas con
+// sched_setaffinity. La máscara se aplica a cada hilo del proceso; los hilos
+// nuevos y los procesos hijos (perf, flamegraph.pl) la heredan, así que la
+// medición queda fuera de esas CPUs.
+func SetSelfAffinity(cpus []int) error {
+	var mask [maxCPUs / 64]uint64
+	for _, cpu := range cpus {
+		mask[cpu/64] |= 1 << (uint(cpu) % 64)
+	}
+
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return fmt.Errorf("error listing threads: %v", err)
+	}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY,
+			uintptr(tid), unsafe.Sizeof(mask), uintptr(unsafe.Pointer(&mask[0])))
+		if errno != 0 {
+			return fmt.Errorf("error setting CPU affinity to %v: %v (are those CPUs online?)", cpus, errno)
+		}
+	}
+	return nil
+}
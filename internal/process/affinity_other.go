@@ -0,0 +1,10 @@
+//go:build !linux
+
+package process
+
+import "fmt"
+
+// SetSelfAffinity solo está disponible en Linux
+func SetSelfAffinity(cpus []int) error {
+	return fmt.Errorf("--self-affinity is only supported on Linux")
+}
@@ -0,0 +1,41 @@
+package process
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCPUList(t *testing.T) {
+	tests := []struct {
+		list     string
+		expected []int
+		wantErr  bool
+	}{
+		{list: "0", expected: []int{0}},
+		{list: "0-3,8", expected: []int{0, 1, 2, 3, 8}},
+		{list: "10-11, 2,2", expected: []int{2, 10, 11}},
+		{list: "", wantErr: true},
+		{list: "1,", wantErr: true},
+		{list: "3-1", wantErr: true},
+		{list: "a-b", wantErr: true},
+		{list: "-1", wantErr: true},
+		{list: "0-4096", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		cpus, err := ParseCPUList(tt.list)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseCPUList(%q) expected error, got %v", tt.list, cpus)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseCPUList(%q) unexpected error: %v", tt.list, err)
+			continue
+		}
+		if !reflect.DeepEqual(cpus, tt.expected) {
+			t.Errorf("ParseCPUList(%q) = %v, want %v", tt.list, cpus, tt.expected)
+		}
+	}
+}
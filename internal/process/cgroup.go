@@ -0,0 +1,98 @@
+package process
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// CgroupOf devuelve el cgroup de un proceso leyendo /proc/<pid>/cgroup (por
+// ejemplo, "/system.slice/nginx.service" o "/kubepods/burstable/pod.../<id>").
+// El proceso tiene que seguir vivo.
+func CgroupOf(pid int) (string, error) {
+	content, err := os.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return "", fmt.Errorf("error reading cgroup of PID %d: %v", pid, err)
+	}
+
+	cgroup := parseCgroup(string(content))
+	if cgroup == "" {
+		return "", fmt.Errorf("no cgroup found for PID %d", pid)
+	}
+	return cgroup, nil
+}
+
+// parseCgroup extrae la ruta del cgroup del contenido de /proc/<pid>/cgroup.
+// Con cgroup v2 hay una única línea "0::/ruta". Con v1 hay una línea por
+// jerarquía ("4:cpu,cpuacct:/ruta") y se usa la del controlador cpu, que es
+// la que reparte el tiempo de CPU; si no existe, la primera línea.
+func parseCgroup(content string) string {
+	var first string
+	for _, line := range strings.Split(strings.TrimSpace(content), "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		hierarchy, controllers, path := parts[0], parts[1], parts[2]
+
+		if hierarchy == "0" && controllers == "" {
+			return path
+		}
+		for _, controller := range strings.Split(controllers, ",") {
+			if controller == "cpu" {
+				return path
+			}
+		}
+		if first == "" {
+			first = path
+		}
+	}
+	return first
+}
+
+// ContainerCgroup devuelve el cgroup de un contenedor (Docker, Podman,
+// containerd, CRI-O) a partir de su ID completo o abreviado, como lo muestra
+// `docker ps`. Busca en /proc un proceso cuyo cgroup termine con el ID
+// (/docker/<id>, /system.slice/docker-<id>.scope, .../cri-containerd-<id>.scope),
+// así que no hace falta el CLI del runtime y la ruta se ve desde el host,
+// fuera del namespace de PIDs del contenedor.
+func ContainerCgroup(id string) (string, error) {
+	id = strings.ToLower(strings.TrimSpace(id))
+	if len(id) < 4 || strings.Trim(id, "0123456789abcdef") != "" {
+		return "", fmt.Errorf("invalid container ID %q (expected the hexadecimal ID shown by docker ps)", id)
+	}
+
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return "", fmt.Errorf("error reading /proc: %v", err)
+	}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		// Los procesos pueden terminar mientras se recorre /proc
+		cgroup, err := CgroupOf(pid)
+		if err == nil && isContainerCgroup(cgroup, id) {
+			return cgroup, nil
+		}
+	}
+	return "", fmt.Errorf("%w: no process found in container %s", ErrProcessNotFound, id)
+}
+
+// isContainerCgroup indica si cgroup es el del contenedor id. El ID completo
+// es el último componente de la ruta, solo o tras el prefijo del runtime y
+// con .scope al final; Podman sin root agrega un subgrupo "container".
+func isContainerCgroup(cgroup, id string) bool {
+	name := path.Base(cgroup)
+	if name == "container" {
+		name = path.Base(path.Dir(cgroup))
+	}
+	name = strings.TrimSuffix(name, ".scope")
+	if i := strings.LastIndex(name, "-"); i >= 0 {
+		name = name[i+1:]
+	}
+	return len(name) >= len(id) && strings.HasPrefix(name, id)
+}
@@ -0,0 +1,45 @@
+package process
+
+import "testing"
+
+func TestParseCgroup(t *testing.T) {
+	tests := map[string]string{
+		"0::/system.slice/nginx.service\n":                                                "/system.slice/nginx.service",
+		"12:pids:/docker/abc\n4:cpu,cpuacct:/docker/abc123\n1:name=systemd:/docker/abc\n": "/docker/abc123",
+		"3:memory:/kubepods/pod1\n":                                                       "/kubepods/pod1",
+		"garbage\n":                                                                       "",
+	}
+	for content, expected := range tests {
+		if got := parseCgroup(content); got != expected {
+			t.Errorf("parseCgroup(%q) = %q, want %q", content, got, expected)
+		}
+	}
+}
+
+func TestIsContainerCgroup(t *testing.T) {
+	id := "3f4e5d6c7b8a"
+	matching := []string{
+		"/docker/3f4e5d6c7b8a91d2e3f4a5b6c7d8e9f0a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6",
+		"/system.slice/docker-3f4e5d6c7b8a91d2e3f4a5b6c7d8e9f0a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6.scope",
+		"/kubepods.slice/kubepods-burstable.slice/cri-containerd-3f4e5d6c7b8a91d2.scope",
+		"/user.slice/user-1000.slice/user@1000.service/user.slice/libpod-3f4e5d6c7b8a91d2.scope/container",
+	}
+	for _, cgroup := range matching {
+		if !isContainerCgroup(cgroup, id) {
+			t.Errorf("Expected %s to belong to container %s", cgroup, id)
+		}
+	}
+	for _, cgroup := range []string{"/system.slice/nginx.service", "/docker/aaaa3f4e5d6c7b8a", "/"} {
+		if isContainerCgroup(cgroup, id) {
+			t.Errorf("Expected %s not to belong to container %s", cgroup, id)
+		}
+	}
+}
+
+func TestContainerCgroupInvalidID(t *testing.T) {
+	for _, id := range []string{"", "abc", "mariadb", "../etc"} {
+		if _, err := ContainerCgroup(id); err == nil {
+			t.Errorf("Expected container ID %q to be rejected", id)
+		}
+	}
+}
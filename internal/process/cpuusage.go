@@ -0,0 +1,48 @@
+package process
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicks es USER_HZ, la unidad de utime/stime en /proc/<pid>/stat. Vale
+// 100 en todas las arquitecturas que soporta Linux hoy.
+const clockTicks = 100
+
+// CPUTime devuelve el tiempo de CPU (usuario + sistema) consumido por todos
+// los hilos de un proceso, leído de /proc/<pid>/stat
+func CPUTime(pid int) (time.Duration, error) {
+	content, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, fmt.Errorf("%w: error reading /proc/%d/stat: %v", ErrProcessNotFound, pid, err)
+	}
+	return parseStatCPUTime(string(content))
+}
+
+// parseStatCPUTime extrae utime y stime (campos 14 y 15) de una línea de
+// /proc/<pid>/stat. El nombre del proceso (campo 2) va entre paréntesis y
+// puede contener espacios, así que se cuenta desde el último ')'.
+func parseStatCPUTime(content string) (time.Duration, error) {
+	end := strings.LastIndex(content, ")")
+	if end < 0 {
+		return 0, fmt.Errorf("malformed /proc stat line")
+	}
+	fields := strings.Fields(content[end+1:])
+	// fields[0] es el campo 3 (estado), así que utime y stime son 11 y 12
+	if len(fields) < 13 {
+		return 0, fmt.Errorf("malformed /proc stat line: %d fields", len(fields)+2)
+	}
+
+	utime, err := strconv.ParseUint(fields[11], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid utime %q: %v", fields[11], err)
+	}
+	stime, err := strconv.ParseUint(fields[12], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid stime %q: %v", fields[12], err)
+	}
+	return time.Duration(utime+stime) * time.Second / clockTicks, nil
+}
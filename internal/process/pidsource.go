@@ -0,0 +1,56 @@
+package process
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// ReadPidFile lee el PID de un pidfile (por ejemplo, /run/nginx.pid). Solo se
+// usa la primera línea, como hacen los scripts de init.
+func ReadPidFile(path string) (int, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("error reading pidfile: %v", err)
+	}
+
+	line := strings.TrimSpace(strings.SplitN(string(content), "\n", 2)[0])
+	pid, err := strconv.Atoi(line)
+	if err != nil || pid < 1 {
+		return 0, fmt.Errorf("pidfile %s does not contain a valid PID (%q)", path, line)
+	}
+	return pid, nil
+}
+
+// GetPidBySystemdUnit devuelve el PID principal de una unidad de systemd
+// (por ejemplo, "mariadb.service") consultando `systemctl show -p MainPID`.
+// Si la unidad no está corriendo, MainPID es 0 y se devuelve ErrProcessNotFound.
+func GetPidBySystemdUnit(unit string) (int, error) {
+	cmd := exec.Command("systemctl", "show", "-p", "MainPID", unit)
+	output, err := cmd.Output()
+	if err != nil {
+		return 0, fmt.Errorf("error running systemctl show for unit '%s': %v", unit, err)
+	}
+
+	pid, err := parseMainPID(string(output))
+	if err != nil {
+		return 0, fmt.Errorf("unexpected systemctl output for unit '%s': %v", unit, err)
+	}
+	if pid == 0 {
+		return 0, fmt.Errorf("%w: systemd unit '%s' has no main PID (not running?)", ErrProcessNotFound, unit)
+	}
+	return pid, nil
+}
+
+// parseMainPID parsea la salida de `systemctl show -p MainPID` ("MainPID=1234")
+func parseMainPID(output string) (int, error) {
+	value := strings.TrimSpace(output)
+	value = strings.TrimPrefix(value, "MainPID=")
+	pid, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid MainPID %q", value)
+	}
+	return pid, nil
+}
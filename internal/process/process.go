@@ -1,50 +1,150 @@
 package process
 
 import (
+	"errors"
 	"fmt"
 	"os/exec"
 	"strconv"
 	"strings"
 )
 
+// ErrProcessNotFound indica que el proceso objetivo no existe (o ya terminó)
+var ErrProcessNotFound = errors.New("process not found")
+
+// ErrMultipleProcesses indica que varios procesos coinciden con el nombre y
+// no hay forma de saber cuál es el objetivo
+var ErrMultipleProcesses = errors.New("several processes match")
+
+// Match es un proceso encontrado por nombre: su PID y su comando
+type Match struct {
+	PID     int
+	Command string
+}
+
 // GetPidByName busca el PID de un proceso a partir de su nombre (por ejemplo, "mariadbd") usando pgrep (o ps si pgrep no está disponible) y devuelve el PID (o un error si no se encuentra).
-func GetPidByName(processName string) (int, error) {
-	// Intentar usar pgrep (más rápido y común en Linux)
-	cmd := exec.Command("pgrep", processName)
+// Con exact el nombre tiene que coincidir completo; si no, basta con que lo contenga (mysql encuentra mysqld_safe).
+// Si varios procesos coinciden devuelve ErrMultipleProcesses con la lista de candidatos, en lugar de elegir uno.
+func GetPidByName(processName string, exact bool) (int, error) {
+	matches, err := FindProcesses(processName, exact)
+	if err != nil {
+		return 0, err
+	}
+	if len(matches) > 1 {
+		return 0, MultipleProcessesError(processName, matches)
+	}
+	return matches[0].PID, nil
+}
+
+// FindProcesses devuelve todos los procesos cuyo nombre coincide (por ejemplo, los workers de nginx), en el orden en que los lista pgrep (o ps si pgrep no está disponible).
+// Con exact se usa pgrep -x: el nombre del proceso (comm) tiene que ser exactamente processName.
+func FindProcesses(processName string, exact bool) ([]Match, error) {
+	// Intentar usar pgrep (más rápido y común en Linux); -l agrega el nombre de cada proceso
+	args := []string{"-l"}
+	if exact {
+		args = append(args, "-x")
+	}
+	cmd := exec.Command("pgrep", append(args, processName)...)
 	output, err := cmd.Output()
 	if err == nil {
-		// pgrep devuelve el PID (o varios, uno por línea) en su salida estándar.
-		// Aquí asumimos que solo se devuelve un PID (el primero si hay varios).
-		pidStr := strings.TrimSpace(string(output))
-		lines := strings.Split(pidStr, "\n")
-		if len(lines) == 0 || lines[0] == "" {
-			return 0, fmt.Errorf("no process found with name '%s'", processName)
-		}
-		pid, err := strconv.Atoi(lines[0])
+		matches, err := parsePgrepOutput(string(output))
 		if err != nil {
-			return 0, fmt.Errorf("error parsing pgrep output ('%v'): %v", lines[0], err)
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("%w with name '%s'", ErrProcessNotFound, processName)
+		}
+		return matches, nil
+	}
+
+	if exact {
+		// Sin pgrep, ps lista el nombre (comm) de cada proceso con el mismo formato que pgrep -l
+		output, err := exec.Command("ps", "-e", "-o", "pid=,comm=").Output()
+		if err != nil {
+			return nil, fmt.Errorf("error running ps (or pgrep) for '%s': %v", processName, err)
+		}
+		all, err := parsePgrepOutput(string(output))
+		if err != nil {
+			return nil, err
+		}
+		var matches []Match
+		for _, match := range all {
+			if match.Command == processName {
+				matches = append(matches, match)
+			}
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("%w with name '%s'", ErrProcessNotFound, processName)
 		}
-		return pid, nil
+		return matches, nil
 	}
 
 	// Si pgrep falla (por ejemplo, no está instalado o no se encuentra el proceso), intentar con "ps" (más lento pero más común).
 	// Ejemplo: "ps aux | grep [m]ariadbd" (usando "[" para evitar que grep se capture a sí mismo).
 	cmd = exec.Command("sh", "-c", fmt.Sprintf("ps aux | grep [%c]%s", processName[0], processName[1:]))
 	output, err = cmd.Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		// grep sale con 1 cuando ninguna línea coincide
+		return nil, fmt.Errorf("%w with name '%s'", ErrProcessNotFound, processName)
+	}
 	if err != nil {
 		// Si "ps" también falla, devolver un error.
-		return 0, fmt.Errorf("error running ps (or pgrep) for '%s': %v", processName, err)
+		return nil, fmt.Errorf("error running ps (or pgrep) for '%s': %v", processName, err)
 	}
-	// "ps" devuelve líneas (una por proceso) con el PID en la segunda columna (índice 1).
-	// Aquí asumimos que solo se devuelve una línea (el primer proceso encontrado).
-	fields := strings.Fields(string(output))
-	if len(fields) < 2 {
-		return 0, fmt.Errorf("no process found (or ps output unexpected) for '%s'", processName)
+	return parsePsPids(string(output), processName)
+}
+
+// MultipleProcessesError devuelve un error ErrMultipleProcesses que lista los
+// candidatos, uno por línea, para que el usuario elija
+func MultipleProcessesError(processName string, matches []Match) error {
+	var list strings.Builder
+	for _, match := range matches {
+		list.WriteString(fmt.Sprintf("\n  %d %s", match.PID, match.Command))
 	}
-	pidStr := fields[1]
-	pid, err := strconv.Atoi(pidStr)
-	if err != nil {
-		return 0, fmt.Errorf("error parsing ps output (%s): %v", pidStr, err)
+	return fmt.Errorf("%w name '%s' (%d):%s", ErrMultipleProcesses, processName, len(matches), list.String())
+}
+
+// parsePgrepOutput extrae los procesos de la salida de "pgrep -l" (o "ps -o pid=,comm="): una línea "PID nombre" por proceso.
+func parsePgrepOutput(output string) ([]Match, error) {
+	var matches []Match
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		pidField, command, _ := strings.Cut(line, " ")
+		pid, err := strconv.Atoi(pidField)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing pgrep output ('%v'): %v", line, err)
+		}
+		matches = append(matches, Match{PID: pid, Command: strings.TrimSpace(command)})
+	}
+	return matches, nil
+}
+
+// parsePsPids extrae los procesos de la salida de "ps aux": una línea por proceso, con el PID en la segunda columna (índice 1) y la línea de comandos desde la columna 11 (índice 10).
+func parsePsPids(output, processName string) ([]Match, error) {
+	var matches []Match
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%w (or ps output unexpected) for '%s'", ErrProcessNotFound, processName)
+		}
+		pid, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("error parsing ps output (%s): %v", fields[1], err)
+		}
+		match := Match{PID: pid}
+		if len(fields) > 10 {
+			match.Command = strings.Join(fields[10:], " ")
+		}
+		matches = append(matches, match)
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("%w (or ps output unexpected) for '%s'", ErrProcessNotFound, processName)
 	}
-	return pid, nil
+	return matches, nil
 }
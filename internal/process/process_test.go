@@ -0,0 +1,131 @@
+package process
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetPidByNameNotFound(t *testing.T) {
+	// Built at run time so no command line running the test contains it
+	name := fmt.Sprintf("blc-missing-%d", os.Getpid())
+
+	_, err := GetPidByName(name, false)
+	if !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("Expected ErrProcessNotFound, got %v", err)
+	}
+}
+
+func TestFindProcessesNotFound(t *testing.T) {
+	name := fmt.Sprintf("blc-missing-%d", os.Getpid())
+
+	for _, exact := range []bool{false, true} {
+		if _, err := FindProcesses(name, exact); !errors.Is(err, ErrProcessNotFound) {
+			t.Errorf("Expected ErrProcessNotFound (exact=%v), got %v", exact, err)
+		}
+	}
+}
+
+func TestParsePgrepOutput(t *testing.T) {
+	output := "1200 mysqld_safe\n1201 mysqld\n1300 mysql_exporter\n\n"
+	matches, err := parsePgrepOutput(output)
+	if err != nil {
+		t.Fatalf("parsePgrepOutput failed: %v", err)
+	}
+	expected := []Match{{1200, "mysqld_safe"}, {1201, "mysqld"}, {1300, "mysql_exporter"}}
+	if fmt.Sprint(matches) != fmt.Sprint(expected) {
+		t.Errorf("parsePgrepOutput() = %v, want %v", matches, expected)
+	}
+
+	// ps -o pid=,comm= right-aligns the PID
+	if matches, err := parsePgrepOutput("    1 systemd\n  842 my worker\n"); err != nil || fmt.Sprint(matches) != "[{1 systemd} {842 my worker}]" {
+		t.Errorf("Unexpected ps output parse: %v (%v)", matches, err)
+	}
+	if _, err := parsePgrepOutput("pgrep: invalid option\n"); err == nil {
+		t.Error("Expected an error for a line without a PID")
+	}
+
+	err = MultipleProcessesError("mysql", matches)
+	if !errors.Is(err, ErrMultipleProcesses) {
+		t.Errorf("Expected ErrMultipleProcesses, got %v", err)
+	}
+	for _, candidate := range []string{"\n  1200 mysqld_safe", "\n  1201 mysqld", "\n  1300 mysql_exporter"} {
+		if !strings.Contains(err.Error(), candidate) {
+			t.Errorf("Error does not list %q:\n%v", candidate, err)
+		}
+	}
+}
+
+func TestParsePsPids(t *testing.T) {
+	output := `www-data  1201  0.0  0.1  55280  5120 ?  S  10:00  0:01 nginx: worker process
+www-data  1202  0.0  0.1  55280  5120 ?  S  10:00  0:01 nginx: worker process
+root      1200  0.0  0.0  55000  1500 ?  Ss 10:00  0:00 nginx: master process /usr/sbin/nginx
+`
+	matches, err := parsePsPids(output, "nginx")
+	if err != nil {
+		t.Fatalf("parsePsPids failed: %v", err)
+	}
+	if len(matches) != 3 || matches[0].PID != 1201 || matches[1].PID != 1202 || matches[2].PID != 1200 {
+		t.Errorf("parsePsPids() = %v, want PIDs 1201, 1202, 1200", matches)
+	}
+	if matches[2].Command != "nginx: master process /usr/sbin/nginx" {
+		t.Errorf("Unexpected command %q", matches[2].Command)
+	}
+	if _, err := parsePsPids("\n", "nginx"); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("Expected ErrProcessNotFound for empty output, got %v", err)
+	}
+}
+
+func TestReadPidFile(t *testing.T) {
+	dir := t.TempDir()
+
+	valid := filepath.Join(dir, "app.pid")
+	os.WriteFile(valid, []byte("4321\n"), 0644)
+	if pid, err := ReadPidFile(valid); err != nil || pid != 4321 {
+		t.Errorf("Expected PID 4321, got %d (%v)", pid, err)
+	}
+
+	invalid := filepath.Join(dir, "bad.pid")
+	os.WriteFile(invalid, []byte("not-a-pid\n"), 0644)
+	if _, err := ReadPidFile(invalid); err == nil {
+		t.Error("Expected an error for a pidfile without a PID")
+	}
+
+	if _, err := ReadPidFile(filepath.Join(dir, "missing.pid")); err == nil {
+		t.Error("Expected an error for a missing pidfile")
+	}
+}
+
+func TestParseMainPID(t *testing.T) {
+	tests := map[string]int{
+		"MainPID=1234\n": 1234,
+		"MainPID=0\n":    0,
+		"987":            987,
+	}
+	for output, expected := range tests {
+		if pid, err := parseMainPID(output); err != nil || pid != expected {
+			t.Errorf("parseMainPID(%q) = %d, %v; want %d", output, pid, err, expected)
+		}
+	}
+	if _, err := parseMainPID("MainPID=\n"); err == nil {
+		t.Error("Expected an error for an empty MainPID")
+	}
+}
+
+func TestParseStatCPUTime(t *testing.T) {
+	line := "4321 (my worker) S 1 4321 4321 0 -1 4194560 2500 0 0 0 250 75 0 0 20 0 8 0 12345 1000000 500 18446744073709551615\n"
+	if cpu, err := parseStatCPUTime(line); err != nil || cpu != 3250*time.Millisecond {
+		t.Errorf("Expected 3.25s of CPU time, got %v (%v)", cpu, err)
+	}
+	if _, err := parseStatCPUTime("4321 (short) S 1"); err == nil {
+		t.Error("Expected an error for a truncated stat line")
+	}
+
+	if cpu, err := CPUTime(os.Getpid()); err != nil || cpu < 0 {
+		t.Errorf("Expected the CPU time of the test process, got %v (%v)", cpu, err)
+	}
+}
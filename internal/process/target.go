@@ -0,0 +1,95 @@
+package process
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// TargetInfo describe cómo fue lanzado el proceso analizado
+type TargetInfo struct {
+	Cmdline []string          `json:"cmdline"`
+	Env     map[string]string `json:"env,omitempty"`
+}
+
+// redactedValue reemplaza el valor de las variables de entorno sensibles
+const redactedValue = "<redacted>"
+
+// secretEnvMarkers son fragmentos de nombre que identifican variables con secretos
+var secretEnvMarkers = []string{"PASSWORD", "PASSWD", "SECRET", "TOKEN", "KEY", "CREDENTIAL", "AUTH", "PRIVATE", "COOKIE", "SESSION"}
+
+// GetTargetInfo lee /proc/<pid>/cmdline y /proc/<pid>/environ. Los valores de
+// variables cuyo nombre parece contener secretos se reemplazan por <redacted>.
+// El entorno puede no ser legible (procesos de otro usuario); en ese caso se
+// devuelve solo la línea de comandos.
+func GetTargetInfo(pid int) (*TargetInfo, error) {
+	cmdline, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return nil, fmt.Errorf("error reading cmdline of PID %d: %v", pid, err)
+	}
+
+	info := &TargetInfo{Cmdline: splitNul(cmdline)}
+
+	if environ, err := os.ReadFile(fmt.Sprintf("/proc/%d/environ", pid)); err == nil {
+		info.Env = parseEnviron(environ)
+	}
+
+	return info, nil
+}
+
+// IsJVM indica si el objetivo es una máquina virtual Java lanzada con el
+// binario java
+func (t *TargetInfo) IsJVM() bool {
+	return len(t.Cmdline) > 0 && filepath.Base(t.Cmdline[0]) == "java"
+}
+
+// HasArg indica si la línea de comandos incluye el argumento arg
+func (t *TargetInfo) HasArg(arg string) bool {
+	for _, a := range t.Cmdline {
+		if a == arg {
+			return true
+		}
+	}
+	return false
+}
+
+// parseEnviron convierte el contenido de /proc/<pid>/environ en un mapa,
+// ocultando los valores sensibles
+func parseEnviron(environ []byte) map[string]string {
+	env := make(map[string]string)
+	for _, entry := range splitNul(environ) {
+		name, value, found := strings.Cut(entry, "=")
+		if !found || name == "" {
+			continue
+		}
+		if isSecretEnv(name) {
+			value = redactedValue
+		}
+		env[name] = value
+	}
+	return env
+}
+
+// isSecretEnv indica si el nombre de la variable sugiere que contiene un secreto
+func isSecretEnv(name string) bool {
+	upper := strings.ToUpper(name)
+	for _, marker := range secretEnvMarkers {
+		if strings.Contains(upper, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitNul separa una lista de cadenas terminadas en NUL
+func splitNul(data []byte) []string {
+	fields := make([]string, 0)
+	for _, field := range bytes.Split(bytes.TrimRight(data, "\x00"), []byte{0}) {
+		if len(field) > 0 {
+			fields = append(fields, string(field))
+		}
+	}
+	return fields
+}
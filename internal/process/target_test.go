@@ -0,0 +1,37 @@
+package process
+
+import (
+	"os"
+	"testing"
+)
+
+func TestParseEnviron(t *testing.T) {
+	environ := []byte("GOGC=200\x00MALLOC_ARENA_MAX=2\x00DB_PASSWORD=hunter2\x00aws_secret_access_key=abc\x00BROKEN\x00\x00")
+
+	env := parseEnviron(environ)
+
+	expected := map[string]string{
+		"GOGC":                  "200",
+		"MALLOC_ARENA_MAX":      "2",
+		"DB_PASSWORD":           redactedValue,
+		"aws_secret_access_key": redactedValue,
+	}
+	if len(env) != len(expected) {
+		t.Fatalf("Expected %d variables, got %d: %v", len(expected), len(env), env)
+	}
+	for name, value := range expected {
+		if env[name] != value {
+			t.Errorf("env[%s] = %q, want %q", name, env[name], value)
+		}
+	}
+}
+
+func TestGetTargetInfo(t *testing.T) {
+	info, err := GetTargetInfo(os.Getpid())
+	if err != nil {
+		t.Skipf("/proc not available: %v", err)
+	}
+	if len(info.Cmdline) == 0 {
+		t.Error("Expected a non-empty command line for the current process")
+	}
+}
@@ -0,0 +1,473 @@
+// Package tui implements an interactive terminal browser for parsed samples.
+//
+// It is drawn with tcell, which only needs a terminal (an SSH session is
+// enough): scrollable lists of the top functions and threads and an ASCII
+// flamegraph with drill-down, navigated with the arrow keys.
+package tui
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/santiagolertora/blc-perf-analyzer/internal/parser"
+)
+
+const (
+	flameDepth = 6   // Levels drawn below the focused frame
+	flameMin   = 1.0 // Frames under this % of the focused samples are hidden
+	barWidth   = 30  // Width of the 100% bar
+	rootLabel  = "all"
+)
+
+const helpLine = "Up/Down PgUp/PgDn scroll  Enter drill/select  Backspace up  Tab or 1-3 view  a all threads  q quit"
+
+// view is one of the screens of the browser
+type view int
+
+const (
+	viewTop view = iota
+	viewThreads
+	viewFlame
+	viewCount
+)
+
+// thread identifies a thread by command name and TID
+type thread struct {
+	Command string
+	TID     int
+	Samples int
+}
+
+// row is one line of a view. Enter on a flamegraph row focuses on path, on
+// a threads row it selects thread (nil for all threads).
+type row struct {
+	text   string
+	share  float64
+	path   []string
+	thread *thread
+}
+
+// Browser holds the navigation state of an interactive session
+type Browser struct {
+	samples []*parser.Sample
+
+	view   view
+	thread *thread  // Thread filter, nil for all threads
+	path   []string // Flamegraph focus from the stack root
+
+	title  string // Heading of the current view
+	empty  string // Shown instead of the rows when there are none
+	rows   []row  // Rows of the current view
+	cursor int    // Selected row
+	offset int    // First row on screen
+	height int    // Screen height at the last draw
+}
+
+// NewBrowser creates a browser over samples, showing the top functions
+func NewBrowser(samples []*parser.Sample) *Browser {
+	b := &Browser{samples: samples}
+	b.refresh()
+	return b
+}
+
+// Run takes over the terminal until q or Esc is pressed
+func (b *Browser) Run() error {
+	screen, err := tcell.NewScreen()
+	if err != nil {
+		return err
+	}
+	if err := screen.Init(); err != nil {
+		return err
+	}
+	defer screen.Fini()
+	return b.run(screen)
+}
+
+// run is the event loop on an initialized screen
+func (b *Browser) run(screen tcell.Screen) error {
+	for {
+		b.Draw(screen)
+		switch ev := screen.PollEvent().(type) {
+		case *tcell.EventResize:
+			screen.Sync()
+		case *tcell.EventKey:
+			if !b.HandleKey(ev) {
+				return nil
+			}
+		case nil:
+			return nil // Screen finalized
+		}
+	}
+}
+
+// HandleKey applies a key press and reports whether the session continues
+func (b *Browser) HandleKey(ev *tcell.EventKey) bool {
+	switch ev.Key() {
+	case tcell.KeyEscape, tcell.KeyCtrlC:
+		return false
+	case tcell.KeyUp:
+		b.cursor--
+	case tcell.KeyDown:
+		b.cursor++
+	case tcell.KeyPgUp:
+		b.cursor -= b.pageRows()
+	case tcell.KeyPgDn:
+		b.cursor += b.pageRows()
+	case tcell.KeyHome:
+		b.cursor = 0
+	case tcell.KeyEnd:
+		b.cursor = len(b.rows) - 1
+	case tcell.KeyEnter, tcell.KeyRight:
+		b.enter()
+	case tcell.KeyBackspace, tcell.KeyBackspace2, tcell.KeyLeft:
+		b.up()
+	case tcell.KeyTab:
+		b.show((b.view + 1) % viewCount)
+	case tcell.KeyRune:
+		switch ev.Rune() {
+		case 'q':
+			return false
+		case 'k':
+			b.cursor--
+		case 'j':
+			b.cursor++
+		case 'l':
+			b.enter()
+		case 'h':
+			b.up()
+		case '1':
+			b.show(viewTop)
+		case '2':
+			b.show(viewThreads)
+		case '3':
+			b.show(viewFlame)
+		case 'a':
+			b.thread = nil
+			b.refresh()
+		}
+	}
+	b.scroll()
+	return true
+}
+
+// show switches to v with the cursor on the first row
+func (b *Browser) show(v view) {
+	b.view = v
+	b.cursor = 0
+	b.refresh()
+}
+
+// enter drills into the selected flamegraph frame or selects the thread
+func (b *Browser) enter() {
+	if b.cursor < 0 || b.cursor >= len(b.rows) {
+		return
+	}
+	selected := b.rows[b.cursor]
+	switch b.view {
+	case viewFlame:
+		b.path = selected.path
+		b.show(viewFlame)
+	case viewThreads:
+		b.thread = selected.thread
+		b.show(viewTop)
+	}
+}
+
+// up moves the flamegraph focus to the parent frame, with the cursor on the
+// frame just left
+func (b *Browser) up() {
+	if b.view != viewFlame || len(b.path) == 0 {
+		return
+	}
+	left := b.path
+	b.path = b.path[:len(b.path)-1]
+	b.show(viewFlame)
+	for i, r := range b.rows {
+		if equalPath(r.path, left) {
+			b.cursor = i
+			break
+		}
+	}
+}
+
+// pageRows is the number of rows that fit on screen
+func (b *Browser) pageRows() int {
+	if b.height < 3 {
+		return 1
+	}
+	return b.height - 2
+}
+
+// scroll keeps the cursor within the rows and on screen
+func (b *Browser) scroll() {
+	if b.cursor >= len(b.rows) {
+		b.cursor = len(b.rows) - 1
+	}
+	if b.cursor < 0 {
+		b.cursor = 0
+	}
+	if b.cursor < b.offset {
+		b.offset = b.cursor
+	}
+	if b.cursor >= b.offset+b.pageRows() {
+		b.offset = b.cursor - b.pageRows() + 1
+	}
+}
+
+// Draw renders the current view: the title, the visible rows with the
+// selected one highlighted, and the key help on the last line
+func (b *Browser) Draw(screen tcell.Screen) {
+	width, height := screen.Size()
+	b.height = height
+	b.scroll()
+
+	screen.Clear()
+	drawText(screen, 0, 0, width, tcell.StyleDefault.Bold(true), b.title)
+	if len(b.rows) == 0 {
+		drawText(screen, 0, 1, width, tcell.StyleDefault, b.empty)
+	}
+	for y := 1; y <= b.pageRows() && b.offset+y-1 < len(b.rows); y++ {
+		i := b.offset + y - 1
+		style := tcell.StyleDefault
+		if i == b.cursor {
+			style = style.Reverse(true)
+		}
+		r := b.rows[i]
+		drawText(screen, 0, y, width, style, fmt.Sprintf("%6.2f%% %s %s", r.share, bar(r.share), r.text))
+	}
+	drawText(screen, 0, height-1, width, tcell.StyleDefault.Dim(true), helpLine)
+	screen.Show()
+}
+
+// drawText writes text at x, y, cut at width
+func drawText(screen tcell.Screen, x, y, width int, style tcell.Style, text string) {
+	for _, r := range text {
+		if x >= width {
+			return
+		}
+		screen.SetContent(x, y, r, nil, style)
+		x++
+	}
+}
+
+// refresh rebuilds the rows of the current view
+func (b *Browser) refresh() {
+	switch b.view {
+	case viewTop:
+		b.topRows()
+	case viewThreads:
+		b.threadRows()
+	case viewFlame:
+		b.flameRows()
+	}
+	b.scroll()
+}
+
+// location describes the current filter and focus for the title
+func (b *Browser) location() string {
+	where := rootLabel
+	if len(b.path) > 0 {
+		where = strings.Join(b.path, " > ")
+	}
+	if b.thread != nil {
+		where = fmt.Sprintf("%s/%d: %s", b.thread.Command, b.thread.TID, where)
+	}
+	return where
+}
+
+// focused returns the samples matching the thread filter whose stack starts
+// (from the root) with the focus path
+func (b *Browser) focused() []*parser.Sample {
+	result := make([]*parser.Sample, 0, len(b.samples))
+	for _, sample := range b.samples {
+		if b.thread != nil && (sample.TID != b.thread.TID || sample.Command != b.thread.Command) {
+			continue
+		}
+		if !hasRootPrefix(sample, b.path) {
+			continue
+		}
+		result = append(result, sample)
+	}
+	return result
+}
+
+// hasRootPrefix reports whether the root-first stack of sample starts with path
+func hasRootPrefix(sample *parser.Sample, path []string) bool {
+	if len(path) > len(sample.Stack) {
+		return false
+	}
+	for i, symbol := range path {
+		if sample.Stack[len(sample.Stack)-1-i].Symbol != symbol {
+			return false
+		}
+	}
+	return true
+}
+
+// topRows lists the functions with the most self samples
+func (b *Browser) topRows() {
+	samples := b.focused()
+	counts := make(map[string]int)
+	for _, sample := range samples {
+		if top := sample.GetTopFrame(); top != nil {
+			counts[top.Symbol]++
+		}
+	}
+
+	b.title = fmt.Sprintf("Top functions of %s (%d samples)", b.location(), len(samples))
+	b.empty = "No samples"
+	b.rows = nil
+	for i, name := range sortedByCount(counts) {
+		b.rows = append(b.rows, row{
+			text:  fmt.Sprintf("%4d. %s", i+1, name),
+			share: percent(counts[name], len(samples)),
+		})
+	}
+}
+
+// threadRows lists the sample count of every thread, after an entry that
+// clears the thread filter
+func (b *Browser) threadRows() {
+	counts := make(map[thread]int)
+	for _, sample := range b.samples {
+		counts[thread{Command: sample.Command, TID: sample.TID}]++
+	}
+
+	threads := make([]thread, 0, len(counts))
+	for t, count := range counts {
+		t.Samples = count
+		threads = append(threads, t)
+	}
+	sort.Slice(threads, func(i, j int) bool {
+		if threads[i].Samples != threads[j].Samples {
+			return threads[i].Samples > threads[j].Samples
+		}
+		return threads[i].TID < threads[j].TID
+	})
+
+	b.title = fmt.Sprintf("Threads (%d)", len(threads))
+	b.empty = "No samples"
+	b.rows = []row{{text: "all threads", share: percent(len(b.samples), len(b.samples))}}
+	for i := range threads {
+		t := threads[i]
+		b.rows = append(b.rows, row{
+			text:   fmt.Sprintf("%s/%d", t.Command, t.TID),
+			share:  percent(t.Samples, len(b.samples)),
+			thread: &t,
+		})
+	}
+}
+
+// flameNode is a frame in the merged call tree
+type flameNode struct {
+	name     string
+	samples  int
+	children map[string]*flameNode
+}
+
+// flameRows lists the call tree below the focused frame as indented rows,
+// each with a bar proportional to its share of the focused samples
+func (b *Browser) flameRows() {
+	samples := b.focused()
+	root := &flameNode{name: b.location(), children: make(map[string]*flameNode)}
+	for _, sample := range samples {
+		root.samples++
+		node := root
+		for i := len(sample.Stack) - 1 - len(b.path); i >= 0; i-- {
+			symbol := sample.Stack[i].Symbol
+			child, exists := node.children[symbol]
+			if !exists {
+				child = &flameNode{name: symbol, children: make(map[string]*flameNode)}
+				node.children[symbol] = child
+			}
+			child.samples++
+			node = child
+		}
+	}
+
+	b.title = fmt.Sprintf("Flamegraph of %s (%d samples)", root.name, root.samples)
+	b.empty = "(leaf frame, no callees)"
+	if len(samples) == 0 {
+		b.empty = "No samples"
+	}
+	b.rows = nil
+	for _, name := range sortedChildren(root) {
+		b.addNode(root.children[name], root.samples, 0, b.path)
+	}
+}
+
+// addNode adds node and its children down to flameDepth levels, parent
+// being the path of the frame that calls node
+func (b *Browser) addNode(node *flameNode, total, depth int, parent []string) {
+	share := percent(node.samples, total)
+	if share < flameMin {
+		return
+	}
+	path := append(append([]string(nil), parent...), node.name)
+	b.rows = append(b.rows, row{
+		text:  strings.Repeat("  ", depth) + node.name,
+		share: share,
+		path:  path,
+	})
+	if depth+1 >= flameDepth {
+		return
+	}
+	for _, name := range sortedChildren(node) {
+		b.addNode(node.children[name], total, depth+1, path)
+	}
+}
+
+// equalPath reports whether two focus paths are the same
+func equalPath(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// sortedChildren returns the child names of node, hottest first
+func sortedChildren(node *flameNode) []string {
+	counts := make(map[string]int, len(node.children))
+	for name, child := range node.children {
+		counts[name] = child.samples
+	}
+	return sortedByCount(counts)
+}
+
+// sortedByCount returns the keys of counts, highest count first
+func sortedByCount(counts map[string]int) []string {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+func percent(count, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(count) / float64(total) * 100
+}
+
+// bar renders share as a fixed-width ASCII bar
+func bar(share float64) string {
+	filled := int(share/100*barWidth + 0.5)
+	if filled > barWidth {
+		filled = barWidth
+	}
+	return "|" + strings.Repeat("#", filled) + strings.Repeat(" ", barWidth-filled) + "|"
+}
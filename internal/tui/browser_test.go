@@ -0,0 +1,156 @@
+package tui
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/santiagolertora/blc-perf-analyzer/internal/parser"
+)
+
+// stack builds a sample from leaf-first symbols
+func stack(command string, tid int, symbols ...string) *parser.Sample {
+	sample := &parser.Sample{Command: command, PID: 1, TID: tid}
+	for _, symbol := range symbols {
+		sample.Stack = append(sample.Stack, parser.StackFrame{Symbol: symbol})
+	}
+	return sample
+}
+
+// press sends keys to b, runes as tcell.KeyRune
+func press(b *Browser, keys ...interface{}) {
+	for _, key := range keys {
+		switch k := key.(type) {
+		case rune:
+			b.HandleKey(tcell.NewEventKey(tcell.KeyRune, k, tcell.ModNone))
+		case tcell.Key:
+			b.HandleKey(tcell.NewEventKey(k, 0, tcell.ModNone))
+		}
+	}
+}
+
+// screenText draws b on a simulated width x height terminal and returns its lines
+func screenText(t *testing.T, b *Browser, width, height int) []string {
+	screen := tcell.NewSimulationScreen("")
+	if err := screen.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer screen.Fini()
+	screen.SetSize(width, height)
+
+	b.Draw(screen)
+	cells, _, _ := screen.GetContents()
+	lines := make([]string, height)
+	for y := 0; y < height; y++ {
+		var line strings.Builder
+		for x := 0; x < width; x++ {
+			runes := cells[y*width+x].Runes
+			if len(runes) == 0 {
+				line.WriteRune(' ')
+				continue
+			}
+			line.WriteRune(runes[0])
+		}
+		lines[y] = strings.TrimRight(line.String(), " ")
+	}
+	return lines
+}
+
+func TestBrowserSession(t *testing.T) {
+	samples := []*parser.Sample{
+		stack("app", 10, "lock", "worker", "main"),
+		stack("app", 10, "lock", "worker", "main"),
+		stack("app", 10, "parse", "reader", "main"),
+		stack("log", 11, "write", "flush", "main"),
+	}
+	b := NewBrowser(samples)
+
+	lines := screenText(t, b, 100, 10)
+	if lines[0] != "Top functions of all (4 samples)" {
+		t.Errorf("Unexpected title %q", lines[0])
+	}
+	if lines[1] != " 50.00% |###############               |    1. lock" {
+		t.Errorf("Unexpected first row %q", lines[1])
+	}
+	if !strings.HasPrefix(lines[9], "Up/Down") {
+		t.Errorf("Expected the key help on the last line, got %q", lines[9])
+	}
+
+	press(b, '3', tcell.KeyEnter)
+	lines = screenText(t, b, 100, 10)
+	if lines[0] != "Flamegraph of main (4 samples)" || !strings.HasSuffix(lines[1], "| worker") {
+		t.Errorf("Expected the flamegraph focused on main, got:\n%s", strings.Join(lines, "\n"))
+	}
+
+	press(b, tcell.KeyDown, tcell.KeyBackspace)
+	if b.location() != rootLabel || b.rows[b.cursor].text != "main" {
+		t.Errorf("Expected the focus back at the root with main selected, got %q on %q", b.location(), b.rows[b.cursor].text)
+	}
+
+	press(b, '2', tcell.KeyDown, tcell.KeyDown, tcell.KeyEnter)
+	lines = screenText(t, b, 100, 10)
+	if lines[0] != "Top functions of log/11: all (1 samples)" || lines[1] != "100.00% |##############################|    1. write" {
+		t.Errorf("Expected the top functions of thread log/11, got:\n%s", strings.Join(lines, "\n"))
+	}
+
+	press(b, 'a')
+	if b.thread != nil {
+		t.Error("Expected a to clear the thread filter")
+	}
+	if b.HandleKey(tcell.NewEventKey(tcell.KeyRune, 'q', tcell.ModNone)) {
+		t.Error("Expected q to end the session")
+	}
+}
+
+func TestBrowserScroll(t *testing.T) {
+	var samples []*parser.Sample
+	for i := 0; i < 50; i++ {
+		samples = append(samples, stack("app", 10, strings.Repeat("f", i+1), "main"))
+	}
+	b := NewBrowser(samples)
+	screenText(t, b, 80, 12) // 10 rows between the title and the help
+
+	press(b, tcell.KeyPgDn, tcell.KeyPgDn, tcell.KeyDown)
+	if b.cursor != 21 || b.offset != 12 {
+		t.Errorf("Expected cursor 21 at offset 12, got %d at %d", b.cursor, b.offset)
+	}
+	lines := screenText(t, b, 80, 12)
+	if !strings.Contains(lines[10], "22. ") {
+		t.Errorf("Expected the selected row at the bottom of the screen, got %q", lines[10])
+	}
+
+	press(b, tcell.KeyEnd, tcell.KeyDown)
+	if b.cursor != 49 {
+		t.Errorf("Expected the cursor to stop at the last row, got %d", b.cursor)
+	}
+	press(b, tcell.KeyHome, tcell.KeyUp)
+	if b.cursor != 0 || b.offset != 0 {
+		t.Errorf("Expected the cursor to stop at the first row, got %d at %d", b.cursor, b.offset)
+	}
+}
+
+func TestBrowserDrillFocus(t *testing.T) {
+	samples := []*parser.Sample{
+		stack("app", 10, "lock", "worker", "main"),
+		stack("app", 10, "parse", "reader", "main"),
+	}
+	b := NewBrowser(samples)
+
+	// Rows: main, reader, parse, worker, lock (ties sort by name)
+	press(b, '3', tcell.KeyDown, tcell.KeyDown, tcell.KeyDown, tcell.KeyEnter)
+	if got := b.location(); got != "main > worker" {
+		t.Fatalf("Unexpected focus %q", got)
+	}
+	if focused := b.focused(); len(focused) != 1 || focused[0].Stack[0].Symbol != "lock" {
+		t.Errorf("Expected only the worker sample in focus, got %d samples", len(focused))
+	}
+
+	press(b, tcell.KeyEnter)
+	if got := b.location(); got != "main > worker > lock" || len(b.rows) != 0 {
+		t.Errorf("Expected the leaf lock in focus with no rows, got %q with %d rows", got, len(b.rows))
+	}
+	press(b, tcell.KeyEnter)
+	if got := b.location(); got != "main > worker > lock" {
+		t.Errorf("Enter without rows changed the focus to %q", got)
+	}
+}
@@ -0,0 +1,71 @@
+package upload
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// CreateArchive packs dir into a gzip-compressed tarball at archivePath.
+// Entries are stored under the directory's base name.
+func CreateArchive(dir, archivePath string) error {
+	file, err := os.Create(archivePath)
+	if err != nil {
+		return fmt.Errorf("error creating archive: %v", err)
+	}
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+
+	root := filepath.Base(filepath.Clean(dir))
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() && !info.IsDir() {
+			return nil // Skip sockets, symlinks and other special files
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(filepath.Join(root, rel))
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		src, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(tw, src)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error archiving %s: %v", dir, err)
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("error finishing archive: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("error finishing archive: %v", err)
+	}
+	return file.Close()
+}
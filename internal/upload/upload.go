@@ -0,0 +1,101 @@
+package upload
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Uploader sends a local file to a destination URL
+type Uploader interface {
+	Upload(path string, destination *url.URL) error
+}
+
+var (
+	uploadersMu sync.RWMutex
+
+	// uploaders maps URL schemes to their uploader. Cloud object stores can
+	// be added with RegisterUploader; presigned HTTPS URLs work out of the box.
+	uploaders = map[string]Uploader{
+		"http":  &HTTPUploader{},
+		"https": &HTTPUploader{},
+	}
+)
+
+// RegisterUploader adds or replaces the uploader for a URL scheme (e.g. "s3")
+func RegisterUploader(scheme string, uploader Uploader) {
+	uploadersMu.Lock()
+	defer uploadersMu.Unlock()
+	uploaders[strings.ToLower(scheme)] = uploader
+}
+
+// Upload sends the file at path to destination using the uploader registered
+// for its scheme. A destination ending in "/" gets the file name appended.
+// It returns the final destination URL.
+func Upload(path, destination string) (string, error) {
+	target, err := url.Parse(destination)
+	if err != nil {
+		return "", fmt.Errorf("invalid upload URL %q: %v", destination, err)
+	}
+	if strings.HasSuffix(target.Path, "/") {
+		target.Path += filepath.Base(path)
+	}
+
+	uploadersMu.RLock()
+	uploader, exists := uploaders[strings.ToLower(target.Scheme)]
+	uploadersMu.RUnlock()
+	if !exists {
+		return "", fmt.Errorf("unsupported upload scheme %q (use an http(s) URL, e.g. a presigned S3/GCS URL)", target.Scheme)
+	}
+
+	if err := uploader.Upload(path, target); err != nil {
+		return "", err
+	}
+	return target.String(), nil
+}
+
+// HTTPUploader uploads files with an HTTP PUT request
+type HTTPUploader struct {
+	Client *http.Client // Defaults to a client with a 10 minute timeout
+}
+
+// Upload implements Uploader
+func (u *HTTPUploader) Upload(path string, destination *url.URL) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %v", path, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", path, err)
+	}
+
+	req, err := http.NewRequest(http.MethodPut, destination.String(), file)
+	if err != nil {
+		return fmt.Errorf("error creating upload request: %v", err)
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", "application/gzip")
+
+	client := u.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Minute}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error uploading to %s: %v", destination.Redacted(), err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("upload to %s failed: %s", destination.Redacted(), resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package upload
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCreateArchive(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "run-001")
+	if err := os.MkdirAll(filepath.Join(dir, "annotations"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(dir, "summary.txt"), []byte("summary"), 0644)
+	os.WriteFile(filepath.Join(dir, "annotations", "01-main.txt"), []byte("annotation"), 0644)
+
+	archivePath := dir + ".tar.gz"
+	if err := CreateArchive(dir, archivePath); err != nil {
+		t.Fatalf("CreateArchive failed: %v", err)
+	}
+
+	file, err := os.Open(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+
+	files := make(map[string]string)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			content, _ := io.ReadAll(tr)
+			files[header.Name] = string(content)
+		}
+	}
+
+	if files["run-001/summary.txt"] != "summary" || files["run-001/annotations/01-main.txt"] != "annotation" {
+		t.Errorf("Unexpected archive contents: %v", files)
+	}
+}
+
+func TestUpload(t *testing.T) {
+	var gotPath, gotBody string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		gotPath, gotBody = r.URL.Path, string(body)
+		if r.URL.Path == "/denied/run.tar.gz" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "run.tar.gz")
+	os.WriteFile(path, []byte("archive"), 0644)
+
+	dest, err := Upload(path, server.URL+"/captures/")
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if gotPath != "/captures/run.tar.gz" || gotBody != "archive" || dest != server.URL+"/captures/run.tar.gz" {
+		t.Errorf("Unexpected upload: path=%q body=%q dest=%q", gotPath, gotBody, dest)
+	}
+
+	if _, err := Upload(path, server.URL+"/denied/"); err == nil {
+		t.Error("Expected error for a rejected upload")
+	}
+	if _, err := Upload(path, "s3://bucket/prefix/"); err == nil {
+		t.Error("Expected error for an unregistered scheme")
+	}
+}
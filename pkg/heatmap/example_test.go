@@ -0,0 +1,74 @@
+package heatmap_test
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/santiagolertora/blc-perf-analyzer/pkg/heatmap"
+	"github.com/santiagolertora/blc-perf-analyzer/pkg/parser"
+)
+
+// raftDetector flags windows where apply_raft_log takes more than 20% of the
+// samples
+type raftDetector struct{}
+
+func (raftDetector) Name() string { return "raft_apply" }
+
+func (raftDetector) Detect(windows []*heatmap.TimeWindowData) []heatmap.Anomaly {
+	var anomalies []heatmap.Anomaly
+	for i, window := range windows {
+		if window.SampleCount == 0 {
+			continue
+		}
+		percent := float64(window.FunctionCounts["apply_raft_log"]) / float64(window.SampleCount) * 100
+		if percent > 20 {
+			anomalies = append(anomalies, heatmap.Anomaly{
+				WindowIndex: i,
+				Type:        "raft_apply",
+				Description: fmt.Sprintf("apply_raft_log took %.0f%% of the samples", percent),
+				Severity:    "medium",
+				Value:       percent,
+			})
+		}
+	}
+	return anomalies
+}
+
+func ExampleRegisterDetector() {
+	heatmap.RegisterDetector(raftDetector{})
+
+	// Two one-second windows; apply_raft_log dominates the second one
+	var script strings.Builder
+	for i := 0; i < 20; i++ {
+		function := "handle_request"
+		if i >= 10 && i%2 == 0 {
+			function = "apply_raft_log"
+		}
+		fmt.Fprintf(&script, "kvd 100/101 [000] %.2f:     250000 cpu-clock:\n\t    55555560abcd %s+0x10 (/usr/bin/kvd)\n\n", 1000+float64(i)*0.1, function)
+	}
+	samples, err := parser.ParsePerfScript(script.String())
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "heatmap-example")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	patterns, err := heatmap.GenerateHeatmap(samples, &heatmap.HeatmapConfig{OutputDir: dir, WindowSize: 1.0, QuietMode: true})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, anomaly := range patterns.Anomalies {
+		if anomaly.Type == "raft_apply" {
+			fmt.Printf("window %d: %s\n", anomaly.WindowIndex, anomaly.Description)
+		}
+	}
+	// Output:
+	// window 1: apply_raft_log took 50% of the samples
+}
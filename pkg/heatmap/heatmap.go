@@ -0,0 +1,40 @@
+// Package heatmap is the public API of the temporal heatmap for programs that
+// embed blc-perf-analyzer: it renders heatmap.html from parsed samples and
+// lets them add anomaly detectors without importing internal packages. The
+// types are aliases of the ones the analysis uses, so registered detectors
+// run on every heatmap made in the same program.
+package heatmap
+
+import (
+	"github.com/santiagolertora/blc-perf-analyzer/internal/heatmap"
+	"github.com/santiagolertora/blc-perf-analyzer/pkg/parser"
+)
+
+type (
+	// AnomalyDetector finds anomalies across the time windows of a capture.
+	// Detectors must not modify the windows.
+	AnomalyDetector = heatmap.AnomalyDetector
+	// TimeWindowData holds the counts of one time window
+	TimeWindowData = heatmap.TimeWindowData
+	// Anomaly is one anomaly found in a window
+	Anomaly = heatmap.Anomaly
+	// PatternDetection holds the anomalies found across a capture
+	PatternDetection = heatmap.PatternDetection
+	// HeatmapConfig is the configuration of GenerateHeatmap
+	HeatmapConfig = heatmap.HeatmapConfig
+	// PatternThresholds are the sensitivities of the built-in detectors
+	PatternThresholds = heatmap.PatternThresholds
+)
+
+// RegisterDetector adds a custom anomaly detector that runs on every heatmap
+// after the built-in detectors. Register detectors before generating
+// heatmaps.
+func RegisterDetector(detector AnomalyDetector) {
+	heatmap.RegisterDetector(detector)
+}
+
+// GenerateHeatmap writes heatmap.html and patterns.json for samples into
+// config.OutputDir and returns the patterns detected across its time windows
+func GenerateHeatmap(samples []*parser.Sample, config *HeatmapConfig) (*PatternDetection, error) {
+	return heatmap.GenerateHeatmap(samples, config)
+}
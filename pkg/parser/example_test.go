@@ -0,0 +1,33 @@
+package parser_test
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/santiagolertora/blc-perf-analyzer/pkg/parser"
+)
+
+func ExampleRegisterClassifier() {
+	// Node.js frames from a perf map, claimed before the built-in JIT rule
+	const frameTypeNode parser.FrameType = "nodejs"
+	parser.RegisterClassifier(func(frame *parser.StackFrame, module, symbol string) (parser.Classification, bool) {
+		if strings.HasPrefix(symbol, "lazycompile:") {
+			return parser.Classification{Type: frameTypeNode, Userland: true}, true
+		}
+		return parser.Classification{}, false
+	}, frameTypeNode)
+
+	samples, err := parser.ParsePerfScript("node 4242/4242 [000] 5000.000001:     250000 cpu-clock:\n" +
+		"\t    3a2c01d2e0 LazyCompile:*handle /srv/app.js:10+0x10 (/tmp/perf-4242.map)\n" +
+		"\t    55555560abcd uv_run+0x20 (/usr/bin/node)\n")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, frame := range samples[0].Stack {
+		fmt.Printf("%s: %s\n", frame.Symbol, frame.Type)
+	}
+	// Output:
+	// LazyCompile:*handle /srv/app.js:10: nodejs
+	// uv_run: application
+}
@@ -0,0 +1,70 @@
+// Package parser is the public API of the perf script parser for programs
+// that embed blc-perf-analyzer: it parses captures and lets them add frame
+// classifiers without importing internal packages. The types are aliases of
+// the ones the analysis uses, so registered classifiers apply to every report
+// made in the same program.
+package parser
+
+import (
+	"io"
+
+	"github.com/santiagolertora/blc-perf-analyzer/internal/parser"
+)
+
+type (
+	// Sample is one perf sample with its stack, leaf frame first
+	Sample = parser.Sample
+	// StackFrame is one frame of a sample's stack
+	StackFrame = parser.StackFrame
+	// FrameType categorizes a frame
+	FrameType = parser.FrameType
+	// Classification is the category assigned to a frame
+	Classification = parser.Classification
+	// Classifier claims a frame by returning its classification and true.
+	// module and symbol are the frame's Module and Symbol in lower case.
+	Classifier = parser.Classifier
+)
+
+// Built-in frame types
+const (
+	FrameTypeKernelCore   = parser.FrameTypeKernelCore
+	FrameTypeKernelDriver = parser.FrameTypeKernelDriver
+	FrameTypeLibC         = parser.FrameTypeLibC
+	FrameTypeLibPthread   = parser.FrameTypeLibPthread
+	FrameTypeLibMySQL     = parser.FrameTypeLibMySQL
+	FrameTypeJIT          = parser.FrameTypeJIT
+	FrameTypeGoRuntime    = parser.FrameTypeGoRuntime
+	FrameTypeAllocator    = parser.FrameTypeAllocator
+	FrameTypeApplication  = parser.FrameTypeApplication
+	FrameTypeGuest        = parser.FrameTypeGuest
+	FrameTypeUnknown      = parser.FrameTypeUnknown
+)
+
+// RegisterClassifier adds a classifier that is consulted before the built-in
+// ones, so it can claim frames the defaults would otherwise categorize.
+// types lists the frame types the classifier may return so that they are
+// reported by FrameTypes. Register classifiers before parsing.
+func RegisterClassifier(c Classifier, types ...FrameType) {
+	parser.RegisterClassifier(c, types...)
+}
+
+// FrameTypes returns every known frame type, built-ins first
+func FrameTypes() []FrameType {
+	return parser.FrameTypes()
+}
+
+// ClassifyFrame determines the type of a stack frame and whether it is
+// kernel or userland code
+func ClassifyFrame(frame *StackFrame) (FrameType, bool, bool) {
+	return parser.ClassifyFrame(frame)
+}
+
+// ParsePerfScript parses the output of `perf script`
+func ParsePerfScript(content string) ([]*Sample, error) {
+	return parser.ParsePerfScript(content)
+}
+
+// ParsePerfScriptReader parses `perf script` output read line by line from r
+func ParsePerfScriptReader(r io.Reader) ([]*Sample, error) {
+	return parser.ParsePerfScriptReader(r)
+}